@@ -52,9 +52,16 @@ func init() {
 }
 
 func (x *cmdActivate) Execute(args []string) error {
-	return withMutexAndRetry(x.doActivate)
+	return withPackageLockAndRetry([]string{x.Args.Snap}, x.doActivate)
 }
 
 func (x *cmdActivate) doActivate() error {
-	return snappy.SetActive(x.Args.Snap, x.activate, progress.MakeProgressBar())
+	op := snappy.HistoryActivate
+	if !x.activate {
+		op = snappy.HistoryDeactivate
+	}
+
+	return snappy.RecordOp(op, x.Args.Snap, cliInitiator(), func() (string, error) {
+		return "", snappy.SetActive(x.Args.Snap, x.activate, progress.MakeProgressBar())
+	})
 }
@@ -22,21 +22,20 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
-const clickReview = "click-review"
-
 type cmdBuild struct {
 	Output      string `long:"output" short:"o"`
 	BuildSnapfs bool   `long:"snapfs"`
+	Convert     string `long:"convert"`
+	SplitArch   bool   `long:"split-arch"`
 }
 
-var longBuildHelp = i18n.G("Creates a snap package and if available, runs the review scripts.")
+var longBuildHelp = i18n.G("Creates a snap package and checks it for problems, like 'snappy lint' does.")
 
 func init() {
 	cmd, err := parser.AddCommand("build",
@@ -49,6 +48,8 @@ func init() {
 
 	cmd.Aliases = append(cmd.Aliases, "bu")
 	addOptionDescription(cmd, "output", i18n.G("Specify an alternate output directory for the resulting package"))
+	addOptionDescription(cmd, "convert", i18n.G("Convert the given legacy snap into a snapfs snap"))
+	addOptionDescription(cmd, "split-arch", i18n.G("Build one snap for each architecture"))
 }
 
 func (x *cmdBuild) Execute(args []string) (err error) {
@@ -56,28 +57,40 @@ func (x *cmdBuild) Execute(args []string) (err error) {
 		args = []string{"."}
 	}
 
-	var snapPackage string
+	build := snappy.BuildLegacySnap
 	if x.BuildSnapfs {
-		snapPackage, err = snappy.BuildSnapfsSnap(args[0], x.Output)
-	} else {
-		snapPackage, err = snappy.BuildLegacySnap(args[0], x.Output)
+		build = snappy.BuildSnapfsSnap
+	}
+
+	var snapPackages []string
+	switch {
+	case x.Convert != "":
+		var snapPackage string
+		snapPackage, err = snappy.ConvertLegacySnap(x.Convert, x.Output)
+		snapPackages = []string{snapPackage}
+	case x.SplitArch:
+		snapPackages, err = snappy.BuildArchSnaps(args[0], x.Output, build)
+	default:
+		var snapPackage string
+		snapPackage, err = build(args[0], x.Output)
+		snapPackages = []string{snapPackage}
 	}
 	if err != nil {
 		return err
 	}
 
-	_, err = exec.LookPath(clickReview)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: could not review package (%s not available)\n", clickReview)
-	} else {
-		cmd := exec.Command(clickReview, snapPackage)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		// we ignore the error for now
-		_ = cmd.Run()
+	for _, snapPackage := range snapPackages {
+		// the problems are shown, but do not fail the build for now
+		issues, err := snappy.Lint(snapPackage)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not check package: %v\n", err)
+		} else {
+			showLint(issues, os.Stderr)
+		}
+
+		// TRANSLATORS: the %s is a pkgname
+		fmt.Printf(i18n.G("Generated '%s' snap\n"), snapPackage)
 	}
 
-	// TRANSLATORS: the %s is a pkgname
-	fmt.Printf(i18n.G("Generated '%s' snap\n"), snapPackage)
 	return nil
 }
@@ -63,7 +63,16 @@ func (x *cmdConfig) Execute(args []string) (err error) {
 		return errors.New(i18n.G("package name is required"))
 	}
 
-	newConfig, err := configurePackage(pkgName, configFile)
+	var newConfig string
+	if configFile == "" {
+		newConfig, err = configurePackage(pkgName, configFile)
+	} else {
+		err = snappy.RecordOp(snappy.HistoryConfig, pkgName, cliInitiator(), func() (string, error) {
+			var err error
+			newConfig, err = configurePackage(pkgName, configFile)
+			return "", err
+		})
+	}
 	if err == snappy.ErrPackageNotFound {
 		// TRANSLATORS: the %s is a pkgname
 		return fmt.Errorf(i18n.G("No snap: '%s' found"), pkgName)
@@ -83,7 +92,10 @@ func configurePackage(pkgName, configFile string) (string, error) {
 		return "", err
 	}
 
-	snap := snappy.ActiveSnapByName(pkgName)
+	snap, err := snappy.ActiveSnapByQualifiedName(pkgName)
+	if err != nil {
+		return "", err
+	}
 	if snap == nil {
 		return "", snappy.ErrPackageNotFound
 	}
@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdData struct {
+	Export dataExport `command:"export"`
+	Import dataImport `command:"import"`
+}
+
+type dataExport struct {
+	Args struct {
+		Snap string `positional-arg-name:"snap"`
+		File string `positional-arg-name:"file"`
+	} `required:"true" positional-args:"yes"`
+}
+
+type dataImport struct {
+	Args struct {
+		File string `positional-arg-name:"file"`
+	} `required:"true" positional-args:"yes"`
+}
+
+var (
+	shortDataHelp = i18n.G("Export and import the data of a package")
+	longDataHelp  = i18n.G(`Export the data of the active version of a package, for the system and for all users, together with its configuration to a file, or import such a file on another device. The package needs to be installed from the same origin, in the same or a newer version, to import its data.`)
+)
+
+func init() {
+	_, err := parser.AddCommand("data",
+		shortDataHelp,
+		longDataHelp,
+		&cmdData{})
+	if err != nil {
+		logger.Panicf("Unable to data: %v", err)
+	}
+}
+
+func (x *dataExport) Execute(args []string) error {
+	return withPackageLockAndRetry([]string{x.Args.Snap}, x.doExport)
+}
+
+func (x *dataExport) doExport() (err error) {
+	f, err := os.OpenFile(x.Args.File, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := snappy.ExportData(x.Args.Snap, f); err != nil {
+		return err
+	}
+
+	// TRANSLATORS: the first %s is a pkgname, the second a path
+	fmt.Printf(i18n.G("Exported the data of %s to %s\n"), x.Args.Snap, x.Args.File)
+
+	return nil
+}
+
+func (x *dataImport) Execute(args []string) error {
+	info, err := snappy.ReadDataExport(x.Args.File)
+	if err != nil {
+		return err
+	}
+
+	return withPackageLockAndRetry([]string{info.Name}, func() error {
+		info, err := snappy.ImportData(x.Args.File, progress.MakeProgressBar())
+		if err != nil {
+			return err
+		}
+
+		// TRANSLATORS: the first %s is a pkgname, the second a version
+		fmt.Printf(i18n.G("Imported the data of %s version %s\n"), info.Name, info.Version)
+
+		return nil
+	})
+}
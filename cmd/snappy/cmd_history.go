@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdHistory struct {
+	Positional struct {
+		PackageName string `positional-arg-name:"package name"`
+	} `positional-args:"yes"`
+}
+
+var (
+	shortHistoryHelp = i18n.G("Show the history of changes to the system")
+	longHistoryHelp  = i18n.G(`Show the installs, updates, removals, rollbacks and configuration changes done on the system, oldest first, together with who did them and whether they worked. If a package name is given only the changes to that package are shown.`)
+)
+
+func init() {
+	arg, err := parser.AddCommand("history",
+		shortHistoryHelp,
+		longHistoryHelp,
+		&cmdHistory{})
+	if err != nil {
+		logger.Panicf("Unable to history: %v", err)
+	}
+	addOptionDescription(arg, "package name", i18n.G("Show the history of this package only"))
+}
+
+func (x *cmdHistory) Execute(args []string) error {
+	entries, err := snappy.History(x.Positional.PackageName)
+	if err != nil {
+		return err
+	}
+
+	showHistory(entries, os.Stdout)
+
+	return nil
+}
+
+func showHistory(entries []*snappy.HistoryEntry, o io.Writer) {
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, i18n.G("Date\tOperation\tName\tDeveloper\tOld\tNew\tChannel\tBy\tOutcome\t"))
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			e.Time.Format("2006-01-02 15:04:05"), e.Op, dashIfEmpty(e.Name), dashIfEmpty(e.Origin),
+			dashIfEmpty(e.OldVersion), dashIfEmpty(e.NewVersion), dashIfEmpty(e.Channel),
+			e.Initiator, e.Outcome)
+	}
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
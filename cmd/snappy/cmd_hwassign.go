@@ -51,7 +51,7 @@ func init() {
 }
 
 func (x *cmdHWAssign) Execute(args []string) error {
-	return withMutexAndRetry(x.doHWAssign)
+	return withPackageLockAndRetry([]string{x.Positional.PackageName}, x.doHWAssign)
 }
 
 func (x *cmdHWAssign) doHWAssign() error {
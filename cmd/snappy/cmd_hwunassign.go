@@ -51,7 +51,7 @@ func init() {
 }
 
 func (x *cmdHWUnassign) Execute(args []string) error {
-	return withMutexAndRetry(x.doHWUnassign)
+	return withPackageLockAndRetry([]string{x.Positional.PackageName}, x.doHWUnassign)
 }
 
 func (x *cmdHWUnassign) doHWUnassign() error {
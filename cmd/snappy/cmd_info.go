@@ -70,7 +70,10 @@ func (x *cmdInfo) Execute(args []string) (err error) {
 }
 
 func snapInfo(pkgname string, includeStore, verbose bool) error {
-	snap := snappy.ActiveSnapByName(pkgname)
+	snap, err := snappy.ActiveSnapByQualifiedName(pkgname)
+	if err != nil {
+		return err
+	}
 	if snap == nil && includeStore {
 		m := snappy.NewUbuntuStoreSnapRepository()
 		snaps, err := m.Details(snappy.SplitOrigin(pkgname))
@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdInspect struct {
+	JSON       bool `long:"json"`
+	Positional struct {
+		Snap string `positional-arg-name:"snap"`
+	} `required:"true" positional-args:"yes"`
+}
+
+var (
+	shortInspectHelp = i18n.G("Show what is inside a snap file")
+	longInspectHelp  = i18n.G(`Show what is inside the given snap file without installing it: its package.yaml with the binaries, services, security and frameworks, its files with their sizes and modes (from its hashes, if it has them), whether its signature verifies, and how big it is to download and once installed.`)
+)
+
+func init() {
+	arg, err := parser.AddCommand("inspect",
+		shortInspectHelp,
+		longInspectHelp,
+		&cmdInspect{})
+	if err != nil {
+		logger.Panicf("Unable to inspect: %v", err)
+	}
+	addOptionDescription(arg, "json", i18n.G("Show the snap as json"))
+	addOptionDescription(arg, "snap", i18n.G("The snap file to inspect"))
+}
+
+func (x *cmdInspect) Execute(args []string) error {
+	in, err := snappy.Inspect(x.Positional.Snap)
+	if err != nil {
+		return err
+	}
+
+	if x.JSON {
+		return showInspectJSON(in, os.Stdout)
+	}
+
+	showInspect(in, os.Stdout)
+
+	return nil
+}
+
+// securitySummary is a short description of the security definitions
+// of a binary or service
+func securitySummary(sd snappy.SecurityDefinitions) string {
+	var l []string
+	if sd.SecurityTemplate != "" {
+		l = append(l, fmt.Sprintf("template %s", sd.SecurityTemplate))
+	}
+	if len(sd.SecurityCaps) > 0 {
+		l = append(l, fmt.Sprintf("caps %s", strings.Join(sd.SecurityCaps, ",")))
+	}
+	if sd.SecurityOverride != nil {
+		l = append(l, "override")
+	}
+	if sd.SecurityPolicy != nil {
+		l = append(l, "policy")
+	}
+	if len(l) == 0 {
+		return "default"
+	}
+
+	return strings.Join(l, "; ")
+}
+
+func showInspect(in *snappy.Inspection, o io.Writer) {
+	fmt.Fprintf(o, "name: %s\n", in.Name)
+	fmt.Fprintf(o, "version: %s\n", in.Version)
+	fmt.Fprintf(o, "vendor: %s\n", dashIfEmpty(in.Vendor))
+	fmt.Fprintf(o, "type: %s\n", in.Type)
+	fmt.Fprintf(o, "architectures: %s\n", strings.Join(in.Architectures, ", "))
+	fmt.Fprintf(o, "frameworks: %s\n", dashIfEmpty(strings.Join(in.Frameworks, ", ")))
+	fmt.Fprintf(o, "signature: %s\n", in.Signature)
+	fmt.Fprintf(o, "download-size: %d\n", in.DownloadSize)
+	fmt.Fprintf(o, "installed-size: %d\n", in.InstalledSize)
+
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+	defer w.Flush()
+
+	if len(in.Binaries) > 0 {
+		fmt.Fprintln(w, "binaries:")
+		for _, bin := range in.Binaries {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t\n", bin.Name, bin.Exec, securitySummary(bin.SecurityDefinitions))
+		}
+	}
+
+	if len(in.Services) > 0 {
+		fmt.Fprintln(w, "services:")
+		for _, svc := range in.Services {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t\n", svc.Name, svc.Start, securitySummary(svc.SecurityDefinitions))
+		}
+	}
+
+	if len(in.Files) > 0 {
+		fmt.Fprintln(w, "files:")
+		for _, f := range in.Files {
+			size := "-"
+			if f.Size != nil {
+				size = fmt.Sprintf("%d", *f.Size)
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t\n", f.Mode, size, f.Name)
+		}
+	}
+}
+
+func showInspectJSON(in *snappy.Inspection, o io.Writer) error {
+	out, err := json.MarshalIndent(in, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(o, "%s\n", out)
+
+	return err
+}
@@ -22,8 +22,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"text/tabwriter"
 
+	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/progress"
@@ -31,8 +34,10 @@ import (
 )
 
 type cmdInstall struct {
-	AllowUnauthenticated bool `long:"allow-unauthenticated"`
-	DisableGC            bool `long:"no-gc"`
+	AllowUnauthenticated bool   `long:"allow-unauthenticated"`
+	DisableGC            bool   `long:"no-gc"`
+	DryRun               bool   `long:"dry-run"`
+	Channel              string `long:"channel"`
 	Positional           struct {
 		PackageName string `positional-arg-name:"package name"`
 		ConfigFile  string `positional-arg-name:"config file"`
@@ -49,12 +54,19 @@ func init() {
 	}
 	addOptionDescription(arg, "allow-unauthenticated", i18n.G("Install snaps even if the signature can not be verified."))
 	addOptionDescription(arg, "no-gc", i18n.G("Do not clean up old versions of the package."))
-	addOptionDescription(arg, "package name", i18n.G("The Package to install (name or path)"))
+	addOptionDescription(arg, "dry-run", i18n.G("Only check whether the package can be installed."))
+	addOptionDescription(arg, "channel", i18n.G("Install from this channel of the store, and track it for updates."))
+	addOptionDescription(arg, "package name", i18n.G("The Package to install (name[.origin][=version] or path)"))
 	addOptionDescription(arg, "config file", i18n.G("The configuration for the given install"))
 }
 
 func (x *cmdInstall) Execute(args []string) error {
-	return withMutexAndRetry(x.doInstall)
+	// the name of a snap file is only known once it is opened
+	if x.Positional.PackageName == "" || helpers.FileExists(x.Positional.PackageName) {
+		return withMutexAndRetry(x.doInstall)
+	}
+
+	return withPackageLockAndRetry([]string{x.Positional.PackageName}, x.doInstall)
 }
 
 func (x *cmdInstall) doInstall() error {
@@ -73,10 +85,26 @@ func (x *cmdInstall) doInstall() error {
 	if x.AllowUnauthenticated {
 		flags |= snappy.AllowUnauthenticated
 	}
+
+	if x.DryRun {
+		report, err := snappy.Preflight(pkgName, x.Channel, flags)
+		if err != nil {
+			return err
+		}
+		showPreflightReport(report, os.Stdout)
+
+		return report.Err()
+	}
+
 	// TRANSLATORS: the %s is a pkgname
 	fmt.Printf(i18n.G("Installing %s\n"), pkgName)
 
-	realPkgName, err := snappy.Install(pkgName, flags, progress.MakeProgressBar())
+	var realPkgName string
+	err := snappy.RecordOp(snappy.HistoryInstall, pkgName, cliInitiator(), func() (string, error) {
+		var err error
+		realPkgName, err = snappy.InstallFromChannel(pkgName, x.Channel, flags, progress.MakeProgressBar())
+		return realPkgName, err
+	})
 	if err != nil {
 		return err
 	}
@@ -97,3 +125,22 @@ func (x *cmdInstall) doInstall() error {
 
 	return nil
 }
+
+func showPreflightReport(report *snappy.PreflightReport, o io.Writer) {
+	name := report.Name + "." + report.Origin
+	if report.Channel != "" {
+		name += "/" + report.Channel
+	}
+	// TRANSLATORS: the first %s is a pkgname, the second a version
+	fmt.Fprintf(o, i18n.G("Checking %s (%s)\n"), name, report.Version)
+
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+	for _, check := range report.Checks {
+		result := i18n.G("ok")
+		if !check.OK {
+			result = i18n.G("FAIL")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", check.Name, result, check.Detail)
+	}
+	w.Flush()
+}
@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdLint struct {
+	JSON       bool `long:"json"`
+	Positional struct {
+		Snap string `positional-arg-name:"dir|snap"`
+	} `positional-args:"yes"`
+}
+
+var (
+	shortLintHelp = i18n.G("Check a snap for problems")
+	longLintHelp  = i18n.G(`Check the given snap source directory (by default the current one) or snap file for problems: the package.yaml, the binaries and services, the security definitions, the readme, the icon and the license. Each problem is an error, a warning or an info; the command fails if there are errors.`)
+)
+
+func init() {
+	arg, err := parser.AddCommand("lint",
+		shortLintHelp,
+		longLintHelp,
+		&cmdLint{})
+	if err != nil {
+		logger.Panicf("Unable to lint: %v", err)
+	}
+	addOptionDescription(arg, "json", i18n.G("Show the problems as json"))
+	addOptionDescription(arg, "dir|snap", i18n.G("The snap source directory or snap file to check"))
+}
+
+func (x *cmdLint) Execute(args []string) error {
+	path := x.Positional.Snap
+	if path == "" {
+		path = "."
+	}
+
+	issues, err := snappy.Lint(path)
+	if err != nil {
+		return err
+	}
+
+	if x.JSON {
+		if err := showLintJSON(issues, os.Stdout); err != nil {
+			return err
+		}
+	} else {
+		showLint(issues, os.Stdout)
+	}
+
+	for _, issue := range issues {
+		if issue.Severity == snappy.LintError {
+			return errLintFailed
+		}
+	}
+
+	return nil
+}
+
+func showLint(issues []snappy.LintIssue, o io.Writer) {
+	if len(issues) == 0 {
+		return
+	}
+
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, i18n.G("Severity\tCheck\tFile\tProblem\t"))
+	for _, issue := range issues {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", issue.Severity, issue.Check, dashIfEmpty(issue.File), issue.Message)
+	}
+}
+
+func showLintJSON(issues []snappy.LintIssue, o io.Writer) error {
+	// an empty list, not null
+	if issues == nil {
+		issues = []snappy.LintIssue{}
+	}
+
+	out, err := json.MarshalIndent(issues, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(o, "%s\n", out)
+
+	return err
+}
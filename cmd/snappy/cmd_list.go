@@ -107,7 +107,7 @@ func showInstalledList(installed []snappy.Part, o io.Writer) {
 func showVerboseList(installed []snappy.Part, o io.Writer) {
 	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
 
-	fmt.Fprintln(w, i18n.G("Name\tDate\tVersion\tDeveloper\t"))
+	fmt.Fprintln(w, i18n.G("Name\tDate\tVersion\tDeveloper\tChannel\t"))
 	for _, part := range installed {
 		active := ""
 		if part.IsActive() {
@@ -119,7 +119,7 @@ func showVerboseList(installed []snappy.Part, o io.Writer) {
 			active = "!"
 		}
 
-		fmt.Fprintln(w, fmt.Sprintf("%s%s\t%s\t%s\t%s%s\t", part.Name(), needsReboot, formatDate(part.Date()), part.Version(), part.Origin(), active))
+		fmt.Fprintln(w, fmt.Sprintf("%s%s\t%s\t%s\t%s%s\t%s\t", part.Name(), needsReboot, formatDate(part.Date()), part.Version(), part.Origin(), active, part.Channel()))
 	}
 	w.Flush()
 
@@ -49,7 +49,7 @@ func init() {
 }
 
 func (x *cmdPurge) Execute(args []string) error {
-	return withMutexAndRetry(func() error {
+	return withPackageLockAndRetry(args, func() error {
 		return x.doPurge(args)
 	})
 }
@@ -64,7 +64,10 @@ func (x *cmdPurge) doPurge(args []string) error {
 		// TRANSLATORS: the %s is a pkgname
 		fmt.Printf(i18n.G("Purging %s\n"), part)
 
-		if err := snappy.Purge(part, flags, progress.MakeProgressBar()); err != nil {
+		err := snappy.RecordOp(snappy.HistoryPurge, part, cliInitiator(), func() (string, error) {
+			return "", snappy.Purge(part, flags, progress.MakeProgressBar())
+		})
+		if err != nil {
 			return err
 		}
 	}
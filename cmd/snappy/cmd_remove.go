@@ -44,7 +44,7 @@ func init() {
 }
 
 func (x *cmdRemove) Execute(args []string) (err error) {
-	return withMutexAndRetry(func() error {
+	return withPackageLockAndRetry(args, func() error {
 		return x.doRemove(args)
 	})
 }
@@ -59,7 +59,10 @@ func (x *cmdRemove) doRemove(args []string) error {
 		// TRANSLATORS: the %s is a pkgname
 		fmt.Printf(i18n.G("Removing %s\n"), part)
 
-		if err := snappy.Remove(part, flags, progress.MakeProgressBar()); err != nil {
+		err := snappy.RecordOp(snappy.HistoryRemove, part, cliInitiator(), func() (string, error) {
+			return "", snappy.Remove(part, flags, progress.MakeProgressBar())
+		})
+		if err != nil {
 			return err
 		}
 	}
@@ -53,7 +53,7 @@ func init() {
 }
 
 func (x *cmdRollback) Execute(args []string) (err error) {
-	return withMutexAndRetry(x.doRollback)
+	return withPackageLockAndRetry([]string{x.Positional.PackageName}, x.doRollback)
 }
 
 func (x *cmdRollback) doRollback() error {
@@ -63,7 +63,12 @@ func (x *cmdRollback) doRollback() error {
 		return errNeedPackageName
 	}
 
-	nowVersion, err := snappy.Rollback(pkg, version, progress.MakeProgressBar())
+	var nowVersion string
+	err := snappy.RecordOp(snappy.HistoryRollback, pkg, cliInitiator(), func() (string, error) {
+		var err error
+		nowVersion, err = snappy.Rollback(pkg, version, progress.MakeProgressBar())
+		return "", err
+	})
 	if err != nil {
 		return err
 	}
@@ -68,6 +68,16 @@ func init() {
 
 }
 
+// withLockAndRetry takes the lock of the snap, or the global lock if
+// no snap is given
+func (s *svcBase) withLockAndRetry(f func() error) error {
+	if s.Args.Snap == "" {
+		return withMutexAndRetry(f)
+	}
+
+	return withPackageLockAndRetry([]string{s.Args.Snap}, f)
+}
+
 const (
 	doStatus = iota
 	doStart
@@ -135,7 +145,7 @@ func (s *svcStatus) Execute(args []string) error {
 }
 
 func (s *svcLogs) Execute([]string) error {
-	return withMutexAndRetry(func() error {
+	return s.withLockAndRetry(func() error {
 		logs, err := s.doExecute(doLogs)
 		if err != nil {
 			return err
@@ -150,35 +160,35 @@ func (s *svcLogs) Execute([]string) error {
 }
 
 func (s *svcStart) Execute(args []string) error {
-	return withMutexAndRetry(func() error {
+	return s.withLockAndRetry(func() error {
 		_, err := s.doExecute(doStart)
 		return err
 	})
 }
 
 func (s *svcStop) Execute(args []string) error {
-	return withMutexAndRetry(func() error {
+	return s.withLockAndRetry(func() error {
 		_, err := s.doExecute(doStop)
 		return err
 	})
 }
 
 func (s *svcRestart) Execute(args []string) error {
-	return withMutexAndRetry(func() error {
+	return s.withLockAndRetry(func() error {
 		_, err := s.doExecute(doRestart)
 		return err
 	})
 }
 
 func (s *svcEnable) Execute(args []string) error {
-	return withMutexAndRetry(func() error {
+	return s.withLockAndRetry(func() error {
 		_, err := s.doExecute(doEnable)
 		return err
 	})
 }
 
 func (s *svcDisable) Execute(args []string) error {
-	return withMutexAndRetry(func() error {
+	return s.withLockAndRetry(func() error {
 		_, err := s.doExecute(doDisable)
 		return err
 	})
@@ -37,9 +37,15 @@ var setHelp = i18n.G(`Set properties of system or package
 
 Supported properties are:
   active=VERSION
+  channel=CHANNEL
+
+Supported system properties are:
+  parallel-origins=true|false
 
 Example:
   set hello-world active=1.0
+  set hello-world channel=edge
+  set parallel-origins=true
 `)
 
 func init() {
@@ -54,7 +60,12 @@ func init() {
 
 func (x *cmdSet) Execute(args []string) (err error) {
 	x.args = args
-	return withMutexAndRetry(x.doSet)
+	pkgname, _, err := parseSetPropertyCmdline(args...)
+	if err != nil {
+		return err
+	}
+
+	return withPackageLockAndRetry([]string{pkgname}, x.doSet)
 }
 
 func (x *cmdSet) doSet() (err error) {
@@ -63,7 +74,9 @@ func (x *cmdSet) doSet() (err error) {
 		return err
 	}
 
-	return snappy.SetProperty(pkgname, progress.MakeProgressBar(), args...)
+	return snappy.RecordOp(snappy.HistorySet, pkgname, cliInitiator(), func() (string, error) {
+		return "", snappy.SetProperty(pkgname, progress.MakeProgressBar(), args...)
+	})
 }
 
 func parseSetPropertyCmdline(args ...string) (pkgname string, out []string, err error) {
@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdSnapshot struct {
+	Save    snapshotSave    `command:"save"`
+	Restore snapshotRestore `command:"restore"`
+}
+
+type snapshotSave struct {
+	Args struct {
+		Snap string `positional-arg-name:"snap"`
+	} `required:"true" positional-args:"yes"`
+}
+
+type snapshotRestore struct {
+	Args struct {
+		Snap     string `positional-arg-name:"snap"`
+		Snapshot string `positional-arg-name:"snapshot file"`
+	} `required:"true" positional-args:"yes"`
+}
+
+var (
+	shortSnapshotHelp = i18n.G("Save and restore the data of a package")
+	longSnapshotHelp  = i18n.G(`Save the data of the active version of a package, for the system and for all users, to a tarball in /var/lib/snappy/snapshots, or replace that data with the data in such a tarball. The package is stopped while its data is restored.`)
+)
+
+func init() {
+	_, err := parser.AddCommand("snapshot",
+		shortSnapshotHelp,
+		longSnapshotHelp,
+		&cmdSnapshot{})
+	if err != nil {
+		logger.Panicf("Unable to snapshot: %v", err)
+	}
+}
+
+func (x *snapshotSave) Execute(args []string) error {
+	return withPackageLockAndRetry([]string{x.Args.Snap}, func() error {
+		path, err := snappy.SnapshotSave(x.Args.Snap)
+		if err != nil {
+			return err
+		}
+
+		// TRANSLATORS: the first %s is a pkgname, the second a path
+		fmt.Printf(i18n.G("Saved the data of %s to %s\n"), x.Args.Snap, path)
+
+		return nil
+	})
+}
+
+func (x *snapshotRestore) Execute(args []string) error {
+	return withPackageLockAndRetry([]string{x.Args.Snap}, func() error {
+		// TRANSLATORS: the first %s is a pkgname, the second a path
+		fmt.Printf(i18n.G("Restoring the data of %s from %s\n"), x.Args.Snap, x.Args.Snapshot)
+
+		return snappy.SnapshotRestore(x.Args.Snap, x.Args.Snapshot, progress.MakeProgressBar())
+	})
+}
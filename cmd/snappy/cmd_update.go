@@ -66,7 +66,9 @@ func (x *cmdUpdate) doUpdate() error {
 		flags = 0
 	}
 
-	updates, err := snappy.Update(flags, progress.MakeProgressBar())
+	updates, err := snappy.RecordUpdates(cliInitiator(), func() ([]snappy.Part, error) {
+		return snappy.Update(flags, progress.MakeProgressBar())
+	})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdVerify struct {
+	Repair     bool `long:"repair"`
+	Positional struct {
+		PackageName string `positional-arg-name:"package name"`
+	} `positional-args:"yes"`
+}
+
+var (
+	shortVerifyHelp = i18n.G("Check the files of installed packages")
+	longVerifyHelp  = i18n.G(`Check the files of the active version of the given package, or of all active packages, against the hashes they were installed with, and show the files that were modified, are missing, are extra or had their mode changed. With --repair the modified and missing files and the changed modes are put back from the snap file the package was installed from, if it was kept; extra files are left alone.`)
+)
+
+func init() {
+	arg, err := parser.AddCommand("verify",
+		shortVerifyHelp,
+		longVerifyHelp,
+		&cmdVerify{})
+	if err != nil {
+		logger.Panicf("Unable to verify: %v", err)
+	}
+	addOptionDescription(arg, "repair", i18n.G("Put back the damaged files"))
+	addOptionDescription(arg, "package name", i18n.G("Check this package only"))
+}
+
+func (x *cmdVerify) Execute(args []string) error {
+	if !x.Repair {
+		return x.doVerify()
+	}
+
+	if x.Positional.PackageName == "" {
+		return withMutexAndRetry(x.doVerify)
+	}
+
+	return withPackageLockAndRetry([]string{x.Positional.PackageName}, x.doVerify)
+}
+
+func (x *cmdVerify) doVerify() error {
+	results, err := snappy.Verify(x.Positional.PackageName, x.Repair, progress.MakeProgressBar())
+	if err != nil {
+		return err
+	}
+
+	showVerify(results, os.Stdout)
+
+	for _, r := range results {
+		if !r.OK() {
+			return errVerifyFailed
+		}
+	}
+
+	return nil
+}
+
+func showVerify(results []*snappy.VerifyResult, o io.Writer) {
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+
+	fmt.Fprintln(w, i18n.G("Name\tVersion\tDeveloper\tFile\tProblem\tRepaired\t"))
+	for _, r := range results {
+		for _, p := range r.Problems {
+			repaired := i18n.G("no")
+			if p.Repaired {
+				repaired = i18n.G("yes")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", r.Name, r.Version, dashIfEmpty(r.Origin), p.Path, p.Kind, repaired)
+		}
+	}
+	w.Flush()
+
+	for _, r := range results {
+		if r.NotVerifiable {
+			// TRANSLATORS: the first %s is a pkgname, the second a version
+			fmt.Fprintf(o, i18n.G("%s (%s) can not be verified, it has no hashes\n"), r.Name, r.Version)
+		}
+		if r.HashesUnchecked {
+			// TRANSLATORS: the first %s is a pkgname, the second a version
+			fmt.Fprintf(o, i18n.G("The hashes of %s (%s) could not be checked, the snap file it was installed from was not kept\n"), r.Name, r.Version)
+		}
+	}
+}
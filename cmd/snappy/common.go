@@ -21,19 +21,20 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"os/user"
 	"strings"
 	"time"
 
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/priv"
+	"github.com/ubuntu-core/snappy/snappy"
 
 	"github.com/jessevdk/go-flags"
 )
 
-const snappyLockFile = "/run/snappy.lock"
-
 func isAutoPilotRunning() bool {
 	unitName := "snappy-autopilot"
 	bs, err := exec.Command("systemctl", "show", "--property=SubState", unitName).CombinedOutput()
@@ -44,13 +45,34 @@ func isAutoPilotRunning() bool {
 	return strings.TrimSpace(string(bs)) == "SubState=running"
 }
 
-// withMutexAndRetry runs the given function with a filelock mutex and provides
+// withMutexAndRetry runs the given function holding the global lock, so
+// no other privileged operation runs at the same time, and provides
 // automatic re-try and helpful messages if the lock is already taken
 func withMutexAndRetry(f func() error) error {
+	return withLockAndRetry(func(op string) (*snappy.OpLock, error) {
+		return snappy.LockSystem(op)
+	}, f)
+}
+
+// withPackageLockAndRetry runs the given function holding the locks of
+// the given packages, so other packages can be worked on at the same
+// time, with the same re-try as withMutexAndRetry
+func withPackageLockAndRetry(pkgs []string, f func() error) error {
+	return withLockAndRetry(func(op string) (*snappy.OpLock, error) {
+		return snappy.LockPackages(op, pkgs...)
+	}, f)
+}
+
+func withLockAndRetry(lock func(op string) (*snappy.OpLock, error), f func() error) error {
+	if err := priv.CheckRoot(); err != nil {
+		return err
+	}
+
+	op := "snappy " + strings.Join(os.Args[1:], " ")
 	for {
-		err := priv.WithMutex(snappyLockFile, f)
+		l, err := lock(op)
 		// if already locked, auto-retry
-		if err == priv.ErrAlreadyLocked {
+		if e, ok := err.(*snappy.ErrPrivOpInProgress); ok {
 			var msg string
 			if isAutoPilotRunning() {
 				// FIXME: we could even do a
@@ -62,10 +84,11 @@ take some minutes. Will try again in %d seconds...
 Press ctrl-c to cancel.
 `)
 			} else {
-				msg = i18n.G(
-					`Another snappy is running, will try again in %d seconds...
+				// TRANSLATORS: the %s is what the other snappy is doing
+				msg = fmt.Sprintf(i18n.G(
+					`Another snappy is running (%s), will try again in %%d seconds...
 Press ctrl-c to cancel.
-`)
+`), e.Op)
 			}
 			// wait a wee bit
 			wait := 5
@@ -73,9 +96,26 @@ Press ctrl-c to cancel.
 			time.Sleep(time.Duration(wait) * time.Second)
 			continue
 		}
+		if err != nil {
+			return err
+		}
+		defer l.Unlock()
 
-		return err
+		return f()
+	}
+}
+
+// cliInitiator returns who is recorded in the history as running the
+// current command: the user that ran it through sudo, if so
+func cliInitiator() string {
+	who := os.Getenv("SUDO_USER")
+	if who == "" {
+		if u, err := user.Current(); err == nil {
+			who = u.Username
+		}
 	}
+
+	return "cli:" + who
 }
 
 // addOptionDescription will try to find the given longName in the
@@ -23,4 +23,6 @@ import "errors"
 
 var (
 	errNeedPackageName = errors.New("need package name argument")
+	errVerifyFailed    = errors.New("some files do not match what was installed")
+	errLintFailed      = errors.New("the snap has errors")
 )
@@ -52,6 +52,9 @@ var api = []*Command{
 	packageSvcCmd,
 	packageSvcsCmd,
 	packageSvcLogsCmd,
+	packagePreflightCmd,
+	packageDataCmd,
+	historyCmd,
 	operationCmd,
 }
 
@@ -112,6 +115,22 @@ var (
 		GET:  getLogs,
 	}
 
+	packagePreflightCmd = &Command{
+		Path: "/1.0/packages/{name}.{origin}/preflight",
+		GET:  getPackagePreflight,
+	}
+
+	packageDataCmd = &Command{
+		Path: "/1.0/packages/{name}.{origin}/data",
+		GET:  getPackageData,
+		PUT:  putPackageData,
+	}
+
+	historyCmd = &Command{
+		Path: "/1.0/history",
+		GET:  getHistory,
+	}
+
 	operationCmd = &Command{
 		Path:   "/1.0/operations/{uuid}",
 		GET:    getOpInfo,
@@ -213,6 +232,107 @@ func webify(result map[string]string, resource string) map[string]string {
 	return result
 }
 
+var preflight = snappy.PreflightUpdate
+
+func getPackagePreflight(c *Command, r *http.Request) Response {
+	vars := muxVars(r)
+	name := vars["name"]
+	origin := vars["origin"]
+	if name == "" || origin == "" {
+		return BadRequest(nil, "missing name or origin")
+	}
+
+	// an installed package is checked for an update
+	report, err := preflight(name+"."+origin, "", 0)
+	switch err {
+	case nil:
+		return SyncResponse(report)
+	case snappy.ErrPackageNotFound:
+		return NotFound
+	default:
+		return InternalError(err, "unable to check %s.%s: %v", name, origin, err)
+	}
+}
+
+var (
+	exportData     = snappy.ExportData
+	importData     = snappy.ImportData
+	readDataExport = snappy.ReadDataExport
+)
+
+func getPackageData(c *Command, r *http.Request) Response {
+	vars := muxVars(r)
+	name := vars["name"]
+	origin := vars["origin"]
+	if name == "" || origin == "" {
+		return BadRequest(nil, "missing name or origin")
+	}
+
+	bag := lightweight.PartBagByName(name, origin)
+	if bag == nil || bag.ActiveIndex() < 0 {
+		return NotFound
+	}
+
+	tmpf, err := ioutil.TempFile("", "snapd-data-export-")
+	if err != nil {
+		return InternalError(err, "can't create tempfile: %v", err)
+	}
+	defer tmpf.Close()
+
+	if err := exportData(name, tmpf); err != nil {
+		os.Remove(tmpf.Name())
+		return InternalError(err, "unable to export data of %s.%s: %v", name, origin, err)
+	}
+
+	return tempFileResponse(tmpf.Name())
+}
+
+func putPackageData(c *Command, r *http.Request) Response {
+	route := c.d.router.Get(operationCmd.Path)
+	if route == nil {
+		return InternalError(nil, "router can't find route for operation")
+	}
+
+	vars := muxVars(r)
+	name := vars["name"]
+	origin := vars["origin"]
+	if name == "" || origin == "" {
+		return BadRequest(nil, "missing name or origin")
+	}
+
+	tmpf, err := ioutil.TempFile("", "snapd-data-import-")
+	if err != nil {
+		return InternalError(err, "can't create tempfile: %v", err)
+	}
+	defer tmpf.Close()
+
+	if _, err := io.Copy(tmpf, r.Body); err != nil {
+		os.Remove(tmpf.Name())
+		return InternalError(err, "can't copy request into tempfile: %v", err)
+	}
+
+	info, err := readDataExport(tmpf.Name())
+	if err != nil {
+		os.Remove(tmpf.Name())
+		return BadRequest(err, "unable to read data export: %v", err)
+	}
+	if info.Name != name || info.Origin != origin {
+		os.Remove(tmpf.Name())
+		return BadRequest(nil, "data export is for %s.%s", info.Name, info.Origin)
+	}
+
+	return AsyncResponse(c.d.AddTask(withPackageLock("import data of "+name, []string{name}, func() interface{} {
+		defer os.Remove(tmpf.Name())
+
+		info, err := importData(tmpf.Name(), &progress.NullProgress{})
+		if err != nil {
+			return err
+		}
+
+		return info
+	})).Map(route))
+}
+
 type byQN []snappy.Part
 
 func (ps byQN) Len() int      { return len(ps) }
@@ -393,7 +513,7 @@ func packageService(c *Command, r *http.Request) Response {
 		return SyncResponse(f())
 	}
 
-	return AsyncResponse(c.d.AddTask(func() interface{} {
+	return AsyncResponse(c.d.AddTask(withPackageLock(action+" services of "+pkgName, []string{pkgName}, func() interface{} {
 		switch action {
 		case "start":
 			err = actor.Start()
@@ -413,7 +533,7 @@ func packageService(c *Command, r *http.Request) Response {
 		}
 
 		return f()
-	}).Map(route))
+	})).Map(route))
 }
 
 func packageConfig(c *Command, r *http.Request) Response {
@@ -445,7 +565,24 @@ func packageConfig(c *Command, r *http.Request) Response {
 		return BadRequest(err, "reading config request body gave %v", err)
 	}
 
-	config, err := part.Config(bs)
+	var config string
+	configure := func() (string, error) {
+		var err error
+		config, err = part.Config(bs)
+		return "", err
+	}
+	if r.Method == "PUT" {
+		var lock *snappy.OpLock
+		lock, err = snappy.LockPackages("snapd configure "+pkgName, pkgName)
+		if err != nil {
+			return InternalError(err, "unable to configure %s: %v", pkgName, err)
+		}
+		defer lock.Unlock()
+
+		err = snappy.RecordOp(snappy.HistoryConfig, pkgName, requestInitiator(r), configure)
+	} else {
+		_, err = configure()
+	}
 	if err != nil {
 		return InternalError(err, "unable to retrieve config for %s: %v", pkgName, err)
 	}
@@ -470,7 +607,13 @@ func configMulti(c *Command, r *http.Request) Response {
 		return BadRequest(err, "can't decode request body into map[string]string: %v", err)
 	}
 
-	return AsyncResponse(c.d.AddTask(func() interface{} {
+	pkgs := make([]string, 0, len(pkgmap))
+	for pkg := range pkgmap {
+		pkgs = append(pkgs, pkg)
+	}
+	initiator := requestInitiator(r)
+
+	return AsyncResponse(c.d.AddTask(withPackageLock("configure", pkgs, func() interface{} {
 		rspmap := make(map[string]*configSubtask, len(pkgmap))
 		bags := lightweight.AllPartBags()
 		for pkg, cfg := range pkgmap {
@@ -491,7 +634,12 @@ func configMulti(c *Command, r *http.Request) Response {
 				continue
 			}
 
-			config, err := part.Config([]byte(cfg))
+			var config string
+			err := snappy.RecordOp(snappy.HistoryConfig, pkg, initiator, func() (string, error) {
+				var err error
+				config, err = part.Config([]byte(cfg))
+				return "", err
+			})
 			if err != nil {
 				out.Msg = "Config failed"
 				out.Str = err.Error()
@@ -503,7 +651,7 @@ func configMulti(c *Command, r *http.Request) Response {
 		}
 
 		return rspmap
-	}).Map(route))
+	})).Map(route))
 }
 
 func getOpInfo(c *Command, r *http.Request) Response {
@@ -538,10 +686,12 @@ func deleteOp(c *Command, r *http.Request) Response {
 }
 
 type packageInstruction struct {
-	Action   string `json:"action"`
-	LeaveOld bool   `json:"leave_old"`
-	pkg      string
-	prog     progress.Meter
+	Action    string `json:"action"`
+	LeaveOld  bool   `json:"leave_old"`
+	Channel   string `json:"channel"`
+	pkg       string
+	prog      progress.Meter
+	initiator string
 }
 
 func (inst *packageInstruction) install() interface{} {
@@ -549,7 +699,7 @@ func (inst *packageInstruction) install() interface{} {
 	if inst.LeaveOld {
 		flags = 0
 	}
-	_, err := snappy.Install(inst.pkg, flags, inst.prog)
+	_, err := snappy.InstallFromChannel(inst.pkg, inst.Channel, flags, inst.prog)
 
 	if err != nil {
 		return err
@@ -576,10 +726,12 @@ func (inst *packageInstruction) update() interface{} {
 
 	for _, part := range parts {
 		if snappy.QualifiedName(part) == inst.pkg {
-			if _, err := part.Install(inst.prog, flags); err != nil {
-				return err
-			}
-			return snappy.GarbageCollect(inst.pkg, flags, inst.prog)
+			return snappy.RecordOp(snappy.HistoryUpdate, inst.pkg, inst.initiator, func() (string, error) {
+				if _, err := part.Install(inst.prog, flags); err != nil {
+					return "", err
+				}
+				return "", snappy.GarbageCollect(inst.pkg, flags, inst.prog)
+			})
 		}
 	}
 
@@ -655,13 +807,72 @@ func postPackage(c *Command, r *http.Request) Response {
 	vars := muxVars(r)
 	inst.pkg = vars["name"] + "." + vars["origin"]
 	inst.prog = &progress.NullProgress{}
+	inst.initiator = requestInitiator(r)
 
 	f := pkgActionDispatch(&inst)
 	if f == nil {
 		return BadRequest(nil, "unknown action %s", inst.Action)
 	}
+	if inst.Action != "update" {
+		// updates are only recorded if there is one
+		f = withHistory(inst.Action, inst.pkg, inst.initiator, f)
+	}
+
+	return AsyncResponse(c.d.AddTask(withPackageLock(inst.Action+" "+inst.pkg, []string{inst.pkg}, f)).Map(route))
+}
+
+// withPackageLock returns a task that runs f holding the locks of the
+// given packages; the task fails if they are held by someone else
+func withPackageLock(op string, pkgs []string, f func() interface{}) func() interface{} {
+	return func() interface{} {
+		lock, err := snappy.LockPackages("snapd "+op, pkgs...)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		return f()
+	}
+}
+
+// withSystemLock returns a task that runs f holding the global lock;
+// the task fails if it is held by someone else
+func withSystemLock(op string, f func() interface{}) func() interface{} {
+	return func() interface{} {
+		lock, err := snappy.LockSystem("snapd " + op)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		return f()
+	}
+}
+
+// withHistory returns a task that runs f, which does op on the given
+// package, and records it in the history
+func withHistory(op, pkg, initiator string, f func() interface{}) func() interface{} {
+	return func() interface{} {
+		var result interface{}
+		snappy.RecordOp(op, pkg, initiator, func() (string, error) {
+			result = f()
+			err, _ := result.(error)
+			return "", err
+		})
 
-	return AsyncResponse(c.d.AddTask(f).Map(route))
+		return result
+	}
+}
+
+// requestInitiator returns who is recorded in the history as doing
+// what the given request asks for; for requests over the unix socket
+// that is the uid of the peer, e.g. "snapd:uid=1000"
+func requestInitiator(r *http.Request) string {
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return "snapd:local"
+	}
+
+	return "snapd:" + r.RemoteAddr
 }
 
 const maxReadBuflen = 1024 * 1024
@@ -732,21 +943,39 @@ func sideloadPackage(c *Command, r *http.Request) Response {
 		return InternalError(err, "can't copy request into tempfile: %v", err)
 	}
 
-	return AsyncResponse(c.d.AddTask(func() interface{} {
+	// the name of the package is not known until it is opened
+	initiator := requestInitiator(r)
+	return AsyncResponse(c.d.AddTask(withSystemLock("sideload", func() interface{} {
 		defer os.Remove(tmpf.Name())
 
-		part, err := newSnap(tmpf.Name(), snappy.SideloadedOrigin, unsignedOk)
-		if err != nil {
-			return err
-		}
+		var name string
+		err := snappy.RecordOp(snappy.HistoryInstall, tmpf.Name(), initiator, func() (string, error) {
+			part, err := newSnap(tmpf.Name(), snappy.SideloadedOrigin, unsignedOk)
+			if err != nil {
+				return "", err
+			}
 
-		name, err := part.Install(&progress.NullProgress{}, 0)
+			name, err = part.Install(&progress.NullProgress{}, 0)
+			return name, err
+		})
 		if err != nil {
 			return err
 		}
 
 		return name
-	}).Map(route))
+	})).Map(route))
+}
+
+func getHistory(c *Command, r *http.Request) Response {
+	entries, err := snappy.History(r.URL.Query().Get("package"))
+	if err != nil {
+		return InternalError(err, "unable to read the history: %v", err)
+	}
+	if entries == nil {
+		entries = []*snappy.HistoryEntry{}
+	}
+
+	return SyncResponse(entries)
 }
 
 func getLogs(c *Command, r *http.Request) Response {
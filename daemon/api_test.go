@@ -271,13 +271,17 @@ func (s *apiSuite) TestListIncludesAll(c *check.C) {
 
 	exceptions := []string{ // keep sorted, for scanning ease
 		"api",
+		"exportData",
 		"findServices",
+		"importData",
 		"maxReadBuflen",
 		"muxVars",
 		"newRemoteRepo",
 		"newSystemRepo",
 		"newSnap",
 		"pkgActionDispatch",
+		"preflight",
+		"readDataExport",
 	}
 	c.Check(found, check.Equals, len(api)+len(exceptions),
 		check.Commentf(`At a glance it looks like you've not added all the Commands defined in api to the api list. If that is not the case, please add the exception to the "exceptions" list in this test.`))
@@ -687,6 +691,30 @@ func (s *apiSuite) TestPackagePutConfig(c *check.C) {
 	})
 }
 
+func (s *apiSuite) TestPackagePutConfigLocked(c *check.C) {
+	req, err := http.NewRequest("PUT", "/1.0/packages/foo.bar/config", bytes.NewBufferString("some other config"))
+	c.Assert(err, check.IsNil)
+
+	oldConcrete := lightweight.NewConcrete
+	defer func() {
+		lightweight.NewConcrete = oldConcrete
+	}()
+	lightweight.NewConcrete = func(*lightweight.PartBag, string) lightweight.Concreter {
+		return &cfgc{cfg: "some: config"}
+	}
+
+	s.vars = map[string]string{"name": "foo", "origin": "bar"}
+	s.mkInstalled(c, "foo", "bar", "v1", true, "")
+
+	lock, err := snappy.LockPackages("install foo", "foo")
+	c.Assert(err, check.IsNil)
+	defer lock.Unlock()
+
+	rsp := packageConfig(packageConfigCmd, req).Self(nil, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusInternalServerError)
+	c.Check(rsp.Result.(*errorResult).Obj, check.FitsTypeOf, &snappy.ErrPrivOpInProgress{})
+}
+
 func (s *apiSuite) TestPackagePutConfigMissing(c *check.C) {
 	s.vars = map[string]string{"name": "foo", "origin": "bar"}
 
@@ -913,6 +941,14 @@ func (s *apiSuite) sideloadCheck(c *check.C, content string, unsignedExpected bo
 	c.Check(rsp.Type, check.Equals, ResponseTypeAsync)
 
 	<-ch
+
+	// the next sideload needs the lock this one holds
+	uuid := rsp.Result.(map[string]interface{})["resource"].(string)[16:]
+	task := packagesCmd.d.GetTask(uuid)
+	c.Assert(task, check.NotNil)
+	for task.State() == TaskRunning {
+		time.Sleep(time.Millisecond)
+	}
 }
 
 func (s *apiSuite) TestServiceLogs(c *check.C) {
@@ -935,3 +971,233 @@ func (s *apiSuite) TestServiceLogs(c *check.C) {
 		Result: []map[string]interface{}{{"message": "hi", "timestamp": "42", "raw": log}},
 	})
 }
+
+func (s *apiSuite) TestPackagePreflight(c *check.C) {
+	s.vars = map[string]string{"name": "foo", "origin": "bar"}
+
+	report := &snappy.PreflightReport{
+		Name:    "foo",
+		Origin:  "bar",
+		Version: "1.0",
+		Checks:  []snappy.PreflightCheck{{Name: "architecture", OK: true}},
+	}
+	var asked string
+	preflight = func(name, channel string, flags snappy.InstallFlags) (*snappy.PreflightReport, error) {
+		asked = name
+		return report, nil
+	}
+	defer func() { preflight = snappy.PreflightUpdate }()
+
+	rsp := getPackagePreflight(packagePreflightCmd, nil).Self(nil, nil).(*resp)
+	c.Check(asked, check.Equals, "foo.bar")
+	c.Check(rsp, check.DeepEquals, &resp{
+		Type:   ResponseTypeSync,
+		Status: http.StatusOK,
+		Result: report,
+	})
+}
+
+func (s *apiSuite) TestPackagePreflightNotFound(c *check.C) {
+	s.vars = map[string]string{"name": "foo", "origin": "bar"}
+
+	preflight = func(string, string, snappy.InstallFlags) (*snappy.PreflightReport, error) {
+		return nil, snappy.ErrPackageNotFound
+	}
+	defer func() { preflight = snappy.PreflightUpdate }()
+
+	rsp := getPackagePreflight(packagePreflightCmd, nil).Self(nil, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusNotFound)
+}
+
+func (s *apiSuite) TestPackageDataExport(c *check.C) {
+	s.vars = map[string]string{"name": "foo", "origin": "bar"}
+	s.mkInstalled(c, "foo", "bar", "v1", true, "")
+
+	var asked string
+	exportData = func(name string, w io.Writer) error {
+		asked = name
+		_, err := w.Write([]byte("data"))
+		return err
+	}
+	defer func() { exportData = snappy.ExportData }()
+
+	req, err := http.NewRequest("GET", "/1.0/packages/foo.bar/data", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp, ok := getPackageData(packageDataCmd, req).(tempFileResponse)
+	c.Assert(ok, check.Equals, true)
+	c.Check(asked, check.Equals, "foo")
+
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, http.StatusOK)
+	c.Check(rec.Body.String(), check.Equals, "data")
+
+	_, err = os.Stat(string(rsp))
+	c.Check(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *apiSuite) TestPackageDataExportNotInstalled(c *check.C) {
+	s.vars = map[string]string{"name": "foo", "origin": "bar"}
+
+	rsp := getPackageData(packageDataCmd, nil).Self(nil, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusNotFound)
+}
+
+func (s *apiSuite) TestPackageDataImport(c *check.C) {
+	d := newTestDaemon()
+	s.vars = map[string]string{"name": "foo", "origin": "bar"}
+
+	info := &snappy.DataExportInfo{Name: "foo", Origin: "bar", Version: "v1"}
+	readDataExport = func(fn string) (*snappy.DataExportInfo, error) {
+		bs, err := ioutil.ReadFile(fn)
+		c.Check(err, check.IsNil)
+		c.Check(string(bs), check.Equals, "xyzzy")
+
+		return info, nil
+	}
+	importData = func(string, progress.Meter) (*snappy.DataExportInfo, error) {
+		return info, nil
+	}
+	defer func() {
+		readDataExport = snappy.ReadDataExport
+		importData = snappy.ImportData
+	}()
+
+	req, err := http.NewRequest("PUT", "/1.0/packages/foo.bar/data", bytes.NewBufferString("xyzzy"))
+	c.Assert(err, check.IsNil)
+
+	rsp := putPackageData(packageDataCmd, req).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeAsync)
+
+	uuid := rsp.Result.(map[string]interface{})["resource"].(string)[16:]
+	task := d.GetTask(uuid)
+	c.Assert(task, check.NotNil)
+	for task.State() == TaskRunning {
+		time.Sleep(time.Millisecond)
+	}
+	c.Check(task.State(), check.Equals, TaskSucceeded)
+	c.Check(task.Output(), check.Equals, info)
+}
+
+func (s *apiSuite) TestPackageDataImportOtherPackage(c *check.C) {
+	newTestDaemon()
+	s.vars = map[string]string{"name": "foo", "origin": "bar"}
+
+	readDataExport = func(string) (*snappy.DataExportInfo, error) {
+		return &snappy.DataExportInfo{Name: "foo", Origin: "baz", Version: "v1"}, nil
+	}
+	defer func() { readDataExport = snappy.ReadDataExport }()
+
+	req, err := http.NewRequest("PUT", "/1.0/packages/foo.bar/data", bytes.NewBufferString("xyzzy"))
+	c.Assert(err, check.IsNil)
+
+	rsp := putPackageData(packageDataCmd, req).Self(nil, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+}
+
+func (s *apiSuite) TestPostPackageLocked(c *check.C) {
+	d := newTestDaemon()
+	s.vars = map[string]string{"name": "foo", "origin": "bar"}
+
+	pkgActionDispatch = func(*packageInstruction) func() interface{} {
+		return func() interface{} {
+			c.Fatal("ran while locked")
+			return nil
+		}
+	}
+	defer func() {
+		pkgActionDispatch = pkgActionDispatchImpl
+	}()
+
+	lock, err := snappy.LockPackages("install foo", "foo")
+	c.Assert(err, check.IsNil)
+	defer lock.Unlock()
+
+	req, err := http.NewRequest("POST", "/1.0/packages/foo.bar", bytes.NewBufferString(`{"action": "remove"}`))
+	c.Assert(err, check.IsNil)
+
+	rsp := postPackage(packageCmd, req).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeAsync)
+
+	uuid := rsp.Result.(map[string]interface{})["resource"].(string)[16:]
+	task := d.GetTask(uuid)
+	c.Assert(task, check.NotNil)
+	for task.State() == TaskRunning {
+		time.Sleep(time.Millisecond)
+	}
+	c.Check(task.State(), check.Equals, TaskFailed)
+	c.Check(task.Output().(errorResult).Obj, check.FitsTypeOf, &snappy.ErrPrivOpInProgress{})
+}
+
+func (s *apiSuite) TestPostPackageRecordsHistory(c *check.C) {
+	d := newTestDaemon()
+	s.vars = map[string]string{"name": "foo", "origin": "bar"}
+
+	pkgActionDispatch = func(*packageInstruction) func() interface{} {
+		return func() interface{} {
+			return errors.New("boom")
+		}
+	}
+	defer func() {
+		pkgActionDispatch = pkgActionDispatchImpl
+	}()
+
+	req, err := http.NewRequest("POST", "/1.0/packages/foo.bar", bytes.NewBufferString(`{"action": "remove"}`))
+	c.Assert(err, check.IsNil)
+
+	rsp := postPackage(packageCmd, req).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeAsync)
+
+	uuid := rsp.Result.(map[string]interface{})["resource"].(string)[16:]
+	task := d.GetTask(uuid)
+	c.Assert(task, check.NotNil)
+	for task.State() == TaskRunning {
+		time.Sleep(time.Millisecond)
+	}
+	c.Check(task.State(), check.Equals, TaskFailed)
+
+	entries, err := snappy.History("foo")
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Check(entries[0].Op, check.Equals, snappy.HistoryRemove)
+	c.Check(entries[0].Origin, check.Equals, "bar")
+	c.Check(entries[0].Initiator, check.Equals, "snapd:local")
+	c.Check(entries[0].Outcome, check.Equals, "boom")
+}
+
+func (s *apiSuite) TestRequestInitiator(c *check.C) {
+	req, err := http.NewRequest("POST", "/1.0/packages/foo.bar", nil)
+	c.Assert(err, check.IsNil)
+
+	c.Check(requestInitiator(req), check.Equals, "snapd:local")
+
+	req.RemoteAddr = "uid=1000"
+	c.Check(requestInitiator(req), check.Equals, "snapd:uid=1000")
+}
+
+func (s *apiSuite) TestGetHistory(c *check.C) {
+	for _, name := range []string{"foo", "baz"} {
+		c.Assert(snappy.RecordHistory(&snappy.HistoryEntry{
+			Op:        snappy.HistoryInstall,
+			Name:      name,
+			Origin:    "bar",
+			Initiator: "cli:root",
+			Outcome:   "ok",
+		}), check.IsNil)
+	}
+
+	req, err := http.NewRequest("GET", "/1.0/history?package=foo.bar", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getHistory(historyCmd, req).Self(nil, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeSync)
+	entries := rsp.Result.([]*snappy.HistoryEntry)
+	c.Assert(entries, check.HasLen, 1)
+	c.Check(entries[0].Name, check.Equals, "foo")
+
+	req, err = http.NewRequest("GET", "/1.0/history", nil)
+	c.Assert(err, check.IsNil)
+	rsp = getHistory(historyCmd, req).Self(nil, nil).(*resp)
+	c.Check(rsp.Result, check.HasLen, 2)
+}
@@ -120,7 +120,8 @@ func (d *Daemon) Init() error {
 		return fmt.Errorf("daemon does not handle %d listeners right now, just one", len(listeners))
 	}
 
-	d.listener = listeners[0]
+	// so that requests can tell who sent them
+	d.listener = &ucrednetListener{listeners[0]}
 
 	d.addRoutes()
 
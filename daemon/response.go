@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 
 	"github.com/ubuntu-core/snappy/logger"
 )
@@ -163,6 +164,19 @@ func (f FileResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, string(f))
 }
 
+// A tempFileResponse 's ServeHTTP method serves the file, and removes it
+// afterwards
+type tempFileResponse string
+
+// Self from the Response interface
+func (f tempFileResponse) Self(*Command, *http.Request) Response { return f }
+
+// ServeHTTP from the Response interface
+func (f tempFileResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer os.Remove(string(f))
+	http.ServeFile(w, r, string(f))
+}
+
 // ErrorResponseFunc is a callable error Response.
 // So you can return e.g. InternalError, or InternalError(err, "something broke"), etc.
 type ErrorResponseFunc func(error, string, ...interface{}) Response
@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// ucrednetAddr is the address of the peer of a unix socket connection,
+// as told by the kernel via SO_PEERCRED
+type ucrednetAddr struct {
+	net.Addr
+	uid uint32
+}
+
+func (a *ucrednetAddr) String() string {
+	return fmt.Sprintf("uid=%d", a.uid)
+}
+
+type ucrednetConn struct {
+	net.Conn
+	addr *ucrednetAddr
+}
+
+func (c *ucrednetConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// ucrednetListener wraps a listener so that the unix socket connections
+// it accepts have the uid of their peer as their remote address, which
+// is what http.Request.RemoteAddr is then set to
+type ucrednetListener struct {
+	net.Listener
+}
+
+func (l *ucrednetListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		unixConn, ok := conn.(*net.UnixConn)
+		if !ok {
+			return conn, nil
+		}
+
+		ucred, err := peerCred(unixConn)
+		if err != nil {
+			// don't serve a peer we can not tell apart
+			logger.Noticef("Can not get the credentials of %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+
+		return &ucrednetConn{
+			Conn: conn,
+			addr: &ucrednetAddr{Addr: conn.RemoteAddr(), uid: ucred.Uid},
+		}, nil
+	}
+}
+
+// peerCred returns the SO_PEERCRED credentials of the peer of conn
+func peerCred(conn *net.UnixConn) (*syscall.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ucred, credErr
+}
@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+type ucrednetSuite struct{}
+
+var _ = check.Suite(&ucrednetSuite{})
+
+func (s *ucrednetSuite) TestAcceptSetsPeerUid(c *check.C) {
+	sock := filepath.Join(c.MkDir(), "snapd.socket")
+	l, err := net.Listen("unix", sock)
+	c.Assert(err, check.IsNil)
+	wl := &ucrednetListener{l}
+	defer wl.Close()
+
+	client, err := net.Dial("unix", sock)
+	c.Assert(err, check.IsNil)
+	defer client.Close()
+
+	conn, err := wl.Accept()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+
+	c.Check(conn.RemoteAddr().String(), check.Equals, fmt.Sprintf("uid=%d", os.Getuid()))
+}
+
+func (s *ucrednetSuite) TestAcceptNotUnix(c *check.C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	wl := &ucrednetListener{l}
+	defer wl.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, check.IsNil)
+	defer client.Close()
+
+	conn, err := wl.Accept()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+
+	c.Check(conn.RemoteAddr().String(), check.Equals, client.LocalAddr().String())
+}
@@ -35,6 +35,13 @@ var (
 	LocaleDir        string
 	SnapIconsDir     string
 	SnapMetaDir      string
+	SnapBlobDir      string
+
+	SnapDownloadCacheDir string
+	SnapSeedDir          string
+	SnapSnapshotsDir     string
+	SnapLockDir          string
+	SnapHistoryFile      string
 
 	SnapBinariesDir  string
 	SnapServicesDir  string
@@ -59,6 +66,12 @@ func SetRootDir(rootdir string) {
 	SnapSeccompDir = filepath.Join(rootdir, SnappyDir, "seccomp", "profiles")
 	SnapIconsDir = filepath.Join(rootdir, SnappyDir, "icons")
 	SnapMetaDir = filepath.Join(rootdir, SnappyDir, "meta")
+	SnapBlobDir = filepath.Join(rootdir, SnappyDir, "snaps")
+	SnapDownloadCacheDir = filepath.Join(rootdir, SnappyDir, "cache", "downloads")
+	SnapSeedDir = filepath.Join(rootdir, SnappyDir, "seed")
+	SnapSnapshotsDir = filepath.Join(rootdir, SnappyDir, "snapshots")
+	SnapLockDir = filepath.Join(rootdir, "/run/snappy")
+	SnapHistoryFile = filepath.Join(rootdir, SnappyDir, "history.log")
 
 	SnapBinariesDir = filepath.Join(SnapAppsDir, "bin")
 	SnapServicesDir = filepath.Join(rootdir, "/etc/systemd/system")
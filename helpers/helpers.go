@@ -33,6 +33,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"text/template"
@@ -114,13 +115,84 @@ type UnpackTarTransformFunc func(path string) (newPath string, err error)
 
 var mknod = syscall.Mknod
 
-// UnpackTar unpacks the given tar file into the target directory
-func UnpackTar(r io.Reader, targetDir string, fn UnpackTarTransformFunc) error {
+// UnpackLimits are the limits UnpackTar enforces on what it extracts,
+// to protect against malicious archives that expand unbounded. A zero
+// limit is no limit.
+type UnpackLimits struct {
+	// MaxSize is the total size of the files in bytes
+	MaxSize int64
+	// MaxFiles is the number of archive members
+	MaxFiles int
+	// MaxDepth is the number of elements of a path
+	MaxDepth int
+}
+
+// unpackPath returns the cleaned up name of an archive member, or an
+// error if it points outside of the target directory
+func unpackPath(name string) (string, error) {
+	path := filepath.Clean(name)
+	// Clean() will remove any internal ".." elements, except if it's a relative
+	// path and the ".." element is the first one.  So let's check for that:
+	if path == ".." || strings.HasPrefix(path, "../") {
+		return "", &ErrUnpackPathTraversal{Name: name}
+	}
+
+	return path, nil
+}
+
+// pathDepth returns the number of elements of the cleaned up path
+func pathDepth(path string) int {
+	path = strings.Trim(path, "/")
+	if path == "" || path == "." {
+		return 0
+	}
+
+	return strings.Count(path, "/") + 1
+}
+
+// hasSymlinkParent checks if one of the parent directories of path inside
+// of targetDir is a symlink, writing through it could write outside of
+// targetDir
+func hasSymlinkParent(targetDir, path string) bool {
+	dir := targetDir
+	for _, elem := range strings.Split(filepath.Dir(path), "/") {
+		if elem == "" || elem == "." {
+			continue
+		}
+		dir = filepath.Join(dir, elem)
+		st, err := os.Lstat(dir)
+		if err != nil {
+			return false
+		}
+		if IsSymlink(st.Mode()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UnpackTar unpacks the given tar file into the target directory. No file
+// is extracted outside of the target directory (no ".." in the paths, and
+// no writing through or over symlinks is allowed) and the given limits
+// (if any) are enforced while streaming.
+func UnpackTar(r io.Reader, targetDir string, limits *UnpackLimits, fn UnpackTarTransformFunc) error {
 	// ensure we we extract with the original permissions
 	oldUmask := syscall.Umask(0)
 	defer syscall.Umask(oldUmask)
 
+	if limits == nil {
+		limits = &UnpackLimits{}
+	}
+	var size int64
+	var files int
+
 	return TarIterate(r, func(tr *tar.Reader, hdr *tar.Header) (err error) {
+		files++
+		if limits.MaxFiles > 0 && files > limits.MaxFiles {
+			return &ErrUnpackTooManyFiles{Limit: limits.MaxFiles}
+		}
+
 		// run tar transform func
 		name := hdr.Name
 		if fn != nil {
@@ -130,52 +202,128 @@ func UnpackTar(r io.Reader, targetDir string, fn UnpackTarTransformFunc) error {
 			}
 		}
 
-		path := filepath.Join(targetDir, name)
-		mode := hdr.FileInfo().Mode()
-		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		name, err = unpackPath(name)
+		if err != nil {
 			return err
 		}
-
-		switch {
-		case mode.IsDir():
-			err := os.Mkdir(path, mode)
-			if err != nil {
-				return nil
-			}
-		case IsSymlink(mode):
-			if err := os.Symlink(hdr.Linkname, path); err != nil {
-				return err
-			}
-		case IsDevice(mode):
-			switch {
-			case (mode & os.ModeCharDevice) != 0:
-				mode |= syscall.S_IFCHR
-			case (mode & os.ModeDevice) != 0:
-				mode |= syscall.S_IFBLK
-			}
-			devNum := Makedev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
-			err = mknod(path, uint32(mode), int(devNum))
-			if err != nil {
-				return err
-			}
-		case mode.IsRegular():
-			out, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, mode)
-			if err != nil {
-				return err
-			}
-			defer out.Close()
-			_, err = io.Copy(out, tr)
-			if err != nil {
-				return err
+		if limits.MaxDepth > 0 && pathDepth(name) > limits.MaxDepth {
+			return &ErrUnpackTooDeep{Name: name, Limit: limits.MaxDepth}
+		}
+		if mode := hdr.FileInfo().Mode(); mode.IsRegular() {
+			// the tar reader returns no more than hdr.Size bytes
+			size += hdr.Size
+			if limits.MaxSize > 0 && size > limits.MaxSize {
+				return &ErrUnpackTooBig{Limit: limits.MaxSize}
 			}
-		default:
-			return &ErrUnsupportedFileType{path, mode}
 		}
 
-		return nil
+		return UnpackTarEntry(tr, hdr, targetDir, name)
 	})
 }
 
+// UnpackTarEntry extracts the given tar member with the content read
+// from r to name inside of targetDir. It refuses to write through or
+// over symlinks, so that nothing ends up outside of targetDir.
+func UnpackTarEntry(r io.Reader, hdr *tar.Header, targetDir, name string) error {
+	if hasSymlinkParent(targetDir, name) {
+		return &ErrUnpackPathTraversal{Name: name}
+	}
+
+	path := filepath.Join(targetDir, name)
+	mode := hdr.FileInfo().Mode()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	// whatever is there already is replaced, unless it is a symlink,
+	// which an earlier member may have pointed anywhere
+	st, err := os.Lstat(path)
+	switch {
+	case err == nil && IsSymlink(st.Mode()):
+		return &ErrUnpackPathTraversal{Name: name}
+	case err == nil && st.IsDir():
+		if mode.IsDir() {
+			return nil
+		}
+		return &ErrUnsupportedFileType{path, mode}
+	case err == nil:
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	switch {
+	case mode.IsDir():
+		return os.Mkdir(path, mode)
+	case IsSymlink(mode):
+		return os.Symlink(hdr.Linkname, path)
+	case IsDevice(mode):
+		switch {
+		case (mode & os.ModeCharDevice) != 0:
+			mode |= syscall.S_IFCHR
+		case (mode & os.ModeDevice) != 0:
+			mode |= syscall.S_IFBLK
+		}
+		devNum := Makedev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		return mknod(path, uint32(mode), int(devNum))
+	case mode.IsRegular():
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+
+	return &ErrUnsupportedFileType{path, mode}
+}
+
+// ErrUnpackPathTraversal is returned when an archive member would be
+// extracted outside of the target directory
+type ErrUnpackPathTraversal struct {
+	Name string
+}
+
+func (e *ErrUnpackPathTraversal) Error() string {
+	return fmt.Sprintf("%s: path is outside of the target directory", e.Name)
+}
+
+// ErrUnpackTooBig is returned when the files of an archive are bigger
+// than the size limit
+type ErrUnpackTooBig struct {
+	Limit int64
+}
+
+func (e *ErrUnpackTooBig) Error() string {
+	return fmt.Sprintf("archive content is bigger than the limit of %d bytes", e.Limit)
+}
+
+// ErrUnpackTooManyFiles is returned when an archive has more members than
+// the file count limit
+type ErrUnpackTooManyFiles struct {
+	Limit int
+}
+
+func (e *ErrUnpackTooManyFiles) Error() string {
+	return fmt.Sprintf("archive has more than the limit of %d files", e.Limit)
+}
+
+// ErrUnpackTooDeep is returned when the path of an archive member has more
+// elements than the path depth limit
+type ErrUnpackTooDeep struct {
+	Name  string
+	Limit int
+}
+
+func (e *ErrUnpackTooDeep) Error() string {
+	return fmt.Sprintf("%s: path is deeper than the limit of %d", e.Name, e.Limit)
+}
+
 // ErrUnsupportedFileType is returned when trying to extract a file
 // that is not a regular file, a directory, or a symlink.
 type ErrUnsupportedFileType struct {
@@ -292,6 +440,36 @@ func NewSideloadVersion() string {
 	return string(bs)
 }
 
+// SourceDateEpochEnv is the environment variable that asks for a
+// reproducible build, see https://reproducible-builds.org/specs/source-date-epoch/
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// SourceDateEpoch returns the time set in SourceDateEpochEnv, or nil if
+// it is not set. Reproducible builds clamp all timestamps to it.
+func SourceDateEpoch() (*time.Time, error) {
+	value := os.Getenv(SourceDateEpochEnv)
+	if value == "" {
+		return nil, nil
+	}
+
+	secs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || secs < 0 {
+		return nil, fmt.Errorf("invalid %s %q", SourceDateEpochEnv, value)
+	}
+	epoch := time.Unix(secs, 0).UTC()
+
+	return &epoch, nil
+}
+
+// ClampTime returns t, or epoch if epoch is set and t is later than it
+func ClampTime(t time.Time, epoch *time.Time) time.Time {
+	if epoch != nil && t.After(*epoch) {
+		return *epoch
+	}
+
+	return t
+}
+
 // AtomicWriteFlags are a bitfield of flags for AtomicWriteFile
 type AtomicWriteFlags uint
 
@@ -20,6 +20,8 @@
 package helpers
 
 import (
+	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io/ioutil"
@@ -27,8 +29,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -66,7 +70,7 @@ func (ts *HTestSuite) TestUnpack(c *C) {
 	f2, err := gzip.NewReader(f)
 	c.Assert(err, IsNil)
 
-	err = UnpackTar(f2, unpackdir, nil)
+	err = UnpackTar(f2, unpackdir, nil, nil)
 	c.Assert(err, IsNil)
 
 	// we have the expected file
@@ -88,6 +92,108 @@ func (ts *HTestSuite) TestUnpack(c *C) {
 	c.Check(fn, Equals, "/etc/fstab")
 }
 
+// makeTar returns a tar with the given regular files, a name ending in
+// "/" is a directory and a "name -> target" a symlink
+func makeTar(c *C, files map[string]string, names ...string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}
+		switch {
+		case strings.HasSuffix(name, "/"):
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+			hdr.Size = 0
+		case strings.Contains(name, " -> "):
+			l := strings.SplitN(name, " -> ", 2)
+			hdr.Name, hdr.Linkname = l[0], l[1]
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Size = 0
+		}
+		c.Assert(tw.WriteHeader(hdr), IsNil)
+		_, err := tw.Write([]byte(files[name]))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(tw.Close(), IsNil)
+
+	return buf
+}
+
+func (ts *HTestSuite) TestUnpackPath(c *C) {
+	for _, t := range []struct {
+		name, path string
+	}{
+		{"foo", "foo"},
+		{"./foo/bar/../baz", "foo/baz"},
+		{".../foo", ".../foo"},
+		{"/foo/../../bar", "/bar"},
+	} {
+		path, err := unpackPath(t.name)
+		c.Check(err, IsNil)
+		c.Check(path, Equals, t.path)
+	}
+
+	for _, name := range []string{"..", "./foo/../../baz"} {
+		_, err := unpackPath(name)
+		c.Check(err, DeepEquals, &ErrUnpackPathTraversal{Name: name})
+	}
+}
+
+func (ts *HTestSuite) TestUnpackTarPathTraversal(c *C) {
+	targetDir := filepath.Join(c.MkDir(), "target")
+
+	err := UnpackTar(makeTar(c, nil, "../evil"), targetDir, nil, nil)
+	c.Check(err, ErrorMatches, "../evil: path is outside of the target directory")
+
+	outside := c.MkDir()
+	err = UnpackTar(makeTar(c, map[string]string{"link/evil": "evil"}, "link -> "+outside, "link/evil"), targetDir, nil, nil)
+	c.Check(err, DeepEquals, &ErrUnpackPathTraversal{Name: "link/evil"})
+	c.Check(FileExists(filepath.Join(outside, "evil")), Equals, false)
+}
+
+func (ts *HTestSuite) TestUnpackTarOverSymlink(c *C) {
+	targetDir := filepath.Join(c.MkDir(), "target")
+	victim := filepath.Join(c.MkDir(), "passwd")
+	c.Assert(ioutil.WriteFile(victim, []byte("root"), 0644), IsNil)
+
+	err := UnpackTar(makeTar(c, map[string]string{"foo": "evil"}, "foo -> "+victim, "foo"), targetDir, nil, nil)
+	c.Check(err, DeepEquals, &ErrUnpackPathTraversal{Name: "foo"})
+
+	content, err := ioutil.ReadFile(victim)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "root")
+}
+
+func (ts *HTestSuite) TestUnpackTarReplacesFiles(c *C) {
+	targetDir := c.MkDir()
+
+	err := UnpackTar(makeTar(c, map[string]string{"foo": "first"}, "foo"), targetDir, nil, nil)
+	c.Assert(err, IsNil)
+	err = UnpackTar(makeTar(c, map[string]string{"foo": "second"}, "foo"), targetDir, nil, nil)
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadFile(filepath.Join(targetDir, "foo"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "second")
+}
+
+func (ts *HTestSuite) TestUnpackTarLimits(c *C) {
+	files := map[string]string{"a/b/c": "12345", "a/d": "67890"}
+	names := []string{"a/", "a/b/", "a/b/c", "a/d"}
+
+	err := UnpackTar(makeTar(c, files, names...), c.MkDir(), &UnpackLimits{MaxSize: 10, MaxFiles: 4, MaxDepth: 3}, nil)
+	c.Check(err, IsNil)
+
+	err = UnpackTar(makeTar(c, files, names...), c.MkDir(), &UnpackLimits{MaxSize: 9}, nil)
+	c.Check(err, DeepEquals, &ErrUnpackTooBig{Limit: 9})
+
+	err = UnpackTar(makeTar(c, files, names...), c.MkDir(), &UnpackLimits{MaxFiles: 3}, nil)
+	c.Check(err, DeepEquals, &ErrUnpackTooManyFiles{Limit: 3})
+
+	err = UnpackTar(makeTar(c, files, names...), c.MkDir(), &UnpackLimits{MaxDepth: 2}, nil)
+	c.Check(err, DeepEquals, &ErrUnpackTooDeep{Name: "a/b/c", Limit: 2})
+}
+
 func (ts *HTestSuite) TestUbuntuArchitecture(c *C) {
 	goarch = "arm"
 	c.Check(UbuntuArchitecture(), Equals, "armhf")
@@ -183,6 +289,28 @@ func (ts *HTestSuite) TestMakeMapFromEnvListInvalidInput(c *C) {
 	c.Assert(envMap, DeepEquals, map[string]string(nil))
 }
 
+func (ts *HTestSuite) TestSourceDateEpoch(c *C) {
+	defer os.Unsetenv(SourceDateEpochEnv)
+
+	os.Unsetenv(SourceDateEpochEnv)
+	epoch, err := SourceDateEpoch()
+	c.Assert(err, IsNil)
+	c.Check(epoch, IsNil)
+	c.Check(ClampTime(time.Unix(100, 0), epoch).Unix(), Equals, int64(100))
+
+	os.Setenv(SourceDateEpochEnv, "42")
+	epoch, err = SourceDateEpoch()
+	c.Assert(err, IsNil)
+	c.Assert(epoch, NotNil)
+	c.Check(epoch.Unix(), Equals, int64(42))
+	c.Check(ClampTime(time.Unix(100, 0), epoch).Unix(), Equals, int64(42))
+	c.Check(ClampTime(time.Unix(10, 0), epoch).Unix(), Equals, int64(10))
+
+	os.Setenv(SourceDateEpochEnv, "yesterday")
+	_, err = SourceDateEpoch()
+	c.Check(err, ErrorMatches, `invalid SOURCE_DATE_EPOCH "yesterday"`)
+}
+
 func (ts *HTestSuite) TestSha512sum(c *C) {
 	tempdir := c.MkDir()
 
@@ -457,7 +585,7 @@ func (ts *HTestSuite) TestUnpacksMknod(c *C) {
 	f, err := os.Open(tmpfile)
 	c.Assert(err, IsNil)
 
-	err = UnpackTar(f, c.MkDir(), nil)
+	err = UnpackTar(f, c.MkDir(), nil, nil)
 	c.Assert(err, IsNil)
 	c.Assert(mknodWasCalled, Equals, true)
 }
@@ -642,7 +770,7 @@ func (ts *HTestSuite) TestUnpackPermissions(c *C) {
 	f, err := os.Open(tarArchive)
 	c.Assert(err, IsNil)
 	defer f.Close()
-	UnpackTar(f, unpackdir, nil)
+	UnpackTar(f, unpackdir, nil, nil)
 
 	st, err := os.Stat(filepath.Join(unpackdir, canaryName))
 	c.Assert(err, IsNil)
@@ -0,0 +1,980 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+// This is a decoder for .xz data, so that snaps can be read without
+// xz-utils. It only does what snaps need: one or more streams with blocks
+// compressed with the LZMA2 filter alone. It decodes while it reads and
+// keeps no more than the dictionary of the stream in memory.
+// See http://tukaani.org/xz/xz-file-format.txt
+
+// ErrXzTooBig is returned when xz data decodes to more than allowed
+var ErrXzTooBig = errors.New("xz: decoded data is too big")
+
+var (
+	errXzFormat     = errors.New("xz: invalid data")
+	errXzCorrupted  = errors.New("xz: corrupted data")
+	errXzUnexpected = errors.New("xz: unexpected end of data")
+)
+
+var xzMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+
+const (
+	xzCheckNone   = 0x00
+	xzCheckCRC32  = 0x01
+	xzCheckCRC64  = 0x04
+	xzCheckSHA256 = 0x0a
+
+	xzFilterLZMA2 = 0x21
+
+	// the biggest dictionary xz makes (with -9), which bounds the
+	// memory a stream can make us use
+	xzDictMax = 64 << 20
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// XzDecompress decodes the given (possibly concatenated) xz streams,
+// which may decode to no more than max bytes
+func XzDecompress(data []byte, max int) ([]byte, error) {
+	var out bytes.Buffer
+	x := newXzDecoder(bytes.NewReader(data), &out)
+	x.left = int64(max)
+	if err := x.decode(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// NewXzReader returns a reader of the decoded (possibly concatenated) xz
+// streams read from r. Close it if it is not read to the end.
+func NewXzReader(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	x := newXzDecoder(r, pw)
+
+	// decode in its own go-routine
+	go func() {
+		pw.CloseWithError(x.decode())
+	}()
+
+	return pr
+}
+
+// xzDecoder decodes the xz data from r and writes it to w
+type xzDecoder struct {
+	r   *bufio.Reader
+	w   io.Writer
+	pos int64
+
+	// how much more may be decoded, if not negative
+	left int64
+
+	// the bytes read while recording is set
+	recording bool
+	recorded  []byte
+}
+
+func newXzDecoder(r io.Reader, w io.Writer) *xzDecoder {
+	return &xzDecoder{
+		r:    bufio.NewReader(r),
+		w:    w,
+		left: -1,
+	}
+}
+
+// decode decodes all of the streams
+func (x *xzDecoder) decode() error {
+	for {
+		if err := x.stream(); err != nil {
+			return err
+		}
+
+		// stream padding, and maybe another stream
+		for {
+			b, err := x.r.Peek(1)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if b[0] != 0 {
+				break
+			}
+			if _, err := x.next(1); err != nil {
+				return err
+			}
+		}
+		if x.pos%4 != 0 {
+			return errXzFormat
+		}
+	}
+}
+
+func (x *xzDecoder) next(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errXzUnexpected
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(x.r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errXzUnexpected
+		}
+		return nil, err
+	}
+	x.pos += int64(n)
+	if x.recording {
+		x.recorded = append(x.recorded, b...)
+	}
+
+	return b, nil
+}
+
+func (x *xzDecoder) peek() (byte, error) {
+	b, err := x.r.Peek(1)
+	if err == io.EOF {
+		return 0, errXzUnexpected
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+// vli reads a variable length integer
+func (x *xzDecoder) vli() (uint64, error) {
+	var v uint64
+	for i := uint(0); i < 9; i++ {
+		b, err := x.next(1)
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b[0]&0x7f) << (7 * i)
+		if b[0]&0x80 == 0 {
+			if i > 0 && b[0] == 0 {
+				return 0, errXzFormat
+			}
+			return v, nil
+		}
+	}
+
+	return 0, errXzFormat
+}
+
+// pad skips the zeros up to the next multiple of four from start
+func (x *xzDecoder) pad(start int64) error {
+	for (x.pos-start)%4 != 0 {
+		b, err := x.next(1)
+		if err != nil {
+			return err
+		}
+		if b[0] != 0 {
+			return errXzFormat
+		}
+	}
+
+	return nil
+}
+
+// grow makes sure n more bytes can be decoded
+func (x *xzDecoder) grow(n int) error {
+	if x.left < 0 {
+		return nil
+	}
+	if int64(n) > x.left {
+		return ErrXzTooBig
+	}
+	x.left -= int64(n)
+
+	return nil
+}
+
+// stream decodes one stream
+func (x *xzDecoder) stream() error {
+	header, err := x.next(12)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:6], xzMagic) || header[6] != 0 || header[7] > 0x0f {
+		return errXzFormat
+	}
+	if crc32.ChecksumIEEE(header[6:8]) != binary.LittleEndian.Uint32(header[8:]) {
+		return errXzCorrupted
+	}
+	check := header[7]
+
+	var records [][2]uint64
+	for {
+		b, err := x.peek()
+		if err != nil {
+			return err
+		}
+		// the index comes after the last block
+		if b == 0 {
+			break
+		}
+
+		start := x.pos
+		size, h, err := x.block(check)
+		if err != nil {
+			return err
+		}
+		dataEnd := x.pos
+		if err := x.pad(start); err != nil {
+			return err
+		}
+		padding := x.pos - dataEnd
+		if err := x.check(check, h); err != nil {
+			return err
+		}
+		// the unpadded size has the check, but not the padding
+		records = append(records, [2]uint64{uint64(x.pos - start - padding), uint64(size)})
+	}
+
+	indexStart := x.pos
+	if err := x.index(records); err != nil {
+		return err
+	}
+	indexSize := x.pos - indexStart
+
+	footer, err := x.next(12)
+	if err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer[:4]) {
+		return errXzCorrupted
+	}
+	if int64(binary.LittleEndian.Uint32(footer[4:8])+1)*4 != indexSize ||
+		!bytes.Equal(footer[8:10], header[6:8]) ||
+		footer[10] != 'Y' || footer[11] != 'Z' {
+		return errXzFormat
+	}
+
+	return nil
+}
+
+// xzBlockWriter writes the data of a block, counting it and feeding the
+// hash of its check
+type xzBlockWriter struct {
+	w    io.Writer
+	h    hash.Hash
+	size int64
+}
+
+func (bw *xzBlockWriter) Write(data []byte) (int, error) {
+	if bw.h != nil {
+		bw.h.Write(data)
+	}
+	bw.size += int64(len(data))
+
+	return bw.w.Write(data)
+}
+
+// newXzCheck returns the hash of the given check, nil if there is none
+// or it is unknown
+func newXzCheck(check byte) hash.Hash {
+	switch check {
+	case xzCheckCRC32:
+		return crc32.NewIEEE()
+	case xzCheckCRC64:
+		return crc64.New(crc64Table)
+	case xzCheckSHA256:
+		return sha256.New()
+	}
+
+	return nil
+}
+
+// block decodes the block header and the compressed data after it,
+// returning the size of the data and the hash of its check
+func (x *xzDecoder) block(check byte) (int64, hash.Hash, error) {
+	b, err := x.peek()
+	if err != nil {
+		return 0, nil, err
+	}
+	size := (int(b) + 1) * 4
+	header, err := x.next(size)
+	if err != nil {
+		return 0, nil, err
+	}
+	if crc32.ChecksumIEEE(header[:size-4]) != binary.LittleEndian.Uint32(header[size-4:]) {
+		return 0, nil, errXzCorrupted
+	}
+
+	h := newXzDecoder(bytes.NewReader(header[2:size-4]), nil)
+	flags := header[1]
+	if flags&0x3c != 0 {
+		return 0, nil, errXzFormat
+	}
+
+	compressedSize := int64(-1)
+	if flags&0x40 != 0 {
+		v, err := h.vli()
+		if err != nil {
+			return 0, nil, err
+		}
+		compressedSize = int64(v)
+	}
+	uncompressedSize := int64(-1)
+	if flags&0x80 != 0 {
+		v, err := h.vli()
+		if err != nil {
+			return 0, nil, err
+		}
+		uncompressedSize = int64(v)
+	}
+
+	if flags&0x03 != 0 {
+		return 0, nil, fmt.Errorf("xz: only the LZMA2 filter alone is supported")
+	}
+	id, err := h.vli()
+	if err != nil {
+		return 0, nil, err
+	}
+	propsSize, err := h.vli()
+	if err != nil {
+		return 0, nil, err
+	}
+	if id != xzFilterLZMA2 {
+		return 0, nil, fmt.Errorf("xz: unsupported filter %#x", id)
+	}
+	if propsSize != 1 {
+		return 0, nil, errXzFormat
+	}
+	props, err := h.next(1)
+	if err != nil {
+		return 0, nil, err
+	}
+	dictSize, err := lzma2DictSize(props[0])
+	if err != nil {
+		return 0, nil, err
+	}
+	rest, err := h.next(size - 6 - int(h.pos))
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, b := range rest {
+		if b != 0 {
+			return 0, nil, errXzFormat
+		}
+	}
+
+	dataStart := x.pos
+	bw := &xzBlockWriter{w: x.w, h: newXzCheck(check)}
+	if err := x.lzma2(dictSize, bw); err != nil {
+		return 0, nil, err
+	}
+
+	if compressedSize >= 0 && x.pos-dataStart != compressedSize {
+		return 0, nil, errXzCorrupted
+	}
+	if uncompressedSize >= 0 && bw.size != uncompressedSize {
+		return 0, nil, errXzCorrupted
+	}
+
+	return bw.size, bw.h, nil
+}
+
+// lzma2DictSize returns the dictionary size of the given LZMA2 filter
+// properties
+func lzma2DictSize(props byte) (int, error) {
+	if props > 40 {
+		return 0, errXzFormat
+	}
+	if props == 40 {
+		return 0, fmt.Errorf("xz: dictionary is bigger than %d bytes", xzDictMax)
+	}
+	size := (2 | int(props)&1) << (props/2 + 11)
+	if size > xzDictMax {
+		return 0, fmt.Errorf("xz: dictionary is bigger than %d bytes", xzDictMax)
+	}
+
+	return size, nil
+}
+
+// check verifies the check of the block against its hash
+func (x *xzDecoder) check(check byte, h hash.Hash) error {
+	// the unknown ones are skipped over
+	size := 0
+	if check != 0 {
+		size = 4 << ((check - 1) / 3)
+	}
+	want, err := x.next(size)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return nil
+	}
+
+	sum := h.Sum(nil)
+	// the crcs are stored little endian
+	if check != xzCheckSHA256 {
+		for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+			sum[i], sum[j] = sum[j], sum[i]
+		}
+	}
+	if !bytes.Equal(sum, want) {
+		return errXzCorrupted
+	}
+
+	return nil
+}
+
+// index reads the index and checks it matches the blocks
+func (x *xzDecoder) index(records [][2]uint64) error {
+	start := x.pos
+	x.recording = true
+	x.recorded = nil
+	defer func() { x.recording = false }()
+
+	if _, err := x.next(1); err != nil {
+		return err
+	}
+	count, err := x.vli()
+	if err != nil {
+		return err
+	}
+	if count != uint64(len(records)) {
+		return errXzCorrupted
+	}
+	for _, record := range records {
+		unpadded, err := x.vli()
+		if err != nil {
+			return err
+		}
+		uncompressed, err := x.vli()
+		if err != nil {
+			return err
+		}
+		if unpadded != record[0] || uncompressed != record[1] {
+			return errXzCorrupted
+		}
+	}
+	if err := x.pad(start); err != nil {
+		return err
+	}
+
+	x.recording = false
+	sum, err := x.next(4)
+	if err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(x.recorded) != binary.LittleEndian.Uint32(sum) {
+		return errXzCorrupted
+	}
+
+	return nil
+}
+
+// lzma2 decodes LZMA2 chunks up to the end marker and writes them to w.
+// It keeps at least the last dictSize bytes for the matches.
+func (x *xzDecoder) lzma2(dictSize int, w io.Writer) error {
+	var d *lzmaDecoder
+	// the dictionary, from dictStart on; dictStart goes negative when
+	// the start is dropped
+	var buf []byte
+	dictStart := 0
+	needDictReset := true
+
+	for {
+		b, err := x.next(1)
+		if err != nil {
+			return err
+		}
+		control := b[0]
+		before := len(buf)
+
+		switch {
+		case control == 0x00:
+			return nil
+		case control == 0x01 || control == 0x02:
+			if control == 0x01 {
+				dictStart = len(buf)
+				needDictReset = false
+			} else if needDictReset {
+				return errXzCorrupted
+			}
+			sz, err := x.next(2)
+			if err != nil {
+				return err
+			}
+			n := int(binary.BigEndian.Uint16(sz)) + 1
+			if err := x.grow(n); err != nil {
+				return err
+			}
+			chunk, err := x.next(n)
+			if err != nil {
+				return err
+			}
+			buf = append(buf, chunk...)
+		case control >= 0x80:
+			sz, err := x.next(4)
+			if err != nil {
+				return err
+			}
+			unpacked := int(control&0x1f)<<16 + int(binary.BigEndian.Uint16(sz[:2])) + 1
+			packed := int(binary.BigEndian.Uint16(sz[2:])) + 1
+			if err := x.grow(unpacked); err != nil {
+				return err
+			}
+
+			reset := (control >> 5) & 0x03
+			if reset == 3 {
+				dictStart = len(buf)
+				needDictReset = false
+			} else if needDictReset {
+				return errXzCorrupted
+			}
+			if reset >= 2 {
+				props, err := x.next(1)
+				if err != nil {
+					return err
+				}
+				if d, err = newLzmaDecoder(props[0]); err != nil {
+					return err
+				}
+			} else if d == nil {
+				return errXzCorrupted
+			} else if reset == 1 {
+				d.reset()
+			}
+
+			chunk, err := x.next(packed)
+			if err != nil {
+				return err
+			}
+			if err := d.decode(chunk, &buf, dictStart, unpacked); err != nil {
+				return err
+			}
+		default:
+			return errXzCorrupted
+		}
+
+		if _, err := w.Write(buf[before:]); err != nil {
+			return err
+		}
+
+		// drop what is no longer in the dictionary, now and then
+		if len(buf) > 2*dictSize {
+			drop := len(buf) - dictSize
+			buf = buf[:copy(buf, buf[drop:])]
+			dictStart -= drop
+		}
+	}
+}
+
+// the LZMA decoder, as in the LZMA SDK
+
+const (
+	lzmaStates         = 12
+	lzmaPosBitsMax     = 4
+	lzmaLenToPosStates = 4
+	lzmaAlignBits      = 4
+	lzmaEndPosModel    = 14
+	lzmaFullDistances  = 1 << (lzmaEndPosModel >> 1)
+	lzmaMatchMinLen    = 2
+
+	lzmaProbBits  = 11
+	lzmaProbInit  = 1 << (lzmaProbBits - 1)
+	lzmaMoveBits  = 5
+	lzmaTopValue  = 1 << 24
+	lzmaLiterals  = 0x300
+	lzmaLenLow    = 3
+	lzmaLenMid    = 3
+	lzmaLenHigh   = 8
+	lzmaPosSlots  = 6
+	lzmaLowSymbol = 1 << lzmaLenLow
+	lzmaMidSymbol = 1 << lzmaLenMid
+)
+
+type prob uint16
+
+type rangeDecoder struct {
+	data  []byte
+	pos   int
+	rng   uint32
+	code  uint32
+	short bool
+}
+
+func (rc *rangeDecoder) init(data []byte) error {
+	if len(data) < 5 || data[0] != 0 {
+		return errXzCorrupted
+	}
+	rc.data = data
+	rc.pos = 5
+	rc.rng = 0xffffffff
+	rc.code = binary.BigEndian.Uint32(data[1:5])
+	rc.short = false
+
+	return nil
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < lzmaTopValue {
+		rc.rng <<= 8
+		if rc.pos < len(rc.data) {
+			rc.code = rc.code<<8 | uint32(rc.data[rc.pos])
+			rc.pos++
+		} else {
+			rc.code <<= 8
+			rc.short = true
+		}
+	}
+}
+
+func (rc *rangeDecoder) bit(p *prob) uint32 {
+	rc.normalize()
+	bound := (rc.rng >> lzmaProbBits) * uint32(*p)
+	if rc.code < bound {
+		rc.rng = bound
+		*p += ((1 << lzmaProbBits) - *p) >> lzmaMoveBits
+		return 0
+	}
+	rc.rng -= bound
+	rc.code -= bound
+	*p -= *p >> lzmaMoveBits
+
+	return 1
+}
+
+func (rc *rangeDecoder) direct(n uint) uint32 {
+	var v uint32
+	for ; n > 0; n-- {
+		rc.normalize()
+		rc.rng >>= 1
+		v <<= 1
+		if rc.code >= rc.rng {
+			rc.code -= rc.rng
+			v |= 1
+		}
+	}
+
+	return v
+}
+
+func (rc *rangeDecoder) tree(probs []prob, bits uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < bits; i++ {
+		m = m<<1 | rc.bit(&probs[m])
+	}
+
+	return m - (1 << bits)
+}
+
+func (rc *rangeDecoder) reverseTree(probs []prob, bits uint) uint32 {
+	m := uint32(1)
+	var v uint32
+	for i := uint(0); i < bits; i++ {
+		b := rc.bit(&probs[m])
+		m = m<<1 | b
+		v |= b << i
+	}
+
+	return v
+}
+
+// finished tells whether all of the data was used up, as it has to be
+// at the end of a chunk
+func (rc *rangeDecoder) finished() bool {
+	// the bits normalize before they decode, so the last one may
+	// still be pending
+	rc.normalize()
+
+	return !rc.short && rc.pos == len(rc.data) && rc.code == 0
+}
+
+type lenDecoder struct {
+	choice  prob
+	choice2 prob
+	low     [1 << lzmaPosBitsMax][lzmaLowSymbol]prob
+	mid     [1 << lzmaPosBitsMax][lzmaMidSymbol]prob
+	high    [1 << lzmaLenHigh]prob
+}
+
+func (ld *lenDecoder) reset() {
+	ld.choice = lzmaProbInit
+	ld.choice2 = lzmaProbInit
+	initProbs(ld.high[:])
+	for i := range ld.low {
+		initProbs(ld.low[i][:])
+		initProbs(ld.mid[i][:])
+	}
+}
+
+func (ld *lenDecoder) decode(rc *rangeDecoder, posState uint32) uint32 {
+	if rc.bit(&ld.choice) == 0 {
+		return rc.tree(ld.low[posState][:], lzmaLenLow)
+	}
+	if rc.bit(&ld.choice2) == 0 {
+		return lzmaLowSymbol + rc.tree(ld.mid[posState][:], lzmaLenMid)
+	}
+
+	return lzmaLowSymbol + lzmaMidSymbol + rc.tree(ld.high[:], lzmaLenHigh)
+}
+
+func initProbs(probs []prob) {
+	for i := range probs {
+		probs[i] = lzmaProbInit
+	}
+}
+
+type lzmaDecoder struct {
+	lc, lp, pb uint
+
+	state uint32
+	reps  [4]uint32
+
+	literal    []prob
+	isMatch    [lzmaStates << lzmaPosBitsMax]prob
+	isRep      [lzmaStates]prob
+	isRepG0    [lzmaStates]prob
+	isRepG1    [lzmaStates]prob
+	isRepG2    [lzmaStates]prob
+	isRep0Long [lzmaStates << lzmaPosBitsMax]prob
+	posSlot    [lzmaLenToPosStates][1 << lzmaPosSlots]prob
+	// one more than needed, see distance()
+	specPos   [lzmaFullDistances - lzmaEndPosModel + 1]prob
+	align     [1 << lzmaAlignBits]prob
+	lenDec    lenDecoder
+	repLenDec lenDecoder
+
+	rc rangeDecoder
+}
+
+func newLzmaDecoder(props byte) (*lzmaDecoder, error) {
+	if props >= 9*5*5 {
+		return nil, errXzCorrupted
+	}
+	d := &lzmaDecoder{
+		lc: uint(props % 9),
+		lp: uint(props / 9 % 5),
+		pb: uint(props / 45),
+	}
+	// LZMA2 needs this
+	if d.lc+d.lp > 4 {
+		return nil, errXzCorrupted
+	}
+	d.literal = make([]prob, lzmaLiterals<<(d.lc+d.lp))
+	d.reset()
+
+	return d, nil
+}
+
+// reset puts the decoder back in its initial state
+func (d *lzmaDecoder) reset() {
+	d.state = 0
+	d.reps = [4]uint32{}
+
+	initProbs(d.literal)
+	initProbs(d.isMatch[:])
+	initProbs(d.isRep[:])
+	initProbs(d.isRepG0[:])
+	initProbs(d.isRepG1[:])
+	initProbs(d.isRepG2[:])
+	initProbs(d.isRep0Long[:])
+	for i := range d.posSlot {
+		initProbs(d.posSlot[i][:])
+	}
+	initProbs(d.specPos[:])
+	initProbs(d.align[:])
+	d.lenDec.reset()
+	d.repLenDec.reset()
+}
+
+// decode decodes the chunk into size more bytes of out, which holds
+// the dictionary from dictStart on. The positions count from dictStart,
+// even if it is negative because the start of the dictionary was dropped
+func (d *lzmaDecoder) decode(chunk []byte, out *[]byte, dictStart, size int) error {
+	rc := &d.rc
+	if err := rc.init(chunk); err != nil {
+		return err
+	}
+
+	buf := *out
+	// what is there of the dictionary
+	first := dictStart
+	if first < 0 {
+		first = 0
+	}
+	end := len(buf) + size
+	pbMask := uint32(1)<<d.pb - 1
+	lpMask := uint32(1)<<d.lp - 1
+
+	for len(buf) < end {
+		pos := uint32(len(buf) - dictStart)
+		posState := pos & pbMask
+		state := d.state
+
+		if rc.bit(&d.isMatch[state<<lzmaPosBitsMax+posState]) == 0 {
+			var prev uint32
+			if len(buf) > first {
+				prev = uint32(buf[len(buf)-1])
+			}
+			litState := (pos&lpMask)<<d.lc + prev>>(8-d.lc)
+			probs := d.literal[lzmaLiterals*litState:]
+
+			symbol := uint32(1)
+			if state >= 7 {
+				rep0 := int(d.reps[0])
+				if rep0 >= len(buf)-first {
+					return errXzCorrupted
+				}
+				match := uint32(buf[len(buf)-rep0-1])
+				for symbol < 0x100 {
+					matchBit := (match >> 7) & 1
+					match <<= 1
+					b := rc.bit(&probs[0x100+matchBit<<8+symbol])
+					symbol = symbol<<1 | b
+					if matchBit != b {
+						break
+					}
+				}
+			}
+			for symbol < 0x100 {
+				symbol = symbol<<1 | rc.bit(&probs[symbol])
+			}
+			buf = append(buf, byte(symbol))
+
+			switch {
+			case state < 4:
+				d.state = 0
+			case state < 10:
+				d.state = state - 3
+			default:
+				d.state = state - 6
+			}
+			continue
+		}
+
+		var length uint32
+		if rc.bit(&d.isRep[state]) == 0 {
+			// a new distance
+			length = d.lenDec.decode(rc, posState)
+			if state < 7 {
+				d.state = 7
+			} else {
+				d.state = 10
+			}
+			d.reps[3], d.reps[2], d.reps[1] = d.reps[2], d.reps[1], d.reps[0]
+			d.reps[0] = d.distance(length)
+			if d.reps[0] == 0xffffffff {
+				// end markers are not allowed in LZMA2
+				return errXzCorrupted
+			}
+		} else {
+			if rc.bit(&d.isRepG0[state]) == 0 {
+				if rc.bit(&d.isRep0Long[state<<lzmaPosBitsMax+posState]) == 0 {
+					// a single byte at rep0
+					if state < 7 {
+						d.state = 9
+					} else {
+						d.state = 11
+					}
+					rep0 := int(d.reps[0])
+					if rep0 >= len(buf)-first {
+						return errXzCorrupted
+					}
+					buf = append(buf, buf[len(buf)-rep0-1])
+					continue
+				}
+			} else {
+				var dist uint32
+				if rc.bit(&d.isRepG1[state]) == 0 {
+					dist = d.reps[1]
+				} else {
+					if rc.bit(&d.isRepG2[state]) == 0 {
+						dist = d.reps[2]
+					} else {
+						dist = d.reps[3]
+						d.reps[3] = d.reps[2]
+					}
+					d.reps[2] = d.reps[1]
+				}
+				d.reps[1] = d.reps[0]
+				d.reps[0] = dist
+			}
+			length = d.repLenDec.decode(rc, posState)
+			if state < 7 {
+				d.state = 8
+			} else {
+				d.state = 11
+			}
+		}
+
+		n := int(length) + lzmaMatchMinLen
+		dist := int(d.reps[0]) + 1
+		if dist > len(buf)-first || n > end-len(buf) {
+			return errXzCorrupted
+		}
+		from := len(buf) - dist
+		for i := 0; i < n; i++ {
+			buf = append(buf, buf[from+i])
+		}
+	}
+
+	*out = buf
+	if !rc.finished() {
+		return errXzCorrupted
+	}
+
+	return nil
+}
+
+// distance decodes the distance of a match of the given length
+func (d *lzmaDecoder) distance(length uint32) uint32 {
+	rc := &d.rc
+
+	lenState := length
+	if lenState > lzmaLenToPosStates-1 {
+		lenState = lzmaLenToPosStates - 1
+	}
+	slot := rc.tree(d.posSlot[lenState][:], lzmaPosSlots)
+	if slot < 4 {
+		return slot
+	}
+
+	bits := uint(slot>>1) - 1
+	dist := (2 | slot&1) << bits
+	if slot < lzmaEndPosModel {
+		// the SDK indexes these from dist-slot-1, which is -1 for
+		// the first slot, hence the extra prob in front
+		return dist + rc.reverseTree(d.specPos[dist-slot:], bits)
+	}
+
+	dist += rc.direct(bits-lzmaAlignBits) << lzmaAlignBits
+	return dist + rc.reverseTree(d.align[:], lzmaAlignBits)
+}
@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package helpers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os/exec"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+// xzCompress compresses data with the xz tool
+func xzCompress(c *C, data []byte, args ...string) []byte {
+	if _, err := exec.LookPath("xz"); err != nil {
+		c.Skip("no xz")
+	}
+
+	cmd := exec.Command("xz", append([]string{"--format=xz", "-c"}, args...)...)
+	cmd.Stdin = bytes.NewReader(data)
+	output, err := cmd.Output()
+	c.Assert(err, IsNil)
+
+	return output
+}
+
+func xzTestData() map[string][]byte {
+	random := make([]byte, 200000)
+	rand.New(rand.NewSource(42)).Read(random)
+
+	// some of both, so there are compressed and stored chunks
+	mixed := append([]byte(strings.Repeat("name: foo\nversion: 1.0\n", 5000)), random...)
+
+	return map[string][]byte{
+		"empty":  nil,
+		"byte":   []byte("x"),
+		"text":   []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 3000)),
+		"random": random,
+		"mixed":  mixed,
+	}
+}
+
+func (ts *HTestSuite) TestXzDecompress(c *C) {
+	for name, data := range xzTestData() {
+		for _, args := range [][]string{
+			{"--check=crc32"},
+			{"--check=crc64"},
+			{"--check=sha256"},
+			{"--check=none"},
+			{"-9e", "--check=crc32"},
+			{"--lzma2=preset=6,lc=0,lp=2,pb=0"},
+			{"--lzma2=preset=6,pb=1"},
+		} {
+			out, err := XzDecompress(xzCompress(c, data, args...), len(data))
+			c.Assert(err, IsNil, Commentf("%s %v", name, args))
+			c.Check(bytes.Equal(out, data), Equals, true, Commentf("%s %v", name, args))
+		}
+	}
+}
+
+func (ts *HTestSuite) TestXzDecompressConcatenated(c *C) {
+	first := xzCompress(c, []byte("hello "))
+	second := xzCompress(c, []byte("world"))
+
+	data := append(append(first, 0, 0, 0, 0), second...)
+	out, err := XzDecompress(data, 1<<20)
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, "hello world")
+}
+
+func (ts *HTestSuite) TestXzDecompressCorrupted(c *C) {
+	data := xzCompress(c, []byte(strings.Repeat("some data ", 100)))
+
+	_, err := XzDecompress(data[:len(data)-1], 1<<20)
+	c.Check(err, NotNil)
+
+	_, err = XzDecompress([]byte("not xz"), 1<<20)
+	c.Check(err, Equals, errXzUnexpected)
+
+	// flip a bit of the compressed data
+	broken := append([]byte(nil), data...)
+	broken[30] ^= 0x10
+	_, err = XzDecompress(broken, 1<<20)
+	c.Check(err, NotNil)
+}
+
+func (ts *HTestSuite) TestXzDecompressTooBig(c *C) {
+	data := []byte(strings.Repeat("some data ", 100000))
+	z := xzCompress(c, data)
+
+	_, err := XzDecompress(z, len(data)-1)
+	c.Check(err, Equals, ErrXzTooBig)
+
+	out, err := XzDecompress(z, len(data))
+	c.Assert(err, IsNil)
+	c.Check(bytes.Equal(out, data), Equals, true)
+}
+
+func (ts *HTestSuite) TestXzDecompressFilters(c *C) {
+	data := xzCompress(c, []byte("some data"), "--x86", "--lzma2")
+
+	_, err := XzDecompress(data, 1<<20)
+	c.Check(err, ErrorMatches, "xz: only the LZMA2 filter alone is supported")
+}
+
+func (ts *HTestSuite) TestNewXzReader(c *C) {
+	for name, data := range xzTestData() {
+		// a small dictionary, so that it is dropped while decoding
+		for _, args := range [][]string{
+			{"--check=crc64"},
+			{"--lzma2=preset=6,dict=4KiB"},
+		} {
+			r := NewXzReader(bytes.NewReader(xzCompress(c, data, args...)))
+			out, err := ioutil.ReadAll(r)
+			c.Assert(err, IsNil, Commentf("%s %v", name, args))
+			c.Check(bytes.Equal(out, data), Equals, true, Commentf("%s %v", name, args))
+			c.Check(r.Close(), IsNil)
+		}
+	}
+}
+
+func (ts *HTestSuite) TestNewXzReaderCorrupted(c *C) {
+	data := xzCompress(c, []byte(strings.Repeat("some data ", 100)))
+
+	_, err := ioutil.ReadAll(NewXzReader(bytes.NewReader(data[:len(data)-1])))
+	c.Check(err, Equals, errXzUnexpected)
+}
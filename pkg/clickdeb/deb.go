@@ -39,10 +39,6 @@ import (
 )
 
 var (
-	// ErrSnapInvalidContent is returned if a snap package contains
-	// invalid content
-	ErrSnapInvalidContent = errors.New("snap contains invalid content")
-
 	// ErrMemberNotFound is returned when a tar member is not found in the archive
 	ErrMemberNotFound = errors.New("member not found")
 )
@@ -59,21 +55,17 @@ func (e *ErrUnpackFailed) Error() string {
 	return fmt.Sprintf("unpack %s to %s failed with %s", e.snapFile, e.instDir, e.origErr)
 }
 
-// simple pipe based xz reader
-func xzPipeReader(r io.Reader) io.Reader {
-	pr, pw := io.Pipe()
-	cmd := exec.Command("xz", "--decompress", "--stdout")
-	cmd.Stdin = r
-	cmd.Stdout = pw
-
-	// run xz in its own go-routine
-	go func() {
-		pw.CloseWithError(cmd.Run())
-	}()
-
-	return pr
+// UnpackLimits are the limits on the content of the snaps that are
+// unpacked, a snap that exceeds them fails to unpack
+var UnpackLimits = helpers.UnpackLimits{
+	MaxSize:  4 << 30,
+	MaxFiles: 500000,
+	MaxDepth: 64,
 }
 
+// maxMemberSize is the size limit of a single member read into memory
+var maxMemberSize int64 = 16 << 20
+
 // simple pipe based xz writer
 type xzPipeWriter struct {
 	cmd *exec.Cmd
@@ -108,19 +100,6 @@ func (x *xzPipeWriter) Close() error {
 	return x.cmd.Wait()
 }
 
-// ensure that the content of our data is valid:
-// - no relative path allowed to prevent writing outside of the parent dir
-func clickVerifyContentFn(path string) (string, error) {
-	path = filepath.Clean(path)
-	// Clean() will remove any internal ".." elements, except if it's a relative
-	// path and the ".." element is the first one.  So let's check for that:
-	if path == ".." || strings.HasPrefix(path, "../") {
-		return "", ErrSnapInvalidContent
-	}
-
-	return path, nil
-}
-
 // ClickDeb provides support for the "click" containers (a special kind of
 // deb package)
 type ClickDeb struct {
@@ -155,6 +134,16 @@ func (d *ClickDeb) Close() error {
 	return d.file.Close()
 }
 
+// NeedsMountUnit returns false, clickdebs are unpacked on install
+func (d *ClickDeb) NeedsMountUnit() bool {
+	return false
+}
+
+// CopyBlob copies the clickdeb to a new place
+func (d *ClickDeb) CopyBlob(targetFile string) error {
+	return helpers.CopyFile(d.Name(), targetFile, helpers.CopyFlagOverwrite)
+}
+
 // Verify checks that the clickdeb is signed
 func (d *ClickDeb) Verify(allowUnauthenticated bool) error {
 	return Verify(d.Name(), allowUnauthenticated)
@@ -187,11 +176,15 @@ func (d *ClickDeb) member(arMember, tarMember string) (content []byte, err error
 	if err != nil {
 		return nil, err
 	}
+	defer dataReader.Close()
 
 	found := false
 	err = helpers.TarIterate(dataReader, func(tr *tar.Reader, hdr *tar.Header) error {
 		if filepath.Clean(hdr.Name) == tarMember {
 			found = true
+			if hdr.Size > maxMemberSize {
+				return &helpers.ErrUnpackTooBig{Limit: maxMemberSize}
+			}
 			content, err = ioutil.ReadAll(tr)
 			if err != nil {
 				return err
@@ -224,8 +217,8 @@ func (d *ClickDeb) ExtractHashes(dir string) error {
 	return ioutil.WriteFile(hashesFile, hashesData, 0644)
 }
 
-// Unpack unpacks the data.tar.{gz,bz2,xz} into the given target directory
-// with click specific verification, i.e. no files will be extracted outside
+// Unpack unpacks the data.tar.{gz,bz2,xz} into the given target directory,
+// streaming and within UnpackLimits. No files will be extracted outside
 // of the targetdir (no ".." inside the data.tar is allowed)
 func (d *ClickDeb) Unpack(targetDir string) error {
 	var err error
@@ -239,13 +232,31 @@ func (d *ClickDeb) Unpack(targetDir string) error {
 	if err != nil {
 		return err
 	}
+	defer dataReader.Close()
 
 	// and unpack
-	return helpers.UnpackTar(dataReader, targetDir, clickVerifyContentFn)
+	return helpers.UnpackTar(dataReader, targetDir, &UnpackLimits, nil)
+}
+
+// UnpackControl unpacks the control.tar.{gz,bz2,xz} into the given
+// target directory
+func (d *ClickDeb) UnpackControl(targetDir string) error {
+	if _, err := d.file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	arReader := ar.NewReader(d.file)
+	controlReader, err := skipToArMember(arReader, "control.tar")
+	if err != nil {
+		return err
+	}
+	defer controlReader.Close()
+
+	return helpers.UnpackTar(controlReader, targetDir, &UnpackLimits, nil)
 }
 
 // FIXME: this should move into the "ar" library itself
-func addFileToAr(arWriter *ar.Writer, filename string) error {
+func addFileToAr(arWriter *ar.Writer, filename string, epoch *time.Time) error {
 	dataF, err := os.Open(filename)
 	if err != nil {
 		return nil
@@ -260,10 +271,14 @@ func addFileToAr(arWriter *ar.Writer, filename string) error {
 	size := stat.Size()
 	hdr := &ar.Header{
 		Name:    filepath.Base(filename),
-		ModTime: time.Now(),
+		ModTime: helpers.ClampTime(time.Now(), epoch),
 		Mode:    int64(stat.Mode()),
 		Size:    size,
 	}
+	// the mode of the temporary file depends on the umask
+	if epoch != nil {
+		hdr.Mode = 0644
+	}
 	arWriter.WriteHeader(hdr)
 	_, err = io.Copy(arWriter, dataF)
 	// io.Copy() is confused by the fact that a ar file must be even
@@ -277,11 +292,11 @@ func addFileToAr(arWriter *ar.Writer, filename string) error {
 }
 
 // FIXME: this should move into the "ar" library itself
-func addDataToAr(arWriter *ar.Writer, filename string, data []byte) error {
+func addDataToAr(arWriter *ar.Writer, filename string, data []byte, epoch *time.Time) error {
 	size := int64(len(data))
 	hdr := &ar.Header{
 		Name:    filename,
-		ModTime: time.Now(),
+		ModTime: helpers.ClampTime(time.Now(), epoch),
 		Mode:    0644,
 		Size:    size,
 	}
@@ -299,8 +314,9 @@ func addDataToAr(arWriter *ar.Writer, filename string, data []byte) error {
 type tarExcludeFunc func(path string) bool
 
 // tarCreate creates a tarfile for a clickdeb, all files in the archive
-// belong to root (same as dpkg-deb)
-func tarCreate(tarname string, sourceDir string, fn tarExcludeFunc) error {
+// belong to root (same as dpkg-deb). If epoch is set the mtimes are
+// clamped to it.
+func tarCreate(tarname string, sourceDir string, epoch *time.Time, fn tarExcludeFunc) error {
 	w, err := os.Create(tarname)
 	if err != nil {
 		return err
@@ -372,6 +388,11 @@ func tarCreate(tarname string, sourceDir string, fn tarExcludeFunc) error {
 		hdr.Gid = 0
 		hdr.Uname = "root"
 		hdr.Gname = "root"
+		hdr.ModTime = helpers.ClampTime(hdr.ModTime, epoch)
+		if epoch != nil {
+			hdr.AccessTime = time.Time{}
+			hdr.ChangeTime = time.Time{}
+		}
 
 		if err := tarWriter.WriteHeader(hdr); err != nil {
 			return err
@@ -397,9 +418,14 @@ func tarCreate(tarname string, sourceDir string, fn tarExcludeFunc) error {
 }
 
 // Build takes a build debian directory with DEBIAN/ dir and creates a
-// clickdeb from it
+// clickdeb from it. If SOURCE_DATE_EPOCH is set the build is
+// reproducible: building the same directory again gives the same
+// clickdeb.
 func (d *ClickDeb) Build(sourceDir string, dataTarFinishedCallback func(dataName string) error) error {
-	var err error
+	epoch, err := helpers.SourceDateEpoch()
+	if err != nil {
+		return err
+	}
 
 	// tmp
 	tempdir, err := ioutil.TempDir("", "data")
@@ -411,7 +437,7 @@ func (d *ClickDeb) Build(sourceDir string, dataTarFinishedCallback func(dataName
 	// we use gz to support signature verification on older ubuntu releases
 	// like trusty that does not support xz yet
 	dataName := filepath.Join(tempdir, "data.tar.gz")
-	err = tarCreate(dataName, sourceDir, func(path string) bool {
+	err = tarCreate(dataName, sourceDir, epoch, func(path string) bool {
 		return !strings.HasPrefix(path, filepath.Join(sourceDir, "DEBIAN"))
 	})
 	if err != nil {
@@ -427,7 +453,7 @@ func (d *ClickDeb) Build(sourceDir string, dataTarFinishedCallback func(dataName
 
 	// create control data (for click compat)
 	controlName := filepath.Join(tempdir, "control.tar.gz")
-	if err := tarCreate(controlName, filepath.Join(sourceDir, "DEBIAN"), nil); err != nil {
+	if err := tarCreate(controlName, filepath.Join(sourceDir, "DEBIAN"), epoch, nil); err != nil {
 		return err
 	}
 
@@ -436,29 +462,31 @@ func (d *ClickDeb) Build(sourceDir string, dataTarFinishedCallback func(dataName
 	arWriter.WriteGlobalHeader()
 
 	// debian magic
-	if err := addDataToAr(arWriter, "debian-binary", []byte("2.0\n")); err != nil {
+	if err := addDataToAr(arWriter, "debian-binary", []byte("2.0\n"), epoch); err != nil {
 		return err
 	}
 
 	// click magic
-	if err := addDataToAr(arWriter, "_click-binary", []byte("0.4\n")); err != nil {
+	if err := addDataToAr(arWriter, "_click-binary", []byte("0.4\n"), epoch); err != nil {
 		return err
 	}
 
 	// control file
-	if err := addFileToAr(arWriter, controlName); err != nil {
+	if err := addFileToAr(arWriter, controlName, epoch); err != nil {
 		return err
 	}
 
 	// data file
-	if err := addFileToAr(arWriter, dataName); err != nil {
+	if err := addFileToAr(arWriter, dataName, epoch); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func skipToArMember(arReader *ar.Reader, memberPrefix string) (io.Reader, error) {
+// skipToArMember returns a reader of the uncompressed content of the ar
+// member with the given prefix, close it when done with it
+func skipToArMember(arReader *ar.Reader, memberPrefix string) (io.ReadCloser, error) {
 	var err error
 
 	// find the right ar member
@@ -474,7 +502,7 @@ func skipToArMember(arReader *ar.Reader, memberPrefix string) (io.Reader, error)
 	}
 
 	// figure out what compression to use
-	var dataReader io.Reader
+	var dataReader io.ReadCloser
 	switch {
 	case strings.HasSuffix(header.Name, ".gz"):
 		dataReader, err = gzip.NewReader(arReader)
@@ -482,9 +510,9 @@ func skipToArMember(arReader *ar.Reader, memberPrefix string) (io.Reader, error)
 			return nil, err
 		}
 	case strings.HasSuffix(header.Name, ".bz2"):
-		dataReader = bzip2.NewReader(arReader)
+		dataReader = ioutil.NopCloser(bzip2.NewReader(arReader))
 	case strings.HasSuffix(header.Name, ".xz"):
-		dataReader = xzPipeReader(arReader)
+		dataReader = helpers.NewXzReader(arReader)
 	default:
 		return nil, fmt.Errorf("Can not handle %s", header.Name)
 	}
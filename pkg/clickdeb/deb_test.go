@@ -29,6 +29,7 @@ import (
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -162,32 +163,100 @@ func (s *ClickDebTestSuite) TestSnapDebUnpack(c *C) {
 	}
 }
 
-func (s *ClickDebTestSuite) TestClickVerifyContentFnSimple(c *C) {
-	newPath, err := clickVerifyContentFn("foo")
+func (s *ClickDebTestSuite) TestSnapDebUnpackXZ(c *C) {
+	debName := makeTestDeb(c, "xz")
+	d, err := Open(debName)
+	c.Assert(err, IsNil)
+	defer d.Close()
+
+	content, err := d.MetaMember("package.yaml")
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "name: foo")
+
+	targetDir := c.MkDir()
+	c.Assert(d.Unpack(targetDir), IsNil)
+	content, err = ioutil.ReadFile(filepath.Join(targetDir, "usr", "bin", "foo"))
 	c.Assert(err, IsNil)
-	c.Assert(newPath, Equals, "foo")
+	c.Check(string(content), Equals, "foo")
 }
 
-func (s *ClickDebTestSuite) TestClickVerifyContentFnStillOk(c *C) {
-	newPath, err := clickVerifyContentFn("./foo/bar/../baz")
+func (s *ClickDebTestSuite) TestSnapDebUnpackLimits(c *C) {
+	oldLimits := UnpackLimits
+	defer func() { UnpackLimits = oldLimits }()
+
+	debName := makeTestDeb(c, "gzip")
+	d, err := Open(debName)
 	c.Assert(err, IsNil)
-	c.Assert(newPath, Equals, "foo/baz")
+	defer d.Close()
+
+	UnpackLimits = helpers.UnpackLimits{MaxSize: 5}
+	c.Check(d.Unpack(c.MkDir()), DeepEquals, &helpers.ErrUnpackTooBig{Limit: 5})
+
+	UnpackLimits = helpers.UnpackLimits{MaxFiles: 3}
+	c.Check(d.Unpack(c.MkDir()), DeepEquals, &helpers.ErrUnpackTooManyFiles{Limit: 3})
+
+	UnpackLimits = helpers.UnpackLimits{MaxDepth: 2}
+	c.Check(d.Unpack(c.MkDir()), DeepEquals, &helpers.ErrUnpackTooDeep{Name: "usr/bin/foo", Limit: 2})
 }
 
-func (s *ClickDebTestSuite) TestClickVerifyContentFnThreeDotsOk(c *C) {
-	newPath, err := clickVerifyContentFn(".../foo")
+func (s *ClickDebTestSuite) TestSnapDebMetaMemberTooBig(c *C) {
+	oldMaxMemberSize := maxMemberSize
+	maxMemberSize = 5
+	defer func() { maxMemberSize = oldMaxMemberSize }()
+
+	d, err := Open(makeTestDeb(c, "gzip"))
 	c.Assert(err, IsNil)
-	c.Assert(newPath, Equals, ".../foo")
+	defer d.Close()
+
+	_, err = d.MetaMember("package.yaml")
+	c.Check(err, DeepEquals, &helpers.ErrUnpackTooBig{Limit: 5})
 }
 
-func (s *ClickDebTestSuite) TestClickVerifyContentFnNotOk(c *C) {
-	_, err := clickVerifyContentFn("./foo/../../baz")
-	c.Assert(err, Equals, ErrSnapInvalidContent)
+func (s *ClickDebTestSuite) TestSnapDebUnpackControl(c *C) {
+	path := filepath.Join(c.MkDir(), "foo_1.0_all.deb")
+	d, err := Create(path)
+	c.Assert(err, IsNil)
+	c.Assert(d.Build(makeTestDebDir(c), nil), IsNil)
+
+	targetDir := c.MkDir()
+	c.Assert(d.UnpackControl(targetDir), IsNil)
+
+	content, err := ioutil.ReadFile(filepath.Join(targetDir, "control"))
+	c.Assert(err, IsNil)
+	c.Check(content, DeepEquals, testDebControl)
+	c.Check(helpers.FileExists(filepath.Join(targetDir, "usr")), Equals, false)
 }
 
-func (s *ClickDebTestSuite) TestClickVerifyContentFnJustTwoDotsNotOk(c *C) {
-	_, err := clickVerifyContentFn("..")
-	c.Assert(err, Equals, ErrSnapInvalidContent)
+func (s *ClickDebTestSuite) TestSnapDebBuildReproducible(c *C) {
+	defer os.Unsetenv(helpers.SourceDateEpochEnv)
+	os.Setenv(helpers.SourceDateEpochEnv, "1440000000")
+
+	builddir := makeTestDebDir(c)
+	build := func() string {
+		debName := filepath.Join(c.MkDir(), "foo_1.0_all.deb")
+		d, err := Create(debName)
+		c.Assert(err, IsNil)
+		c.Assert(d.Build(builddir, nil), IsNil)
+		c.Assert(d.Close(), IsNil)
+
+		sha512, err := helpers.Sha512sum(debName)
+		c.Assert(err, IsNil)
+		return sha512
+	}
+
+	first := build()
+
+	// newer files and another umask make no difference
+	later := time.Now().Add(time.Hour)
+	err := filepath.Walk(builddir, func(path string, info os.FileInfo, err error) error {
+		c.Assert(err, IsNil)
+		return os.Chtimes(path, later, later)
+	})
+	c.Assert(err, IsNil)
+	oldUmask := syscall.Umask(077)
+	defer syscall.Umask(oldUmask)
+
+	c.Check(build(), Equals, first)
 }
 
 func (s *ClickDebTestSuite) TestTarCreate(c *C) {
@@ -208,7 +277,7 @@ func (s *ClickDebTestSuite) TestTarCreate(c *C) {
 	// create tar
 	tempdir := c.MkDir()
 	tarfile := filepath.Join(tempdir, "data.tar.xz")
-	err = tarCreate(tarfile, builddir, func(path string) bool {
+	err = tarCreate(tarfile, builddir, nil, func(path string) bool {
 		return !strings.HasSuffix(path, "exclude-me")
 	})
 	c.Assert(err, IsNil)
@@ -255,6 +324,6 @@ func (s *ClickDebTestSuite) TestTarCreateUnknownTypeFailsWithError(c *C) {
 	tempdir := c.MkDir()
 	tarfile := filepath.Join(tempdir, "data.tar.xz")
 
-	err = tarCreate(tarfile, builddir, nil)
+	err = tarCreate(tarfile, builddir, nil, nil)
 	c.Assert(err, ErrorMatches, "unsupported file type for.*")
 }
@@ -20,78 +20,394 @@
 package clickdeb
 
 import (
+	"bytes"
+	"encoding/xml"
+	"errors"
 	"fmt"
-	"os/exec"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
 
-	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/logger"
+
+	"github.com/blakesmith/ar"
 )
 
-const (
-	// from debsig-verify-0.9/debsigs.h (also in debsig-verify(1))
-	dsSuccess           = 0
-	dsFailNosigs        = 10
-	dsFailUnknownOrigin = 11
-	dsFailNopolicies    = 12
-	dsFailBadsig        = 13
-	dsFailInternal      = 14
+// This is the "_gpgorigin" check of debsig-verify(1): the origin
+// signature is a detached signature of the debian-binary, control.tar
+// and data.tar members, in that order. The key id of the signature
+// selects the policies in PoliciesDir/<key id>/*.pol, the first policy
+// that applies says which keyrings in KeyringsDir/<key id>/ the
+// signature must verify against.
+
+var (
+	// PoliciesDir is the directory with the debsig policies
+	PoliciesDir = "/etc/debsig/policies"
+
+	// KeyringsDir is the directory with the debsig keyrings
+	KeyringsDir = "/usr/share/debsig/keyrings"
 )
 
-// error messages from debsig-verify(1), edited for brevity
-var dsErrMsg = map[int]string{
-	dsFailNosigs:        "No signatures, or no origin signature.",
-	dsFailUnknownOrigin: "No policies subdirectory for origin signature.",
-	dsFailNopolicies:    "No policies passed selection; no verification done.",
-	dsFailBadsig:        "Bad signature, or other verification criteria failed.",
-	dsFailInternal:      "Internal error. E.g. Package corrupt, gpg failed, etc.",
+// the name of the ar member with the origin signature
+const originSigMember = "_gpgorigin"
+
+// ErrNoSignature is returned if a snap has no origin signature
+var ErrNoSignature = errors.New("Signature verification failed: no origin signature")
+
+// ErrUnknownKey is returned if a snap is signed by a key that has no
+// policies
+type ErrUnknownKey struct {
+	KeyID string
 }
 
-// ErrSignature is returned if a snap failed the signature verification
-type ErrSignature struct {
-	exitCode int
-	err      error
+func (e *ErrUnknownKey) Error() string {
+	return fmt.Sprintf("Signature verification failed: no policies for key %s", e.KeyID)
 }
 
-func (e *ErrSignature) Error() string {
-	if e.err != nil {
-		return fmt.Sprintf("Signature verification failed: %v", e.err)
-	}
+// ErrPolicyMismatch is returned if none of the policies for the key
+// the snap is signed with applies
+type ErrPolicyMismatch struct {
+	KeyID string
+	Msg   string
+}
 
-	dsc, ok := dsErrMsg[e.exitCode]
-	if !ok {
-		return fmt.Sprintf("Signature verification failed with exit status %v", e.exitCode)
+func (e *ErrPolicyMismatch) Error() string {
+	return fmt.Sprintf("Signature verification failed: no policy for key %s applies: %s", e.KeyID, e.Msg)
+}
+
+// ErrBadSignature is returned if the signature does not verify
+// against the keyrings of the policy
+type ErrBadSignature struct {
+	KeyID  string
+	Policy string
+	Err    error
+}
+
+func (e *ErrBadSignature) Error() string {
+	if e.Policy == "" {
+		return fmt.Sprintf("Signature verification failed: bad signature: %v", e.Err)
 	}
 
-	return fmt.Sprintf("Signature verification failed: %s (exit code %d)", dsc, e.exitCode)
+	return fmt.Sprintf("Signature verification failed: bad signature by key %s (policy %s): %v", e.KeyID, e.Policy, e.Err)
 }
 
-// This function checks if the given exitCode is "ok" when running with
+// This function checks if the given error is "ok" when running with
 // --allow-unauthenticated. We allow package with no signature or with
-// a unknown policy or with no policies at all. We do not allow overriding
-// bad signatures
-func allowUnauthenticatedOkExitCode(exitCode int) bool {
-	return (exitCode == dsFailNosigs ||
-		exitCode == dsFailUnknownOrigin ||
-		exitCode == dsFailNopolicies)
-}
-
-// Verify is a tiny wrapper around debsig-verify
-func Verify(clickFile string, allowUnauthenticated bool) (err error) {
-	cmd := exec.Command(VerifyCmd, clickFile)
-	if err := cmd.Run(); err != nil {
-		exitCode, err := helpers.ExitCode(err)
-		if err == nil {
-			if allowUnauthenticated && allowUnauthenticatedOkExitCode(exitCode) {
-				logger.Noticef("Signature check failed, but installing anyway as requested")
-				return nil
+// a unknown key or with no policy that applies. We do not allow
+// overriding bad signatures
+func allowUnauthenticatedOk(err error) bool {
+	switch err.(type) {
+	case *ErrUnknownKey, *ErrPolicyMismatch:
+		return true
+	}
+
+	return err == ErrNoSignature
+}
+
+// Verify checks the origin signature of the given clickdeb
+var Verify = func(clickFile string, allowUnauthenticated bool) error {
+	err := verifyOriginSignature(clickFile)
+	if err != nil && allowUnauthenticated && allowUnauthenticatedOk(err) {
+		logger.Noticef("Signature check failed, but installing anyway as requested")
+		return nil
+	}
+
+	return err
+}
+
+// debsig policy files, see /usr/share/doc/debsig-verify/policy.dtd
+type debsigPolicy struct {
+	Origin struct {
+		Name string `xml:"Name,attr"`
+		ID   string `xml:"id,attr"`
+	}
+	Selection    debsigRules
+	Verification debsigRules
+}
+
+type debsigRules struct {
+	MinOptional int          `xml:"MinOptional,attr"`
+	Required    []debsigRule `xml:"Required"`
+	Optional    []debsigRule `xml:"Optional"`
+	Reject      []debsigRule `xml:"Reject"`
+}
+
+type debsigRule struct {
+	Type string `xml:"Type,attr"`
+	File string `xml:"File,attr"`
+	ID   string `xml:"id,attr"`
+}
+
+func verifyOriginSignature(clickFile string) error {
+	f, err := os.Open(clickFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	signed, sig, err := readSignedMembers(f)
+	if err != nil {
+		return err
+	}
+	if sig == nil {
+		return ErrNoSignature
+	}
+
+	keyID, err := signatureKeyID(sig)
+	if err != nil {
+		return &ErrBadSignature{Err: err}
+	}
+
+	policies, err := filepath.Glob(filepath.Join(PoliciesDir, keyID, "*.pol"))
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		return &ErrUnknownKey{KeyID: keyID}
+	}
+	sort.Strings(policies)
+
+	var mismatch []string
+	for _, policyFile := range policies {
+		policy, err := readPolicy(policyFile)
+		if err != nil {
+			mismatch = append(mismatch, fmt.Sprintf("%s: %v", filepath.Base(policyFile), err))
+			continue
+		}
+		if err := policy.selects(keyID); err != nil {
+			mismatch = append(mismatch, fmt.Sprintf("%s: %v", filepath.Base(policyFile), err))
+			continue
+		}
+
+		// the first policy that applies decides
+		if err := policy.verify(keyID, signed, sig); err != nil {
+			return &ErrBadSignature{KeyID: keyID, Policy: filepath.Base(policyFile), Err: err}
+		}
+
+		return nil
+	}
+
+	return &ErrPolicyMismatch{KeyID: keyID, Msg: strings.Join(mismatch, ", ")}
+}
+
+// signedMembers are where the members the origin signature is of are in
+// the clickdeb
+type signedMembers struct {
+	f io.ReaderAt
+	// the offset and size of each member, in order
+	sections [][2]int64
+}
+
+// reader returns a reader of the signed content, the members one after
+// the other
+func (m *signedMembers) reader() io.Reader {
+	readers := make([]io.Reader, len(m.sections))
+	for i, section := range m.sections {
+		readers[i] = io.NewSectionReader(m.f, section[0], section[1])
+	}
+
+	return io.MultiReader(readers...)
+}
+
+// the prefixes of the names of the signed members, in the order they
+// have to be in
+var signedMemberPrefixes = []string{"debian-binary", "control.tar", "data.tar"}
+
+// readSignedMembers finds the signed members of the clickdeb, which
+// have to be there once each and in order, and reads the origin
+// signature, if there is one
+func readSignedMembers(f *os.File) (signed *signedMembers, sig []byte, err error) {
+	signed = &signedMembers{f: f}
+	arReader := ar.NewReader(f)
+	for {
+		header, err := arReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := strings.TrimSuffix(strings.TrimSpace(header.Name), "/")
+		if name == originSigMember {
+			if sig != nil {
+				return nil, nil, fmt.Errorf("more than one %s member", originSigMember)
+			}
+			sig, err = ioutil.ReadAll(arReader)
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		for i, prefix := range signedMemberPrefixes {
+			if !strings.HasPrefix(name, prefix) {
+				continue
 			}
-			return &ErrSignature{exitCode: exitCode}
+			if i != len(signed.sections) {
+				return nil, nil, fmt.Errorf("member %s is out of order or repeated", name)
+			}
+
+			// the ar reader has seeked to the start of the member
+			pos, err := f.Seek(0, os.SEEK_CUR)
+			if err != nil {
+				return nil, nil, err
+			}
+			signed.sections = append(signed.sections, [2]int64{pos, header.Size})
+		}
+	}
+
+	if len(signed.sections) != len(signedMemberPrefixes) {
+		return nil, nil, fmt.Errorf("missing member %s", signedMemberPrefixes[len(signed.sections)])
+	}
+
+	return signed, sig, nil
+}
+
+// signatureReader returns a reader for the binary signature, which
+// may be ascii armored
+func signatureReader(sig []byte) (io.Reader, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN")) {
+		return bytes.NewReader(sig), nil
+	}
+
+	block, err := armor.Decode(bytes.NewReader(sig))
+	if err != nil {
+		return nil, err
+	}
+
+	return block.Body, nil
+}
+
+// signatureKeyID returns the long key id of the signature the way
+// debsig names its directories, e.g. "F1D4F2A4A2B5A8D3"
+func signatureKeyID(sig []byte) (string, error) {
+	r, err := signatureReader(sig)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := packet.Read(r)
+	if err != nil {
+		return "", err
+	}
+
+	switch s := p.(type) {
+	case *packet.Signature:
+		if s.IssuerKeyId == nil {
+			return "", fmt.Errorf("signature has no issuer")
+		}
+		return fmt.Sprintf("%016X", *s.IssuerKeyId), nil
+	case *packet.SignatureV3:
+		return fmt.Sprintf("%016X", s.IssuerKeyId), nil
+	}
+
+	return "", fmt.Errorf("%s is not a signature", originSigMember)
+}
+
+func readPolicy(policyFile string) (*debsigPolicy, error) {
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy debsigPolicy
+	if err := xml.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// selects checks that the policy applies to a snap signed by keyID.
+// Only origin signatures are supported, so a policy that asks for
+// anything else does not apply.
+func (p *debsigPolicy) selects(keyID string) error {
+	if !strings.EqualFold(p.Origin.ID, keyID) {
+		return fmt.Errorf("policy is for key %s", p.Origin.ID)
+	}
+
+	for _, rule := range p.Selection.Required {
+		if rule.Type != "origin" {
+			return fmt.Errorf("%s signature required", rule.Type)
+		}
+		if rule.ID != "" && !strings.EqualFold(rule.ID, keyID) {
+			return fmt.Errorf("signature by key %s required", rule.ID)
+		}
+	}
+	for _, rule := range p.Selection.Reject {
+		if rule.Type == "origin" {
+			return fmt.Errorf("origin signature rejected")
+		}
+	}
+
+	return nil
+}
+
+// verify checks the signature against the keyrings the policy requires
+func (p *debsigPolicy) verify(keyID string, signed *signedMembers, sig []byte) error {
+	verified := 0
+	for _, rule := range p.Verification.Required {
+		if rule.Type != "origin" {
+			return fmt.Errorf("%s signature required", rule.Type)
+		}
+		if err := checkSignature(filepath.Join(KeyringsDir, keyID, rule.File), signed, sig); err != nil {
+			return err
+		}
+		verified++
+	}
+
+	optional := 0
+	for _, rule := range p.Verification.Optional {
+		if rule.Type != "origin" {
+			continue
+		}
+		if checkSignature(filepath.Join(KeyringsDir, keyID, rule.File), signed, sig) == nil {
+			optional++
 		}
-		// not a exit code error, something else, pass on
-		return &ErrSignature{err: err}
 	}
+	if optional < p.Verification.MinOptional {
+		return fmt.Errorf("%d of %d optional signatures verified", optional, p.Verification.MinOptional)
+	}
+
+	if verified+optional == 0 {
+		return fmt.Errorf("policy does not verify anything")
+	}
+
 	return nil
 }
 
-// VerifyCmd is the command to run for Verify
-var VerifyCmd = "debsig-verify"
+// checkSignature checks the detached signature of signed against the
+// keys in the given keyring
+func checkSignature(keyringFile string, signed *signedMembers, sig []byte) error {
+	data, err := ioutil.ReadFile(keyringFile)
+	if err != nil {
+		return err
+	}
+
+	var keyring openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+
+	r, err := signatureReader(sig)
+	if err != nil {
+		return err
+	}
+
+	_, err = openpgp.CheckDetachedSignature(keyring, signed.reader(), r)
+
+	return err
+}
@@ -20,68 +20,201 @@
 package clickdeb
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
 
 	. "gopkg.in/check.v1"
+
+	"github.com/blakesmith/ar"
 )
 
 var _ = Suite(&VerifyTestSuite{})
 
 type VerifyTestSuite struct {
-	old string
+	policiesDir string
+	keyringsDir string
+
+	signer *openpgp.Entity
+	other  *openpgp.Entity
+}
+
+const testPolicy = `<?xml version="1.0"?>
+<!DOCTYPE Policy SYSTEM "http://www.debian.org/debsig/1.0/policy.dtd">
+<Policy xmlns="http://www.debian.org/debsig/1.0/">
+  <Origin Name="test" id="%[1]s" Description="Test key"/>
+  <Selection>
+    <Required Type="%[2]s" File="test.gpg" id="%[1]s"/>
+  </Selection>
+  <Verification MinOptional="0">
+    <Required Type="origin" File="test.gpg" id="%[1]s"/>
+  </Verification>
+</Policy>
+`
+
+func (s *VerifyTestSuite) SetUpSuite(c *C) {
+	var err error
+	s.signer, err = openpgp.NewEntity("snappy test", "", "test@example.com", nil)
+	c.Assert(err, IsNil)
+	s.other, err = openpgp.NewEntity("someone else", "", "other@example.com", nil)
+	c.Assert(err, IsNil)
 }
 
 func (s *VerifyTestSuite) SetUpTest(c *C) {
-	s.old = VerifyCmd
+	s.policiesDir = PoliciesDir
+	s.keyringsDir = KeyringsDir
+	PoliciesDir = c.MkDir()
+	KeyringsDir = c.MkDir()
 }
 
 func (s *VerifyTestSuite) TearDownTest(c *C) {
-	VerifyCmd = s.old
+	PoliciesDir = s.policiesDir
+	KeyringsDir = s.keyringsDir
 }
 
-func (s *VerifyTestSuite) mksig(exitcode int, c *C) string {
-	f := filepath.Join(c.MkDir(), "fakedebsig")
-	cmd := fmt.Sprintf("#!/bin/sh\nexit %d\n", exitcode)
-	c.Assert(ioutil.WriteFile(f, []byte(cmd), 0755), IsNil)
-	return f
+func keyID(e *openpgp.Entity) string {
+	return fmt.Sprintf("%016X", e.PrimaryKey.KeyId)
 }
 
-func (s *VerifyTestSuite) TestLocalSnapInstallDebsigVerifyFails(c *C) {
-	VerifyCmd = "false"
+// trust installs a policy of the given selection type and a keyring
+// with the given key for the key id of signer
+func (s *VerifyTestSuite) trust(c *C, signer, key *openpgp.Entity, selection string) {
+	policyDir := filepath.Join(PoliciesDir, keyID(signer))
+	c.Assert(os.MkdirAll(policyDir, 0755), IsNil)
+	policy := fmt.Sprintf(testPolicy, keyID(signer), selection)
+	c.Assert(ioutil.WriteFile(filepath.Join(policyDir, "test.pol"), []byte(policy), 0644), IsNil)
+
+	keyringDir := filepath.Join(KeyringsDir, keyID(signer))
+	c.Assert(os.MkdirAll(keyringDir, 0755), IsNil)
+	var buf bytes.Buffer
+	c.Assert(key.Serialize(&buf), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(keyringDir, "test.gpg"), buf.Bytes(), 0644), IsNil)
+}
+
+// makeSignedDeb builds a clickdeb with an origin signature by signer,
+// like debsigs does
+func makeSignedDeb(c *C, signer *openpgp.Entity, armored bool) string {
+	path := filepath.Join(c.MkDir(), "foo_1.0_all.deb")
+	d, err := Create(path)
+	c.Assert(err, IsNil)
+	c.Assert(d.Build(makeTestDebDir(c), nil), IsNil)
+	c.Assert(d.Close(), IsNil)
+
+	if signer == nil {
+		return path
+	}
+
+	// the signed content is debian-binary, control.tar and data.tar
+	f, err := os.Open(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	var signed bytes.Buffer
+	arReader := ar.NewReader(f)
+	for {
+		hdr, err := arReader.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		if hdr.Name == "debian-binary" || strings.HasPrefix(hdr.Name, "control.tar") || strings.HasPrefix(hdr.Name, "data.tar") {
+			_, err = io.Copy(&signed, arReader)
+			c.Assert(err, IsNil)
+		}
+	}
+
+	var sig bytes.Buffer
+	if armored {
+		err = openpgp.ArmoredDetachSign(&sig, signer, &signed, nil)
+	} else {
+		err = openpgp.DetachSign(&sig, signer, &signed, nil)
+	}
+	c.Assert(err, IsNil)
 
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	defer w.Close()
+	c.Assert(addDataToAr(ar.NewWriter(w), "_gpgorigin", sig.Bytes(), nil), IsNil)
+
+	return path
 }
 
-func (s *VerifyTestSuite) TestReportsSuccess(c *C) {
-	VerifyCmd = "true"
+func (s *VerifyTestSuite) TestVerifyGood(c *C) {
+	s.trust(c, s.signer, s.signer, "origin")
 
-	c.Check(Verify("", true), IsNil)
+	c.Check(Verify(makeSignedDeb(c, s.signer, false), false), IsNil)
+	c.Check(Verify(makeSignedDeb(c, s.signer, true), false), IsNil)
 }
 
-func (s *VerifyTestSuite) TestReportsFailure(c *C) {
-	VerifyCmd = "false"
+func (s *VerifyTestSuite) TestVerifyNoSignature(c *C) {
+	deb := makeSignedDeb(c, nil, false)
 
-	err := Verify("", true)
-	c.Assert(err, NotNil)
-	c.Check(err, ErrorMatches, `Signature verification failed with exit status \d+`)
+	c.Check(Verify(deb, false), Equals, ErrNoSignature)
+	c.Check(Verify(deb, true), IsNil)
 }
 
-func (s *VerifyTestSuite) TestReportsOtherFailure(c *C) {
-	VerifyCmd = "/no/such/thing"
+func (s *VerifyTestSuite) TestVerifyUnknownKey(c *C) {
+	s.trust(c, s.signer, s.signer, "origin")
+	deb := makeSignedDeb(c, s.other, false)
+
+	c.Check(Verify(deb, false), DeepEquals, &ErrUnknownKey{KeyID: keyID(s.other)})
+	c.Check(Verify(deb, true), IsNil)
+}
+
+func (s *VerifyTestSuite) TestVerifyPolicyMismatch(c *C) {
+	s.trust(c, s.signer, s.signer, "maint")
+	deb := makeSignedDeb(c, s.signer, false)
+
+	err := Verify(deb, false)
+	c.Assert(err, FitsTypeOf, &ErrPolicyMismatch{})
+	c.Check(err, ErrorMatches, "Signature verification failed: no policy for key .* applies: test.pol: maint signature required")
+	c.Check(Verify(deb, true), IsNil)
+}
+
+func (s *VerifyTestSuite) TestVerifyBadSignature(c *C) {
+	// the policy is for the key id, but the keyring has another key
+	s.trust(c, s.signer, s.other, "origin")
+	deb := makeSignedDeb(c, s.signer, false)
+
+	err := Verify(deb, false)
+	c.Assert(err, FitsTypeOf, &ErrBadSignature{})
+	c.Check(err.(*ErrBadSignature).KeyID, Equals, keyID(s.signer))
+	c.Check(err.(*ErrBadSignature).Policy, Equals, "test.pol")
+	c.Check(Verify(deb, true), FitsTypeOf, &ErrBadSignature{})
+}
+
+func (s *VerifyTestSuite) TestVerifyTampered(c *C) {
+	s.trust(c, s.signer, s.signer, "origin")
+	deb := makeSignedDeb(c, s.signer, false)
+
+	// change the debian-binary member
+	content, err := ioutil.ReadFile(deb)
+	c.Assert(err, IsNil)
+	content = bytes.Replace(content, []byte("2.0\n"), []byte("2.1\n"), 1)
+	c.Assert(ioutil.WriteFile(deb, content, 0644), IsNil)
 
-	err := Verify("", true)
-	c.Assert(err, NotNil)
-	c.Check(err, ErrorMatches, `Signature verification failed: .*`)
+	err = Verify(deb, true)
+	c.Assert(err, FitsTypeOf, &ErrBadSignature{})
+	c.Check(err, ErrorMatches, "Signature verification failed: bad signature by key .* \\(policy test.pol\\): .*")
 }
 
-func (s *VerifyTestSuite) TestReportsFailureSig(c *C) {
-	VerifyCmd = s.mksig(dsFailNosigs, c)
+func (s *VerifyTestSuite) TestVerifyRepeatedMember(c *C) {
+	s.trust(c, s.signer, s.signer, "origin")
+	deb := makeSignedDeb(c, s.signer, false)
+	c.Assert(Verify(deb, false), IsNil)
 
-	err := Verify("", true)
+	// another data member after the signed ones, e.g. for an
+	// unpacker that takes the last one
+	w, err := os.OpenFile(deb, os.O_WRONLY|os.O_APPEND, 0644)
 	c.Assert(err, IsNil)
+	c.Assert(addDataToAr(ar.NewWriter(w), "data.tar.gz", []byte("evil"), nil), IsNil)
+	c.Assert(w.Close(), IsNil)
 
-	err = Verify("", false)
-	c.Assert(err, NotNil)
-	c.Check(err, ErrorMatches, `Signature verification failed.*`)
+	c.Check(Verify(deb, false), ErrorMatches, "member data.tar.gz is out of order or repeated")
+	c.Check(Verify(deb, true), NotNil)
 }
@@ -27,7 +27,9 @@ import (
 type Snap struct {
 	Alias           string             `json:"alias,omitempty"`
 	AnonDownloadURL string             `json:"anon_download_url,omitempty"`
+	Architectures   []string           `json:"architecture,omitempty"`
 	Channel         string             `json:"channel,omitempty"`
+	Deltas          []Delta            `json:"deltas,omitempty"`
 	DownloadSha512  string             `json:"download_sha512,omitempty"`
 	Description     string             `json:"description,omitempty"`
 	DownloadSize    int64              `json:"binary_filesize,omitempty"`
@@ -39,8 +41,26 @@ type Snap struct {
 	Prices          map[string]float64 `json:"prices,omitempty"`
 	Publisher       string             `json:"publisher,omitempty"`
 	RatingsAverage  float64            `json:"ratings_average,omitempty"`
+	Releases        []string           `json:"release,omitempty"`
+	SignatureURL    string             `json:"signature_url,omitempty"`
 	SupportURL      string             `json:"support_url"`
 	Title           string             `json:"title"`
 	Type            pkg.Type           `json:"content,omitempty"`
 	Version         string             `json:"version"`
 }
+
+// A Delta encapsulates the data the store sends us about a binary delta
+// that turns a previous version of a snap into this one.
+type Delta struct {
+	// Format is the delta format, e.g. "xdelta3" or "bsdiff"
+	Format string `json:"format"`
+	// FromVersion is the version the delta applies to
+	FromVersion string `json:"from_version"`
+	// FromSha512 is the sha512 of the snap the delta applies to
+	FromSha512 string `json:"from_sha512"`
+
+	AnonDownloadURL string `json:"anon_download_url,omitempty"`
+	DownloadURL     string `json:"download_url,omitempty"`
+	DownloadSha512  string `json:"download_sha512,omitempty"`
+	DownloadSize    int64  `json:"binary_filesize,omitempty"`
+}
@@ -112,7 +112,10 @@ func (r *Removed) IsInstalled() bool { return false }
 func (r *Removed) NeedsReboot() bool { return false }
 
 // Date from the snappy.Part interface
-func (r *Removed) Date() time.Time { return time.Time{} } // XXX: keep track of when the package was removed
+func (r *Removed) Date() time.Time {
+	return snappy.LastRemoval(r.name, r.origin, r.version)
+}
+
 // Channel from the snappy.Part interface
 func (r *Removed) Channel() string { return "" }
 
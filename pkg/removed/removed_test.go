@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"gopkg.in/check.v1"
 
@@ -135,3 +136,20 @@ func (s *removedSuite) TestWithStore(c *check.C) {
 	_, err = part.Frameworks()
 	c.Check(err, check.Equals, ErrRemoved)
 }
+
+func (s *removedSuite) TestDate(c *check.C) {
+	part := New("foo", "bar", "1", pkg.TypeApp)
+	c.Check(part.Date().IsZero(), check.Equals, true)
+
+	when := time.Date(2015, 10, 20, 12, 0, 0, 0, time.UTC)
+	c.Assert(snappy.RecordHistory(&snappy.HistoryEntry{
+		Time:       when,
+		Op:         snappy.HistoryRemove,
+		Name:       "foo",
+		Origin:     "bar",
+		OldVersion: "1",
+		Initiator:  "cli:root",
+		Outcome:    "ok",
+	}), check.IsNil)
+	c.Check(part.Date().Equal(when), check.Equals, true)
+}
@@ -21,8 +21,6 @@ package snapfs
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -33,6 +31,9 @@ import (
 // Snap is the squashfs based snap
 type Snap struct {
 	path string
+
+	// the origin from the signature, once verified
+	signedOrigin string
 }
 
 // Name returns the Name of the backing file
@@ -65,6 +66,12 @@ func (s *Snap) ExtractHashes(dir string) error {
 	return nil
 }
 
+// NeedsMountUnit returns true, snapfs snaps are mounted rather than
+// unpacked on install
+func (s *Snap) NeedsMountUnit() bool {
+	return true
+}
+
 // UnpackWithDropPrivs unpacks the meta and puts stuff in place - COMAPT
 func (s *Snap) UnpackWithDropPrivs(instDir, rootdir string) error {
 	// FIXME: actually drop privs
@@ -91,23 +98,24 @@ var runCommand = func(args ...string) error {
 
 // Unpack unpacks the src (which may be a glob into the given target dir
 func (s *Snap) Unpack(src, dstDir string) error {
-	return runCommand("unsquashfs", "-f", "-i", "-d", dstDir, s.path, src)
+	fs, err := openSquashfs(s.path)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+
+	return fs.extract(src, dstDir)
 }
 
 // ReadFile returns the content of a single file inside a snapfs snap
 func (s *Snap) ReadFile(path string) (content []byte, err error) {
-	tmpdir, err := ioutil.TempDir("", "read-file")
+	fs, err := openSquashfs(s.path)
 	if err != nil {
 		return nil, err
 	}
-	defer os.RemoveAll(tmpdir)
-
-	unpackDir := filepath.Join(tmpdir, "unpack")
-	if err := runCommand("unsquashfs", "-i", "-d", unpackDir, s.path, path); err != nil {
-		return nil, err
-	}
+	defer fs.Close()
 
-	return ioutil.ReadFile(filepath.Join(unpackDir, path))
+	return fs.readFile(path)
 }
 
 // CopyBlob copies the snap to a new place
@@ -116,27 +124,34 @@ func (s *Snap) CopyBlob(targetFile string) error {
 	return runCommand("cp", "-a", s.path, targetFile)
 }
 
-// Verify verifies the snap
-func (s *Snap) Verify(unauthOk bool) error {
-	// FIXME: there is no verification yet for snapfs packages, this
-	//        will be done via assertions later for now we rely on
-	//        the https security
-	return nil
-}
-
-// Build builds the snap
+// Build builds the snap. If SOURCE_DATE_EPOCH is set the build is
+// reproducible: it is passed to mksquashfs as the time of every file
+// and of the filesystem itself, as not all versions of mksquashfs read
+// it from the environment, and xattrs of the build dir are left out.
 func (s *Snap) Build(buildDir string) error {
 	fullSnapPath, err := filepath.Abs(s.path)
 	if err != nil {
 		return err
 	}
 
+	epoch, err := helpers.SourceDateEpoch()
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"mksquashfs",
+		".", fullSnapPath,
+		"-all-root",
+		"-noappend",
+		"-comp", "xz",
+	}
+	if epoch != nil {
+		t := fmt.Sprint(epoch.Unix())
+		args = append(args, "-all-time", t, "-mkfs-time", t, "-no-xattrs")
+	}
+
 	return helpers.ChDir(buildDir, func() error {
-		return runCommand(
-			"mksquashfs",
-			".", fullSnapPath,
-			"-all-root",
-			"-noappend",
-			"-comp", "xz")
+		return runCommand(args...)
 	})
 }
@@ -20,6 +20,7 @@
 package snapfs
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -27,6 +28,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ubuntu-core/snappy/helpers"
 
@@ -78,10 +80,12 @@ func (s *SquashfsTestSuite) TestName(c *C) {
 	c.Assert(snap.Name(), Equals, "foo.snap")
 }
 
-// FIXME: stub that needs to be fleshed out once assertions land
-//        and we actually do verify
 func (s *SquashfsTestSuite) TestVerify(c *C) {
+	// no signature, see verify_test.go for the rest
 	err := New("foo").Verify(false)
+	c.Assert(err, ErrorMatches, "Signature verification failed: No signature.")
+
+	err = New("foo").Verify(true)
 	c.Assert(err, IsNil)
 }
 
@@ -157,6 +161,53 @@ squashfs-root/random/dir
 `)
 }
 
+func (s *SquashfsTestSuite) TestBuildReproducible(c *C) {
+	defer os.Unsetenv(helpers.SourceDateEpochEnv)
+	os.Setenv(helpers.SourceDateEpochEnv, "1440000000")
+
+	var args []string
+	origRunCommand := runCommand
+	defer func() { runCommand = origRunCommand }()
+	runCommand = func(cmd ...string) error {
+		args = cmd
+		c.Check(os.Getenv(helpers.SourceDateEpochEnv), Equals, "1440000000")
+		return nil
+	}
+
+	snap := New(filepath.Join(c.MkDir(), "foo.snap"))
+	c.Assert(snap.Build(c.MkDir()), IsNil)
+	c.Check(args, DeepEquals, []string{"mksquashfs", ".", snap.path, "-all-root", "-noappend", "-comp", "xz", "-all-time", "1440000000", "-mkfs-time", "1440000000", "-no-xattrs"})
+
+	os.Setenv(helpers.SourceDateEpochEnv, "now")
+	c.Check(snap.Build(c.MkDir()), ErrorMatches, `invalid SOURCE_DATE_EPOCH "now"`)
+}
+
+func (s *SquashfsTestSuite) TestBuildReproducibleBytes(c *C) {
+	defer os.Unsetenv(helpers.SourceDateEpochEnv)
+	os.Setenv(helpers.SourceDateEpochEnv, "1440000000")
+
+	buildDir := c.MkDir()
+	data := filepath.Join(buildDir, "data.bin")
+	c.Assert(ioutil.WriteFile(data, []byte("data"), 0644), IsNil)
+
+	build := func() []byte {
+		snap := New(filepath.Join(c.MkDir(), "foo.snap"))
+		c.Assert(snap.Build(buildDir), IsNil)
+		content, err := ioutil.ReadFile(snap.path)
+		c.Assert(err, IsNil)
+		return content
+	}
+
+	first := build()
+	// a later build, with files touched since
+	later := time.Now().Add(time.Hour)
+	c.Assert(os.Chtimes(data, later, later), IsNil)
+	c.Assert(os.Chtimes(buildDir, later, later), IsNil)
+	second := build()
+
+	c.Check(bytes.Equal(first, second), Equals, true)
+}
+
 func (s *SquashfsTestSuite) TestRunCommandGood(c *C) {
 	err := runCommand("true")
 	c.Assert(err, IsNil)
@@ -0,0 +1,866 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// This is a reader for the squashfs 4.0 filesystems snapfs snaps are,
+// so that they can be read without squashfs-tools. See
+// Documentation/filesystems/squashfs.txt in the kernel tree, and
+// fs/squashfs/squashfs_fs.h for the details of the format.
+
+const (
+	squashfsMagic        = 0x73717368
+	squashfsMetaSize     = 8192
+	squashfsMetaUnpacked = 1 << 15
+	squashfsDataUnpacked = 1 << 24
+	squashfsNoFragment   = 0xffffffff
+
+	// the 4.0 compressors that snaps can use
+	squashfsZlib = 1
+	squashfsXz   = 4
+
+	// symlinks can not be longer than PATH_MAX
+	squashfsMaxSymlink = 4096
+	// how many symlinks ReadFile follows
+	squashfsMaxSymlinks = 40
+)
+
+// the inode types; the directory entries have the basic ones
+const (
+	squashfsDirType = iota + 1
+	squashfsFileType
+	squashfsSymlinkType
+	squashfsBlkdevType
+	squashfsChrdevType
+	squashfsFifoType
+	squashfsSocketType
+	squashfsLDirType
+	squashfsLFileType
+	squashfsLSymlinkType
+	squashfsLBlkdevType
+	squashfsLChrdevType
+	squashfsLFifoType
+	squashfsLSocketType
+)
+
+var errSquashfsCorrupted = errors.New("squashfs: corrupted filesystem")
+
+// ErrNotInSnap is returned when a path is not in the snap
+type ErrNotInSnap string
+
+func (e ErrNotInSnap) Error() string {
+	return fmt.Sprintf("%s: no such file in snap", string(e))
+}
+
+type squashfsSuperblock struct {
+	Magic               uint32
+	Inodes              uint32
+	MkfsTime            uint32
+	BlockSize           uint32
+	Fragments           uint32
+	Compression         uint16
+	BlockLog            uint16
+	Flags               uint16
+	NoIds               uint16
+	Major               uint16
+	Minor               uint16
+	RootInode           uint64
+	BytesUsed           uint64
+	IDTableStart        uint64
+	XattrIDTableStart   uint64
+	InodeTableStart     uint64
+	DirectoryTableStart uint64
+	FragmentTableStart  uint64
+	LookupTableStart    uint64
+}
+
+// squashfs is an open squashfs filesystem
+type squashfs struct {
+	f  *os.File
+	sb squashfsSuperblock
+	// decompress decompresses a block, into no more than max bytes
+	decompress func(data []byte, max int) ([]byte, error)
+
+	// the metadata blocks read so far, by position
+	meta map[int64]metaBlock
+	// the last fragment block read
+	fragmentPos  int64
+	fragmentData []byte
+}
+
+type metaBlock struct {
+	data []byte
+	next int64
+}
+
+// squashfsInode is what is needed of an inode
+type squashfsInode struct {
+	typ   uint16
+	mode  os.FileMode
+	mtime time.Time
+	num   uint32
+
+	// directories
+	dirBlock  uint32
+	dirOffset uint16
+	dirSize   uint32
+
+	// regular files
+	size           uint64
+	start          uint64
+	blocks         []uint32
+	fragment       uint32
+	fragmentOffset uint32
+
+	// symlinks
+	target string
+}
+
+func (ino *squashfsInode) isDir() bool {
+	return ino.typ == squashfsDirType || ino.typ == squashfsLDirType
+}
+
+func (ino *squashfsInode) isRegular() bool {
+	return ino.typ == squashfsFileType || ino.typ == squashfsLFileType
+}
+
+func (ino *squashfsInode) isSymlink() bool {
+	return ino.typ == squashfsSymlinkType || ino.typ == squashfsLSymlinkType
+}
+
+type squashfsDirEntry struct {
+	name string
+	ref  uint64
+}
+
+// openSquashfs opens the squashfs filesystem in the given file
+func openSquashfs(fn string) (*squashfs, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &squashfs{f: f, meta: make(map[int64]metaBlock), fragmentPos: -1}
+	if err := binary.Read(io.NewSectionReader(f, 0, 96), binary.LittleEndian, &fs.sb); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: can not read squashfs superblock: %v", fn, err)
+	}
+
+	sb := &fs.sb
+	if sb.Magic != squashfsMagic || sb.Major != 4 {
+		f.Close()
+		return nil, fmt.Errorf("%s: not a squashfs 4.0 filesystem", fn)
+	}
+	if sb.BlockLog < 12 || sb.BlockLog > 20 || sb.BlockSize != 1<<sb.BlockLog {
+		f.Close()
+		return nil, errSquashfsCorrupted
+	}
+
+	// everything is read within BytesUsed, so that has to be there,
+	// and the inode table ends where the directory table starts
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if sb.BytesUsed > uint64(st.Size()) || sb.InodeTableStart >= sb.DirectoryTableStart || sb.DirectoryTableStart > sb.BytesUsed {
+		f.Close()
+		return nil, errSquashfsCorrupted
+	}
+
+	switch sb.Compression {
+	case squashfsZlib:
+		fs.decompress = zlibDecompress
+	case squashfsXz:
+		fs.decompress = xzDecompress
+	default:
+		f.Close()
+		return nil, fmt.Errorf("%s: unsupported squashfs compression %d", fn, sb.Compression)
+	}
+
+	return fs, nil
+}
+
+func zlibDecompress(data []byte, max int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// one byte more than allowed tells it is too big
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, errSquashfsCorrupted
+	}
+
+	return out, nil
+}
+
+func xzDecompress(data []byte, max int) ([]byte, error) {
+	out, err := helpers.XzDecompress(data, max)
+	if err == helpers.ErrXzTooBig {
+		return nil, errSquashfsCorrupted
+	}
+
+	return out, err
+}
+
+// Close closes the file of the filesystem
+func (fs *squashfs) Close() error {
+	return fs.f.Close()
+}
+
+func (fs *squashfs) readAt(pos int64, n int) ([]byte, error) {
+	if pos < 0 || uint64(pos)+uint64(n) > fs.sb.BytesUsed {
+		return nil, errSquashfsCorrupted
+	}
+
+	buf := make([]byte, n)
+	if _, err := fs.f.ReadAt(buf, pos); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// metaBlock returns the uncompressed metadata block at the given
+// position, and where the next one is
+func (fs *squashfs) metaBlock(pos int64) (metaBlock, error) {
+	if b, ok := fs.meta[pos]; ok {
+		return b, nil
+	}
+
+	header, err := fs.readAt(pos, 2)
+	if err != nil {
+		return metaBlock{}, err
+	}
+	h := binary.LittleEndian.Uint16(header)
+	size := int(h &^ squashfsMetaUnpacked)
+	if size == 0 || size > squashfsMetaSize {
+		return metaBlock{}, errSquashfsCorrupted
+	}
+
+	data, err := fs.readAt(pos+2, size)
+	if err != nil {
+		return metaBlock{}, err
+	}
+	if h&squashfsMetaUnpacked == 0 {
+		if data, err = fs.decompress(data, squashfsMetaSize); err != nil {
+			return metaBlock{}, err
+		}
+	}
+
+	b := metaBlock{data: data, next: pos + 2 + int64(size)}
+	fs.meta[pos] = b
+
+	return b, nil
+}
+
+// metaReader reads the metadata that starts at the given offset of the
+// uncompressed block at the given position, across blocks
+type metaReader struct {
+	fs     *squashfs
+	block  metaBlock
+	offset int
+}
+
+func (fs *squashfs) metaReader(pos int64, offset int) (*metaReader, error) {
+	block, err := fs.metaBlock(pos)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(block.data) {
+		return nil, errSquashfsCorrupted
+	}
+
+	return &metaReader{fs: fs, block: block, offset: offset}, nil
+}
+
+func (mr *metaReader) read(n int) ([]byte, error) {
+	buf := make([]byte, 0, n)
+	for len(buf) < n {
+		if mr.offset == len(mr.block.data) {
+			block, err := mr.fs.metaBlock(mr.block.next)
+			if err != nil {
+				return nil, err
+			}
+			mr.block = block
+			mr.offset = 0
+		}
+
+		m := copy(buf[len(buf):n], mr.block.data[mr.offset:])
+		buf = buf[:len(buf)+m]
+		mr.offset += m
+	}
+
+	return buf, nil
+}
+
+// maxLeft returns how many bytes at most are left to read before end,
+// where the table being read ends; every metadata block takes at least
+// three bytes and unpacks to no more than squashfsMetaSize
+func (mr *metaReader) maxLeft(end int64) uint64 {
+	left := uint64(len(mr.block.data) - mr.offset)
+	if end > mr.block.next {
+		left += uint64(end-mr.block.next) / 3 * squashfsMetaSize
+	}
+
+	return left
+}
+
+func (mr *metaReader) uint16() (uint16, error) {
+	b, err := mr.read(2)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (mr *metaReader) uint32() (uint32, error) {
+	b, err := mr.read(4)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (mr *metaReader) uint64() (uint64, error) {
+	b, err := mr.read(8)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// inode reads the inode with the given reference
+func (fs *squashfs) inode(ref uint64) (*squashfsInode, error) {
+	mr, err := fs.metaReader(int64(fs.sb.InodeTableStart+ref>>16), int(ref&0xffff))
+	if err != nil {
+		return nil, err
+	}
+
+	// type, mode, uid, gid, mtime, inode number
+	header, err := mr.read(16)
+	if err != nil {
+		return nil, err
+	}
+	ino := &squashfsInode{
+		typ:   binary.LittleEndian.Uint16(header[0:]),
+		mode:  unixMode(binary.LittleEndian.Uint16(header[2:])),
+		mtime: time.Unix(int64(binary.LittleEndian.Uint32(header[8:])), 0),
+		num:   binary.LittleEndian.Uint32(header[12:]),
+	}
+
+	switch ino.typ {
+	case squashfsDirType:
+		// start block, nlink, size+offset, parent
+		b, err := mr.read(16)
+		if err != nil {
+			return nil, err
+		}
+		ino.dirBlock = binary.LittleEndian.Uint32(b[0:])
+		ino.dirSize = uint32(binary.LittleEndian.Uint16(b[8:]))
+		ino.dirOffset = binary.LittleEndian.Uint16(b[10:])
+	case squashfsLDirType:
+		// nlink, size, start block, parent, index count, offset, xattr
+		b, err := mr.read(24)
+		if err != nil {
+			return nil, err
+		}
+		ino.dirSize = binary.LittleEndian.Uint32(b[4:])
+		ino.dirBlock = binary.LittleEndian.Uint32(b[8:])
+		ino.dirOffset = binary.LittleEndian.Uint16(b[18:])
+	case squashfsFileType:
+		// start block, fragment, offset, size
+		b, err := mr.read(16)
+		if err != nil {
+			return nil, err
+		}
+		ino.start = uint64(binary.LittleEndian.Uint32(b[0:]))
+		ino.fragment = binary.LittleEndian.Uint32(b[4:])
+		ino.fragmentOffset = binary.LittleEndian.Uint32(b[8:])
+		ino.size = uint64(binary.LittleEndian.Uint32(b[12:]))
+	case squashfsLFileType:
+		// start block, size, sparse, nlink, fragment, offset, xattr
+		b, err := mr.read(40)
+		if err != nil {
+			return nil, err
+		}
+		ino.start = binary.LittleEndian.Uint64(b[0:])
+		ino.size = binary.LittleEndian.Uint64(b[8:])
+		ino.fragment = binary.LittleEndian.Uint32(b[28:])
+		ino.fragmentOffset = binary.LittleEndian.Uint32(b[32:])
+	case squashfsSymlinkType, squashfsLSymlinkType:
+		// nlink, size
+		b, err := mr.read(8)
+		if err != nil {
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint32(b[4:])
+		if size > squashfsMaxSymlink {
+			return nil, errSquashfsCorrupted
+		}
+		target, err := mr.read(int(size))
+		if err != nil {
+			return nil, err
+		}
+		ino.target = string(target)
+	case squashfsBlkdevType, squashfsChrdevType, squashfsFifoType, squashfsSocketType,
+		squashfsLBlkdevType, squashfsLChrdevType, squashfsLFifoType, squashfsLSocketType:
+		// nothing more is needed of these
+	default:
+		return nil, errSquashfsCorrupted
+	}
+
+	if ino.isRegular() {
+		n := ino.size / uint64(fs.sb.BlockSize)
+		if ino.fragment == squashfsNoFragment && ino.size%uint64(fs.sb.BlockSize) != 0 {
+			n++
+		}
+		// no more block sizes than there can be left in the inode
+		// table; they are read as they come, so that what is
+		// allocated is what is really there
+		if n > mr.maxLeft(int64(fs.sb.DirectoryTableStart))/4 {
+			return nil, errSquashfsCorrupted
+		}
+		ino.blocks = make([]uint32, 0, minUint64(n, squashfsMetaSize/4))
+		for i := uint64(0); i < n; i++ {
+			size, err := mr.uint32()
+			if err != nil {
+				return nil, err
+			}
+			ino.blocks = append(ino.blocks, size)
+		}
+	}
+
+	return ino, nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// unixMode turns the permissions of an inode into an os.FileMode
+func unixMode(mode uint16) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&syscall.S_ISUID != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&syscall.S_ISGID != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&syscall.S_ISVTX != 0 {
+		m |= os.ModeSticky
+	}
+
+	return m
+}
+
+// readDir returns the entries of the given directory
+func (fs *squashfs) readDir(ino *squashfsInode) ([]squashfsDirEntry, error) {
+	if !ino.isDir() {
+		return nil, errSquashfsCorrupted
+	}
+	// the size counts "." and ".." too
+	if ino.dirSize <= 3 {
+		return nil, nil
+	}
+
+	mr, err := fs.metaReader(int64(fs.sb.DirectoryTableStart)+int64(ino.dirBlock), int(ino.dirOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []squashfsDirEntry
+	left := int(ino.dirSize) - 3
+	for left > 0 {
+		// count-1, start block, inode number
+		header, err := mr.read(12)
+		if err != nil {
+			return nil, err
+		}
+		left -= 12
+		count := binary.LittleEndian.Uint32(header[0:]) + 1
+		start := uint64(binary.LittleEndian.Uint32(header[4:]))
+		if count > 256 {
+			return nil, errSquashfsCorrupted
+		}
+
+		for i := uint32(0); i < count; i++ {
+			// offset, inode number delta, type, size-1
+			b, err := mr.read(8)
+			if err != nil {
+				return nil, err
+			}
+			offset := uint64(binary.LittleEndian.Uint16(b[0:]))
+			size := int(binary.LittleEndian.Uint16(b[6:])) + 1
+			name, err := mr.read(size)
+			if err != nil {
+				return nil, err
+			}
+			left -= 8 + size
+
+			// no escaping the directory
+			if bytes.IndexByte(name, '/') >= 0 || bytes.IndexByte(name, 0) >= 0 || string(name) == "." || string(name) == ".." {
+				return nil, fmt.Errorf("squashfs: invalid file name %q", name)
+			}
+
+			entries = append(entries, squashfsDirEntry{name: string(name), ref: start<<16 | offset})
+		}
+	}
+	if left != 0 {
+		return nil, errSquashfsCorrupted
+	}
+
+	return entries, nil
+}
+
+// lookup returns the inode at the given path, following the symlinks
+// to other places in the filesystem
+func (fs *squashfs) lookup(name string) (*squashfsInode, error) {
+	return fs.lookupFollow(name, name, 0)
+}
+
+func (fs *squashfs) lookupFollow(orig, name string, followed int) (*squashfsInode, error) {
+	ino, err := fs.inode(fs.sb.RootInode)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := "/"
+	parts := strings.Split(path.Clean("/"+name), "/")[1:]
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if !ino.isDir() {
+			return nil, ErrNotInSnap(orig)
+		}
+
+		entries, err := fs.readDir(ino)
+		if err != nil {
+			return nil, err
+		}
+
+		ino = nil
+		for _, entry := range entries {
+			if entry.name == part {
+				if ino, err = fs.inode(entry.ref); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		if ino == nil {
+			return nil, ErrNotInSnap(orig)
+		}
+
+		if ino.isSymlink() {
+			if followed >= squashfsMaxSymlinks {
+				return nil, fmt.Errorf("%s: too many levels of symbolic links", orig)
+			}
+			// absolute ones are about the system, not the snap
+			if path.IsAbs(ino.target) {
+				return nil, ErrNotInSnap(orig)
+			}
+			rest := append([]string{dir, ino.target}, parts[i+1:]...)
+			return fs.lookupFollow(orig, path.Join(rest...), followed+1)
+		}
+		dir = path.Join(dir, part)
+	}
+
+	return ino, nil
+}
+
+// fragment returns the fragment block with the given index
+func (fs *squashfs) fragment(index uint32) ([]byte, error) {
+	if index >= fs.sb.Fragments {
+		return nil, errSquashfsCorrupted
+	}
+
+	// the table of fragments is in metadata blocks, listed at its start
+	b, err := fs.readAt(int64(fs.sb.FragmentTableStart)+8*int64(index/(squashfsMetaSize/16)), 8)
+	if err != nil {
+		return nil, err
+	}
+	mr, err := fs.metaReader(int64(binary.LittleEndian.Uint64(b)), int(index%(squashfsMetaSize/16))*16)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := mr.uint64()
+	if err != nil {
+		return nil, err
+	}
+	size, err := mr.uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(pos) == fs.fragmentPos {
+		return fs.fragmentData, nil
+	}
+	data, err := fs.dataBlock(int64(pos), size)
+	if err != nil {
+		return nil, err
+	}
+	fs.fragmentPos, fs.fragmentData = int64(pos), data
+
+	return data, nil
+}
+
+// dataBlock reads the data block at the given position, with the size
+// as in the block lists
+func (fs *squashfs) dataBlock(pos int64, size uint32) ([]byte, error) {
+	n := size &^ squashfsDataUnpacked
+	if n > fs.sb.BlockSize {
+		return nil, errSquashfsCorrupted
+	}
+
+	data, err := fs.readAt(pos, int(n))
+	if err != nil {
+		return nil, err
+	}
+	if size&squashfsDataUnpacked == 0 {
+		if data, err = fs.decompress(data, int(fs.sb.BlockSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// writeFile writes the content of the given regular file to w
+func (fs *squashfs) writeFile(ino *squashfsInode, w io.Writer) error {
+	if !ino.isRegular() {
+		return errSquashfsCorrupted
+	}
+
+	blockSize := uint64(fs.sb.BlockSize)
+	left := ino.size
+	pos := int64(ino.start)
+	for _, size := range ino.blocks {
+		n := blockSize
+		if left < n {
+			n = left
+		}
+
+		var data []byte
+		if size == 0 {
+			// a sparse block
+			data = make([]byte, n)
+		} else {
+			var err error
+			if data, err = fs.dataBlock(pos, size); err != nil {
+				return err
+			}
+			pos += int64(size &^ squashfsDataUnpacked)
+		}
+		if uint64(len(data)) != n {
+			return errSquashfsCorrupted
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		left -= n
+	}
+
+	if left > 0 {
+		if ino.fragment == squashfsNoFragment {
+			return errSquashfsCorrupted
+		}
+		data, err := fs.fragment(ino.fragment)
+		if err != nil {
+			return err
+		}
+		end := uint64(ino.fragmentOffset) + left
+		if end > uint64(len(data)) {
+			return errSquashfsCorrupted
+		}
+		if _, err := w.Write(data[ino.fragmentOffset:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFile returns the content of the file at the given path
+func (fs *squashfs) readFile(name string) ([]byte, error) {
+	ino, err := fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ino.isRegular() {
+		return nil, fmt.Errorf("%s: not a regular file", name)
+	}
+
+	var buf bytes.Buffer
+	if err := fs.writeFile(ino, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// globMatch matches a name like unsquashfs does, with a leading "."
+// only matched by a leading "."
+func globMatch(pattern, name string) bool {
+	if strings.HasPrefix(name, ".") && !strings.HasPrefix(pattern, ".") {
+		return false
+	}
+	match, err := path.Match(pattern, name)
+
+	return err == nil && match
+}
+
+// extract unpacks what matches the given glob into dstDir; like with
+// unsquashfs each part of the glob is matched against one level of
+// directories, and what matches the last part is unpacked whole
+func (fs *squashfs) extract(glob, dstDir string) error {
+	var patterns []string
+	for _, p := range strings.Split(glob, "/") {
+		if p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+
+	root, err := fs.inode(fs.sb.RootInode)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return err
+	}
+
+	return fs.extractDir(root, dstDir, patterns, make(map[uint32]bool))
+}
+
+// extractDir unpacks the entries of the given directory that match the
+// first of the patterns into dst, or all of them if there are none.
+// Directories are only ever met once, visited has the inode numbers of
+// the ones met so far.
+func (fs *squashfs) extractDir(dir *squashfsInode, dst string, patterns []string, visited map[uint32]bool) error {
+	if visited[dir.num] {
+		return errSquashfsCorrupted
+	}
+	visited[dir.num] = true
+
+	entries, err := fs.readDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		var rest []string
+		if len(patterns) > 0 {
+			if !globMatch(patterns[0], entry.name) {
+				continue
+			}
+			rest = patterns[1:]
+		}
+
+		ino, err := fs.inode(entry.ref)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, entry.name)
+		if len(rest) > 0 && !ino.isDir() {
+			continue
+		}
+
+		if err := fs.extractInode(ino, target, rest, visited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractInode unpacks the given inode to dst
+func (fs *squashfs) extractInode(ino *squashfsInode, dst string, patterns []string, visited map[uint32]bool) error {
+	// whatever is in the way goes, but directories are reused
+	if fi, err := os.Lstat(dst); err == nil && !(ino.isDir() && fi.IsDir()) {
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case ino.isDir():
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+		if err := fs.extractDir(ino, dst, patterns, visited); err != nil {
+			return err
+		}
+	case ino.isRegular():
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if err := fs.writeFile(ino, f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case ino.isSymlink():
+		return os.Symlink(ino.target, dst)
+	default:
+		return fmt.Errorf("%s: can not unpack device nodes, fifos or sockets", dst)
+	}
+
+	// set last, so that read-only directories can be filled
+	if err := os.Chmod(dst, ino.mode); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, ino.mtime, ino.mtime)
+}
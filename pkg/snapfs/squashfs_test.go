@@ -0,0 +1,640 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// testFile is a file to put in a test squashfs
+type testFile struct {
+	name     string
+	mode     os.FileMode
+	content  string
+	target   string
+	dir      bool
+	children []*testFile
+}
+
+func testDir(name string, mode os.FileMode, children ...*testFile) *testFile {
+	return &testFile{name: name, mode: mode, dir: true, children: children}
+}
+
+func testRegular(name string, mode os.FileMode, content string) *testFile {
+	return &testFile{name: name, mode: mode, content: content}
+}
+
+func testSymlink(name, target string) *testFile {
+	return &testFile{name: name, mode: 0777, target: target}
+}
+
+// testMetaWriter writes metadata blocks
+type testMetaWriter struct {
+	compress func([]byte) []byte
+	out      bytes.Buffer
+	cur      []byte
+}
+
+// pos is where the next write goes, as a block and an offset in it
+func (mw *testMetaWriter) pos() (uint32, uint16) {
+	return uint32(mw.out.Len()), uint16(len(mw.cur))
+}
+
+func (mw *testMetaWriter) write(data ...interface{}) {
+	var buf bytes.Buffer
+	for _, d := range data {
+		binary.Write(&buf, binary.LittleEndian, d)
+	}
+
+	b := buf.Bytes()
+	for len(b) > 0 {
+		n := squashfsMetaSize - len(mw.cur)
+		if n > len(b) {
+			n = len(b)
+		}
+		mw.cur = append(mw.cur, b[:n]...)
+		b = b[n:]
+		if len(mw.cur) == squashfsMetaSize {
+			mw.flush()
+		}
+	}
+}
+
+func (mw *testMetaWriter) flush() {
+	if len(mw.cur) == 0 {
+		return
+	}
+
+	z := mw.compress(mw.cur)
+	if len(z) < len(mw.cur) {
+		binary.Write(&mw.out, binary.LittleEndian, uint16(len(z)))
+		mw.out.Write(z)
+	} else {
+		binary.Write(&mw.out, binary.LittleEndian, uint16(len(mw.cur))|squashfsMetaUnpacked)
+		mw.out.Write(mw.cur)
+	}
+	mw.cur = nil
+}
+
+// testWriter writes a squashfs the way mksquashfs does, for when it is
+// not around
+type testWriter struct {
+	compression uint16
+	compress    func([]byte) []byte
+	blockSize   uint32
+	fragments   bool
+
+	data     bytes.Buffer
+	inodes   *testMetaWriter
+	dirs     *testMetaWriter
+	inodeNum uint32
+
+	frag      []byte
+	fragTable [][2]uint64
+}
+
+func zlibCompress(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+
+	return buf.Bytes()
+}
+
+// xzCompress compresses data with the xz tool
+func xzCompress(c *C, data []byte, args ...string) []byte {
+	if _, err := exec.LookPath("xz"); err != nil {
+		c.Skip("no xz")
+	}
+
+	cmd := exec.Command("xz", append([]string{"--format=xz", "-c"}, args...)...)
+	cmd.Stdin = bytes.NewReader(data)
+	output, err := cmd.Output()
+	c.Assert(err, IsNil)
+
+	return output
+}
+
+func (w *testWriter) dataBlock(b []byte) uint32 {
+	if len(b) == int(w.blockSize) && bytes.Count(b, []byte{0}) == len(b) {
+		// sparse
+		return 0
+	}
+
+	z := w.compress(b)
+	if len(z) < len(b) {
+		w.data.Write(z)
+		return uint32(len(z))
+	}
+	w.data.Write(b)
+
+	return uint32(len(b)) | squashfsDataUnpacked
+}
+
+func (w *testWriter) flushFragment() {
+	if len(w.frag) == 0 {
+		return
+	}
+
+	pos := uint64(w.data.Len())
+	size := w.dataBlock(w.frag)
+	w.fragTable = append(w.fragTable, [2]uint64{pos, uint64(size)})
+	w.frag = nil
+}
+
+func (w *testWriter) header(typ uint16, mode os.FileMode) {
+	w.inodeNum++
+	w.inodes.write(typ, uint16(mode.Perm()), uint16(0), uint16(0), uint32(1234567890), w.inodeNum)
+}
+
+// add writes the given file, and returns its inode reference, number
+// and type
+func (w *testWriter) add(f *testFile) (uint64, uint32, uint16) {
+	var typ uint16
+	var entries []*testFile
+	var refs []uint64
+	var nums []uint32
+	var types []uint16
+
+	if f.dir {
+		entries = append(entries, f.children...)
+		sort.Sort(testFilesByName(entries))
+		for _, child := range entries {
+			ref, num, typ := w.add(child)
+			refs = append(refs, ref)
+			nums = append(nums, num)
+			types = append(types, typ)
+		}
+	}
+
+	block, offset := w.inodes.pos()
+	ref := uint64(block)<<16 | uint64(offset)
+
+	switch {
+	case f.dir:
+		typ = squashfsDirType
+		dirBlock, dirOffset := w.dirs.pos()
+		size := 3
+		for i := 0; i < len(entries); {
+			// all of a header's entries share an inode block
+			j := i
+			for j < len(entries) && j-i < 256 && refs[j]>>16 == refs[i]>>16 {
+				j++
+			}
+			w.dirs.write(uint32(j-i-1), uint32(refs[i]>>16), nums[i])
+			size += 12
+			for k := i; k < j; k++ {
+				w.dirs.write(uint16(refs[k]&0xffff), int16(nums[k]-nums[i]), types[k], uint16(len(entries[k].name)-1), []byte(entries[k].name))
+				size += 8 + len(entries[k].name)
+			}
+			i = j
+		}
+		w.header(typ, f.mode)
+		w.inodes.write(dirBlock, uint32(2), uint16(size), dirOffset, uint32(0))
+	case f.target != "":
+		typ = squashfsSymlinkType
+		w.header(typ, f.mode)
+		w.inodes.write(uint32(1), uint32(len(f.target)), []byte(f.target))
+	default:
+		typ = squashfsFileType
+		start := uint32(w.data.Len())
+		content := []byte(f.content)
+		var blocks []uint32
+		for len(content) >= int(w.blockSize) {
+			blocks = append(blocks, w.dataBlock(content[:w.blockSize]))
+			content = content[w.blockSize:]
+		}
+
+		fragment, fragmentOffset := uint32(squashfsNoFragment), uint32(0)
+		if len(content) > 0 {
+			if w.fragments {
+				if len(w.frag)+len(content) > int(w.blockSize) {
+					w.flushFragment()
+				}
+				fragment, fragmentOffset = uint32(len(w.fragTable)), uint32(len(w.frag))
+				w.frag = append(w.frag, content...)
+			} else {
+				blocks = append(blocks, w.dataBlock(content))
+			}
+		}
+
+		w.header(typ, f.mode)
+		w.inodes.write(start, fragment, fragmentOffset, uint32(len(f.content)), blocks)
+	}
+
+	return ref, w.inodeNum, typ
+}
+
+// table writes the given entries in metadata blocks followed by their
+// index, and returns where the index is
+func (w *testWriter) table(entries ...interface{}) uint64 {
+	var index []uint64
+	for i := 0; i < len(entries); {
+		mw := &testMetaWriter{compress: w.compress}
+		for ; i < len(entries) && mw.out.Len() == 0; i++ {
+			mw.write(entries[i])
+		}
+		mw.flush()
+		index = append(index, uint64(w.data.Len()))
+		w.data.Write(mw.out.Bytes())
+	}
+
+	start := uint64(w.data.Len())
+	binary.Write(&w.data, binary.LittleEndian, index)
+
+	return start
+}
+
+func (w *testWriter) write(c *C, root *testFile, fn string) {
+	w.inodes = &testMetaWriter{compress: w.compress}
+	w.dirs = &testMetaWriter{compress: w.compress}
+
+	// room for the superblock
+	w.data.Write(make([]byte, 96))
+
+	rootRef, _, _ := w.add(root)
+	w.flushFragment()
+
+	sb := squashfsSuperblock{
+		Magic:             squashfsMagic,
+		Inodes:            w.inodeNum,
+		MkfsTime:          1234567890,
+		BlockSize:         w.blockSize,
+		Fragments:         uint32(len(w.fragTable)),
+		Compression:       w.compression,
+		NoIds:             1,
+		Major:             4,
+		RootInode:         rootRef,
+		XattrIDTableStart: 0xffffffffffffffff,
+		LookupTableStart:  0xffffffffffffffff,
+	}
+	for sb.BlockSize>>sb.BlockLog != 1 {
+		sb.BlockLog++
+	}
+
+	w.inodes.flush()
+	sb.InodeTableStart = uint64(w.data.Len())
+	w.data.Write(w.inodes.out.Bytes())
+
+	w.dirs.flush()
+	sb.DirectoryTableStart = uint64(w.data.Len())
+	w.data.Write(w.dirs.out.Bytes())
+
+	var fragEntries []interface{}
+	for _, f := range w.fragTable {
+		fragEntries = append(fragEntries, f[0], uint32(f[1]), uint32(0))
+	}
+	sb.FragmentTableStart = w.table(fragEntries...)
+	sb.IDTableStart = w.table(uint32(0))
+	sb.BytesUsed = uint64(w.data.Len())
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, sb)
+	img := w.data.Bytes()
+	copy(img, buf.Bytes())
+
+	c.Assert(ioutil.WriteFile(fn, img, 0644), IsNil)
+}
+
+type testFilesByName []*testFile
+
+func (fs testFilesByName) Len() int           { return len(fs) }
+func (fs testFilesByName) Swap(i, j int)      { fs[i], fs[j] = fs[j], fs[i] }
+func (fs testFilesByName) Less(i, j int) bool { return fs[i].name < fs[j].name }
+
+var testRandom = func() string {
+	b := make([]byte, 3*4096+100)
+	rand.New(rand.NewSource(1)).Read(b)
+	return string(b)
+}()
+
+func makeTestTree() *testFile {
+	var many []*testFile
+	for i := 0; i < 400; i++ {
+		many = append(many, testRegular(fmt.Sprintf("file-%03d", i), 0644, fmt.Sprintf("file %d\n", i)))
+	}
+
+	return testDir("", 0755,
+		testDir("meta", 0755,
+			testRegular("package.yaml", 0644, "name: foo\nversion: 1.0\n"),
+			testDir("hooks", 0700,
+				testRegular("config", 0755, "#!/bin/sh\n"),
+			),
+		),
+		testDir(".click", 0755,
+			testRegular("manifest", 0644, "{}"),
+		),
+		testDir("bin", 0755,
+			testRegular("foo", 0755, "#!/bin/sh\necho foo\n"),
+			testSymlink("bar", "foo"),
+		),
+		testRegular("data.bin", 0600, testRandom),
+		testRegular("text", 0644, strings.Repeat("some text\n", 2000)),
+		testRegular("zeros", 0644, strings.Repeat("\x00", 2*4096+10)),
+		testRegular("empty", 0644, ""),
+		testDir("many", 0755, many...),
+		testSymlink("package.yaml", "meta/package.yaml"),
+		testSymlink("absolute", "/etc/passwd"),
+		testSymlink("loop", "loop"),
+	)
+}
+
+// makeTestSquashfs writes the test tree to a squashfs
+func makeTestSquashfs(c *C, compression uint16, fragments bool) *Snap {
+	w := &testWriter{
+		compression: compression,
+		blockSize:   4096,
+		fragments:   fragments,
+	}
+	switch compression {
+	case squashfsZlib:
+		w.compress = zlibCompress
+	case squashfsXz:
+		w.compress = func(data []byte) []byte {
+			return xzCompress(c, data, "--check=crc32")
+		}
+	}
+
+	fn := filepath.Join(c.MkDir(), "foo.snap")
+	w.write(c, makeTestTree(), fn)
+
+	return New(fn)
+}
+
+func (s *SquashfsTestSuite) TestNativeReadFile(c *C) {
+	for _, compression := range []uint16{squashfsZlib, squashfsXz} {
+		for _, fragments := range []bool{true, false} {
+			comment := Commentf("compression %d, fragments %v", compression, fragments)
+			snap := makeTestSquashfs(c, compression, fragments)
+
+			for name, content := range map[string]string{
+				"meta/package.yaml":   "name: foo\nversion: 1.0\n",
+				"/meta/hooks/config":  "#!/bin/sh\n",
+				"data.bin":            testRandom,
+				"text":                strings.Repeat("some text\n", 2000),
+				"zeros":               strings.Repeat("\x00", 2*4096+10),
+				"empty":               "",
+				"many/file-000":       "file 0\n",
+				"many/file-399":       "file 399\n",
+				"bin/bar":             "#!/bin/sh\necho foo\n",
+				"package.yaml":        "name: foo\nversion: 1.0\n",
+				"bin/../meta/../text": strings.Repeat("some text\n", 2000),
+			} {
+				got, err := snap.ReadFile(name)
+				c.Assert(err, IsNil, Commentf("%s %s", name, comment.CheckCommentString()))
+				c.Check(string(got) == content, Equals, true, Commentf("%s %s", name, comment.CheckCommentString()))
+			}
+
+			content, err := snap.MetaMember("package.yaml")
+			c.Assert(err, IsNil)
+			c.Check(string(content), Equals, "name: foo\nversion: 1.0\n")
+		}
+	}
+}
+
+func (s *SquashfsTestSuite) TestNativeReadFileErrors(c *C) {
+	snap := makeTestSquashfs(c, squashfsZlib, true)
+
+	_, err := snap.ReadFile("meta/no-such-file")
+	c.Check(err, Equals, ErrNotInSnap("meta/no-such-file"))
+	_, err = snap.ControlMember("control")
+	c.Check(err, Equals, ErrNotInSnap("DEBIAN/control"))
+	_, err = snap.ReadFile("text/foo")
+	c.Check(err, Equals, ErrNotInSnap("text/foo"))
+
+	_, err = snap.ReadFile("meta")
+	c.Check(err, ErrorMatches, "meta: not a regular file")
+
+	// outside of the snap
+	_, err = snap.ReadFile("absolute")
+	c.Check(err, Equals, ErrNotInSnap("absolute"))
+	_, err = snap.ReadFile("loop")
+	c.Check(err, ErrorMatches, "loop: too many levels of symbolic links")
+}
+
+func (s *SquashfsTestSuite) TestNativeNotSquashfs(c *C) {
+	fn := filepath.Join(c.MkDir(), "foo.snap")
+	c.Assert(ioutil.WriteFile(fn, bytes.Repeat([]byte("not squashfs"), 10), 0644), IsNil)
+
+	_, err := New(fn).ReadFile("meta/package.yaml")
+	c.Check(err, ErrorMatches, ".*: not a squashfs 4.0 filesystem")
+}
+
+func (s *SquashfsTestSuite) TestNativeUnpack(c *C) {
+	snap := makeTestSquashfs(c, squashfsXz, true)
+
+	outputDir := c.MkDir()
+	c.Assert(snap.Unpack("*", outputDir), IsNil)
+
+	content, err := ioutil.ReadFile(filepath.Join(outputDir, "data.bin"))
+	c.Assert(err, IsNil)
+	c.Check(string(content) == testRandom, Equals, true)
+
+	content, err = ioutil.ReadFile(filepath.Join(outputDir, "many", "file-123"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "file 123\n")
+
+	for name, mode := range map[string]os.FileMode{
+		"data.bin":     0600,
+		"bin/foo":      0755,
+		"meta/hooks":   os.ModeDir | 0700,
+		"package.yaml": os.ModeSymlink | 0777,
+	} {
+		fi, err := os.Lstat(filepath.Join(outputDir, name))
+		c.Assert(err, IsNil)
+		c.Check(fi.Mode(), Equals, mode, Commentf(name))
+		if !strings.HasSuffix(name, ".yaml") {
+			c.Check(fi.ModTime().Unix(), Equals, int64(1234567890), Commentf(name))
+		}
+	}
+
+	target, err := os.Readlink(filepath.Join(outputDir, "bin", "bar"))
+	c.Assert(err, IsNil)
+	c.Check(target, Equals, "foo")
+
+	// like with unsquashfs, a "*" does not get the dot files
+	c.Check(helpers.FileExists(filepath.Join(outputDir, ".click")), Equals, false)
+}
+
+func (s *SquashfsTestSuite) TestNativeUnpackGlob(c *C) {
+	snap := makeTestSquashfs(c, squashfsZlib, true)
+
+	outputDir := c.MkDir()
+	c.Assert(snap.Unpack("many/file-01*", outputDir), IsNil)
+	files, err := filepath.Glob(filepath.Join(outputDir, "*", "*"))
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 10)
+	c.Check(files[0], Equals, filepath.Join(outputDir, "many", "file-010"))
+
+	outputDir = c.MkDir()
+	c.Assert(snap.UnpackMeta(outputDir), IsNil)
+	c.Check(helpers.FileExists(filepath.Join(outputDir, "meta", "hooks", "config")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(outputDir, ".click", "manifest")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(outputDir, "data.bin")), Equals, false)
+}
+
+func (s *SquashfsTestSuite) TestNativeUnpackReplaces(c *C) {
+	snap := makeTestSquashfs(c, squashfsZlib, true)
+
+	// what is in the way goes, symlinks are not followed
+	outside := c.MkDir()
+	outputDir := c.MkDir()
+	c.Assert(os.Symlink(outside, filepath.Join(outputDir, "meta")), IsNil)
+	c.Assert(os.Symlink(filepath.Join(outside, "text"), filepath.Join(outputDir, "text")), IsNil)
+
+	c.Assert(snap.Unpack("*", outputDir), IsNil)
+
+	c.Check(helpers.FileExists(filepath.Join(outputDir, "meta", "package.yaml")), Equals, true)
+	fi, err := os.Lstat(filepath.Join(outputDir, "text"))
+	c.Assert(err, IsNil)
+	c.Check(fi.Mode().IsRegular(), Equals, true)
+	files, err := ioutil.ReadDir(outside)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 0)
+
+	// again, over what is there now
+	c.Assert(snap.Unpack("*", outputDir), IsNil)
+}
+
+func (s *SquashfsTestSuite) TestNativeInvalidNames(c *C) {
+	for _, name := range []string{"..", ".", "a/b"} {
+		w := &testWriter{compression: squashfsZlib, compress: zlibCompress, blockSize: 4096}
+		fn := filepath.Join(c.MkDir(), "foo.snap")
+		w.write(c, testDir("", 0755, testRegular(name, 0644, "evil")), fn)
+
+		err := New(fn).Unpack("*", c.MkDir())
+		c.Check(err, ErrorMatches, fmt.Sprintf("squashfs: invalid file name %q", name))
+	}
+}
+
+func (s *SquashfsTestSuite) TestNativeCorrupted(c *C) {
+	snap := makeTestSquashfs(c, squashfsZlib, true)
+
+	img, err := ioutil.ReadFile(snap.path)
+	c.Assert(err, IsNil)
+	// the block size has to match its log
+	binary.LittleEndian.PutUint32(img[12:], 4097)
+	c.Assert(ioutil.WriteFile(snap.path, img, 0644), IsNil)
+
+	_, err = snap.ReadFile("text")
+	c.Check(err, Equals, errSquashfsCorrupted)
+}
+
+func (s *SquashfsTestSuite) TestNativeForgedSizes(c *C) {
+	// no compression, so that the inode table can be changed
+	w := &testWriter{compression: squashfsZlib, compress: func(data []byte) []byte { return data }, blockSize: 4096}
+	fn := filepath.Join(c.MkDir(), "foo.snap")
+	w.write(c, testDir("", 0755, testRegular("f", 0644, "hello")), fn)
+
+	orig, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	var sb squashfsSuperblock
+	c.Assert(binary.Read(bytes.NewReader(orig), binary.LittleEndian, &sb), IsNil)
+
+	for comment, forge := range map[string]func(img []byte) []byte{
+		"bytes used past the end": func(img []byte) []byte {
+			binary.LittleEndian.PutUint64(img[40:], ^uint64(0))
+			return img
+		},
+		"truncated": func(img []byte) []byte {
+			return img[:len(img)-10]
+		},
+		"huge file": func(img []byte) []byte {
+			// "f" is the first inode; its size follows the
+			// header, start block, fragment and offset
+			binary.LittleEndian.PutUint32(img[sb.InodeTableStart+2+28:], 0xffffffff)
+			// and enough room that the blocks would fit in
+			// the filesystem, but not in its inode table
+			img = append(img, make([]byte, 4<<20)...)
+			binary.LittleEndian.PutUint64(img[40:], uint64(len(img)))
+			return img
+		},
+	} {
+		img := forge(append([]byte(nil), orig...))
+		c.Assert(ioutil.WriteFile(fn, img, 0644), IsNil)
+
+		_, err := New(fn).ReadFile("f")
+		c.Check(err, Equals, errSquashfsCorrupted, Commentf(comment))
+	}
+}
+
+func (s *SquashfsTestSuite) TestNativeDecompressTooBig(c *C) {
+	bomb := func(compress func([]byte) []byte) func([]byte) []byte {
+		return func(data []byte) []byte {
+			// the blocks of the bomb decompress to much more
+			if bytes.HasPrefix(data, []byte("bomb")) {
+				return compress(make([]byte, 1<<20))
+			}
+			return compress(data)
+		}
+	}
+
+	for compression, compress := range map[uint16]func([]byte) []byte{
+		squashfsZlib: zlibCompress,
+		squashfsXz: func(data []byte) []byte {
+			return xzCompress(c, data, "--check=crc32")
+		},
+	} {
+		w := &testWriter{compression: compression, compress: bomb(compress), blockSize: 4096}
+		fn := filepath.Join(c.MkDir(), "foo.snap")
+		w.write(c, testDir("", 0755, testRegular("bomb", 0644, strings.Repeat("bomb", 1000))), fn)
+
+		_, err := New(fn).ReadFile("bomb")
+		c.Check(err, Equals, errSquashfsCorrupted, Commentf("compression %d", compression))
+	}
+}
+
+func (s *SquashfsTestSuite) TestNativeUnpackDirectoryLoop(c *C) {
+	// no compression, so that the directory table can be changed
+	w := &testWriter{compression: squashfsZlib, compress: func(data []byte) []byte { return data }, blockSize: 4096}
+	fn := filepath.Join(c.MkDir(), "foo.snap")
+	w.write(c, testDir("", 0755, testDir("a", 0755)), fn)
+
+	img, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	var sb squashfsSuperblock
+	c.Assert(binary.Read(bytes.NewReader(img), binary.LittleEndian, &sb), IsNil)
+
+	// the listing of the root is the only one; point its "a" back
+	// at the root
+	listing := img[sb.DirectoryTableStart+2:]
+	c.Assert(string(listing[20:21]), Equals, "a")
+	binary.LittleEndian.PutUint32(listing[4:], uint32(sb.RootInode>>16))
+	binary.LittleEndian.PutUint16(listing[12:], uint16(sb.RootInode&0xffff))
+	c.Assert(ioutil.WriteFile(fn, img, 0644), IsNil)
+
+	err = New(fn).Unpack("*", c.MkDir())
+	c.Check(err, Equals, errSquashfsCorrupted)
+}
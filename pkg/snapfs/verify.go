@@ -0,0 +1,201 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// A snapfs snap is signed with a detached signature next to it, e.g.
+// foo_1.0_all.snap.sig for foo_1.0_all.snap. The signature is an
+// OpenPGP clearsigned manifest like:
+//
+//   name: foo
+//   version: 1.0
+//   origin: mvo
+//   sha512: 0a1b...
+//
+// that is checked against the keyring of trusted keys.
+
+// SignatureExt is the extension of the detached signature of a snap
+const SignatureExt = ".sig"
+
+// TrustedKeyring is the keyring with the keys that may sign snaps
+var TrustedKeyring = "/usr/share/snappy/trusted.gpg"
+
+const (
+	sigFailNoSig = iota + 1
+	sigFailNoKeyring
+	sigFailUnknownKey
+	sigFailBadSig
+	sigFailMismatch
+)
+
+var sigErrMsg = map[int]string{
+	sigFailNoSig:      "No signature.",
+	sigFailNoKeyring:  "No keyring with trusted keys.",
+	sigFailUnknownKey: "Signed by an unknown key.",
+	sigFailBadSig:     "Bad signature.",
+	sigFailMismatch:   "Signed manifest does not match the snap.",
+}
+
+// ErrSignature is returned if a snap failed the signature verification
+type ErrSignature struct {
+	reason int
+	err    error
+}
+
+func (e *ErrSignature) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("Signature verification failed: %s (%v)", sigErrMsg[e.reason], e.err)
+	}
+
+	return fmt.Sprintf("Signature verification failed: %s", sigErrMsg[e.reason])
+}
+
+// like for clickdebs we allow snaps that are not signed or signed by
+// a key we do not know when running with --allow-unauthenticated, but
+// never bad signatures
+func allowUnauthenticatedOk(reason int) bool {
+	return (reason == sigFailNoSig ||
+		reason == sigFailNoKeyring ||
+		reason == sigFailUnknownKey)
+}
+
+// signedManifest is the content of the signature
+type signedManifest struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Origin  string `yaml:"origin"`
+	Sha512  string `yaml:"sha512"`
+}
+
+// Verify verifies the detached signature of the snap
+func (s *Snap) Verify(unauthOk bool) error {
+	origin, err := s.verifySignature()
+	if err != nil {
+		if e, ok := err.(*ErrSignature); ok && unauthOk && allowUnauthenticatedOk(e.reason) {
+			logger.Noticef("Signature check failed, but installing anyway as requested")
+			return nil
+		}
+		return err
+	}
+
+	s.signedOrigin = origin
+
+	return nil
+}
+
+// SignedOrigin returns the origin the snap was signed for, it is empty
+// until the snap was verified and for snaps without valid signature
+func (s *Snap) SignedOrigin() string {
+	return s.signedOrigin
+}
+
+func (s *Snap) verifySignature() (string, error) {
+	sig, err := ioutil.ReadFile(s.path + SignatureExt)
+	if os.IsNotExist(err) {
+		return "", &ErrSignature{reason: sigFailNoSig}
+	}
+	if err != nil {
+		return "", &ErrSignature{reason: sigFailNoSig, err: err}
+	}
+
+	block, _ := clearsign.Decode(sig)
+	if block == nil {
+		return "", &ErrSignature{reason: sigFailBadSig, err: fmt.Errorf("%s is not clearsigned", s.Name()+SignatureExt)}
+	}
+
+	keyring, err := readKeyring(TrustedKeyring)
+	if os.IsNotExist(err) {
+		return "", &ErrSignature{reason: sigFailNoKeyring}
+	}
+	if err != nil {
+		return "", &ErrSignature{reason: sigFailNoKeyring, err: err}
+	}
+
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body); err != nil {
+		if err == pgperrors.ErrUnknownIssuer {
+			return "", &ErrSignature{reason: sigFailUnknownKey}
+		}
+		return "", &ErrSignature{reason: sigFailBadSig, err: err}
+	}
+
+	// the signature is good, now check that it is for this snap
+	var manifest signedManifest
+	if err := yaml.Unmarshal(block.Plaintext, &manifest); err != nil {
+		return "", &ErrSignature{reason: sigFailMismatch, err: err}
+	}
+
+	sha512, err := helpers.Sha512sum(s.path)
+	if err != nil {
+		return "", err
+	}
+	if manifest.Sha512 != sha512 {
+		return "", &ErrSignature{reason: sigFailMismatch, err: fmt.Errorf("sha512 %q, expected %q", sha512, manifest.Sha512)}
+	}
+
+	yamlData, err := s.MetaMember("package.yaml")
+	if err != nil {
+		return "", err
+	}
+	var m struct {
+		Name    string `yaml:"name"`
+		Version string `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(yamlData, &m); err != nil {
+		return "", err
+	}
+	if manifest.Name != m.Name || manifest.Version != m.Version {
+		return "", &ErrSignature{reason: sigFailMismatch, err: fmt.Errorf("%s %s, expected %s %s", m.Name, m.Version, manifest.Name, manifest.Version)}
+	}
+	// the origin is what the signature is for, without it the snap
+	// could be installed for any origin
+	if manifest.Origin == "" {
+		return "", &ErrSignature{reason: sigFailMismatch, err: fmt.Errorf("no origin")}
+	}
+
+	return manifest.Origin, nil
+}
+
+// readKeyring reads a binary or ascii armored keyring
+func readKeyring(path string) (openpgp.EntityList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
@@ -0,0 +1,175 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+
+	"github.com/ubuntu-core/snappy/helpers"
+
+	. "gopkg.in/check.v1"
+)
+
+type VerifyTestSuite struct {
+	keyring string
+	signer  *openpgp.Entity
+}
+
+var _ = Suite(&VerifyTestSuite{})
+
+func (s *VerifyTestSuite) SetUpSuite(c *C) {
+	var err error
+	s.signer, err = openpgp.NewEntity("snappy test", "", "test@example.com", nil)
+	c.Assert(err, IsNil)
+}
+
+func (s *VerifyTestSuite) SetUpTest(c *C) {
+	c.Assert(os.Chdir(c.MkDir()), IsNil)
+
+	s.keyring = TrustedKeyring
+	TrustedKeyring = filepath.Join(c.MkDir(), "trusted.gpg")
+
+	var buf bytes.Buffer
+	c.Assert(s.signer.Serialize(&buf), IsNil)
+	c.Assert(ioutil.WriteFile(TrustedKeyring, buf.Bytes(), 0644), IsNil)
+}
+
+func (s *VerifyTestSuite) TearDownTest(c *C) {
+	TrustedKeyring = s.keyring
+}
+
+// sign writes the detached signature of the given manifest for snap
+func sign(c *C, snap *Snap, signer *openpgp.Entity, manifest string) {
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, signer.PrivateKey, nil)
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte(manifest))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	c.Assert(ioutil.WriteFile(snap.path+SignatureExt, buf.Bytes(), 0644), IsNil)
+}
+
+func manifestFor(c *C, snap *Snap, name, version, origin string) string {
+	sha512, err := helpers.Sha512sum(snap.path)
+	c.Assert(err, IsNil)
+
+	return fmt.Sprintf("name: %s\nversion: %s\norigin: %s\nsha512: %s\n", name, version, origin, sha512)
+}
+
+func (s *VerifyTestSuite) TestVerifyGood(c *C) {
+	snap := makeSnap(c, "name: foo\nversion: 1.10", "data")
+	sign(c, snap, s.signer, manifestFor(c, snap, "foo", "1.10", "mvo"))
+
+	c.Assert(snap.Verify(false), IsNil)
+	c.Check(snap.SignedOrigin(), Equals, "mvo")
+}
+
+func (s *VerifyTestSuite) TestVerifyArmoredKeyring(c *C) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.signer.Serialize(w), IsNil)
+	c.Assert(w.Close(), IsNil)
+	c.Assert(ioutil.WriteFile(TrustedKeyring, buf.Bytes(), 0644), IsNil)
+
+	snap := makeSnap(c, "name: foo\nversion: 1.0", "data")
+	sign(c, snap, s.signer, manifestFor(c, snap, "foo", "1.0", "mvo"))
+
+	c.Check(snap.Verify(false), IsNil)
+}
+
+func (s *VerifyTestSuite) TestVerifyNoSignature(c *C) {
+	snap := makeSnap(c, "name: foo\nversion: 1.0", "data")
+
+	c.Check(snap.Verify(false), ErrorMatches, "Signature verification failed: No signature.")
+	c.Check(snap.Verify(true), IsNil)
+	c.Check(snap.SignedOrigin(), Equals, "")
+}
+
+func (s *VerifyTestSuite) TestVerifyNoKeyring(c *C) {
+	snap := makeSnap(c, "name: foo\nversion: 1.0", "data")
+	sign(c, snap, s.signer, manifestFor(c, snap, "foo", "1.0", "mvo"))
+	c.Assert(os.Remove(TrustedKeyring), IsNil)
+
+	c.Check(snap.Verify(false), ErrorMatches, "Signature verification failed: No keyring with trusted keys.")
+	c.Check(snap.Verify(true), IsNil)
+}
+
+func (s *VerifyTestSuite) TestVerifyUnknownKey(c *C) {
+	other, err := openpgp.NewEntity("someone else", "", "other@example.com", nil)
+	c.Assert(err, IsNil)
+
+	snap := makeSnap(c, "name: foo\nversion: 1.0", "data")
+	sign(c, snap, other, manifestFor(c, snap, "foo", "1.0", "mvo"))
+
+	c.Check(snap.Verify(false), ErrorMatches, "Signature verification failed: Signed by an unknown key.")
+	c.Check(snap.Verify(true), IsNil)
+	c.Check(snap.SignedOrigin(), Equals, "")
+}
+
+func (s *VerifyTestSuite) TestVerifyBadSignature(c *C) {
+	snap := makeSnap(c, "name: foo\nversion: 1.0", "data")
+	sign(c, snap, s.signer, manifestFor(c, snap, "foo", "1.0", "mvo"))
+
+	// change the signed manifest
+	sig, err := ioutil.ReadFile(snap.path + SignatureExt)
+	c.Assert(err, IsNil)
+	sig = []byte(strings.Replace(string(sig), "origin: mvo", "origin: evil", 1))
+	c.Assert(ioutil.WriteFile(snap.path+SignatureExt, sig, 0644), IsNil)
+
+	c.Check(snap.Verify(false), ErrorMatches, "Signature verification failed: Bad signature. .*")
+	c.Check(snap.Verify(true), ErrorMatches, "Signature verification failed: Bad signature. .*")
+
+	c.Assert(ioutil.WriteFile(snap.path+SignatureExt, []byte("name: foo\n"), 0644), IsNil)
+	c.Check(snap.Verify(true), ErrorMatches, `Signature verification failed: Bad signature. \(foo.snap.sig is not clearsigned\)`)
+}
+
+func (s *VerifyTestSuite) TestVerifyMismatch(c *C) {
+	snap := makeSnap(c, "name: foo\nversion: 1.0", "data")
+
+	// signed for another snap
+	sign(c, snap, s.signer, "name: foo\nversion: 1.0\norigin: mvo\nsha512: 1234\n")
+	c.Check(snap.Verify(true), ErrorMatches, `Signature verification failed: Signed manifest does not match the snap. \(sha512 .*\)`)
+
+	// signed for another version
+	sign(c, snap, s.signer, manifestFor(c, snap, "foo", "2.0", "mvo"))
+	c.Check(snap.Verify(true), ErrorMatches, `Signature verification failed: Signed manifest does not match the snap. \(foo 1.0, expected foo 2.0\)`)
+}
+
+func (s *VerifyTestSuite) TestVerifyNoOrigin(c *C) {
+	snap := makeSnap(c, "name: foo\nversion: 1.0", "data")
+	sha512, err := helpers.Sha512sum(snap.path)
+	c.Assert(err, IsNil)
+
+	sign(c, snap, s.signer, fmt.Sprintf("name: foo\nversion: 1.0\nsha512: %s\n", sha512))
+	c.Check(snap.Verify(false), ErrorMatches, `Signature verification failed: Signed manifest does not match the snap. \(no origin\)`)
+	c.Check(snap.Verify(true), ErrorMatches, `Signature verification failed: Signed manifest does not match the snap. \(no origin\)`)
+	c.Check(snap.SignedOrigin(), Equals, "")
+}
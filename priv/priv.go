@@ -54,9 +54,9 @@ func New(fileName string) *Mutex {
 	}
 }
 
-// commonChecks encapsulates the checks that need to be run before any
-// privileged operation.
-func (m *Mutex) commonChecks() error {
+// CheckRoot returns ErrNeedRoot if the caller is not running as the
+// superuser.
+func CheckRoot() error {
 	if !isRoot() {
 		return ErrNeedRoot
 	}
@@ -64,6 +64,12 @@ func (m *Mutex) commonChecks() error {
 	return nil
 }
 
+// commonChecks encapsulates the checks that need to be run before any
+// privileged operation.
+func (m *Mutex) commonChecks() error {
+	return CheckRoot()
+}
+
 // Lock attempts to acquire the mutex lock, and wil block if it is
 // already locked.
 func (m *Mutex) Lock() error {
@@ -102,14 +108,3 @@ func (m *Mutex) Unlock() error {
 
 	return nil
 }
-
-// WithMutex runs the function f with the priv.Mutex hold
-func WithMutex(fileName string, f func() error) error {
-	privMutex := New(fileName)
-	if err := privMutex.TryLock(); err != nil {
-		return err
-	}
-	defer privMutex.Unlock()
-
-	return f()
-}
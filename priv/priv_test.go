@@ -24,7 +24,6 @@ import (
 
 	"path/filepath"
 	"testing"
-	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -93,54 +92,6 @@ func (ts *PrivTestSuite) TestPrivMutexIsNotRoot(c *C) {
 	c.Assert(privMutex.Unlock(), DeepEquals, ErrNeedRoot)
 }
 
-func (ts *PrivTestSuite) TestWithPrivMutexSimple(c *C) {
-	called := false
-	lockfile := filepath.Join(ts.tempdir, "lock")
-
-	err := WithMutex(lockfile, func() error {
-		called = true
-		return nil
-	})
-
-	c.Assert(err, IsNil)
-	c.Assert(called, Equals, true)
-}
-
-func (ts *PrivTestSuite) TestWithPrivMutexErrOnLockHeld(c *C) {
-	var err, err1, err2 error
-	var callCount int
-
-	delay := time.Millisecond * 100
-	slowFunc := func() error {
-		time.Sleep(delay)
-		callCount++
-		return nil
-	}
-
-	lockfile := filepath.Join(ts.tempdir, "lock")
-	go func() {
-		err1 = WithMutex(lockfile, slowFunc)
-	}()
-	err2 = WithMutex(lockfile, slowFunc)
-	// give the go routine time to catch up
-	time.Sleep(delay + 1)
-
-	// find which err is set (depends on the order in which go
-	// runs the goroutine)
-	if err1 != nil {
-		err = err1
-	} else {
-		err = err2
-	}
-
-	// only one functions errored
-	c.Assert(err1 != nil && err2 != nil, Equals, false)
-	// the other returned a proper error
-	c.Assert(err, Equals, ErrAlreadyLocked)
-	// and we did not call it too often
-	c.Assert(callCount, Equals, 1)
-}
-
 func (ts *PrivTestSuite) TestPrivLockLock(c *C) {
 	lockfile := filepath.Join(ts.tempdir, "lock")
 	priv := New(lockfile)
@@ -20,6 +20,11 @@
 package snappy
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/ubuntu-core/snappy/helpers"
 )
 
@@ -46,3 +51,82 @@ func Architecture() ArchitectureType {
 func SetArchitecture(newArch ArchitectureType) {
 	arch = newArch
 }
+
+// archSubtreesDir is the dir of a multi-architecture snap with the
+// per-architecture subtrees, e.g. arch/armhf/bin/hello is the
+// bin/hello for armhf
+const archSubtreesDir = "arch"
+
+// archSubtrees returns the architectures the snap in baseDir has
+// subtrees for
+func archSubtrees(baseDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(baseDir, archSubtreesDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var archs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			archs = append(archs, entry.Name())
+		}
+	}
+
+	return archs, nil
+}
+
+// checkArchSubtrees checks that the snap in baseDir has a subtree for
+// each of its architectures and none for others, if it has any
+func checkArchSubtrees(baseDir string, m *packageYaml) error {
+	subtrees, err := archSubtrees(baseDir)
+	if err != nil || len(subtrees) == 0 {
+		return err
+	}
+
+	for _, arch := range subtrees {
+		if !hasArch(m.Architectures, arch) {
+			return &ErrArchSubtree{Arch: arch, Msg: "not in architectures"}
+		}
+	}
+	for _, arch := range m.Architectures {
+		if arch == "all" {
+			return &ErrArchSubtree{Arch: arch, Msg: "a snap with per-architecture subtrees can not be for all architectures"}
+		}
+		if !hasArch(subtrees, arch) {
+			return &ErrArchSubtree{Arch: arch, Msg: "missing"}
+		}
+	}
+
+	return nil
+}
+
+func hasArch(archs []string, arch string) bool {
+	for _, a := range archs {
+		if a == arch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// archCommand returns the command of a binary or service of the snap
+// in baseDir, relative to baseDir. For a multi-architecture snap this
+// is the command in the subtree of the system architecture, if there
+// is one.
+func archCommand(baseDir, command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return command
+	}
+
+	prefix := archSubtreesDir + "/" + helpers.UbuntuArchitecture() + "/"
+	if helpers.FileExists(filepath.Join(baseDir, prefix+fields[0])) {
+		return prefix + command
+	}
+
+	return command
+}
@@ -0,0 +1,137 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg/clickdeb"
+)
+
+// otherArch is an architecture that is not the one of the system
+func otherArch() string {
+	if helpers.UbuntuArchitecture() == ArchArmhf {
+		return ArchAmd64
+	}
+
+	return ArchArmhf
+}
+
+// makeMultiArchSourceDir makes a snap source dir with a bin/bar in
+// the subtrees of the given architectures
+func makeMultiArchSourceDir(c *C, archs ...string) string {
+	packageYaml := fmt.Sprintf(`name: hello
+version: 1.0
+vendor: Foo <foo@example.com>
+architectures: [%s, %s]
+binaries:
+ - name: bar
+   exec: bin/bar
+`, helpers.UbuntuArchitecture(), otherArch())
+	sourceDir := makeExampleSnapSourceDir(c, packageYaml)
+
+	for _, arch := range archs {
+		binDir := filepath.Join(sourceDir, archSubtreesDir, arch, "bin")
+		c.Assert(os.MkdirAll(binDir, 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(binDir, "bar"), []byte("#!/bin/sh\necho "+arch), 0755), IsNil)
+	}
+
+	return sourceDir
+}
+
+func (s *SnapTestSuite) TestArchCommand(c *C) {
+	baseDir := c.MkDir()
+	c.Check(archCommand(baseDir, "bin/bar --baz"), Equals, "bin/bar --baz")
+	c.Check(archCommand(baseDir, ""), Equals, "")
+
+	archBinDir := filepath.Join(baseDir, archSubtreesDir, helpers.UbuntuArchitecture(), "bin")
+	c.Assert(os.MkdirAll(archBinDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(archBinDir, "bar"), nil, 0755), IsNil)
+	c.Check(archCommand(baseDir, "bin/bar --baz"), Equals, "arch/"+helpers.UbuntuArchitecture()+"/bin/bar --baz")
+}
+
+func (s *SnapTestSuite) TestBuildMultiArchSnapChecksSubtrees(c *C) {
+	sourceDir := makeMultiArchSourceDir(c, helpers.UbuntuArchitecture())
+	_, err := BuildLegacySnap(sourceDir, c.MkDir())
+	c.Check(err, DeepEquals, &ErrArchSubtree{Arch: otherArch(), Msg: "missing"})
+
+	sourceDir = makeMultiArchSourceDir(c, helpers.UbuntuArchitecture(), otherArch(), "sparc")
+	_, err = BuildLegacySnap(sourceDir, c.MkDir())
+	c.Check(err, ErrorMatches, "architecture subtree arch/sparc: not in architectures")
+}
+
+func (s *SnapTestSuite) TestInstallMultiArchSnap(c *C) {
+	sourceDir := makeMultiArchSourceDir(c, helpers.UbuntuArchitecture(), otherArch())
+	snapFile, err := BuildLegacySnap(sourceDir, c.MkDir())
+	c.Assert(err, IsNil)
+	c.Check(filepath.Base(snapFile), Equals, "hello_1.0_multi.snap")
+
+	_, err = installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	c.Assert(err, IsNil)
+
+	// the wrapper runs the binary of the system architecture
+	wrapper, err := ioutil.ReadFile(filepath.Join(dirs.SnapBinariesDir, "hello.bar"))
+	c.Assert(err, IsNil)
+	archBin := fmt.Sprintf("/apps/hello.%s/1.0/arch/%s/bin/bar", testOrigin, helpers.UbuntuArchitecture())
+	c.Check(string(wrapper), Matches, "(?ms).* "+archBin+` "\$@"`+"\n")
+}
+
+func (s *SnapTestSuite) TestBuildArchSnaps(c *C) {
+	sourceDir := makeMultiArchSourceDir(c, helpers.UbuntuArchitecture(), otherArch())
+	targetDir := c.MkDir()
+
+	snaps, err := BuildArchSnaps(sourceDir, targetDir, BuildLegacySnap)
+	c.Assert(err, IsNil)
+	c.Assert(snaps, HasLen, 2)
+
+	for i, arch := range []string{helpers.UbuntuArchitecture(), otherArch()} {
+		c.Check(snaps[i], Equals, filepath.Join(targetDir, "hello_1.0_"+arch+".snap"))
+
+		d, err := clickdeb.Open(snaps[i])
+		c.Assert(err, IsNil)
+		defer d.Close()
+		unpackDir := c.MkDir()
+		c.Assert(d.Unpack(unpackDir), IsNil)
+
+		// the subtree of the architecture is on top of the common files
+		content, err := ioutil.ReadFile(filepath.Join(unpackDir, "bin", "bar"))
+		c.Assert(err, IsNil)
+		c.Check(string(content), Equals, "#!/bin/sh\necho "+arch)
+		c.Check(helpers.FileExists(filepath.Join(unpackDir, "bin", "hello-world")), Equals, true)
+		c.Check(helpers.FileExists(filepath.Join(unpackDir, archSubtreesDir)), Equals, false)
+
+		m, err := parsePackageYamlFile(filepath.Join(unpackDir, "meta", "package.yaml"))
+		c.Assert(err, IsNil)
+		c.Check(m.Architectures, DeepEquals, []string{arch})
+		c.Check(m.Binaries[0].Exec, Equals, "bin/bar")
+	}
+
+	// the source is left alone
+	m, err := parsePackageYamlFile(filepath.Join(sourceDir, "meta", "package.yaml"))
+	c.Assert(err, IsNil)
+	c.Check(m.Architectures, HasLen, 2)
+}
@@ -25,9 +25,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"text/template"
@@ -227,17 +227,46 @@ func handleConfigHookApparmor(buildDir string, m *packageYaml) error {
 	return nil
 }
 
-// the du(1) command, useful to override for testing
-var duCmd = "du"
+func handleLifecycleHooksApparmor(buildDir string, m *packageYaml) error {
+	if !hasLifecycleHooks(filepath.Join(buildDir, "meta", "hooks")) {
+		return nil
+	}
+
+	s := &SecurityDefinitions{}
+	content, err := s.generateApparmorJSONContent()
+	if err != nil {
+		return err
+	}
+	hooksApparmorJSONFile := filepath.Join("meta", lifecycleHooksProfile+".apparmor")
+	if err := ioutil.WriteFile(filepath.Join(buildDir, hooksApparmorJSONFile), content, 0644); err != nil {
+		return err
+	}
+	m.addLifecycleHooksIntegration()
+
+	return nil
+}
+
+var dirSize = dirSizeImpl
+
+// dirSizeImpl returns the size of the regular files and symlinks in
+// buildDir in 1k blocks, rounded up, as a deb wants it
+func dirSizeImpl(buildDir string) (string, error) {
+	var size int64
+	err := filepath.Walk(buildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0 {
+			size += info.Size()
+		}
 
-func dirSize(buildDir string) (string, error) {
-	cmd := exec.Command(duCmd, "-s", "--apparent-size", buildDir)
-	output, err := cmd.Output()
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
 
-	return strings.Fields(string(output))[0], nil
+	return strconv.FormatInt((size+1023)/1024, 10), nil
 }
 
 func hashForFile(buildDir, path string, info os.FileInfo) (h *fileHash, err error) {
@@ -409,14 +438,20 @@ func writeClickManifest(buildDir string, m *packageYaml) error {
 }
 
 func copyToBuildDir(sourceDir, buildDir string) error {
-	sourceDir, err := filepath.Abs(sourceDir)
-	if err != nil {
+	err := os.Remove(buildDir)
+	if err != nil && !os.IsNotExist(err) {
+		// this shouldn't happen, but.
 		return err
 	}
 
-	err = os.Remove(buildDir)
-	if err != nil && !os.IsNotExist(err) {
-		// this shouldn't happen, but.
+	return copyTree(sourceDir, buildDir)
+}
+
+// copyTree copies the files in sourceDir into destDir, which may have
+// dirs of the same name already, but not files
+func copyTree(sourceDir, destDir string) error {
+	sourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
 		return err
 	}
 
@@ -437,11 +472,21 @@ func copyToBuildDir(sourceDir, buildDir string) error {
 			return nil
 		}
 
-		dest := filepath.Join(buildDir, relpath)
+		dest := filepath.Join(destDir, relpath)
 
 		// handle dirs
 		if info.IsDir() {
-			return os.Mkdir(dest, info.Mode())
+			err := os.Mkdir(dest, info.Mode())
+			if os.IsExist(err) && helpers.IsDirectory(dest) {
+				return nil
+			}
+			return err
+		}
+
+		// never overwrite, the file may be a link to another
+		// source file
+		if _, err := os.Lstat(dest); err == nil {
+			return &os.PathError{Op: "copy", Path: dest, Err: syscall.EEXIST}
 		}
 
 		// handle char/block devices
@@ -508,6 +553,10 @@ func prepare(sourceDir, targetDir, buildDir string) (snapName string, err error)
 		return "", err
 	}
 
+	if err := checkArchSubtrees(sourceDir, m); err != nil {
+		return "", err
+	}
+
 	if err := copyToBuildDir(sourceDir, buildDir); err != nil {
 		return "", err
 	}
@@ -527,6 +576,11 @@ func prepare(sourceDir, targetDir, buildDir string) (snapName string, err error)
 		return "", err
 	}
 
+	// and the one for the lifecycle hooks
+	if err := handleLifecycleHooksApparmor(buildDir, m); err != nil {
+		return "", err
+	}
+
 	if err := writeDebianControl(buildDir, m); err != nil {
 		return "", err
 	}
@@ -537,7 +591,13 @@ func prepare(sourceDir, targetDir, buildDir string) (snapName string, err error)
 	}
 
 	// build the package
-	snapName = fmt.Sprintf("%s_%s_%v.snap", m.Name, m.Version, debArchitecture(m))
+	return snapFileName(m, targetDir)
+}
+
+// snapFileName returns the name of the snap file for the given package
+// in targetDir, creating targetDir if needed
+func snapFileName(m *packageYaml, targetDir string) (string, error) {
+	snapName := fmt.Sprintf("%s_%s_%v.snap", m.Name, m.Version, debArchitecture(m))
 
 	if targetDir != "" {
 		snapName = filepath.Join(targetDir, snapName)
@@ -551,8 +611,14 @@ func prepare(sourceDir, targetDir, buildDir string) (snapName string, err error)
 	return snapName, nil
 }
 
-// BuildSnapfsSnap the given sourceDirectory and return the generated snap file
+// BuildSnapfsSnap the given sourceDirectory and return the generated snap file.
+// If SOURCE_DATE_EPOCH is set the build is reproducible.
 func BuildSnapfsSnap(sourceDir, targetDir string) (string, error) {
+	// the generated files must not depend on the umask of the
+	// builder, or the build is not reproducible
+	oldUmask := syscall.Umask(022)
+	defer syscall.Umask(oldUmask)
+
 	// create build dir
 	buildDir, err := ioutil.TempDir("", "snappy-build-")
 	if err != nil {
@@ -573,8 +639,14 @@ func BuildSnapfsSnap(sourceDir, targetDir string) (string, error) {
 	return snapName, nil
 }
 
-// BuildLegacySnap the given sourceDirectory and return the generated snap file
+// BuildLegacySnap the given sourceDirectory and return the generated snap file.
+// If SOURCE_DATE_EPOCH is set the build is reproducible.
 func BuildLegacySnap(sourceDir, targetDir string) (string, error) {
+	// the generated files must not depend on the umask of the
+	// builder, or the build is not reproducible
+	oldUmask := syscall.Umask(022)
+	defer syscall.Umask(oldUmask)
+
 	// create build dir
 	buildDir, err := ioutil.TempDir("", "snappy-build-")
 	if err != nil {
@@ -603,3 +675,94 @@ func BuildLegacySnap(sourceDir, targetDir string) (string, error) {
 
 	return snapName, nil
 }
+
+// BuildArchSnaps builds one snap for each architecture of the snap in
+// sourceDir with the given builder, e.g. BuildLegacySnap, and returns
+// the generated snap files. The snap for an architecture has the files
+// of the arch/<architecture>/ subtree of the source, if there is one,
+// on top of the common ones, and is only for that architecture.
+func BuildArchSnaps(sourceDir, targetDir string, build func(sourceDir, targetDir string) (string, error)) ([]string, error) {
+	m, err := parsePackageYamlFile(filepath.Join(sourceDir, "meta", "package.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkArchSubtrees(sourceDir, m); err != nil {
+		return nil, err
+	}
+
+	var snaps []string
+	for _, arch := range m.Architectures {
+		snap, err := buildArchSnap(sourceDir, targetDir, arch, build)
+		if err != nil {
+			return snaps, err
+		}
+		snaps = append(snaps, snap)
+	}
+
+	return snaps, nil
+}
+
+func buildArchSnap(sourceDir, targetDir, arch string, build func(sourceDir, targetDir string) (string, error)) (string, error) {
+	archSourceDir, err := ioutil.TempDir("", "snappy-arch-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(archSourceDir)
+
+	if err := copyToBuildDir(sourceDir, archSourceDir); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(filepath.Join(archSourceDir, archSubtreesDir)); err != nil {
+		return "", err
+	}
+
+	if arch == "all" {
+		return build(archSourceDir, targetDir)
+	}
+
+	subtree := filepath.Join(sourceDir, archSubtreesDir, arch)
+	if helpers.IsDirectory(subtree) {
+		if err := copyTree(subtree, archSourceDir); err != nil {
+			return "", err
+		}
+	}
+
+	if err := setArchitectures(filepath.Join(archSourceDir, "meta", "package.yaml"), []string{arch}); err != nil {
+		return "", err
+	}
+
+	return build(archSourceDir, targetDir)
+}
+
+// setArchitectures sets the architectures of the given package.yaml,
+// the rest of it stays as it is
+func setArchitectures(yamlPath string, archs []string) error {
+	yamlData, err := ioutil.ReadFile(yamlPath)
+	if err != nil {
+		return err
+	}
+
+	// a yaml round trip would change the values, e.g. version 1.0
+	// to 1, so drop the (top level) architecture keys with their
+	// values and add new ones
+	var lines []string
+	skip := false
+	for _, line := range strings.Split(strings.TrimRight(string(yamlData), "\n"), "\n") {
+		if skip && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "-")) {
+			continue
+		}
+		skip = strings.HasPrefix(line, "architecture:") || strings.HasPrefix(line, "architectures:")
+		if !skip {
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, fmt.Sprintf("architectures: [%s]", strings.Join(archs, ", ")))
+
+	// the file may be a link to the one in the source dir
+	if err := os.Remove(yamlPath); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(yamlPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -28,23 +28,13 @@ import (
 	"regexp"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ubuntu-core/snappy/helpers"
 
 	. "gopkg.in/check.v1"
 )
 
-func makeFakeDuCommand(c *C) string {
-	tempdir := c.MkDir()
-	duCmdPath := filepath.Join(tempdir, "du")
-	fakeDuContent := `#!/bin/sh
-echo 17 some-dir`
-	err := ioutil.WriteFile(duCmdPath, []byte(fakeDuContent), 0755)
-	c.Assert(err, IsNil)
-
-	return duCmdPath
-}
-
 func makeExampleSnapSourceDir(c *C, packageYaml string) string {
 	tempdir := c.MkDir()
 
@@ -547,3 +537,66 @@ integration:
 		c.Assert(string(output), Matches, expr)
 	}
 }
+
+// buildTwice builds the snap in sourceDir twice, with newer files and
+// another umask the second time, and returns the sha512s of both
+func buildTwice(c *C, build func(sourceDir, targetDir string) (string, error), sourceDir string) (first, second string) {
+	defer os.Unsetenv(helpers.SourceDateEpochEnv)
+	os.Setenv(helpers.SourceDateEpochEnv, "1440000000")
+
+	snap, err := build(sourceDir, c.MkDir())
+	c.Assert(err, IsNil)
+	first, err = helpers.Sha512sum(snap)
+	c.Assert(err, IsNil)
+
+	later := time.Now().Add(time.Hour)
+	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		c.Assert(err, IsNil)
+		if helpers.IsSymlink(info.Mode()) {
+			return nil
+		}
+		return os.Chtimes(path, later, later)
+	})
+	c.Assert(err, IsNil)
+	oldUmask := syscall.Umask(077)
+	defer syscall.Umask(oldUmask)
+
+	snap, err = build(sourceDir, c.MkDir())
+	c.Assert(err, IsNil)
+	second, err = helpers.Sha512sum(snap)
+	c.Assert(err, IsNil)
+
+	return first, second
+}
+
+func (s *SnapTestSuite) TestBuildReproducible(c *C) {
+	sourceDir := makeExampleSnapSourceDir(c, "name: hello\nversion: 1.0.1\nvendor: Foo <foo@example.com>\n")
+
+	first, second := buildTwice(c, BuildLegacySnap, sourceDir)
+	c.Check(second, Equals, first)
+}
+
+func (s *SnapTestSuite) TestBuildSnapfsReproducible(c *C) {
+	sourceDir := makeExampleSnapSourceDir(c, "name: hello\nversion: 1.0.1\nvendor: Foo <foo@example.com>\n")
+
+	first, second := buildTwice(c, BuildSnapfsSnap, sourceDir)
+	c.Check(second, Equals, first)
+}
+
+func (s *SnapTestSuite) TestDirSize(c *C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "file"), make([]byte, 1020), 0644), IsNil)
+	c.Assert(os.Symlink("abcd", filepath.Join(dir, "link")), IsNil)
+	// directories do not count
+	c.Assert(os.Mkdir(filepath.Join(dir, "dir"), 0755), IsNil)
+
+	size, err := dirSizeImpl(dir)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, "1")
+
+	// a part of a block is a block
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "dir", "more"), []byte("x"), 0644), IsNil)
+	size, err = dirSizeImpl(dir)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, "2")
+}
@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// validChannel matches the names of store channels, e.g. stable, beta
+// or edge
+var validChannel = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// trackedChannelPath returns the path of the file that keeps the
+// channel the package with the given qualified name tracks
+func trackedChannelPath(qn string) string {
+	return filepath.Join(dirs.SnapMetaDir, qn+".channel")
+}
+
+// trackedChannel returns the channel the package with the given
+// qualified name tracks, or "" if it was never set
+func trackedChannel(qn string) string {
+	content, err := ioutil.ReadFile(trackedChannelPath(qn))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+// setTrackedChannel makes the package with the given qualified name
+// track the given channel, or the default one if it is ""
+func setTrackedChannel(qn, channel string) error {
+	if channel == "" {
+		if err := os.Remove(trackedChannelPath(qn)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if !validChannel.MatchString(channel) {
+		return ErrInvalidChannel(channel)
+	}
+
+	if err := os.MkdirAll(dirs.SnapMetaDir, 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(trackedChannelPath(qn), []byte(channel+"\n"), 0644, 0)
+}
+
+// installedChannel returns the channel tracked by the active package
+// with the given name and origin, or "" if it is not installed or
+// tracks the default channel
+func installedChannel(name, origin string) string {
+	part, _ := activeSnapByNameAndOrigin(name, origin)
+	if part == nil {
+		return ""
+	}
+
+	return trackedChannel(QualifiedName(part))
+}
+
+// SetChannel makes the given installed package track the given channel
+// from now on; the next update will come from there
+func SetChannel(pkgname, channel string, inter progress.Meter) error {
+	if !validChannel.MatchString(channel) {
+		return ErrInvalidChannel(channel)
+	}
+
+	name, origin := SplitOrigin(pkgname)
+	part, err := activeSnapByNameAndOrigin(name, origin)
+	if err != nil {
+		return err
+	}
+	if part == nil {
+		return ErrPackageNotFound
+	}
+
+	if _, ok := part.(*SnapPart); !ok {
+		return fmt.Errorf("Can not set the channel of %s", name)
+	}
+
+	return setTrackedChannel(QualifiedName(part), channel)
+}
@@ -0,0 +1,175 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+func (s *SnapTestSuite) TestSetChannel(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+	c.Check(ActiveSnapByName("foo").Channel(), Equals, "remote-channel")
+
+	c.Assert(SetProperty("foo", &MockProgressMeter{}, "channel=edge"), IsNil)
+	part := ActiveSnapByName("foo")
+	c.Check(part.Channel(), Equals, "edge")
+	c.Check(fullNameWithChannel(part), Equals, "foo."+testOrigin+"/edge")
+
+	c.Check(SetChannel("foo."+testOrigin, "beta", &MockProgressMeter{}), IsNil)
+	c.Check(ActiveSnapByName("foo").Channel(), Equals, "beta")
+}
+
+func (s *SnapTestSuite) TestSetChannelErrors(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+
+	c.Check(SetChannel("foo", "../edge", &MockProgressMeter{}), Equals, ErrInvalidChannel("../edge"))
+	c.Check(SetChannel("bar", "edge", &MockProgressMeter{}), Equals, ErrPackageNotFound)
+	c.Check(SetChannel("foo.other", "edge", &MockProgressMeter{}), Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsFromChannel(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/details/"+funkyAppName+"."+funkyAppOrigin)
+		c.Check(r.URL.Query().Get("channel"), Equals, "beta")
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+
+	results, err := repo.DetailsFromChannel(funkyAppName, funkyAppOrigin, "beta")
+	c.Assert(err, IsNil)
+	c.Check(results, HasLen, 1)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsTrackedChannel(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+	c.Assert(SetChannel("foo", "edge", &MockProgressMeter{}), IsNil)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Query().Get("channel"), Equals, "edge")
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	_, err = NewUbuntuStoreSnapRepository().Details("foo", "")
+	c.Assert(err, IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallFromChannel(c *C) {
+	snapPackage := makeTestSnapPackage(c, "name: foo\nversion: 2\nvendor: foo")
+	snapR, err := os.Open(snapPackage)
+	c.Assert(err, IsNil)
+	defer snapR.Close()
+
+	var dlURL, iconURL string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/details/foo":
+			c.Check(r.URL.Query().Get("channel"), Equals, "beta")
+			io.WriteString(w, `{
+"package_name": "foo",
+"version": "2",
+"origin": "test",
+"channel": "beta",
+"anon_download_url": "`+dlURL+`",
+"icon_url": "`+iconURL+`"
+}`)
+		case "/dl":
+			snapR.Seek(0, 0)
+			io.Copy(w, snapR)
+		case "/icon":
+			fmt.Fprintf(w, "")
+		default:
+			panic("unexpected url path: " + r.URL.Path)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	dlURL = mockServer.URL + "/dl"
+	iconURL = mockServer.URL + "/icon"
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	_, err = InstallFromChannel("foo", "-beta", 0, &progress.NullProgress{})
+	c.Assert(err, ErrorMatches, ".*"+ErrInvalidChannel("-beta").Error())
+
+	name, err := InstallFromChannel("foo", "beta", 0, &progress.NullProgress{})
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "foo")
+	c.Check(trackedChannel("foo.test"), Equals, "beta")
+	c.Check(ActiveSnapByName("foo").Channel(), Equals, "beta")
+}
+
+func (s *SnapTestSuite) TestInstallKeepsTrackedChannel(c *C) {
+	snapPackage := makeTestSnapPackage(c, "name: foo\nversion: 2\nvendor: foo")
+	snapR, err := os.Open(snapPackage)
+	c.Assert(err, IsNil)
+	defer snapR.Close()
+
+	var dlURL, iconURL string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/details/foo":
+			io.WriteString(w, `{"package_name": "foo", "version": "2", "origin": "test", "anon_download_url": "`+dlURL+`", "icon_url": "`+iconURL+`"}`)
+		case "/dl":
+			snapR.Seek(0, 0)
+			io.Copy(w, snapR)
+		case "/icon":
+			fmt.Fprintf(w, "")
+		default:
+			panic("unexpected url path: " + r.URL.Path)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	dlURL = mockServer.URL + "/dl"
+	iconURL = mockServer.URL + "/icon"
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	// no channel asked for, the tracked one stays
+	c.Assert(setTrackedChannel("foo.test", "edge"), IsNil)
+	_, err = Install("foo", 0, &progress.NullProgress{})
+	c.Assert(err, IsNil)
+	c.Check(trackedChannel("foo.test"), Equals, "edge")
+}
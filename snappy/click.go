@@ -272,7 +272,7 @@ func generateBinaryName(m *packageYaml, binary Binary) string {
 	if m.Type == pkg.TypeFramework {
 		binName = filepath.Base(binary.Name)
 	} else {
-		binName = fmt.Sprintf("%s.%s", m.Name, filepath.Base(binary.Name))
+		binName = fmt.Sprintf("%s.%s", m.unitName(), filepath.Base(binary.Name))
 	}
 
 	return filepath.Join(dirs.SnapBinariesDir, binName)
@@ -470,15 +470,15 @@ func generateSnapSocketFile(service ServiceYaml, baseDir string, aaProfile strin
 }
 
 func generateServiceFileName(m *packageYaml, service ServiceYaml) string {
-	return filepath.Join(dirs.SnapServicesDir, fmt.Sprintf("%s_%s_%s.service", m.Name, service.Name, m.Version))
+	return filepath.Join(dirs.SnapServicesDir, fmt.Sprintf("%s_%s_%s.service", m.unitName(), service.Name, m.Version))
 }
 
 func generateSocketFileName(m *packageYaml, service ServiceYaml) string {
-	return filepath.Join(dirs.SnapServicesDir, fmt.Sprintf("%s_%s_%s.socket", m.Name, service.Name, m.Version))
+	return filepath.Join(dirs.SnapServicesDir, fmt.Sprintf("%s_%s_%s.socket", m.unitName(), service.Name, m.Version))
 }
 
 func generateBusPolicyFileName(m *packageYaml, service ServiceYaml) string {
-	return filepath.Join(dirs.SnapBusPolicyDir, fmt.Sprintf("%s_%s_%s.conf", m.Name, service.Name, m.Version))
+	return filepath.Join(dirs.SnapBusPolicyDir, fmt.Sprintf("%s_%s_%s.conf", m.unitName(), service.Name, m.Version))
 }
 
 // takes a directory and removes the global root, this is needed
@@ -505,6 +505,11 @@ func (m *packageYaml) addPackageServices(baseDir string, inhibitHooks bool, inte
 		// is in the service file when the SetRoot() option
 		// is used
 		realBaseDir := stripGlobalRootDir(baseDir)
+		// multi-architecture snaps run the commands of the
+		// system architecture
+		service.Start = archCommand(baseDir, service.Start)
+		service.Stop = archCommand(baseDir, service.Stop)
+		service.PostStop = archCommand(baseDir, service.PostStop)
 		// Generate service file
 		content, err := generateSnapServicesFile(service, realBaseDir, aaProfile, m)
 		if err != nil {
@@ -639,6 +644,9 @@ func (m *packageYaml) addPackageBinaries(baseDir string) error {
 		// is in the service file when the SetRoot() option
 		// is used
 		realBaseDir := stripGlobalRootDir(baseDir)
+		// multi-architecture snaps run the binary of the system
+		// architecture
+		binary.Exec = archCommand(baseDir, binary.Exec)
 		content, err := generateSnapBinaryWrapper(binary, realBaseDir, aaProfile, m)
 		if err != nil {
 			return err
@@ -773,7 +781,31 @@ func installClick(snapFile string, flags InstallFlags, inter progress.Meter, ori
 	}
 	defer part.deb.Close()
 
-	return part.Install(inter, flags)
+	// the frameworks are locked until the snap is in, so that they
+	// are not removed under it or installed twice
+	lock, err := lockFrameworks("install "+part.Name(), part.m.Frameworks)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+
+	// check the snap before installing anything for it; the
+	// frameworks are checked by installing them
+	if err := part.canInstall((flags&AllowOEM) != 0, inter, false); err != nil {
+		return "", err
+	}
+
+	fmks, err := installMissingFrameworks(part.m, flags, inter)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			rollbackFrameworks(fmks, inter)
+		}
+	}()
+
+	return part.install(inter, flags)
 }
 
 // removeSnapData removes the data for the given version of the given snap
@@ -808,8 +840,9 @@ func snapDataDirs(fullName, version string) ([]string, error) {
 }
 
 // Copy all data for "fullName" from "oldVersion" to "newVersion"
-// (but never overwrite)
-func copySnapData(fullName, oldVersion, newVersion string) (err error) {
+// (but never overwrite). With hardlink the new data is a tree of
+// hardlinks to the old data instead of a copy.
+func copySnapData(fullName, oldVersion, newVersion string, hardlink bool) (err error) {
 	oldDataDirs, err := snapDataDirs(fullName, oldVersion)
 	if err != nil {
 		return err
@@ -818,7 +851,12 @@ func copySnapData(fullName, oldVersion, newVersion string) (err error) {
 	for _, oldDir := range oldDataDirs {
 		// replace the trailing "../$old-ver" with the "../$new-ver"
 		newDir := filepath.Join(filepath.Dir(oldDir), newVersion)
-		if err := copySnapDataDirectory(oldDir, newDir); err != nil {
+		if hardlink {
+			err = linkSnapDataDirectory(oldDir, newDir)
+		} else {
+			err = copySnapDataDirectory(oldDir, newDir)
+		}
+		if err != nil {
 			return err
 		}
 	}
@@ -826,24 +864,54 @@ func copySnapData(fullName, oldVersion, newVersion string) (err error) {
 	return nil
 }
 
-// Lowlevel copy the snap data (but never override existing data)
+// Lowlevel copy the snap data (but never override existing data).
+// A copy-on-write clone is tried first, if the filesystem can't do
+// that the data is copied.
 func copySnapDataDirectory(oldPath, newPath string) (err error) {
-	if _, err := os.Stat(oldPath); err == nil {
-		if _, err := os.Stat(newPath); err != nil {
-			// there is no golang "CopyFile"
-			cmd := exec.Command("cp", "-a", oldPath, newPath)
-			if err := cmd.Run(); err != nil {
-				if exitCode, err := helpers.ExitCode(err); err == nil {
-					return &ErrDataCopyFailed{
-						OldPath:  oldPath,
-						NewPath:  newPath,
-						ExitCode: exitCode}
-				}
-				return err
-			}
+	return cpSnapDataDirectory(oldPath, newPath, cpReflinkArgs, cpCopyArgs)
+}
+
+// Lowlevel hardlink the snap data (but never override existing
+// data), falling back to a copy if hardlinks can't be made
+func linkSnapDataDirectory(oldPath, newPath string) (err error) {
+	return cpSnapDataDirectory(oldPath, newPath, cpHardlinkArgs, cpCopyArgs)
+}
+
+// the ways of creating the new data dir, as cp options
+var (
+	cpReflinkArgs  = []string{"-a", "--reflink=always"}
+	cpHardlinkArgs = []string{"-a", "-l"}
+	cpCopyArgs     = []string{"-a"}
+)
+
+// cpSnapDataDirectory tries each set of cp options in turn until one
+// works, the error of the last one is returned
+func cpSnapDataDirectory(oldPath, newPath string, attempts ...[]string) (err error) {
+	if _, err := os.Stat(oldPath); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return nil
+	}
+
+	for _, args := range attempts {
+		// clean up what a failed attempt left behind
+		os.RemoveAll(newPath)
+
+		// there is no golang "CopyFile"
+		cmd := exec.Command("cp", append(args, oldPath, newPath)...)
+		if err = cmd.Run(); err == nil {
+			return nil
 		}
 	}
-	return nil
+
+	if exitCode, e := helpers.ExitCode(err); e == nil {
+		return &ErrDataCopyFailed{
+			OldPath:  oldPath,
+			NewPath:  newPath,
+			ExitCode: exitCode}
+	}
+	return err
 }
 
 // RunHooks will run all click system hooks
@@ -214,9 +214,9 @@ func (s *SnapTestSuite) TestLocalSnapInstallFailsAlreadyInstalled(c *C) {
 }
 
 func (s *SnapTestSuite) TestLocalSnapInstallDebsigVerifyFails(c *C) {
-	old := clickdeb.VerifyCmd
-	clickdeb.VerifyCmd = "false"
-	defer func() { clickdeb.VerifyCmd = old }()
+	clickdeb.Verify = func(string, bool) error {
+		return &clickdeb.ErrBadSignature{Err: errors.New("bad")}
+	}
 
 	snapFile := makeTestSnapPackage(c, "")
 	_, err := installClick(snapFile, 0, nil, testOrigin)
@@ -227,15 +227,10 @@ func (s *SnapTestSuite) TestLocalSnapInstallDebsigVerifyFails(c *C) {
 	c.Assert(err, NotNil)
 }
 
-// ensure that the right parameters are passed to runDebsigVerify()
+// ensure that the right parameters are passed to clickdeb.Verify()
 func (s *SnapTestSuite) TestLocalSnapInstallDebsigVerifyPassesUnauth(c *C) {
-	// make a fake debsig that fails with unauth
-	f := filepath.Join(c.MkDir(), "fakedebsig")
-	c.Assert(ioutil.WriteFile(f, []byte("#!/bin/sh\nexit 10\n"), 0755), IsNil)
-
-	old := clickdeb.VerifyCmd
-	clickdeb.VerifyCmd = f
-	defer func() { clickdeb.VerifyCmd = old }()
+	// the real thing, the test snap is not signed
+	clickdeb.Verify = s.verify
 
 	snapFile := makeTestSnapPackage(c, "")
 	name, err := installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
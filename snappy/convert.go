@@ -0,0 +1,232 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg/clickdeb"
+	"github.com/ubuntu-core/snappy/pkg/snapfs"
+)
+
+// the control members that are carried over into the DEBIAN dir of
+// the snapfs snap
+var convertControlMembers = []string{"control", "manifest", "hashes.yaml"}
+
+// ConvertLegacySnap converts the given legacy (clickdeb) snap into a
+// snapfs snap in targetDir and returns the name of the new snap. The
+// snapfs snap is checked to install the same files as the legacy one.
+func ConvertLegacySnap(legacySnap, targetDir string) (string, error) {
+	d, err := clickdeb.Open(legacySnap)
+	if err != nil {
+		return "", err
+	}
+	defer d.Close()
+
+	buildDir, err := ioutil.TempDir("", "snappy-convert-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(buildDir)
+
+	// the data is the snap as it was built, with meta/ and the
+	// generated compat hooks, the control data has the click
+	// manifest and the hashes
+	if err := d.Unpack(buildDir); err != nil {
+		return "", err
+	}
+	if err := d.UnpackControl(filepath.Join(buildDir, "DEBIAN")); err != nil {
+		return "", err
+	}
+
+	m, err := parsePackageYamlFile(filepath.Join(buildDir, "meta", "package.yaml"))
+	if err != nil {
+		return "", err
+	}
+
+	snapName, err := snapFileName(m, targetDir)
+	if err != nil {
+		return "", err
+	}
+	if helpers.FileExists(snapName) {
+		same, err := sameFile(legacySnap, snapName)
+		if err != nil {
+			return "", err
+		}
+		if same {
+			return "", ErrConvertInPlace(legacySnap)
+		}
+	}
+
+	if err := snapfs.New(snapName).Build(buildDir); err != nil {
+		return "", err
+	}
+
+	if err := CheckConvertedSnap(legacySnap, snapName); err != nil {
+		os.Remove(snapName)
+		return "", err
+	}
+
+	return snapName, nil
+}
+
+func sameFile(a, b string) (bool, error) {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+
+	return os.SameFile(ai, bi), nil
+}
+
+// CheckConvertedSnap checks that the legacy snap and the snapfs snap
+// converted from it install the same files, and have the same control
+// data
+func CheckConvertedSnap(legacySnap, snapfsSnap string) error {
+	d, err := clickdeb.Open(legacySnap)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	sfs := snapfs.New(snapfsSnap)
+
+	var diffs []string
+	for _, member := range convertControlMembers {
+		legacy, err := d.ControlMember(member)
+		if err == clickdeb.ErrMemberNotFound {
+			// e.g. snaps from before there were hashes
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		converted, err := sfs.ControlMember(member)
+		if err != nil || !bytes.Equal(legacy, converted) {
+			diffs = append(diffs, filepath.Join("DEBIAN", member))
+		}
+	}
+
+	legacyDir, err := ioutil.TempDir("", "snappy-check-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(legacyDir)
+	if err := d.Unpack(legacyDir); err != nil {
+		return err
+	}
+
+	snapfsDir, err := ioutil.TempDir("", "snappy-check-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(snapfsDir)
+	if err := sfs.Unpack("*", snapfsDir); err != nil {
+		return err
+	}
+
+	legacyTree, err := treeHashes(legacyDir)
+	if err != nil {
+		return err
+	}
+	snapfsTree, err := treeHashes(snapfsDir)
+	if err != nil {
+		return err
+	}
+
+	for name, entry := range legacyTree {
+		other, ok := snapfsTree[name]
+		if !ok || !entry.equal(other) {
+			diffs = append(diffs, name)
+		}
+	}
+	for name := range snapfsTree {
+		if _, ok := legacyTree[name]; !ok {
+			diffs = append(diffs, name)
+		}
+	}
+
+	if len(diffs) > 0 {
+		sort.Strings(diffs)
+		return &ErrConvertedSnapDiffers{Snap: snapfsSnap, Paths: diffs}
+	}
+
+	return nil
+}
+
+// a file in an unpacked snap
+type treeEntry struct {
+	hash *fileHash
+	link string
+}
+
+// treeHashes returns the hashes of the files in dir the way they are
+// written into the hashes.yaml, plus the symlink targets, without the
+// DEBIAN dir
+func treeHashes(dir string) (map[string]treeEntry, error) {
+	entries := make(map[string]treeEntry)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if path == filepath.Join(dir, "DEBIAN") {
+			return filepath.SkipDir
+		}
+
+		h, err := hashForFile(dir, path, info)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if helpers.IsSymlink(info.Mode()) {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		entries[h.Name] = treeEntry{hash: h, link: link}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+func (a treeEntry) equal(b treeEntry) bool {
+	if a.link != b.link {
+		return false
+	}
+	if (a.hash.Size == nil) != (b.hash.Size == nil) || (a.hash.Size != nil && *a.hash.Size != *b.hash.Size) {
+		return false
+	}
+
+	return a.hash.Sha512 == b.hash.Sha512 && a.hash.Device == b.hash.Device && a.hash.Mode.mode == b.hash.Mode.mode
+}
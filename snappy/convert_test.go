@@ -0,0 +1,71 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg/snapfs"
+)
+
+func (s *SnapTestSuite) TestConvertLegacySnap(c *C) {
+	legacy := makeTestSnapPackage(c, "")
+
+	targetDir := c.MkDir()
+	converted, err := ConvertLegacySnap(legacy, targetDir)
+	c.Assert(err, IsNil)
+	c.Check(converted, Equals, filepath.Join(targetDir, "foo_1.0_all.snap"))
+
+	// it is a snapfs snap, with the hashes of the legacy one
+	d, err := OpenPackageFile(converted)
+	c.Assert(err, IsNil)
+	c.Assert(d, FitsTypeOf, &snapfs.Snap{})
+	_, err = d.ControlMember("hashes.yaml")
+	c.Check(err, IsNil)
+
+	c.Check(CheckConvertedSnap(legacy, converted), IsNil)
+}
+
+func (s *SnapTestSuite) TestConvertLegacySnapInPlace(c *C) {
+	legacy := makeTestSnapPackage(c, "")
+
+	_, err := ConvertLegacySnap(legacy, filepath.Dir(legacy))
+	c.Check(err, Equals, ErrConvertInPlace(legacy))
+}
+
+func (s *SnapTestSuite) TestCheckConvertedSnapDiffers(c *C) {
+	legacy := makeTestSnapPackage(c, "")
+
+	snapBuilderFunc = BuildSnapfsSnap
+	defer func() { snapBuilderFunc = BuildLegacySnap }()
+	other := makeTestSnapPackage(c, "name: foo\nversion: 1.0\nvendor: Someone Else <else@example.com>\n")
+
+	err := CheckConvertedSnap(legacy, other)
+	c.Assert(err, FitsTypeOf, &ErrConvertedSnapDiffers{})
+	paths := err.(*ErrConvertedSnapDiffers).Paths
+	c.Check(paths, DeepEquals, []string{
+		"DEBIAN/control",
+		"DEBIAN/hashes.yaml",
+		"DEBIAN/manifest",
+		"meta/package.yaml",
+	})
+}
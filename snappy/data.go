@@ -0,0 +1,185 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// the meta files of a data export
+const (
+	dataExportInfoFile   = "export.json"
+	dataExportConfigFile = "config.yaml"
+)
+
+// DataExportInfo describes where the data in a data export comes from
+type DataExportInfo struct {
+	Name    string `json:"name"`
+	Origin  string `json:"origin"`
+	Version string `json:"version"`
+	Config  string `json:"-"`
+}
+
+// ExportData writes the data of the active version of the given
+// package, for the system and all users, together with its
+// configuration to w
+func ExportData(pkgName string, w io.Writer) error {
+	part, err := activeSnapByNameAndOrigin(SplitOrigin(pkgName))
+	if err != nil {
+		return err
+	}
+	if part == nil {
+		return ErrPackageNotFound
+	}
+
+	info, err := json.Marshal(&DataExportInfo{
+		Name:    part.Name(),
+		Origin:  part.Origin(),
+		Version: part.Version(),
+	})
+	if err != nil {
+		return err
+	}
+	meta := map[string][]byte{dataExportInfoFile: info}
+
+	config, err := part.Config(nil)
+	switch err {
+	case nil:
+		meta[dataExportConfigFile] = []byte(config)
+	case ErrConfigNotFound:
+		// nothing to configure
+	default:
+		return err
+	}
+
+	return writeDataTarball(w, part, meta)
+}
+
+// errDataExportInfoRead is used to stop reading a data export once its
+// meta files have been read
+var errDataExportInfoRead = errors.New("data export info read")
+
+// ReadDataExport returns the information about the data export in the
+// given file
+func ReadDataExport(file string) (*DataExportInfo, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, &ErrInvalidSnapshot{Msg: err.Error()}
+	}
+	defer gz.Close()
+
+	var info *DataExportInfo
+	var config []byte
+	// the meta files are written first
+	err = helpers.TarIterate(gz, func(tr *tar.Reader, hdr *tar.Header) error {
+		dir, name := filepath.Split(filepath.Clean(hdr.Name))
+		if filepath.Clean(dir) != snapshotMetaDir {
+			return errDataExportInfoRead
+		}
+
+		switch name {
+		case dataExportInfoFile:
+			info = &DataExportInfo{}
+			if err := json.NewDecoder(tr).Decode(info); err != nil {
+				return &ErrInvalidSnapshot{Msg: err.Error()}
+			}
+		case dataExportConfigFile:
+			var err error
+			if config, err = ioutil.ReadAll(tr); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil && err != errDataExportInfoRead {
+		return nil, err
+	}
+
+	if info == nil || info.Name == "" {
+		return nil, &ErrInvalidSnapshot{Msg: "not a data export"}
+	}
+	info.Config = string(config)
+
+	return info, nil
+}
+
+// ImportData replaces the data and configuration of the package the
+// data export in the given file comes from with the ones in the
+// export. The package needs to be installed from the same origin, in
+// the same or a newer version than the one the data comes from.
+func ImportData(file string, meter progress.Meter) (*DataExportInfo, error) {
+	info, err := ReadDataExport(file)
+	if err != nil {
+		return nil, err
+	}
+
+	part, err := activeSnapByNameAndOrigin(info.Name, info.Origin)
+	if err != nil {
+		return nil, err
+	}
+	if part == nil {
+		part = ActiveSnapByName(info.Name)
+	}
+	if part == nil {
+		return nil, ErrPackageNotFound
+	}
+
+	if part.Origin() != info.Origin {
+		return nil, &ErrDataImportIncompatible{Snap: info.Name, Msg: "data is from origin " + info.Origin + ", installed is " + part.Origin()}
+	}
+	if VersionCompare(part.Version(), info.Version) < 0 {
+		return nil, &ErrDataImportIncompatible{Snap: info.Name, Msg: "data is from version " + info.Version + ", installed is " + part.Version()}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := restoreDataTarball(part, f, meter); err != nil {
+		return nil, err
+	}
+
+	if info.Config != "" {
+		if _, err := part.Config([]byte(info.Config)); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,135 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func (s *SnapTestSuite) exportData(c *C) string {
+	file := filepath.Join(c.MkDir(), "export.tar.gz")
+	f, err := os.Create(file)
+	c.Assert(err, IsNil)
+	c.Assert(ExportData("hello-app", f), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	return file
+}
+
+func (s *SnapTestSuite) TestDataExportImport(c *C) {
+	var configs []string
+	runConfigScript = func(cs, aa, rc string, env []string) (string, error) {
+		configs = append(configs, rc)
+		return "config: 1\n", nil
+	}
+	defer func() { runConfigScript = runConfigScriptImpl }()
+
+	snapDir, err := s.makeInstalledMockSnapWithConfig(c, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(filepath.Join(snapDir, "meta", "package.yaml")), IsNil)
+
+	dataDir := filepath.Join(dirs.SnapDataDir, "hello-app."+testOrigin, "1.10")
+	c.Assert(os.MkdirAll(dataDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dataDir, "canary"), []byte("ni"), 0644), IsNil)
+
+	file := s.exportData(c)
+	// the current configuration was asked for
+	c.Check(configs, DeepEquals, []string{""})
+
+	info, err := ReadDataExport(file)
+	c.Assert(err, IsNil)
+	c.Check(info, DeepEquals, &DataExportInfo{Name: "hello-app", Origin: testOrigin, Version: "1.10", Config: "config: 1\n"})
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dataDir, "canary"), []byte("ekke"), 0644), IsNil)
+
+	info, err = ImportData(file, &MockProgressMeter{})
+	c.Assert(err, IsNil)
+	c.Check(info.Version, Equals, "1.10")
+
+	content, err := ioutil.ReadFile(filepath.Join(dataDir, "canary"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "ni")
+	// and the configuration was applied
+	c.Check(configs, DeepEquals, []string{"", "config: 1\n"})
+}
+
+func (s *SnapTestSuite) TestDataImportIncompatible(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+	file := s.exportData(c)
+
+	// an older version can't use the data
+	c.Assert(os.RemoveAll(filepath.Join(dirs.SnapAppsDir, "hello-app."+testOrigin)), IsNil)
+	yamlPath, err = s.makeInstalledMockSnap("name: hello-app\nversion: 1.9\nvendor: foo\n")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	_, err = ImportData(file, &MockProgressMeter{})
+	c.Assert(err, FitsTypeOf, &ErrDataImportIncompatible{})
+	c.Check(err, ErrorMatches, "can not import data for hello-app: data is from version 1.10, installed is 1.9")
+
+	// a newer one can
+	c.Assert(os.RemoveAll(filepath.Join(dirs.SnapAppsDir, "hello-app."+testOrigin)), IsNil)
+	yamlPath, err = s.makeInstalledMockSnap("name: hello-app\nversion: 2.0\nvendor: foo\n")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	_, err = ImportData(file, &MockProgressMeter{})
+	c.Check(err, IsNil)
+}
+
+func (s *SnapTestSuite) TestReadDataExportNotAnExport(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	// a snapshot is not an export
+	snapshot, err := SnapshotSave("hello-app")
+	c.Assert(err, IsNil)
+
+	_, err = ReadDataExport(snapshot)
+	c.Check(err, FitsTypeOf, &ErrInvalidSnapshot{})
+}
+
+func (s *SnapTestSuite) TestDataImportThroughSymlink(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	outside := c.MkDir()
+	file := filepath.Join(c.MkDir(), "export.tar.gz")
+	writeTestSnapshot(c, file,
+		testTarEntry{Name: "meta/export.json", Content: `{"name":"hello-app","origin":"` + testOrigin + `","version":"1.10"}`},
+		testTarEntry{Name: "system/x", Linkname: outside},
+		testTarEntry{Name: "system/x/evil", Content: "evil"},
+	)
+
+	_, err = ImportData(file, &MockProgressMeter{})
+	c.Check(err, FitsTypeOf, &ErrInvalidSnapshot{})
+	c.Check(helpers.FileExists(filepath.Join(outside, "evil")), Equals, false)
+}
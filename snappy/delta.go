@@ -0,0 +1,213 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// errNoDelta is returned by downloadDelta if there is no usable delta
+// for the installed version; it is not logged as a failure
+var errNoDelta = errors.New("no usable delta")
+
+// deltaCmds maps the delta formats we know about to the command that
+// applies them, with the arguments in the order (source, delta, target)
+var deltaCmds = map[string]func(source, delta, target string) *exec.Cmd{
+	"xdelta3": func(source, delta, target string) *exec.Cmd {
+		return exec.Command("xdelta3", "-d", "-f", "-s", source, delta, target)
+	},
+	"bsdiff": func(source, delta, target string) *exec.Cmd {
+		return exec.Command("bspatch", source, target, delta)
+	},
+}
+
+// applyDelta applies the given delta to source, writing the result to target
+var applyDelta = applyDeltaImpl
+
+func applyDeltaImpl(format, source, delta, target string) error {
+	mkCmd, ok := deltaCmds[format]
+	if !ok {
+		return &ErrDeltaFormatUnsupported{Format: format}
+	}
+
+	cmd := mkCmd(source, delta, target)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return &ErrDeltaApplyFailed{Format: format, Output: output, OrigErr: err}
+	}
+
+	return nil
+}
+
+// findDelta returns the delta from the given remote snap data that
+// applies to the given version in a format we know how to apply
+func findDelta(r *remote.Snap, fromVersion string) *remote.Delta {
+	for i := range r.Deltas {
+		delta := &r.Deltas[i]
+		if delta.FromVersion != fromVersion {
+			continue
+		}
+		if _, ok := deltaCmds[delta.Format]; ok {
+			return delta
+		}
+	}
+
+	return nil
+}
+
+// downloadDelta tries to build the snap by downloading a delta against
+// the locally kept blob of the currently active version. It returns the
+// filename of the reconstructed snap, which has been verified against the
+// DownloadSha512 of the store data.
+func (s *RemoteSnapPart) downloadDelta(pbar progress.Meter) (string, error) {
+	// without a hash to check the result against we can't trust a delta
+	if s.pkg.DownloadSha512 == "" || len(s.pkg.Deltas) == 0 {
+		return "", errNoDelta
+	}
+
+	current, _ := activeSnapByNameAndOrigin(s.Name(), s.Origin())
+	if current == nil {
+		return "", errNoDelta
+	}
+
+	delta := findDelta(&s.pkg, current.Version())
+	if delta == nil {
+		return "", errNoDelta
+	}
+
+	source := blobPath(current)
+	if !helpers.FileExists(source) {
+		return "", errNoDelta
+	}
+
+	if delta.FromSha512 != "" {
+		sourceSha512, err := helpers.Sha512sum(source)
+		if err != nil {
+			return "", err
+		}
+		if sourceSha512 != delta.FromSha512 {
+			return "", &ErrHashMismatch{File: source, Expected: delta.FromSha512, Got: sourceSha512}
+		}
+	}
+
+	cacheName := fmt.Sprintf("%s_%s_from_%s.%s", QualifiedName(s), s.Version(), delta.FromVersion, delta.Format)
+	deltaFile, err := downloadToCache(s.pkg.Name+" (delta)", cacheName, delta.AnonDownloadURL, delta.DownloadURL, delta.DownloadSha512, pbar)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(deltaFile)
+
+	w, err := ioutil.TempFile("", s.pkg.Name)
+	if err != nil {
+		return "", err
+	}
+	target := w.Name()
+	w.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(target)
+		}
+	}()
+
+	if err = applyDelta(delta.Format, source, deltaFile, target); err != nil {
+		return "", err
+	}
+
+	targetSha512, err := helpers.Sha512sum(target)
+	if err != nil {
+		return "", err
+	}
+	if targetSha512 != s.pkg.DownloadSha512 {
+		err = &ErrHashMismatch{File: target, Expected: s.pkg.DownloadSha512, Got: targetSha512}
+		return "", err
+	}
+
+	return target, nil
+}
+
+// keepBlob keeps a copy of the snap file the given part was installed
+// from, so that later updates can be applied as deltas against it
+func keepBlob(snapFile string, part Part) error {
+	if err := os.MkdirAll(dirs.SnapBlobDir, 0755); err != nil {
+		return err
+	}
+
+	target := blobPath(part)
+	if err := os.Rename(snapFile, target); err == nil {
+		return nil
+	}
+
+	// never write into an existing blob, it might be in use; copy
+	// next to it and move the copy into place instead
+	w, err := ioutil.TempFile(dirs.SnapBlobDir, "blob")
+	if err != nil {
+		return err
+	}
+	tmp := w.Name()
+	w.Close()
+
+	if err := helpers.CopyFile(snapFile, tmp, helpers.CopyFlagOverwrite|helpers.CopyFlagSync); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// removeBlob removes the kept snap file of the given part, if any
+func removeBlob(part Part) {
+	if err := os.Remove(blobPath(part)); err != nil && !os.IsNotExist(err) {
+		logger.Noticef("Failed to remove snap blob %s: %s", blobPath(part), err)
+	}
+}
+
+// pruneBlobs removes the kept snap files of the installed versions of
+// the given part other than its own: deltas only ever apply to the
+// active version. Mounted versions keep theirs, it is what is mounted.
+func pruneBlobs(part Part) {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		logger.Noticef("Failed to prune snap blobs of %s: %s", QualifiedName(part), err)
+		return
+	}
+
+	for _, other := range FindSnapsByName(QualifiedName(part), installed) {
+		if other.Version() == part.Version() {
+			continue
+		}
+		if snap, ok := other.(*SnapPart); ok && snap.isMounted() {
+			continue
+		}
+		removeBlob(other)
+	}
+}
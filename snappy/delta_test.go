@@ -0,0 +1,242 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+func sha512hex(s string) string {
+	h := sha512.Sum512([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// mockApplyDelta "applies" a delta by appending it to the source
+func mockApplyDelta(format, source, delta, target string) error {
+	a, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(delta)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(target, append(a, b...), 0644)
+}
+
+func (s *SnapTestSuite) makeDeltaTestSetup(c *C) (*RemoteSnapPart, *httptest.Server) {
+	yamlFile, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	c.Assert(os.MkdirAll(dirs.SnapBlobDir, 0755), IsNil)
+	blob := filepath.Join(dirs.SnapBlobDir, helloAppComposedName+"_1.10.snap")
+	c.Assert(ioutil.WriteFile(blob, []byte("old"), 0644), IsNil)
+
+	applyDelta = mockApplyDelta
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/snap":
+			fmt.Fprint(w, "full")
+		case "/delta":
+			fmt.Fprint(w, "+delta")
+		default:
+			panic("unexpected url path: " + r.URL.Path)
+		}
+	}))
+
+	snap := NewRemoteSnapPart(remote.Snap{
+		Name:            "hello-app",
+		Origin:          testOrigin,
+		Version:         "2.0",
+		AnonDownloadURL: mockServer.URL + "/snap",
+		DownloadSha512:  sha512hex("old+delta"),
+		Deltas: []remote.Delta{{
+			Format:          "xdelta3",
+			FromVersion:     "1.10",
+			FromSha512:      sha512hex("old"),
+			AnonDownloadURL: mockServer.URL + "/delta",
+			DownloadSha512:  sha512hex("+delta"),
+		}},
+	})
+
+	return snap, mockServer
+}
+
+func (s *SnapTestSuite) checkDownload(c *C, snap *RemoteSnapPart, expected string) {
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, expected)
+}
+
+func (s *SnapTestSuite) TestDownloadDelta(c *C) {
+	snap, mockServer := s.makeDeltaTestSetup(c)
+	defer mockServer.Close()
+
+	s.checkDownload(c, snap, "old+delta")
+}
+
+func (s *SnapTestSuite) TestDownloadDeltaResultMismatchFallsBack(c *C) {
+	snap, mockServer := s.makeDeltaTestSetup(c)
+	defer mockServer.Close()
+
+	snap.pkg.DownloadSha512 = sha512hex("full")
+
+	s.checkDownload(c, snap, "full")
+}
+
+func (s *SnapTestSuite) TestDownloadDeltaSourceMismatchFallsBack(c *C) {
+	snap, mockServer := s.makeDeltaTestSetup(c)
+	defer mockServer.Close()
+
+	snap.pkg.DownloadSha512 = sha512hex("full")
+	snap.pkg.Deltas[0].FromSha512 = sha512hex("something else")
+
+	_, err := snap.downloadDelta(nil)
+	c.Assert(err, FitsTypeOf, &ErrHashMismatch{})
+
+	s.checkDownload(c, snap, "full")
+}
+
+func (s *SnapTestSuite) TestDownloadDeltaNoBlob(c *C) {
+	snap, mockServer := s.makeDeltaTestSetup(c)
+	defer mockServer.Close()
+
+	snap.pkg.DownloadSha512 = sha512hex("full")
+	c.Assert(os.RemoveAll(dirs.SnapBlobDir), IsNil)
+
+	_, err := snap.downloadDelta(nil)
+	c.Assert(err, Equals, errNoDelta)
+
+	s.checkDownload(c, snap, "full")
+}
+
+func (s *SnapTestSuite) TestDownloadDeltaOtherVersion(c *C) {
+	snap, mockServer := s.makeDeltaTestSetup(c)
+	defer mockServer.Close()
+
+	snap.pkg.Deltas[0].FromVersion = "1.0"
+
+	_, err := snap.downloadDelta(nil)
+	c.Assert(err, Equals, errNoDelta)
+}
+
+func (s *SnapTestSuite) TestDownloadDeltaUnknownFormat(c *C) {
+	snap, mockServer := s.makeDeltaTestSetup(c)
+	defer mockServer.Close()
+
+	snap.pkg.Deltas[0].Format = "magic"
+
+	_, err := snap.downloadDelta(nil)
+	c.Assert(err, Equals, errNoDelta)
+}
+
+func (s *SnapTestSuite) TestApplyDeltaUnknownFormat(c *C) {
+	err := applyDeltaImpl("magic", "a", "b", "c")
+	c.Assert(err, DeepEquals, &ErrDeltaFormatUnsupported{Format: "magic"})
+}
+
+func (s *SnapTestSuite) TestKeepAndRemoveBlob(c *C) {
+	snapFile := filepath.Join(c.MkDir(), "foo.snap")
+	c.Assert(ioutil.WriteFile(snapFile, []byte("blob"), 0644), IsNil)
+
+	snap := NewRemoteSnapPart(remote.Snap{Name: "foo", Origin: testOrigin, Version: "1.0"})
+	c.Assert(keepBlob(snapFile, snap), IsNil)
+
+	blob := filepath.Join(dirs.SnapBlobDir, fooComposedName+"_1.0.snap")
+	content, err := ioutil.ReadFile(blob)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "blob")
+
+	removeBlob(snap)
+	c.Check(helpers.FileExists(blob), Equals, false)
+}
+
+func (s *SnapTestSuite) TestKeepBlobReplacesNotTruncates(c *C) {
+	snap := NewRemoteSnapPart(remote.Snap{Name: "foo", Origin: testOrigin, Version: "1.0"})
+	blob := filepath.Join(dirs.SnapBlobDir, fooComposedName+"_1.0.snap")
+	c.Assert(os.MkdirAll(dirs.SnapBlobDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(blob, []byte("old blob"), 0644), IsNil)
+
+	// whoever has the old blob (e.g. a mount) keeps it unchanged
+	inUse := filepath.Join(c.MkDir(), "in-use")
+	c.Assert(os.Link(blob, inUse), IsNil)
+
+	snapFile := filepath.Join(c.MkDir(), "foo.snap")
+	c.Assert(ioutil.WriteFile(snapFile, []byte("new blob"), 0644), IsNil)
+	c.Assert(keepBlob(snapFile, snap), IsNil)
+
+	content, err := ioutil.ReadFile(blob)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "new blob")
+	content, err = ioutil.ReadFile(inUse)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "old blob")
+}
+
+func (s *SnapTestSuite) TestPruneBlobs(c *C) {
+	_, err := s.makeInstalledMockSnap("name: hello-app\nversion: 1.0\nvendor: foo")
+	c.Assert(err, IsNil)
+	yamlFile, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	c.Assert(os.MkdirAll(dirs.SnapBlobDir, 0755), IsNil)
+	blob := func(name string) string {
+		path := filepath.Join(dirs.SnapBlobDir, name)
+		c.Assert(ioutil.WriteFile(path, []byte(name), 0644), IsNil)
+		return path
+	}
+	old := blob(helloAppComposedName + "_1.0.snap")
+	current := blob(helloAppComposedName + "_1.10.snap")
+	other := blob(fooComposedName + "_1.0.snap")
+
+	// 1.0 is mounted, what is mounted stays
+	c.Assert(os.MkdirAll(dirs.SnapServicesDir, 0755), IsNil)
+	mountUnit := mountUnitFileName(filepath.Join(dirs.SnapAppsDir, helloAppComposedName, "1.0"))
+	c.Assert(ioutil.WriteFile(mountUnit, nil, 0644), IsNil)
+	pruneBlobs(NewRemoteSnapPart(remote.Snap{Name: "hello-app", Origin: testOrigin, Version: "2.0"}))
+	c.Check(helpers.FileExists(old), Equals, true)
+	c.Check(helpers.FileExists(current), Equals, false)
+
+	c.Assert(os.Remove(mountUnit), IsNil)
+	pruneBlobs(NewRemoteSnapPart(remote.Snap{Name: "hello-app", Origin: testOrigin, Version: "2.0"}))
+	c.Check(helpers.FileExists(old), Equals, false)
+	c.Check(helpers.FileExists(other), Equals, true)
+}
@@ -0,0 +1,208 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+var (
+	// downloadRetries is the number of times a download is retried
+	// after a transient failure
+	downloadRetries = 5
+
+	// downloadRetryDelay is how long we wait before the first retry,
+	// it is doubled for each further retry
+	downloadRetryDelay = 1 * time.Second
+)
+
+// downloadToCache downloads the given url into the download cache and
+// returns the filename of the downloaded file. A partial download left
+// behind by an earlier attempt is resumed.
+//
+// If expectedSha512 is not empty the download is verified against it.
+func downloadToCache(name, cacheName, anonURL, authURL, expectedSha512 string, pbar progress.Meter) (string, error) {
+	if err := os.MkdirAll(dirs.SnapDownloadCacheDir, 0755); err != nil {
+		return "", err
+	}
+
+	// try anonymous download first and fallback to authenticated
+	url := anonURL
+	if url == "" {
+		url = authURL
+	}
+
+	partial := filepath.Join(dirs.SnapDownloadCacheDir, cacheName+".partial")
+	if err := downloadAndVerify(name, partial, url, expectedSha512, pbar); err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(dirs.SnapDownloadCacheDir, cacheName)
+	if err := os.Rename(partial, target); err != nil {
+		return "", err
+	}
+
+	return target, nil
+}
+
+// downloadAndVerify downloads url into target, retrying with backoff on
+// transient errors, and checks the result against expectedSha512
+func downloadAndVerify(name, target, url, expectedSha512 string, pbar progress.Meter) (err error) {
+	delay := downloadRetryDelay
+	for retry := 0; ; retry++ {
+		// we can only safely resume if we can verify the
+		// result, otherwise every try starts from zero
+		if expectedSha512 == "" {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		err = downloadRange(name, target, url, pbar)
+		if err == nil || retry >= downloadRetries || !isRetryableDownloadError(err) {
+			break
+		}
+
+		logger.Noticef("Download of %s failed, retrying in %s: %v", name, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	if err != nil {
+		return err
+	}
+
+	if expectedSha512 == "" {
+		return nil
+	}
+
+	got, err := helpers.Sha512sum(target)
+	if err != nil {
+		return err
+	}
+	if got != expectedSha512 {
+		// no point in resuming this one
+		if err := os.Remove(target); err != nil {
+			logger.Noticef("Failed to remove %q: %v", target, err)
+		}
+		return &ErrHashMismatch{File: target, Expected: expectedSha512, Got: got}
+	}
+
+	return nil
+}
+
+// downloadRange appends to target whatever it is missing from url,
+// using a http Range request. If the server does not honour the range
+// the download starts over.
+func downloadRange(name, target, url string, pbar progress.Meter) error {
+	w, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	offset, err := w.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	setUbuntuStoreHeaders(req)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 206 && offset > 0:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			// start over on the next try
+			if err := w.Truncate(0); err != nil {
+				return err
+			}
+			return &ErrDownloadRange{Offset: offset, ContentRange: resp.Header.Get("Content-Range")}
+		}
+	case resp.StatusCode == 200:
+		if offset > 0 {
+			if err := w.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := w.Seek(0, os.SEEK_SET); err != nil {
+				return err
+			}
+			offset = 0
+		}
+	case resp.StatusCode == 416 && offset > 0:
+		// we already have all of it (or something bogus, which
+		// the verification will catch)
+		return nil
+	default:
+		return &ErrDownload{Code: resp.StatusCode, URL: req.URL}
+	}
+
+	if pbar != nil {
+		pbar.Start(name, float64(offset+resp.ContentLength))
+		pbar.Set(float64(offset))
+		mw := io.MultiWriter(w, pbar)
+		_, err = io.Copy(mw, resp.Body)
+		pbar.Finished()
+	} else {
+		_, err = io.Copy(w, resp.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.Sync()
+}
+
+// isRetryableDownloadError returns true if the error is one that
+// might go away by trying again
+func isRetryableDownloadError(err error) bool {
+	switch e := err.(type) {
+	case *ErrDownload:
+		return e.Code >= 500
+	case *ErrDownloadRange, *url.Error, net.Error:
+		return true
+	}
+
+	return err == io.ErrUnexpectedEOF
+}
@@ -0,0 +1,222 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+const downloadTestContent = "hello world"
+
+func writePartial(c *C, name, content string) {
+	c.Assert(os.MkdirAll(dirs.SnapDownloadCacheDir, 0755), IsNil)
+	partial := filepath.Join(dirs.SnapDownloadCacheDir, name+".partial")
+	c.Assert(ioutil.WriteFile(partial, []byte(content), 0644), IsNil)
+}
+
+func (s *SnapTestSuite) TestDownloadToCacheResumes(c *C) {
+	var ranges []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "foo.snap", time.Time{}, strings.NewReader(downloadTestContent))
+	}))
+	defer mockServer.Close()
+
+	writePartial(c, "foo.snap", "hello ")
+
+	p := &MockProgressMeter{}
+	fn, err := downloadToCache("foo", "foo.snap", mockServer.URL, "", sha512hex(downloadTestContent), p)
+	c.Assert(err, IsNil)
+	c.Check(fn, Equals, filepath.Join(dirs.SnapDownloadCacheDir, "foo.snap"))
+	c.Check(ranges, DeepEquals, []string{"bytes=6-"})
+	c.Check(p.written, Equals, len("world"))
+	c.Check(p.total, Equals, float64(len(downloadTestContent)))
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, downloadTestContent)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapDownloadCacheDir, "foo.snap.partial")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestDownloadToCacheNoRangeSupport(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, downloadTestContent)
+	}))
+	defer mockServer.Close()
+
+	writePartial(c, "foo.snap", "garbage")
+
+	fn, err := downloadToCache("foo", "foo.snap", mockServer.URL, "", sha512hex(downloadTestContent), nil)
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, downloadTestContent)
+}
+
+func (s *SnapTestSuite) TestDownloadToCacheNoHashDoesNotResume(c *C) {
+	var ranges []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "foo.snap", time.Time{}, strings.NewReader(downloadTestContent))
+	}))
+	defer mockServer.Close()
+
+	writePartial(c, "foo.snap", "stale")
+
+	fn, err := downloadToCache("foo", "foo.snap", mockServer.URL, "", "", nil)
+	c.Assert(err, IsNil)
+	c.Check(ranges, DeepEquals, []string{""})
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, downloadTestContent)
+}
+
+func (s *SnapTestSuite) TestDownloadToCacheNoHashRetryStartsOver(c *C) {
+	downloadRetryDelay = 0
+
+	var ranges []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// cut short
+			w.Header().Set("Content-Length", fmt.Sprint(len(downloadTestContent)))
+			fmt.Fprint(w, "hello ")
+			return
+		}
+		http.ServeContent(w, r, "foo.snap", time.Time{}, strings.NewReader(downloadTestContent))
+	}))
+	defer mockServer.Close()
+
+	fn, err := downloadToCache("foo", "foo.snap", mockServer.URL, "", "", nil)
+	c.Assert(err, IsNil)
+	c.Check(ranges, DeepEquals, []string{"", ""})
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, downloadTestContent)
+}
+
+func (s *SnapTestSuite) TestDownloadToCacheHashMismatch(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "evil")
+	}))
+	defer mockServer.Close()
+
+	_, err := downloadToCache("foo", "foo.snap", mockServer.URL, "", sha512hex(downloadTestContent), nil)
+	c.Assert(err, DeepEquals, &ErrHashMismatch{
+		File:     filepath.Join(dirs.SnapDownloadCacheDir, "foo.snap.partial"),
+		Expected: sha512hex(downloadTestContent),
+		Got:      sha512hex("evil"),
+	})
+
+	// the bogus download is not kept around for resuming
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapDownloadCacheDir, "foo.snap.partial")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapDownloadCacheDir, "foo.snap")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestDownloadToCacheRetries(c *C) {
+	downloadRetryDelay = 0
+
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if n < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		fmt.Fprint(w, downloadTestContent)
+	}))
+	defer mockServer.Close()
+
+	fn, err := downloadToCache("foo", "foo.snap", mockServer.URL, "", sha512hex(downloadTestContent), nil)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 3)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, downloadTestContent)
+}
+
+func (s *SnapTestSuite) TestDownloadToCacheGivesUp(c *C) {
+	downloadRetryDelay = 0
+
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(503)
+	}))
+	defer mockServer.Close()
+
+	_, err := downloadToCache("foo", "foo.snap", mockServer.URL, "", "", nil)
+	c.Assert(err, FitsTypeOf, &ErrDownload{})
+	c.Check(n, Equals, downloadRetries+1)
+}
+
+func (s *SnapTestSuite) TestDownloadToCacheNoRetryOnNotFound(c *C) {
+	downloadRetryDelay = 0
+
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(404)
+	}))
+	defer mockServer.Close()
+
+	_, err := downloadToCache("foo", "foo.snap", mockServer.URL, "", "", nil)
+	c.Assert(err, FitsTypeOf, &ErrDownload{})
+	c.Check(err.(*ErrDownload).Code, Equals, 404)
+	c.Check(n, Equals, 1)
+}
+
+func (s *SnapTestSuite) TestDownloadToCacheWrongRangeStartsOver(c *C) {
+	downloadRetryDelay = 0
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", "bytes 0-10/11")
+			w.WriteHeader(206)
+		}
+		fmt.Fprint(w, downloadTestContent)
+	}))
+	defer mockServer.Close()
+
+	writePartial(c, "foo.snap", "hello ")
+
+	fn, err := downloadToCache("foo", "foo.snap", mockServer.URL, "", sha512hex(downloadTestContent), nil)
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, downloadTestContent)
+}
@@ -84,11 +84,6 @@ var (
 	// an oem package type on a running system.
 	ErrOEMPackageInstall = errors.New("oem package installation not allowed")
 
-	// ErrPrivOpInProgress is returned when a privileged operation
-	// cannot be performed since an existing privileged operation is
-	// still running.
-	ErrPrivOpInProgress = errors.New("privileged operation already in progress")
-
 	// ErrInvalidCredentials is returned on login error
 	ErrInvalidCredentials = errors.New("invalid credentials")
 
@@ -158,6 +153,17 @@ func (e *ErrDownload) Error() string {
 	return fmt.Sprintf("received an unexpected http response code (%v) when trying to download %s", e.Code, e.URL)
 }
 
+// ErrDownloadRange is returned when the server answers a ranged
+// download request with a range other than the one we asked for
+type ErrDownloadRange struct {
+	Offset       int64
+	ContentRange string
+}
+
+func (e *ErrDownloadRange) Error() string {
+	return fmt.Sprintf("asked to resume download at offset %d but got range %q", e.Offset, e.ContentRange)
+}
+
 // ErrArchitectureNotSupported is returned when trying to install a snappy package that
 // is not supported on the system
 type ErrArchitectureNotSupported struct {
@@ -231,6 +237,17 @@ func (e ErrGarbageCollectImpossible) Error() string {
 	return fmt.Sprintf("garbage collection impossible: prerequisites untrue: %s", string(e))
 }
 
+// ErrArchSubtree is returned when building a multi-architecture snap
+// whose per-architecture subtrees do not match its architectures
+type ErrArchSubtree struct {
+	Arch string
+	Msg  string
+}
+
+func (e *ErrArchSubtree) Error() string {
+	return fmt.Sprintf("architecture subtree arch/%s: %s", e.Arch, e.Msg)
+}
+
 // ErrNameClash reports a conflict between a named service and binary in a package.
 type ErrNameClash string
 
@@ -238,6 +255,15 @@ func (e ErrNameClash) Error() string {
 	return fmt.Sprintf("you can't have a binary and service both called %s", string(e))
 }
 
+// ErrAmbiguousName is returned when a package is given by a name alone
+// but active packages of that name are installed from more than one
+// origin
+type ErrAmbiguousName string
+
+func (e ErrAmbiguousName) Error() string {
+	return fmt.Sprintf("%s is installed from more than one origin, use %s.<origin>", string(e), string(e))
+}
+
 // ErrMissingFrameworks reports a conflict between the frameworks needed by an app and those installed in the system
 type ErrMissingFrameworks []string
 
@@ -273,3 +299,172 @@ func (e *ErrInvalidYaml) Error() string {
 	// %#v of string(yaml) so the yaml is presented as a human-readable string, but in a single greppable line
 	return fmt.Sprintf("can not parse %s: %v (from: %#v)", e.File, e.Err, string(e.Yaml))
 }
+
+// ErrHashMismatch is returned when the sha512 of a file (e.g. a
+// downloaded snap) is not the one we expected
+type ErrHashMismatch struct {
+	File     string
+	Expected string
+	Got      string
+}
+
+func (e *ErrHashMismatch) Error() string {
+	return fmt.Sprintf("sha512 mismatch for %s: expected %s, got %s", e.File, e.Expected, e.Got)
+}
+
+// ErrDeltaFormatUnsupported is returned when the store offers a delta
+// in a format we do not know how to apply
+type ErrDeltaFormatUnsupported struct {
+	Format string
+}
+
+func (e *ErrDeltaFormatUnsupported) Error() string {
+	return fmt.Sprintf("unsupported delta format %q", e.Format)
+}
+
+// ErrDeltaApplyFailed is returned when applying a delta fails
+type ErrDeltaApplyFailed struct {
+	Format  string
+	Output  []byte
+	OrigErr error
+}
+
+func (e *ErrDeltaApplyFailed) Error() string {
+	return fmt.Sprintf("applying %s delta failed: %v (%q)", e.Format, e.OrigErr, e.Output)
+}
+
+// ErrInvalidSeedIndex is returned if the index of a seed directory can
+// not be used
+type ErrInvalidSeedIndex struct {
+	File string
+	Err  error
+}
+
+func (e *ErrInvalidSeedIndex) Error() string {
+	return fmt.Sprintf("invalid seed index %s: %v", e.File, e.Err)
+}
+
+// ErrInvalidSnapshot is returned if a data snapshot can not be restored
+type ErrInvalidSnapshot struct {
+	Msg string
+}
+
+func (e *ErrInvalidSnapshot) Error() string {
+	return fmt.Sprintf("invalid snapshot: %s", e.Msg)
+}
+
+// ErrDataImportIncompatible is returned if the data in a data export
+// can not be used by the installed version of the snap
+type ErrDataImportIncompatible struct {
+	Snap string
+	Msg  string
+}
+
+func (e *ErrDataImportIncompatible) Error() string {
+	return fmt.Sprintf("can not import data for %s: %s", e.Snap, e.Msg)
+}
+
+// ErrPrivOpInProgress is returned when a privileged operation
+// cannot be performed since an existing privileged operation is
+// still running.
+type ErrPrivOpInProgress struct {
+	Op string
+}
+
+func (e *ErrPrivOpInProgress) Error() string {
+	if e.Op == "" {
+		return "privileged operation already in progress"
+	}
+	return fmt.Sprintf("privileged operation already in progress: %s", e.Op)
+}
+
+// ErrPreflightFailed is returned if a snap does not pass the preflight
+// checks for installing it
+type ErrPreflightFailed struct {
+	Snap   string
+	Failed []string
+}
+
+func (e *ErrPreflightFailed) Error() string {
+	return fmt.Sprintf("%s can not be installed, failed checks: %s", e.Snap, strings.Join(e.Failed, ", "))
+}
+
+// ErrInvalidChannel is returned when asked to track a channel whose name
+// is not valid
+type ErrInvalidChannel string
+
+func (e ErrInvalidChannel) Error() string {
+	return fmt.Sprintf("invalid channel name: %q", string(e))
+}
+
+// ErrInvalidHealthCheck is returned when the health check of a service
+// in the package.yaml can not be run
+type ErrInvalidHealthCheck struct {
+	Service string
+	Msg     string
+}
+
+func (e *ErrInvalidHealthCheck) Error() string {
+	return fmt.Sprintf("invalid health check for service %s: %s", e.Service, e.Msg)
+}
+
+// ErrHealthCheckFailed is returned when a service does not pass its
+// health check in time
+type ErrHealthCheckFailed struct {
+	Snap    string
+	Service string
+	Err     error
+}
+
+func (e *ErrHealthCheckFailed) Error() string {
+	return fmt.Sprintf("health check of %s service %s failed: %v", e.Snap, e.Service, e.Err)
+}
+
+// ErrNoSnapBlob is returned when the snap file a package was
+// installed from was not kept
+type ErrNoSnapBlob string
+
+func (e ErrNoSnapBlob) Error() string {
+	return fmt.Sprintf("the snap file of %s was not kept, it can not be repaired", string(e))
+}
+
+// ErrVersionNotFound is returned when the store does not have the
+// version of a snap that was asked for
+type ErrVersionNotFound struct {
+	Snap    string
+	Version string
+}
+
+func (e *ErrVersionNotFound) Error() string {
+	return fmt.Sprintf("version %s of %s does not exist", e.Version, e.Snap)
+}
+
+// ErrSignedOriginMismatch is returned when a snap is installed for
+// another origin than the one it was signed for
+type ErrSignedOriginMismatch struct {
+	Origin       string
+	SignedOrigin string
+}
+
+func (e *ErrSignedOriginMismatch) Error() string {
+	return fmt.Sprintf("snap is signed for origin %q, not %q", e.SignedOrigin, e.Origin)
+}
+
+// ErrConvertInPlace is returned when converting a snap would overwrite
+// it with the converted one
+type ErrConvertInPlace string
+
+func (e ErrConvertInPlace) Error() string {
+	return fmt.Sprintf("converting %s would overwrite it, use another output directory", string(e))
+}
+
+// ErrConvertedSnapDiffers is returned when a converted snap does not
+// install the same files as the snap it was converted from
+type ErrConvertedSnapDiffers struct {
+	Snap  string
+	Paths []string
+}
+
+func (e *ErrConvertedSnapDiffers) Error() string {
+	return fmt.Sprintf("converted snap %s differs in: %s", e.Snap, strings.Join(e.Paths, ", "))
+}
@@ -0,0 +1,183 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// HealthCheck tells whether a service works. Exactly one of Command and
+// URL is set.
+type HealthCheck struct {
+	// Command is run, confined like the service, from the package
+	// directory; the service works if it exits with 0
+	Command string `yaml:"command,omitempty" json:"command,omitempty"`
+	// URL is fetched; the service works if it answers with a 2xx
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+	// Timeout is how long the service has to start working
+	Timeout Timeout `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// defaultHealthTimeout is the Timeout of a HealthCheck without one
+const defaultHealthTimeout = 30 * time.Second
+
+// healthCheckInterval is how long to wait between failed probes
+var healthCheckInterval = time.Second
+
+// verifyHealthCheck checks that the health check of the given service,
+// if it has one, can be run
+func verifyHealthCheck(service ServiceYaml) error {
+	h := service.Health
+	if h == nil {
+		return nil
+	}
+
+	command := strings.TrimSpace(h.Command)
+	switch {
+	case command == "" && h.URL == "":
+		return &ErrInvalidHealthCheck{Service: service.Name, Msg: "needs a command or a url"}
+	case command != "" && h.URL != "":
+		return &ErrInvalidHealthCheck{Service: service.Name, Msg: "can not have both a command and a url"}
+	case command != "":
+		if !servicesBinariesStringsWhitelist.MatchString(h.Command) {
+			return &ErrInvalidHealthCheck{Service: service.Name, Msg: fmt.Sprintf("invalid command %q", h.Command)}
+		}
+	default:
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return &ErrInvalidHealthCheck{Service: service.Name, Msg: fmt.Sprintf("invalid url %q", h.URL)}
+		}
+	}
+
+	if h.Timeout < 0 {
+		return &ErrInvalidHealthCheck{Service: service.Name, Msg: "negative timeout"}
+	}
+
+	return nil
+}
+
+// checkHealth runs the health checks of the services of the snap, each
+// until it passes or times out
+func (s *SnapPart) checkHealth(inter interacter) error {
+	for _, service := range s.m.ServiceYamls {
+		if service.Health == nil {
+			continue
+		}
+
+		if inter != nil {
+			inter.Notify(fmt.Sprintf("Checking health of %s service %s", s.Name(), service.Name))
+		}
+
+		timeout := time.Duration(service.Health.Timeout)
+		if timeout == 0 {
+			timeout = defaultHealthTimeout
+		}
+		deadline := time.Now().Add(timeout)
+
+		for {
+			// no probe runs past the deadline
+			err := s.probeHealth(service, deadline.Sub(time.Now()))
+			if err == nil {
+				break
+			}
+			if !time.Now().Add(healthCheckInterval).Before(deadline) {
+				return &ErrHealthCheckFailed{Snap: s.Name(), Service: service.Name, Err: err}
+			}
+			time.Sleep(healthCheckInterval)
+		}
+	}
+
+	return nil
+}
+
+// probeHealth runs the health check of the given service once, giving
+// up after the given timeout
+func (s *SnapPart) probeHealth(service ServiceYaml, timeout time.Duration) error {
+	h := service.Health
+	if h.URL != "" {
+		return probeHealthURL(h.URL, timeout)
+	}
+
+	aaProfile, err := getSecurityProfile(s.m, service.Name, s.basedir)
+	if err != nil {
+		return err
+	}
+
+	args := strings.Fields(h.Command)
+	args[0] = filepath.Join(s.basedir, archCommand(s.basedir, args[0]))
+	output, err := runHealthCommand(s.basedir, aaProfile, args, makeSnapHookEnv(s), timeout)
+	if err != nil {
+		return fmt.Errorf("%v (output: %q)", err, output)
+	}
+
+	return nil
+}
+
+var runHealthCommand = runHealthCommandImpl
+
+// runHealthCommandImpl runs the health check command confined by the
+// given profile and returns its output. It is killed, with whatever it
+// started, if it runs for longer than the given timeout.
+func runHealthCommandImpl(dir, aaProfile string, args []string, env []string, timeout time.Duration) ([]byte, error) {
+	cmd := exec.Command(aaExec, append([]string{"-p", aaProfile}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = env
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	err := cmd.Wait()
+	if !timer.Stop() {
+		return output.Bytes(), fmt.Errorf("timed out after %v", timeout)
+	}
+
+	return output.Bytes(), err
+}
+
+// probeHealthURL fetches the given url and checks the answer is a 2xx
+func probeHealthURL(healthURL string, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(healthURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered with %s", healthURL, resp.Status)
+	}
+
+	return nil
+}
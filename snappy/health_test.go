@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+const healthPackageYaml = `name: foo
+icon: foo.svg
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: service
+   start: bin/hello
+   health:
+`
+
+func (s *SnapTestSuite) TestVerifyHealthCheck(c *C) {
+	for _, t := range []struct {
+		health string
+		valid  bool
+	}{
+		{"    command: bin/check --quick\n", true},
+		{"    url: http://localhost:8080/health\n    timeout: 5s\n", true},
+		{"    timeout: 5s\n", false},
+		{"    command: bin/check\n    url: http://localhost:8080/\n", false},
+		{"    command: bin/check; rm -rf /\n", false},
+		{"    url: file:///etc/passwd\n", false},
+	} {
+		_, err := parsePackageYamlData([]byte(healthPackageYaml+t.health+"version: 1.0"), false)
+		if t.valid {
+			c.Check(err, IsNil, Commentf(t.health))
+		} else {
+			c.Check(err, FitsTypeOf, &ErrInvalidHealthCheck{}, Commentf(t.health))
+		}
+	}
+}
+
+func (s *SnapTestSuite) TestUpdateRunsHealthCheck(c *C) {
+	healthCheckInterval = time.Millisecond
+	var probes [][]string
+	runHealthCommand = func(dir, aaProfile string, args []string, env []string, timeout time.Duration) ([]byte, error) {
+		probes = append(probes, args)
+		c.Check(aaProfile, Equals, "foo."+testOrigin+"_service_2.0")
+		// the service takes a moment to come up
+		if len(probes) < 3 {
+			return nil, errors.New("not yet")
+		}
+		return nil, nil
+	}
+
+	health := "    command: bin/check --quick\n    timeout: 1s\n"
+	_, err := installClick(makeTestSnapPackage(c, healthPackageYaml+health+"version: 1.0"), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+	// a fresh install has nothing to go back to
+	c.Check(probes, HasLen, 0)
+
+	_, err = installClick(makeTestSnapPackage(c, healthPackageYaml+health+"version: 2.0"), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(probes, HasLen, 3)
+	c.Check(probes[0], DeepEquals, []string{filepath.Join(dirs.SnapAppsDir, "foo."+testOrigin, "2.0", "bin", "check"), "--quick"})
+	c.Check(ActiveSnapByName("foo").Version(), Equals, "2.0")
+}
+
+func (s *SnapTestSuite) TestUpdateRollsBackOnFailedHealthCheck(c *C) {
+	healthCheckInterval = time.Millisecond
+	runHealthCommand = func(dir, aaProfile string, args []string, env []string, timeout time.Duration) ([]byte, error) {
+		return []byte("broken"), errors.New("exit status 1")
+	}
+
+	health := "    command: bin/check\n    timeout: 10ms\n"
+	_, err := installClick(makeTestSnapPackage(c, healthPackageYaml+health+"version: 1.0"), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+
+	inter := &MockProgressMeter{}
+	_, err = installClick(makeTestSnapPackage(c, healthPackageYaml+health+"version: 2.0"), AllowUnauthenticated, inter, testOrigin)
+	c.Assert(err, FitsTypeOf, &ErrHealthCheckFailed{})
+	c.Check(strings.Join(inter.notified, "\n"), Matches, "(?s).*service failed: exit status 1 \\(output: \"broken\"\\); going back to foo 1.0.*")
+
+	c.Check(ActiveSnapByName("foo").Version(), Equals, "1.0")
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "foo."+testOrigin, "2.0")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "foo_service_1.0.service")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "foo_service_2.0.service")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRunHealthCommandTimesOut(c *C) {
+	// a check that never finishes, and keeps its output open
+	blocking := filepath.Join(c.MkDir(), "aa-exec")
+	c.Assert(ioutil.WriteFile(blocking, []byte("#!/bin/sh\nsleep 60\n"), 0755), IsNil)
+	aaExec = blocking
+
+	start := time.Now()
+	_, err := runHealthCommandImpl(c.MkDir(), "foo_service_1.0", []string{"bin/check"}, nil, 100*time.Millisecond)
+	c.Check(err, ErrorMatches, "timed out after 100ms")
+	c.Check(time.Since(start) < 10*time.Second, Equals, true)
+}
+
+func (s *SnapTestSuite) TestRunHealthCommand(c *C) {
+	output, err := runHealthCommandImpl(c.MkDir(), "foo_service_1.0", []string{"/bin/echo", "ok"}, nil, 10*time.Second)
+	c.Assert(err, IsNil)
+	c.Check(string(output), Matches, "(?s).*ok\n")
+}
+
+func (s *SnapTestSuite) TestProbeHealthURL(c *C) {
+	status := http.StatusServiceUnavailable
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer mockServer.Close()
+
+	c.Check(probeHealthURL(mockServer.URL, time.Second), ErrorMatches, ".* answered with 503 Service Unavailable")
+
+	status = http.StatusNoContent
+	c.Check(probeHealthURL(mockServer.URL, time.Second), IsNil)
+}
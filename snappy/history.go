@@ -0,0 +1,254 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// The operations recorded in the history
+const (
+	HistoryInstall    = "install"
+	HistoryUpdate     = "update"
+	HistoryRemove     = "remove"
+	HistoryPurge      = "purge"
+	HistoryRollback   = "rollback"
+	HistoryActivate   = "activate"
+	HistoryDeactivate = "deactivate"
+	HistoryConfig     = "config"
+	HistorySet        = "set"
+)
+
+// historyOK is the outcome of an operation that worked
+const historyOK = "ok"
+
+// HistoryEntry is an operation recorded in the history
+type HistoryEntry struct {
+	Time       time.Time `json:"time"`
+	Op         string    `json:"op"`
+	Name       string    `json:"name"`
+	Origin     string    `json:"origin,omitempty"`
+	OldVersion string    `json:"old_version,omitempty"`
+	NewVersion string    `json:"new_version,omitempty"`
+	Channel    string    `json:"channel,omitempty"`
+	Initiator  string    `json:"initiator"`
+	// Outcome is "ok", or the error the operation failed with
+	Outcome string `json:"outcome"`
+}
+
+// OK returns true if the operation worked
+func (e *HistoryEntry) OK() bool {
+	return e.Outcome == historyOK
+}
+
+// RecordHistory appends the given entry to the history
+func RecordHistory(e *HistoryEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dirs.SnapHistoryFile), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(dirs.SnapHistoryFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the CLI and snapd can both be writing
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// History returns the recorded operations on the package given as
+// name[.origin], or on all packages if it is empty, oldest first
+func History(pkgSpec string) ([]*HistoryEntry, error) {
+	f, err := os.Open(dirs.SnapHistoryFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name, origin := SplitOrigin(pkgSpec)
+
+	var entries []*HistoryEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a partial write, or worse; skip it
+			logger.Noticef("Skipping invalid history entry %q: %v", scanner.Text(), err)
+			continue
+		}
+		if name != "" && e.Name != name {
+			continue
+		}
+		if origin != "" && e.Origin != origin {
+			continue
+		}
+		entries = append(entries, &e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// LastRemoval returns when the given version of the package was
+// removed, or the zero time if that is not in the history
+func LastRemoval(name, origin, version string) time.Time {
+	entries, err := History(name)
+	if err != nil {
+		return time.Time{}
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Op == HistoryRemove && e.OK() && e.OldVersion == version && (origin == "" || e.Origin == origin) {
+			return e.Time
+		}
+	}
+
+	return time.Time{}
+}
+
+// RecordOp runs f, which does op on the package given by pkgSpec, and
+// records it in the history together with the versions of the package
+// that were active before and after, and the initiator. f returns the
+// name of the package if pkgSpec does not have it (e.g. it is a snap
+// file). Failing to record is logged but does not fail the operation.
+func RecordOp(op, pkgSpec, initiator string, f func() (string, error)) error {
+	var name, origin, version string
+	if !helpers.FileExists(pkgSpec) {
+		name = packageLockName(pkgSpec)
+		rest := pkgSpec[len(name):]
+		if idx := strings.IndexRune(rest, '='); idx > -1 {
+			version = rest[idx+1:]
+			rest = rest[:idx]
+		}
+		origin = strings.TrimPrefix(rest, ".")
+	}
+
+	e := &HistoryEntry{
+		Op:        op,
+		Name:      name,
+		Origin:    origin,
+		Initiator: initiator,
+	}
+	if name != "" {
+		if before, _ := activeSnapByNameAndOrigin(name, origin); before != nil {
+			e.OldVersion = before.Version()
+			e.Origin = before.Origin()
+		}
+	}
+	if version != "" && (op == HistoryRemove || op == HistoryPurge) {
+		// a version that is not active can be given
+		e.OldVersion = version
+	}
+
+	fname, err := f()
+	if fname != "" {
+		e.Name, e.Origin = SplitOrigin(fname)
+	}
+	if err == nil {
+		e.Outcome = historyOK
+	} else {
+		e.Outcome = err.Error()
+	}
+
+	if e.Name != "" {
+		if after, _ := activeSnapByNameAndOrigin(e.Name, e.Origin); after != nil {
+			e.NewVersion = after.Version()
+			e.Origin = after.Origin()
+			e.Channel = after.Channel()
+		}
+	}
+
+	if rerr := RecordHistory(e); rerr != nil {
+		logger.Noticef("Unable to record %s of %s in the history: %v", op, e.Name, rerr)
+	}
+
+	return err
+}
+
+// RecordUpdates runs f, which updates packages, and records each
+// updated package in the history. If f fails the packages it returns
+// as updated are recorded, then the failure, and its error is
+// returned.
+func RecordUpdates(initiator string, f func() ([]Part, error)) ([]Part, error) {
+	before := make(map[string]string)
+	if installed, err := NewMetaLocalRepository().Installed(); err == nil {
+		for _, part := range installed {
+			if part.IsActive() {
+				before[QualifiedName(part)] = part.Version()
+			}
+		}
+	}
+
+	updates, err := f()
+	for _, part := range updates {
+		e := &HistoryEntry{
+			Op:         HistoryUpdate,
+			Name:       part.Name(),
+			Origin:     part.Origin(),
+			OldVersion: before[QualifiedName(part)],
+			NewVersion: part.Version(),
+			Channel:    part.Channel(),
+			Initiator:  initiator,
+			Outcome:    historyOK,
+		}
+		if rerr := RecordHistory(e); rerr != nil {
+			logger.Noticef("Unable to record update of %s in the history: %v", part.Name(), rerr)
+		}
+	}
+
+	if err != nil {
+		e := &HistoryEntry{Op: HistoryUpdate, Initiator: initiator, Outcome: err.Error()}
+		if rerr := RecordHistory(e); rerr != nil {
+			logger.Noticef("Unable to record failed update in the history: %v", rerr)
+		}
+		return updates, err
+	}
+
+	return updates, nil
+}
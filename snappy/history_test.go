@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+func (s *SnapTestSuite) TestHistoryEmpty(c *C) {
+	entries, err := History("")
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestRecordHistory(c *C) {
+	c.Assert(RecordHistory(&HistoryEntry{Op: HistoryInstall, Name: "foo", Origin: "bar", Outcome: historyOK}), IsNil)
+	c.Assert(RecordHistory(&HistoryEntry{Op: HistoryInstall, Name: "foo", Origin: "baz", Outcome: historyOK}), IsNil)
+	c.Assert(RecordHistory(&HistoryEntry{Op: HistoryRemove, Name: "other", Outcome: historyOK}), IsNil)
+
+	// a partial write is skipped
+	f, err := os.OpenFile(dirs.SnapHistoryFile, os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	_, err = f.WriteString(`{"op": "inst`)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	entries, err := History("")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 3)
+	c.Check(entries[0].Time.IsZero(), Equals, false)
+
+	entries, err = History("foo")
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 2)
+
+	entries, err = History("foo.baz")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Origin, Equals, "baz")
+}
+
+func (s *SnapTestSuite) TestRecordOpRollback(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+
+	err := RecordOp(HistoryRollback, "foo", "cli:root", func() (string, error) {
+		_, err := Rollback("foo", "", &MockProgressMeter{})
+		return "", err
+	})
+	c.Assert(err, IsNil)
+
+	entries, err := History("foo")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	e := entries[0]
+	c.Check(e.Op, Equals, HistoryRollback)
+	c.Check(e.Origin, Equals, testOrigin)
+	c.Check(e.OldVersion, Equals, "2.0")
+	c.Check(e.NewVersion, Equals, "1.0")
+	c.Check(e.Initiator, Equals, "cli:root")
+	c.Check(e.OK(), Equals, true)
+}
+
+func (s *SnapTestSuite) TestRecordOpFailure(c *C) {
+	err := RecordOp(HistoryRemove, "foo.bar=1.0", "snapd:local", func() (string, error) {
+		return "", errors.New("boom")
+	})
+	c.Assert(err, ErrorMatches, "boom")
+
+	entries, err := History("")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Name, Equals, "foo")
+	c.Check(entries[0].Origin, Equals, "bar")
+	c.Check(entries[0].OldVersion, Equals, "1.0")
+	c.Check(entries[0].OK(), Equals, false)
+	c.Check(entries[0].Outcome, Equals, "boom")
+}
+
+func (s *SnapTestSuite) TestRecordOpSnapFile(c *C) {
+	snapFile, err := ioutil.TempFile(c.MkDir(), "")
+	c.Assert(err, IsNil)
+	snapFile.Close()
+
+	err = RecordOp(HistoryInstall, snapFile.Name(), "cli:root", func() (string, error) {
+		return "foo", nil
+	})
+	c.Assert(err, IsNil)
+
+	entries, err := History("")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Name, Equals, "foo")
+}
+
+func (s *SnapTestSuite) TestRecordUpdates(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+
+	updates, err := RecordUpdates("cli:root", func() ([]Part, error) {
+		return []Part{&SnapPart{m: &packageYaml{Name: "foo", Version: "3.0"}, origin: testOrigin}}, nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(updates, HasLen, 1)
+
+	_, err = RecordUpdates("cli:root", func() ([]Part, error) {
+		return nil, errors.New("no network")
+	})
+	c.Assert(err, NotNil)
+
+	entries, err := History("")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Check(entries[0].OldVersion, Equals, "2.0")
+	c.Check(entries[0].NewVersion, Equals, "3.0")
+	c.Check(entries[1].Outcome, Equals, "no network")
+}
+
+func (s *SnapTestSuite) TestRecordUpdatesPartialFailure(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+
+	updates, err := RecordUpdates("cli:root", func() ([]Part, error) {
+		return []Part{&SnapPart{m: &packageYaml{Name: "foo", Version: "3.0"}, origin: testOrigin}}, errors.New("no space")
+	})
+	c.Check(err, ErrorMatches, "no space")
+	c.Check(updates, HasLen, 1)
+
+	entries, err := History("")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Check(entries[0].Name, Equals, "foo")
+	c.Check(entries[0].OldVersion, Equals, "2.0")
+	c.Check(entries[0].NewVersion, Equals, "3.0")
+	c.Check(entries[0].Outcome, Equals, historyOK)
+	c.Check(entries[1].Name, Equals, "")
+	c.Check(entries[1].Outcome, Equals, "no space")
+}
+
+func (s *SnapTestSuite) TestLastRemoval(c *C) {
+	c.Check(LastRemoval("foo", "bar", "1.0").IsZero(), Equals, true)
+
+	when := time.Date(2015, 10, 20, 12, 0, 0, 0, time.UTC)
+	c.Assert(RecordHistory(&HistoryEntry{Time: when, Op: HistoryRemove, Name: "foo", Origin: "bar", OldVersion: "1.0", Outcome: historyOK}), IsNil)
+	c.Assert(RecordHistory(&HistoryEntry{Op: HistoryRemove, Name: "foo", Origin: "bar", OldVersion: "2.0", Outcome: "boom"}), IsNil)
+
+	c.Check(LastRemoval("foo", "bar", "1.0").Equal(when), Equals, true)
+	c.Check(LastRemoval("foo", "", "1.0").Equal(when), Equals, true)
+	c.Check(LastRemoval("foo", "bar", "2.0").IsZero(), Equals, true)
+}
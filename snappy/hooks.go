@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// The lifecycle hooks a snap can ship in meta/hooks/
+const (
+	// hookInstall runs after the snap is installed and active for
+	// the first time
+	hookInstall = "install"
+	// hookPreRefresh runs on the old version before it is replaced
+	// by an update
+	hookPreRefresh = "pre-refresh"
+	// hookPostRefresh runs on the new version after an update, once
+	// the data has been copied over
+	hookPostRefresh = "post-refresh"
+	// hookRemove runs before the active version of the snap is
+	// removed
+	hookRemove = "remove"
+)
+
+var lifecycleHooks = []string{hookInstall, hookPreRefresh, hookPostRefresh, hookRemove}
+
+// lifecycleHooksProfile is the name of the apparmor profile the
+// lifecycle hooks run confined by
+const lifecycleHooksProfile = "snappy-hooks"
+
+// hasLifecycleHooks returns true if there are lifecycle hooks in the
+// given hooks directory
+func hasLifecycleHooks(hooksDir string) bool {
+	for _, hook := range lifecycleHooks {
+		if helpers.FileExists(filepath.Join(hooksDir, hook)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addLifecycleHooksIntegration sets up the apparmor profile for the
+// lifecycle hooks
+func (m *packageYaml) addLifecycleHooksIntegration() {
+	if m.Integration == nil {
+		m.Integration = make(map[string]clickAppHook)
+	}
+	m.Integration[lifecycleHooksProfile] = clickAppHook{"apparmor": "meta/" + lifecycleHooksProfile + ".apparmor"}
+}
+
+// runHook runs the given lifecycle hook of the snap, if it has it
+func (s *SnapPart) runHook(hook string, inter interacter) error {
+	hookScript := filepath.Join(s.basedir, "meta", "hooks", hook)
+	if !helpers.FileExists(hookScript) {
+		return nil
+	}
+
+	if inter != nil {
+		inter.Notify(fmt.Sprintf("Running %s hook for %s", hook, s.Name()))
+	}
+
+	appArmorProfile := fmt.Sprintf("%s_%s_%s", QualifiedName(s), lifecycleHooksProfile, s.Version())
+	output, err := runHookScript(hookScript, appArmorProfile, makeSnapHookEnv(s))
+	if err != nil {
+		if exitCode, e := helpers.ExitCode(err); e == nil {
+			return &ErrHookFailed{
+				Cmd:      hookScript,
+				Output:   string(output),
+				ExitCode: exitCode,
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+var runHookScript = runHookScriptImpl
+
+// runHookScriptImpl runs the hook script confined by the given profile
+// and returns its output
+func runHookScriptImpl(hookScript, appArmorProfile string, env []string) ([]byte, error) {
+	cmd := exec.Command(aaExec, "-p", appArmorProfile, hookScript)
+	cmd.Env = env
+
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,243 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// hookScript returns a hook that logs its name and the version it
+// runs for to the given log, and exits with the given status
+func hookScript(hook, log string, status int) string {
+	return fmt.Sprintf("#!/bin/sh\necho \"%s $SNAP_VERSION\" >> %s\nexit %d\n", hook, log, status)
+}
+
+// makeHookSnap builds version of the "foo" snap with the given hooks
+func makeHookSnap(c *C, version string, hooks map[string]string) string {
+	sourceDir := makeExampleSnapSourceDir(c, fmt.Sprintf("name: foo\nversion: %s\nvendor: Foo <foo@example.com>\n", version))
+	hooksDir := filepath.Join(sourceDir, "meta", "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+	for hook, script := range hooks {
+		c.Assert(ioutil.WriteFile(filepath.Join(hooksDir, hook), []byte(script), 0755), IsNil)
+	}
+
+	snapFile, err := BuildLegacySnap(sourceDir, c.MkDir())
+	c.Assert(err, IsNil)
+
+	return snapFile
+}
+
+func readHookLog(c *C, log string) string {
+	content, err := ioutil.ReadFile(log)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	c.Assert(err, IsNil)
+
+	return string(content)
+}
+
+func (s *SnapTestSuite) TestRunHookNoHook(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlPath, testOrigin)
+	c.Assert(err, IsNil)
+
+	runHookScript = func(string, string, []string) ([]byte, error) {
+		c.Fatalf("no hook to run")
+		return nil, nil
+	}
+
+	c.Check(part.runHook(hookInstall, nil), IsNil)
+}
+
+func (s *SnapTestSuite) TestRunHookConfined(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	hooksDir := filepath.Join(filepath.Dir(yamlPath), "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooksDir, hookInstall), nil, 0755), IsNil)
+	part, err := NewInstalledSnapPart(yamlPath, testOrigin)
+	c.Assert(err, IsNil)
+
+	var profile string
+	var env []string
+	runHookScript = func(script, p string, e []string) ([]byte, error) {
+		c.Check(script, Equals, filepath.Join(hooksDir, hookInstall))
+		profile = p
+		env = e
+		return nil, nil
+	}
+
+	c.Check(part.runHook(hookInstall, nil), IsNil)
+	c.Check(profile, Equals, "hello-app."+testOrigin+"_snappy-hooks_1.10")
+	c.Check(helpers.MakeMapFromEnvList(env)["SNAP_ORIGIN"], Equals, testOrigin)
+
+	// the hooks get their own apparmor profile
+	c.Check(part.m.Integration[lifecycleHooksProfile], DeepEquals, clickAppHook{"apparmor": "meta/snappy-hooks.apparmor"})
+}
+
+func (s *SnapTestSuite) TestRunHookFails(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	hooksDir := filepath.Join(filepath.Dir(yamlPath), "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooksDir, hookRemove), []byte("#!/bin/sh\necho nope\nexit 3\n"), 0755), IsNil)
+	part, err := NewInstalledSnapPart(yamlPath, testOrigin)
+	c.Assert(err, IsNil)
+
+	err = part.runHook(hookRemove, nil)
+	c.Assert(err, DeepEquals, &ErrHookFailed{
+		Cmd:      filepath.Join(hooksDir, hookRemove),
+		Output:   "nope\n",
+		ExitCode: 3,
+	})
+}
+
+func (s *SnapTestSuite) TestBuildLifecycleHooksAppArmor(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	snapFile := makeHookSnap(c, "1.0", map[string]string{hookInstall: hookScript(hookInstall, log, 0)})
+
+	part, err := NewSnapPartFromSnapFile(snapFile, testOrigin, true)
+	c.Assert(err, IsNil)
+	defer part.deb.Close()
+
+	_, err = part.deb.MetaMember("snappy-hooks.apparmor")
+	c.Check(err, IsNil)
+	c.Check(part.m.Integration[lifecycleHooksProfile], DeepEquals, clickAppHook{"apparmor": "meta/snappy-hooks.apparmor"})
+}
+
+func (s *SnapTestSuite) TestInstallRunsInstallHook(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	snapFile := makeHookSnap(c, "1.0", map[string]string{
+		hookInstall:     hookScript(hookInstall, log, 0),
+		hookPostRefresh: hookScript(hookPostRefresh, log, 0),
+	})
+
+	_, err := installClick(snapFile, AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+	c.Check(readHookLog(c, log), Equals, "install 1.0\n")
+}
+
+func (s *SnapTestSuite) TestInstallHookFailureRollsBack(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	snapFile := makeHookSnap(c, "1.0", map[string]string{hookInstall: hookScript(hookInstall, log, 1)})
+
+	_, err := installClick(snapFile, AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, FitsTypeOf, &ErrHookFailed{})
+	c.Check(readHookLog(c, log), Equals, "install 1.0\n")
+
+	c.Check(ActiveSnapByName("foo"), IsNil)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "foo."+testOrigin, "1.0")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapDataDir, "foo."+testOrigin, "1.0")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestInhibitHooksSkipsLifecycleHooks(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	snapFile := makeHookSnap(c, "1.0", map[string]string{hookInstall: hookScript(hookInstall, log, 1)})
+
+	_, err := installClick(snapFile, AllowUnauthenticated|InhibitHooks, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+	c.Check(readHookLog(c, log), Equals, "")
+}
+
+func (s *SnapTestSuite) TestUpdateRunsRefreshHooks(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	hooks := map[string]string{
+		hookInstall:     hookScript(hookInstall, log, 0),
+		hookPreRefresh:  hookScript(hookPreRefresh, log, 0),
+		hookPostRefresh: hookScript(hookPostRefresh, log, 0),
+	}
+
+	_, err := installClick(makeHookSnap(c, "1.0", hooks), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+	_, err = installClick(makeHookSnap(c, "2.0", hooks), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+
+	c.Check(readHookLog(c, log), Equals, "install 1.0\npre-refresh 1.0\npost-refresh 2.0\n")
+	c.Check(ActiveSnapByName("foo").Version(), Equals, "2.0")
+}
+
+func (s *SnapTestSuite) TestPreRefreshFailureAbortsUpdate(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	_, err := installClick(makeHookSnap(c, "1.0", map[string]string{hookPreRefresh: hookScript(hookPreRefresh, log, 1)}), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+
+	_, err = installClick(makeHookSnap(c, "2.0", nil), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, FitsTypeOf, &ErrHookFailed{})
+
+	c.Check(ActiveSnapByName("foo").Version(), Equals, "1.0")
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "foo."+testOrigin, "2.0")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestPostRefreshFailureRollsBack(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	_, err := installClick(makeHookSnap(c, "1.0", nil), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+
+	_, err = installClick(makeHookSnap(c, "2.0", map[string]string{hookPostRefresh: hookScript(hookPostRefresh, log, 1)}), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, FitsTypeOf, &ErrHookFailed{})
+	c.Check(readHookLog(c, log), Equals, "post-refresh 2.0\n")
+
+	c.Check(ActiveSnapByName("foo").Version(), Equals, "1.0")
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "foo."+testOrigin, "2.0")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapDataDir, "foo."+testOrigin, "2.0")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRemoveRunsRemoveHook(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	_, err := installClick(makeHookSnap(c, "1.0", map[string]string{hookRemove: hookScript(hookRemove, log, 0)}), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+
+	c.Assert(Remove("foo", 0, &MockProgressMeter{}), IsNil)
+	c.Check(readHookLog(c, log), Equals, "remove 1.0\n")
+	c.Check(ActiveSnapByName("foo"), IsNil)
+}
+
+func (s *SnapTestSuite) TestRemoveHookFailureAbortsRemove(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	_, err := installClick(makeHookSnap(c, "1.0", map[string]string{hookRemove: hookScript(hookRemove, log, 1)}), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+
+	err = Remove("foo", 0, &MockProgressMeter{})
+	c.Assert(err, FitsTypeOf, &ErrHookFailed{})
+	c.Check(ActiveSnapByName("foo"), NotNil)
+}
+
+func (s *SnapTestSuite) TestGarbageCollectDoesNotRunRemoveHook(c *C) {
+	log := filepath.Join(s.tempdir, "hooks.log")
+	hooks := map[string]string{hookRemove: hookScript(hookRemove, log, 0)}
+	for _, version := range []string{"1.0", "2.0", "3.0"} {
+		_, err := installClick(makeHookSnap(c, version, hooks), AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(GarbageCollect("foo", DoInstallGC, &MockProgressMeter{}), IsNil)
+
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "foo."+testOrigin, "1.0")), Equals, false)
+	c.Check(readHookLog(c, log), Equals, "")
+}
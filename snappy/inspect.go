@@ -0,0 +1,174 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/clickdeb"
+	"github.com/ubuntu-core/snappy/pkg/snapfs"
+)
+
+// SignatureVerified is the Signature of an Inspection of a snap whose
+// signature verified
+const SignatureVerified = "verified"
+
+// InspectFile is a file of a snap, as in its hashes
+type InspectFile struct {
+	Name string `json:"name"`
+	// Mode is like "frwxr-xr-x", the first letter is the type
+	Mode string `json:"mode"`
+	// Size is only there for regular files
+	Size   *int64 `json:"size,omitempty"`
+	Device string `json:"device,omitempty"`
+}
+
+// Inspection is what Inspect finds out about a snap file
+type Inspection struct {
+	Name          string        `json:"name"`
+	Version       string        `json:"version"`
+	Vendor        string        `json:"vendor,omitempty"`
+	Type          pkg.Type      `json:"type"`
+	Architectures []string      `json:"architectures"`
+	Frameworks    []string      `json:"frameworks,omitempty"`
+	Binaries      []Binary      `json:"binaries,omitempty"`
+	Services      []ServiceYaml `json:"services,omitempty"`
+
+	// Files is empty for the snaps without hashes, e.g. snapfs snaps
+	Files []InspectFile `json:"files,omitempty"`
+
+	// Signature is SignatureVerified, or why the signature does
+	// not verify
+	Signature string `json:"signature"`
+
+	// DownloadSize is the size of the snap file and InstalledSize
+	// the estimated size of the snap once installed, in bytes
+	DownloadSize  int64 `json:"download-size"`
+	InstalledSize int64 `json:"installed-size"`
+}
+
+// Inspect looks into the given snap file, without installing it
+func Inspect(snapFile string) (*Inspection, error) {
+	st, err := os.Stat(snapFile)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := OpenPackageFile(snapFile)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	yamlData, err := d.MetaMember("package.yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.MetaMember("hooks/config")
+	hasConfig := err == nil
+
+	m, err := parsePackageYamlData(yamlData, hasConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := inspectFiles(d)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &Inspection{
+		Name:          m.Name,
+		Version:       m.Version,
+		Vendor:        m.Vendor,
+		Type:          m.Type,
+		Architectures: m.Architectures,
+		Frameworks:    m.Frameworks,
+		Binaries:      m.Binaries,
+		Services:      m.ServiceYamls,
+		Files:         files,
+		Signature:     SignatureVerified,
+		DownloadSize:  st.Size(),
+	}
+
+	// if not declared its a app
+	if in.Type == "" {
+		in.Type = pkg.TypeApp
+	}
+
+	if err := d.Verify(false); err != nil {
+		in.Signature = err.Error()
+	}
+
+	// snaps that are mounted take up no more than the snap file,
+	// the others about the size of their files
+	if d.NeedsMountUnit() {
+		in.InstalledSize = in.DownloadSize
+	} else {
+		for _, f := range files {
+			if f.Size != nil {
+				in.InstalledSize += *f.Size
+			}
+		}
+	}
+
+	return in, nil
+}
+
+// inspectFiles returns the files in the hashes of the snap, if it has any
+func inspectFiles(d PackageFile) ([]InspectFile, error) {
+	hashesData, err := d.ControlMember("hashes.yaml")
+	if _, ok := err.(snapfs.ErrNotInSnap); ok || err == clickdeb.ErrMemberNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes hashesYaml
+	if err := yaml.Unmarshal(hashesData, &hashes); err != nil {
+		return nil, &ErrInvalidYaml{File: "hashes.yaml", Err: err, Yaml: hashesData}
+	}
+
+	files := make([]InspectFile, 0, len(hashes.Files))
+	for _, h := range hashes.Files {
+		if h.Mode == nil {
+			return nil, fmt.Errorf("no mode for %s in hashes.yaml", h.Name)
+		}
+		mode, err := h.Mode.MarshalYAML()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, InspectFile{
+			Name:   h.Name,
+			Mode:   mode.(string),
+			Size:   h.Size,
+			Device: h.Device,
+		})
+	}
+
+	return files, nil
+}
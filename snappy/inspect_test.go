@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"os"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/clickdeb"
+)
+
+func (s *SnapTestSuite) TestInspect(c *C) {
+	snapFile, err := BuildLegacySnap(makeExampleSnapSourceDir(c, `name: hello
+version: 1.0
+vendor: Foo <foo@example.com>
+frameworks: [foo]
+binaries:
+ - name: hello
+   exec: bin/hello-world
+   caps: [network-client]
+services:
+ - name: svc
+   start: bin/hello-world
+`), c.MkDir())
+	c.Assert(err, IsNil)
+
+	in, err := Inspect(snapFile)
+	c.Assert(err, IsNil)
+	c.Check(in.Name, Equals, "hello")
+	c.Check(in.Version, Equals, "1.0")
+	c.Check(in.Vendor, Equals, "Foo <foo@example.com>")
+	c.Check(in.Type, Equals, pkg.TypeApp)
+	c.Check(in.Architectures, DeepEquals, []string{"all"})
+	c.Check(in.Frameworks, DeepEquals, []string{"foo"})
+	c.Assert(in.Binaries, HasLen, 1)
+	c.Check(in.Binaries[0].SecurityCaps, DeepEquals, []string{"network-client"})
+	c.Assert(in.Services, HasLen, 1)
+	c.Check(in.Services[0].Start, Equals, "bin/hello-world")
+	c.Check(in.Signature, Equals, SignatureVerified)
+
+	st, err := os.Stat(snapFile)
+	c.Assert(err, IsNil)
+	c.Check(in.DownloadSize, Equals, st.Size())
+
+	var installedSize int64
+	var bin *InspectFile
+	for i, f := range in.Files {
+		if f.Size != nil {
+			installedSize += *f.Size
+		}
+		if f.Name == "bin/hello-world" {
+			bin = &in.Files[i]
+		}
+	}
+	c.Assert(bin, NotNil)
+	c.Check(bin.Mode, Equals, "frwxr-xr-x")
+	c.Check(*bin.Size, Equals, int64(len("#!/bin/sh\nprintf \"hello world\"\n")))
+	c.Check(in.InstalledSize, Equals, installedSize)
+}
+
+func (s *SnapTestSuite) TestInspectSignatureFails(c *C) {
+	clickdeb.Verify = func(string, bool) error {
+		return errors.New("no signature")
+	}
+
+	snapFile, err := BuildLegacySnap(makeExampleSnapSourceDir(c, "name: hello\nversion: 1.0\nvendor: Foo <foo@example.com>\n"), c.MkDir())
+	c.Assert(err, IsNil)
+
+	in, err := Inspect(snapFile)
+	c.Assert(err, IsNil)
+	c.Check(in.Signature, Equals, "no signature")
+}
+
+func (s *SnapTestSuite) TestInspectNoSnap(c *C) {
+	_, err := Inspect("no-such-file.snap")
+	c.Check(os.IsNotExist(err), Equals, true)
+}
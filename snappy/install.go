@@ -27,6 +27,7 @@ import (
 
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/partition"
+	"github.com/ubuntu-core/snappy/pkg"
 	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/provisioning"
 )
@@ -47,14 +48,15 @@ const (
 )
 
 // Update the installed snappy packages, it returns the updated Parts
-// if updates where available and an error and nil if any of the updates
-// fail to apply.
+// if updates where available. If any of the updates fail to apply it
+// returns the Parts updated until then and the error.
 func Update(flags InstallFlags, meter progress.Meter) ([]Part, error) {
 	updates, err := ListUpdates()
 	if err != nil {
 		return nil, err
 	}
 
+	var updated []Part
 	for _, part := range updates {
 		meter.Notify(fmt.Sprintf("Updating %s (%s)", part.Name(), part.Version()))
 
@@ -62,20 +64,29 @@ func Update(flags InstallFlags, meter progress.Meter) ([]Part, error) {
 			logger.Noticef("Skipping sideloaded package: %s", part.Name())
 			continue
 		} else if err != nil {
-			return nil, err
+			return updated, err
 		}
+		updated = append(updated, part)
+
 		if err := GarbageCollect(part.Name(), flags, meter); err != nil {
-			return nil, err
+			return updated, err
 		}
 	}
 
-	return updates, nil
+	return updated, nil
 }
 
 // Install the givens snap names provided via args. This can be local
 // files or snaps that are queried from the store
 func Install(name string, flags InstallFlags, meter progress.Meter) (string, error) {
-	name, err := doInstall(name, flags, meter)
+	return InstallFromChannel(name, "", flags, meter)
+}
+
+// InstallFromChannel installs the given snap like Install, from the
+// given channel of the store, which the snap tracks from then on. The
+// default channel is used if it is "".
+func InstallFromChannel(name, channel string, flags InstallFlags, meter progress.Meter) (string, error) {
+	name, err := doInstall(name, channel, flags, meter)
 	if err != nil {
 		return "", err
 	}
@@ -83,7 +94,7 @@ func Install(name string, flags InstallFlags, meter progress.Meter) (string, err
 	return name, GarbageCollect(name, flags, meter)
 }
 
-func doInstall(name string, flags InstallFlags, meter progress.Meter) (snapName string, err error) {
+func doInstall(name, channel string, flags InstallFlags, meter progress.Meter) (snapName string, err error) {
 	defer func() {
 		if err != nil {
 			err = &ErrInstallFailed{Snap: name, OrigErr: err}
@@ -91,7 +102,9 @@ func doInstall(name string, flags InstallFlags, meter progress.Meter) (snapName
 	}()
 
 	// consume local parts
-	if fi, err := os.Stat(name); err == nil && fi.Mode().IsRegular() {
+	if fi, err := snapFileInfo(name); err != nil {
+		return "", err
+	} else if fi != nil {
 		// we allow unauthenticated package when in developer
 		// mode
 		//
@@ -102,24 +115,35 @@ func doInstall(name string, flags InstallFlags, meter progress.Meter) (snapName
 			flags |= AllowUnauthenticated
 		}
 
+		if channel != "" {
+			return "", fmt.Errorf("can not install a snap file from a channel")
+		}
+
 		return installClick(name, flags, meter, SideloadedOrigin)
 	}
 
+	if channel != "" && !validChannel.MatchString(channel) {
+		return "", ErrInvalidChannel(channel)
+	}
+
 	// check repos next
-	mStore := NewMetaStoreRepository()
 	installed, err := NewMetaLocalRepository().Installed()
 	if err != nil {
 		return "", err
 	}
 
-	origin := ""
-	idx := strings.IndexRune(name, '.')
-	if idx > -1 {
-		origin = name[idx+1:]
-		name = name[:idx]
+	name, origin, version := splitInstallName(name)
+	if version != "" {
+		needle := name
+		if origin != "" {
+			needle += "." + origin
+		}
+		if len(FindSnapsByNameAndVersion(needle, version, installed)) > 0 {
+			return "", ErrAlreadyInstalled
+		}
 	}
 
-	found, err := mStore.Details(name, origin)
+	found, err := findStoreParts(name, origin, version, channel)
 	if err != nil {
 		return "", err
 	}
@@ -129,24 +153,210 @@ func doInstall(name string, flags InstallFlags, meter progress.Meter) (snapName
 		if len(cur) != 0 {
 			return "", ErrAlreadyInstalled
 		}
-		if PackageNameActive(part.Name()) {
+		// another version of the same package can be asked for
+		if active := activeSnapFor(part); active != nil && (version == "" || QualifiedName(active) != QualifiedName(part)) {
 			return "", ErrPackageNameAlreadyInstalled
 		}
 
 		// TODO block oem snaps here once the store supports package types
 
-		return part.Install(meter, flags)
+		n, err := part.Install(meter, flags)
+		if err != nil {
+			return "", err
+		}
+
+		// without a channel asked for, keep tracking the one
+		// tracked so far
+		if channel == "" {
+			return n, nil
+		}
+
+		return n, setTrackedChannel(QualifiedName(part), channel)
 	}
 
 	return "", ErrPackageNotFound
 }
 
+// snapFileInfo returns the FileInfo of name if it names a snap file,
+// that is if it has a path separator or ends in ".snap", or nil if it
+// names a package, so that a file named like a package in the current
+// directory does not change what gets installed
+func snapFileInfo(name string) (os.FileInfo, error) {
+	if !strings.ContainsRune(name, os.PathSeparator) && !strings.HasSuffix(name, ".snap") {
+		return nil, nil
+	}
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a snap file", name)
+	}
+
+	return fi, nil
+}
+
+// splitInstallName splits the name[.origin][=version] of a snap to
+// install
+func splitInstallName(spec string) (name, origin, version string) {
+	name = spec
+	if idx := strings.IndexRune(name, '='); idx > -1 {
+		version = name[idx+1:]
+		name = name[:idx]
+	}
+
+	if idx := strings.IndexRune(name, '.'); idx > -1 {
+		origin = name[idx+1:]
+		name = name[:idx]
+	}
+
+	return name, origin, version
+}
+
+// findStoreParts finds the snap to install in the store: from the given
+// channel, or the default one if it is "", and in the given version if
+// it is not ""
+func findStoreParts(name, origin, version, channel string) ([]Part, error) {
+	var found []Part
+	var err error
+	if channel == "" {
+		found, err = NewMetaStoreRepository().Details(name, origin)
+	} else {
+		found, err = storeDetailsFromChannel(name, origin, channel)
+	}
+	if version != "" && (err == nil || err == ErrPackageNotFound) {
+		found, err = findStoreVersion(found, name, origin, version)
+	}
+
+	return found, err
+}
+
+// findStoreVersion returns the given version of the snap: from the
+// given parts if it is there, or else from the revisions the store has
+// of it
+func findStoreVersion(found []Part, name, origin, version string) ([]Part, error) {
+	for _, part := range found {
+		if part.Version() == version {
+			return []Part{part}, nil
+		}
+	}
+
+	store := NewUbuntuStoreSnapRepository()
+	if store == nil {
+		return nil, ErrPackageNotFound
+	}
+
+	revisions, err := store.Revisions(name, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range revisions {
+		if part.Version() == version {
+			return []Part{part}, nil
+		}
+	}
+
+	return nil, &ErrVersionNotFound{Snap: name, Version: version}
+}
+
+// storeDetailsFromChannel returns the details of the given snap in the
+// given channel of the store
+func storeDetailsFromChannel(name, origin, channel string) ([]Part, error) {
+	store := NewUbuntuStoreSnapRepository()
+	if store == nil {
+		return nil, ErrPackageNotFound
+	}
+
+	return store.DetailsFromChannel(name, origin, channel)
+}
+
+// installMissingFrameworks installs the frameworks needed by the given
+// snap that are not installed yet, and returns the names of those that
+// it installed so they can be rolled back if the install of the snap
+// fails.
+func installMissingFrameworks(m *packageYaml, flags InstallFlags, meter progress.Meter) (installed []string, err error) {
+	missing, err := m.missingFrameworks()
+	if err != nil || len(missing) == 0 {
+		return nil, err
+	}
+
+	// look all of them up before installing anything
+	var toInstall []Part
+	var notFound []string
+	for _, name := range missing {
+		part, err := findFramework(name)
+		if err != nil {
+			logger.Noticef("Unable to find framework %s: %v", name, err)
+			notFound = append(notFound, name)
+			continue
+		}
+		toInstall = append(toInstall, part)
+	}
+	if len(notFound) > 0 {
+		return nil, ErrMissingFrameworks(notFound)
+	}
+
+	defer func() {
+		if err != nil {
+			rollbackFrameworks(installed, meter)
+			installed = nil
+		}
+	}()
+
+	for _, part := range toInstall {
+		if meter != nil {
+			meter.Notify(fmt.Sprintf("Installing required framework %s (%s)", part.Name(), part.Version()))
+		}
+
+		if _, err := part.Install(meter, flags&^AllowOEM); err != nil {
+			return installed, &ErrInstallFailed{Snap: part.Name(), OrigErr: err}
+		}
+		installed = append(installed, part.Name())
+	}
+
+	return installed, nil
+}
+
+// findFramework returns the framework with the given name from the
+// configured repositories
+var findFramework = findFrameworkImpl
+
+func findFrameworkImpl(name string) (Part, error) {
+	found, err := NewMetaStoreRepository().Details(name, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range found {
+		if part.Type() == pkg.TypeFramework {
+			return part, nil
+		}
+	}
+
+	return nil, ErrPackageNotFound
+}
+
+// rollbackFrameworks removes the given frameworks, in reverse order, as
+// part of undoing a failed install
+func rollbackFrameworks(names []string, meter progress.Meter) {
+	for i := len(names) - 1; i >= 0; i-- {
+		part := ActiveSnapByName(names[i])
+		if part == nil {
+			continue
+		}
+
+		if err := part.Uninstall(meter); err != nil {
+			logger.Noticef("Failed to roll back framework %s: %v", names[i], err)
+		}
+	}
+}
+
 // GarbageCollect removes all versions two older than the current active
 // version, as long as NeedsReboot() is false on all the versions found, and
 // DoInstallGC is set.
 func GarbageCollect(name string, flags InstallFlags, pb progress.Meter) error {
-	var parts BySnapVersion
-
 	if (flags & DoInstallGC) == 0 {
 		return nil
 	}
@@ -157,7 +367,25 @@ func GarbageCollect(name string, flags InstallFlags, pb progress.Meter) error {
 		return err
 	}
 
-	parts = FindSnapsByName(name, installed)
+	// the same name can be installed from more than one origin, and
+	// each has its own versions
+	byOrigin := make(map[string]BySnapVersion)
+	for _, part := range FindSnapsByName(name, installed) {
+		byOrigin[part.Origin()] = append(byOrigin[part.Origin()], part)
+	}
+
+	for _, parts := range byOrigin {
+		if err := garbageCollectVersions(parts, pb); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// garbageCollectVersions does the garbage collection for the versions
+// of a single package
+func garbageCollectVersions(parts BySnapVersion, pb progress.Meter) error {
 	if len(parts) < 3 {
 		// not enough things installed to do gc
 		return nil
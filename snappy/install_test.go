@@ -32,7 +32,10 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/partition"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/remote"
 	"github.com/ubuntu-core/snappy/progress"
 )
 
@@ -271,3 +274,199 @@ func (s *SnapTestSuite) TestUpdate(c *C) {
 	c.Check(updates[0].Name(), Equals, "foo")
 	c.Check(updates[0].Version(), Equals, "2")
 }
+
+// fakeFrameworkPart is a framework whose install just puts a mock
+// framework in place
+type fakeFrameworkPart struct {
+	RemoteSnapPart
+	tempdir string
+	fails   bool
+}
+
+func (p *fakeFrameworkPart) Install(progress.Meter, InstallFlags) (string, error) {
+	if p.fails {
+		return "", ErrNotImplemented
+	}
+
+	yamlPath, err := makeInstalledMockSnap(p.tempdir, fmt.Sprintf("name: %s\nversion: 1.0\nvendor: foo\ntype: framework", p.Name()))
+	if err != nil {
+		return "", err
+	}
+
+	return p.Name(), makeSnapActive(yamlPath)
+}
+
+func (s *SnapTestSuite) mockFindFramework(fails ...string) {
+	findFramework = func(name string) (Part, error) {
+		if name == "not-there" {
+			return nil, ErrPackageNotFound
+		}
+
+		part := &fakeFrameworkPart{
+			RemoteSnapPart: RemoteSnapPart{pkg: remote.Snap{Name: name, Version: "1.0", Type: pkg.TypeFramework}},
+			tempdir:        s.tempdir,
+		}
+		for _, f := range fails {
+			if f == name {
+				part.fails = true
+			}
+		}
+
+		return part, nil
+	}
+}
+
+func (s *SnapTestSuite) TestInstallMissingFrameworks(c *C) {
+	s.mockFindFramework()
+	m, err := parsePackageYamlData([]byte("name: foo\nversion: 1.0\nvendor: foo\nframeworks:\n - fmk1\n - fmk2\n"), false)
+	c.Assert(err, IsNil)
+
+	meter := &MockProgressMeter{}
+	installed, err := installMissingFrameworks(m, 0, meter)
+	c.Assert(err, IsNil)
+	c.Check(installed, DeepEquals, []string{"fmk1", "fmk2"})
+	c.Check(meter.notified, HasLen, 2)
+	c.Check(m.checkForFrameworks(), IsNil)
+
+	// nothing left to do
+	installed, err = installMissingFrameworks(m, 0, meter)
+	c.Assert(err, IsNil)
+	c.Check(installed, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestInstallMissingFrameworksNotFound(c *C) {
+	s.mockFindFramework()
+	m, err := parsePackageYamlData([]byte("name: foo\nversion: 1.0\nvendor: foo\nframeworks:\n - fmk1\n - not-there\n"), false)
+	c.Assert(err, IsNil)
+
+	_, err = installMissingFrameworks(m, 0, nil)
+	c.Assert(err, DeepEquals, ErrMissingFrameworks{"not-there"})
+
+	// nothing was installed
+	c.Check(ActiveSnapByName("fmk1"), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallMissingFrameworksRollsBack(c *C) {
+	s.mockFindFramework("fmk2")
+	m, err := parsePackageYamlData([]byte("name: foo\nversion: 1.0\nvendor: foo\nframeworks:\n - fmk1\n - fmk2\n"), false)
+	c.Assert(err, IsNil)
+
+	_, err = installMissingFrameworks(m, 0, &MockProgressMeter{})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).Snap, Equals, "fmk2")
+
+	c.Check(ActiveSnapByName("fmk1"), IsNil)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "fmk1")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestInstallClickInstallsFrameworks(c *C) {
+	s.mockFindFramework()
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: 1.0\nvendor: foo\nframeworks:\n - fmk1\n")
+
+	name, err := installClick(snapFile, AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "foo")
+	c.Check(ActiveSnapByName("fmk1"), NotNil)
+}
+
+func (s *SnapTestSuite) TestInstallClickFrameworkLocked(c *C) {
+	s.mockFindFramework()
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: 1.0\nvendor: foo\nframeworks:\n - fmk1\n")
+
+	lock, err := LockPackages("remove fmk1", "fmk1")
+	c.Assert(err, IsNil)
+	defer lock.Unlock()
+
+	_, err = installClick(snapFile, AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, FitsTypeOf, &ErrPrivOpInProgress{})
+	c.Check(ActiveSnapByName("fmk1"), IsNil)
+	c.Check(ActiveSnapByName("foo"), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallClickChecksBeforeFrameworks(c *C) {
+	findFramework = func(name string) (Part, error) {
+		c.Fatalf("looked for %s for a snap that can not be installed", name)
+		return nil, nil
+	}
+	snapFile := makeTestSnapPackage(c, `name: foo
+version: 1.0
+vendor: foo
+frameworks:
+ - fmk1
+architectures:
+ - potato
+`)
+
+	_, err := installClick(snapFile, AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, FitsTypeOf, &ErrArchitectureNotSupported{})
+	c.Check(ActiveSnapByName("fmk1"), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallClickRollsBackFrameworks(c *C) {
+	s.mockFindFramework()
+	sourceDir := makeExampleSnapSourceDir(c, "name: foo\nversion: 1.0\nvendor: foo\nframeworks:\n - fmk1\n")
+	hooksDir := filepath.Join(sourceDir, "meta", "hooks")
+	c.Assert(os.MkdirAll(hooksDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooksDir, hookInstall), nil, 0755), IsNil)
+	snapFile, err := BuildLegacySnap(sourceDir, c.MkDir())
+	c.Assert(err, IsNil)
+
+	// the snap fails to install after its framework got installed
+	runHookScript = func(string, string, []string) ([]byte, error) {
+		c.Check(ActiveSnapByName("fmk1"), NotNil)
+		return nil, ErrNotImplemented
+	}
+
+	_, err = installClick(snapFile, AllowUnauthenticated, &MockProgressMeter{}, testOrigin)
+	c.Assert(err, NotNil)
+	c.Check(ActiveSnapByName("fmk1"), IsNil)
+	c.Check(ActiveSnapByName("foo"), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallVersion(c *C) {
+	snapPackage := makeTestSnapPackage(c, "name: foo\nversion: 1\nvendor: foo")
+	snapR, err := os.Open(snapPackage)
+	c.Assert(err, IsNil)
+	defer snapR.Close()
+
+	var dlURL, iconURL string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/details/foo":
+			io.WriteString(w, `{"package_name": "foo", "version": "2", "origin": "test"}`)
+		case "/details/foo/revisions":
+			io.WriteString(w, `[
+{"package_name": "foo", "version": "2", "origin": "test"},
+{"package_name": "foo", "version": "1", "origin": "test", "anon_download_url": "`+dlURL+`", "icon_url": "`+iconURL+`"}
+]`)
+		case "/dl":
+			snapR.Seek(0, 0)
+			io.Copy(w, snapR)
+		case "/icon":
+			fmt.Fprintf(w, "")
+		default:
+			panic("unexpected url path: " + r.URL.Path)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	dlURL = mockServer.URL + "/dl"
+	iconURL = mockServer.URL + "/icon"
+
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	_, err = Install("foo=3", 0, &progress.NullProgress{})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).OrigErr, DeepEquals, &ErrVersionNotFound{Snap: "foo", Version: "3"})
+
+	name, err := Install("foo=1", 0, &progress.NullProgress{})
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "foo")
+	c.Check(ActiveSnapByName("foo").Version(), Equals, "1")
+
+	_, err = Install("foo.test=1", 0, &progress.NullProgress{})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).OrigErr, Equals, ErrAlreadyInstalled)
+}
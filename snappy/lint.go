@@ -0,0 +1,401 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg/clickdeb"
+	"github.com/ubuntu-core/snappy/pkg/snapfs"
+)
+
+// LintSeverity is how bad a problem found by Lint is
+type LintSeverity string
+
+const (
+	// LintError is a problem that makes the snap fail to build or
+	// to install, or to work as intended
+	LintError LintSeverity = "error"
+	// LintWarning is a problem the snap should fix
+	LintWarning LintSeverity = "warning"
+	// LintInfo is a hint, e.g. about a deprecated field
+	LintInfo LintSeverity = "info"
+)
+
+// LintIssue is a problem found by Lint
+type LintIssue struct {
+	// Check is the name of the check that found the problem,
+	// e.g. "readme"
+	Check    string       `json:"check"`
+	Severity LintSeverity `json:"severity"`
+	// File is the file of the snap the problem is in, if any
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+// the architectures a snap can be built for
+var lintArchitectures = []string{"all", string(Archi386), ArchAmd64, ArchArmhf, "arm64", "ppc64el"}
+
+// the easyprof dir with the apparmor templates and policy groups the
+// security definitions of the snap are checked against, if it exists
+var lintEasyprofDir = "/usr/share/apparmor/easyprof"
+
+type linter struct {
+	dir    string
+	m      *packageYaml
+	issues []LintIssue
+}
+
+// Lint checks the snap source dir or the snap file at path, and
+// returns the problems it found. It returns an error only if the
+// snap could not be checked at all.
+func Lint(path string) ([]LintIssue, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := path
+	if !info.IsDir() {
+		dir, err = ioutil.TempDir("", "snappy-lint-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		if err := unpackSnapFile(path, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &linter{dir: dir}
+	l.lint()
+
+	return l.issues, nil
+}
+
+func unpackSnapFile(snapFile, targetDir string) error {
+	d, err := OpenPackageFile(snapFile)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	switch d := d.(type) {
+	case *clickdeb.ClickDeb:
+		return d.Unpack(targetDir)
+	case *snapfs.Snap:
+		return d.Unpack("*", targetDir)
+	}
+
+	return fmt.Errorf("can not unpack %s", snapFile)
+}
+
+func (l *linter) add(check string, severity LintSeverity, file string, format string, args ...interface{}) {
+	l.issues = append(l.issues, LintIssue{
+		Check:    check,
+		Severity: severity,
+		File:     file,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// addErr adds the error of one of the checks snappy does on build or
+// install
+func (l *linter) addErr(check string, file string, err error) {
+	// the yaml is in the snap already
+	if e, ok := err.(*ErrInvalidYaml); ok {
+		err = e.Err
+	}
+
+	l.add(check, LintError, file, "%v", err)
+}
+
+func (l *linter) exists(file string) bool {
+	return helpers.FileExists(filepath.Join(l.dir, file))
+}
+
+func (l *linter) lint() {
+	const packageYamlFile = "meta/package.yaml"
+
+	yamlData, err := ioutil.ReadFile(filepath.Join(l.dir, packageYamlFile))
+	if err != nil {
+		l.addErr("package-yaml", packageYamlFile, err)
+		return
+	}
+
+	var m packageYaml
+	if err := yaml.Unmarshal(yamlData, &m); err != nil {
+		l.add("package-yaml", LintError, packageYamlFile, "can not parse: %v", err)
+		return
+	}
+	l.m = &m
+
+	l.lintPackageYaml(packageYamlFile, yamlData)
+	l.lintArchitectures(packageYamlFile)
+	for _, binary := range m.Binaries {
+		l.lintBinary(packageYamlFile, binary)
+	}
+	for _, service := range m.ServiceYamls {
+		l.lintService(packageYamlFile, service)
+	}
+	l.lintReadme()
+	l.lintIcon()
+	l.lintLicense()
+}
+
+func (l *linter) lintPackageYaml(file string, yamlData []byte) {
+	// the binaries and services are checked one by one
+	header := *l.m
+	header.Binaries = nil
+	header.ServiceYamls = nil
+	if err := validatePackageYamlData("package.yaml", yamlData, &header); err != nil {
+		l.addErr("package-yaml", file, err)
+	}
+
+	if l.m.DeprecatedFramework != "" {
+		if len(l.m.Frameworks) != 0 {
+			l.addErr("package-yaml", file, ErrInvalidFrameworkSpecInYaml)
+		} else {
+			l.add("package-yaml", LintInfo, file, `"framework" is deprecated, use "frameworks"`)
+		}
+	}
+
+	if err := l.m.checkForNameClashes(); err != nil {
+		l.addErr("package-yaml", file, err)
+	}
+}
+
+func (l *linter) lintArchitectures(file string) {
+	archs := l.m.Architectures
+	if archs == nil && l.m.DeprecatedArchitecture != nil {
+		l.add("architecture", LintInfo, file, `"architecture" is deprecated, use "architectures"`)
+		archs = l.m.DeprecatedArchitecture
+	}
+
+	for _, arch := range archs {
+		switch {
+		case !hasArch(lintArchitectures, arch):
+			l.add("architecture", LintWarning, file, "unknown architecture %q", arch)
+		case arch == "all" && len(archs) > 1:
+			l.add("architecture", LintWarning, file, `"all" together with other architectures`)
+		}
+	}
+
+	// see parsePackageYamlData
+	if archs == nil {
+		archs = []string{"all"}
+	}
+	if err := checkArchSubtrees(l.dir, &packageYaml{Architectures: archs}); err != nil {
+		l.addErr("architecture", archSubtreesDir, err)
+	}
+}
+
+func (l *linter) lintBinary(file string, binary Binary) {
+	if err := verifyBinariesYaml(binary); err != nil {
+		l.addErr("binaries", file, err)
+	}
+
+	// see parsePackageYamlData
+	exec := binary.Exec
+	if exec == "" {
+		exec = binary.Name
+	}
+	// in a multi-architecture snap the binary can be in each of
+	// the subtrees instead
+	paths := []string{exec}
+	if !l.exists(exec) {
+		if subtrees, _ := archSubtrees(l.dir); len(subtrees) > 0 {
+			paths = nil
+			for _, arch := range subtrees {
+				paths = append(paths, filepath.Join(archSubtreesDir, arch, exec))
+			}
+		}
+	}
+	for _, path := range paths {
+		if info, err := os.Stat(filepath.Join(l.dir, path)); err != nil {
+			l.add("binaries", LintError, path, "binary %q does not exist", binary.Name)
+		} else if info.Mode()&0111 == 0 {
+			l.add("binaries", LintWarning, path, "binary %q is not executable", binary.Name)
+		}
+	}
+
+	l.lintSecurity(file, filepath.Base(binary.Name), &binary.SecurityDefinitions)
+}
+
+func (l *linter) lintService(file string, service ServiceYaml) {
+	if err := verifyServiceYaml(service); err != nil {
+		l.addErr("services", file, err)
+	}
+	if err := verifyHealthCheck(service); err != nil {
+		l.addErr("services", file, err)
+	}
+	if service.BusName != "" {
+		if err := verifyBusName(service.BusName); err != nil {
+			l.addErr("services", file, err)
+		}
+	}
+
+	l.lintSecurity(file, service.Name, &service.SecurityDefinitions)
+}
+
+func (l *linter) lintSecurity(file, app string, sd *SecurityDefinitions) {
+	hasCapsOrTemplate := sd.SecurityCaps != nil || sd.SecurityTemplate != ""
+	if sd.SecurityPolicy != nil && (hasCapsOrTemplate || sd.SecurityOverride != nil) {
+		l.add("security", LintError, file, "%s: security-policy can not be used with caps, security-template or security-override", app)
+	}
+	if sd.SecurityOverride != nil && hasCapsOrTemplate {
+		l.add("security", LintError, file, "%s: security-override can not be used with caps or security-template", app)
+	}
+
+	if o := sd.SecurityOverride; o != nil {
+		if o.Apparmor != "" {
+			l.lintApparmorOverride(app, o.Apparmor)
+		}
+		if o.Seccomp == "" {
+			l.addErr("security", file, fmt.Errorf("%s: %v", app, ErrNoSeccompPolicy))
+		} else {
+			var s securitySeccompOverride
+			if err := readSeccompOverride(filepath.Join(l.dir, o.Seccomp), &s); err != nil {
+				l.addErr("security", o.Seccomp, err)
+			}
+		}
+	}
+
+	if p := sd.SecurityPolicy; p != nil {
+		for _, policy := range []string{p.Apparmor, p.Seccomp} {
+			if policy != "" && !l.exists(policy) {
+				l.add("security", LintError, policy, "%s: security-policy %q does not exist", app, policy)
+			}
+		}
+		l.add("security", LintInfo, file, "%s: security-policy always needs a manual review in the store", app)
+	}
+
+	if sd.SecurityTemplate != "" {
+		templates := easyprofDir("templates")
+		if helpers.IsDirectory(templates) && !helpers.FileExists(filepath.Join(templates, sd.SecurityTemplate)) {
+			l.add("security", LintWarning, file, "%s: unknown security-template %q", app, sd.SecurityTemplate)
+		}
+	}
+
+	policyGroups := easyprofDir("policygroups")
+	seen := make(map[string]bool)
+	for _, capName := range sd.SecurityCaps {
+		if seen[capName] {
+			l.add("security", LintWarning, file, "%s: cap %q is given more than once", app, capName)
+			continue
+		}
+		seen[capName] = true
+
+		// caps of frameworks are named <framework>_<cap>
+		if i := strings.Index(capName, "_"); i > 0 {
+			if !l.hasFramework(capName[:i]) {
+				l.add("security", LintWarning, file, "%s: cap %q is from framework %q, which is not in frameworks", app, capName, capName[:i])
+			}
+			continue
+		}
+		if helpers.IsDirectory(policyGroups) && !helpers.FileExists(filepath.Join(policyGroups, capName)) {
+			l.add("security", LintWarning, file, "%s: unknown cap %q", app, capName)
+		}
+	}
+}
+
+// easyprofDir returns the dir with the templates or policygroups of
+// the default policy vendor and version
+func easyprofDir(kind string) string {
+	return filepath.Join(lintEasyprofDir, kind, defaultPolicyVendor, fmt.Sprintf("%.2f", defaultPolicyVersion))
+}
+
+func (l *linter) hasFramework(name string) bool {
+	frameworks := l.m.Frameworks
+	if l.m.DeprecatedFramework != "" {
+		frameworks = commasplitter(l.m.DeprecatedFramework, -1)
+	}
+	for _, fmk := range frameworks {
+		if fmk == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *linter) lintApparmorOverride(app, file string) {
+	yamlData, err := ioutil.ReadFile(filepath.Join(l.dir, file))
+	if err != nil {
+		l.add("security", LintError, file, "%s: can not read security-override: %v", app, err)
+		return
+	}
+
+	var override map[string]interface{}
+	if err := yaml.Unmarshal(yamlData, &override); err != nil {
+		l.add("security", LintError, file, "%s: can not parse security-override: %v", app, err)
+	}
+}
+
+func (l *linter) lintReadme() {
+	const readmeFile = "meta/readme.md"
+
+	_, description, err := parseReadme(filepath.Join(l.dir, readmeFile))
+	switch {
+	case os.IsNotExist(err):
+		l.add("readme", LintError, readmeFile, "missing")
+	case err != nil:
+		l.addErr("readme", readmeFile, err)
+	case description == "no description":
+		l.add("readme", LintWarning, readmeFile, "no description, it should follow the title after an empty line")
+	}
+}
+
+func (l *linter) lintIcon() {
+	icon := l.m.Icon
+	switch {
+	case icon == "":
+		l.add("icon", LintWarning, "", "no icon")
+	case !l.exists(icon):
+		l.add("icon", LintError, icon, "icon does not exist")
+	default:
+		switch strings.ToLower(filepath.Ext(icon)) {
+		case ".png", ".svg":
+		default:
+			l.add("icon", LintWarning, icon, "icon is not a png or svg")
+		}
+	}
+}
+
+func (l *linter) lintLicense() {
+	if !l.m.ExplicitLicenseAgreement {
+		return
+	}
+
+	if err := checkLicenseExists(l.dir); err != nil {
+		if os.IsNotExist(err) {
+			err = ErrLicenseNotProvided
+		}
+		l.addErr("license", "meta/license.txt", err)
+	}
+}
@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+const lintPackageYaml = `name: hello
+version: 1.0
+vendor: Foo <foo@example.com>
+icon: meta/hello.svg
+architectures: [amd64, armhf]
+binaries:
+ - name: hello
+   exec: bin/hello-world
+`
+
+func makeLintSourceDir(c *C, packageYaml string) string {
+	sourceDir := makeExampleSnapSourceDir(c, packageYaml)
+	err := ioutil.WriteFile(filepath.Join(sourceDir, "meta", "hello.svg"), []byte("<svg/>"), 0644)
+	c.Assert(err, IsNil)
+
+	return sourceDir
+}
+
+func (s *SnapTestSuite) TestLintClean(c *C) {
+	issues, err := Lint(makeLintSourceDir(c, lintPackageYaml))
+	c.Assert(err, IsNil)
+	c.Check(issues, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestLintSnapFile(c *C) {
+	snap, err := BuildLegacySnap(makeLintSourceDir(c, lintPackageYaml), c.MkDir())
+	c.Assert(err, IsNil)
+
+	issues, err := Lint(snap)
+	c.Assert(err, IsNil)
+	c.Check(issues, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestLintProblems(c *C) {
+	sourceDir := makeLintSourceDir(c, `name: hello
+version: 1.0
+architecture: [amd64, sparc]
+icon: meta/missing.png
+binaries:
+ - name: hello
+   exec: bin/nothing
+   caps: [network-client, network-client, other_cap]
+services:
+ - name: svc
+   start: bin/hello-world
+   bus-name: nodots
+   security-override:
+    apparmor: meta/svc.apparmor
+`)
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", "svc.apparmor"), []byte(":"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(sourceDir, "meta", "readme.md"), []byte("just a title\n"), 0644), IsNil)
+
+	issues, err := Lint(sourceDir)
+	c.Assert(err, IsNil)
+
+	type issue struct {
+		check    string
+		severity LintSeverity
+		file     string
+	}
+	var got []issue
+	for _, i := range issues {
+		got = append(got, issue{i.Check, i.Severity, i.File})
+	}
+	c.Check(got, DeepEquals, []issue{
+		{"package-yaml", LintError, "meta/package.yaml"},
+		{"architecture", LintInfo, "meta/package.yaml"},
+		{"architecture", LintWarning, "meta/package.yaml"},
+		{"binaries", LintError, "bin/nothing"},
+		{"security", LintWarning, "meta/package.yaml"},
+		{"security", LintWarning, "meta/package.yaml"},
+		{"services", LintError, "meta/package.yaml"},
+		{"security", LintError, "meta/svc.apparmor"},
+		{"security", LintError, "meta/package.yaml"},
+		{"readme", LintWarning, "meta/readme.md"},
+		{"icon", LintError, "meta/missing.png"},
+	})
+	c.Check(issues[0].Message, Equals, "missing required fields 'vendor'")
+	c.Check(issues[2].Message, Equals, `unknown architecture "sparc"`)
+	c.Check(issues[4].Message, Equals, `hello: cap "network-client" is given more than once`)
+	c.Check(issues[5].Message, Equals, `hello: cap "other_cap" is from framework "other", which is not in frameworks`)
+	c.Check(issues[8].Message, Equals, "svc: no seccomp policy provided")
+}
+
+func (s *SnapTestSuite) TestLintUnknownCaps(c *C) {
+	lintEasyprofDir = c.MkDir()
+	defer func() { lintEasyprofDir = "/usr/share/apparmor/easyprof" }()
+	groups := easyprofDir("policygroups")
+	c.Assert(os.MkdirAll(groups, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(groups, "network-client"), nil, 0644), IsNil)
+
+	issues, err := Lint(makeLintSourceDir(c, lintPackageYaml+"   caps: [network-client, nuclear-launch]\n"))
+	c.Assert(err, IsNil)
+	c.Assert(issues, HasLen, 1)
+	c.Check(issues[0], DeepEquals, LintIssue{
+		Check:    "security",
+		Severity: LintWarning,
+		File:     "meta/package.yaml",
+		Message:  `hello: unknown cap "nuclear-launch"`,
+	})
+}
+
+func (s *SnapTestSuite) TestLintNoPackageYaml(c *C) {
+	issues, err := Lint(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(issues, HasLen, 1)
+	c.Check(issues[0].Check, Equals, "package-yaml")
+	c.Check(issues[0].Severity, Equals, LintError)
+}
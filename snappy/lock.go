@@ -0,0 +1,250 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+// The locks that serialise privileged operations live in
+// dirs.SnapLockDir. Operations on packages hold the lock of each of the
+// packages they work on, and the global lock shared, so that different
+// packages can be worked on at the same time. Operations on the system
+// image or the OEM snap hold the global lock exclusively.
+//
+// The holder of an exclusive lock writes what it is doing into the
+// lock file, so that whoever finds it locked can say what is going on.
+const (
+	globalLockFile    = "global.lock"
+	packageLockPrefix = "package-"
+	lockFileSuffix    = ".lock"
+)
+
+// errLockHeld is returned by tryLock if the lock is held elsewhere
+var errLockHeld = errors.New("lock held")
+
+// systemLocksHeld counts the global locks held exclusively by this
+// process; while there is one, nothing else runs
+var systemLocksHeld int32
+
+// OpLock is held while a privileged operation runs, until its Unlock
+// method is called
+type OpLock struct {
+	files  []*os.File
+	system bool
+}
+
+// tryLock takes the flock of the given kind on the given file without
+// blocking, and writes op into it if the lock is exclusive
+func tryLock(path string, how int, op string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLockHeld
+		}
+		return nil, err
+	}
+
+	if how == syscall.LOCK_EX {
+		f.Truncate(0)
+		f.WriteAt([]byte(fmt.Sprintf("%s (pid %d)", op, os.Getpid())), 0)
+	}
+
+	return f, nil
+}
+
+// lockHolder returns what the holder of the given lock file is doing
+func lockHolder(path string) string {
+	content, _ := ioutil.ReadFile(path)
+	return strings.TrimSpace(string(content))
+}
+
+// heldPackageLocks returns what the holders of the package locks are
+// doing
+func heldPackageLocks() []string {
+	locks, _ := filepath.Glob(filepath.Join(dirs.SnapLockDir, packageLockPrefix+"*"+lockFileSuffix))
+
+	var ops []string
+	for _, path := range locks {
+		f, err := tryLock(path, syscall.LOCK_SH, "")
+		if err == errLockHeld {
+			ops = append(ops, lockHolder(path))
+			continue
+		}
+		if err == nil {
+			syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+			f.Close()
+		}
+	}
+
+	return ops
+}
+
+// packageLockName returns the name of the package in a name[.origin][=version]
+// package spec
+func packageLockName(spec string) string {
+	if idx := strings.IndexAny(spec, ".="); idx > -1 {
+		return spec[:idx]
+	}
+
+	return spec
+}
+
+// needsGlobalLock returns true if working on the given package is a
+// system wide operation
+func needsGlobalLock(name string) bool {
+	if name == SystemImagePartName {
+		return true
+	}
+
+	oem, err := getOem()
+	return err == nil && oem.Name == name
+}
+
+// LockSystem takes the global lock for the given operation, which must
+// not run at the same time as any other privileged operation. If the
+// lock is held an ErrPrivOpInProgress naming the operation holding it
+// is returned.
+func LockSystem(op string) (*OpLock, error) {
+	if err := os.MkdirAll(dirs.SnapLockDir, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dirs.SnapLockDir, globalLockFile)
+	f, err := tryLock(path, syscall.LOCK_EX, op)
+	switch err {
+	case nil:
+		atomic.AddInt32(&systemLocksHeld, 1)
+		return &OpLock{files: []*os.File{f}, system: true}, nil
+	case errLockHeld:
+		holder := lockHolder(path)
+		if holder == "" {
+			// held shared, by package operations
+			holder = strings.Join(heldPackageLocks(), ", ")
+		}
+		return nil, &ErrPrivOpInProgress{Op: holder}
+	default:
+		return nil, err
+	}
+}
+
+// LockPackages takes the locks of the given packages, given as
+// name[.origin][=version], for the given operation. Operations on the
+// system image or the OEM snap take the global lock instead. If a lock
+// is held an ErrPrivOpInProgress naming the operation holding it is
+// returned.
+func LockPackages(op string, pkgs ...string) (*OpLock, error) {
+	names := make([]string, 0, len(pkgs))
+	seen := make(map[string]bool, len(pkgs))
+	for _, pkg := range pkgs {
+		name := packageLockName(pkg)
+		if needsGlobalLock(name) {
+			return LockSystem(op)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	// always lock in the same order
+	sort.Strings(names)
+
+	if err := os.MkdirAll(dirs.SnapLockDir, 0755); err != nil {
+		return nil, err
+	}
+
+	lock := &OpLock{}
+	path := filepath.Join(dirs.SnapLockDir, globalLockFile)
+	f, err := tryLock(path, syscall.LOCK_SH, op)
+	if err != nil {
+		if err == errLockHeld {
+			err = &ErrPrivOpInProgress{Op: lockHolder(path)}
+		}
+		return nil, err
+	}
+	lock.files = append(lock.files, f)
+
+	for _, name := range names {
+		path := filepath.Join(dirs.SnapLockDir, packageLockPrefix+name+lockFileSuffix)
+		f, err := tryLock(path, syscall.LOCK_EX, op)
+		if err != nil {
+			lock.Unlock()
+			if err == errLockHeld {
+				err = &ErrPrivOpInProgress{Op: lockHolder(path)}
+			}
+			return nil, err
+		}
+		lock.files = append(lock.files, f)
+	}
+
+	return lock, nil
+}
+
+// lockFrameworks takes the locks of the given frameworks for op, the
+// install of a snap that needs them, so that they are not installed or
+// removed by someone else meanwhile. The caller holds the lock of the
+// snap; if that is the global lock nothing else runs, and nothing more
+// is taken (it could not be, flocks do not nest).
+func lockFrameworks(op string, names []string) (*OpLock, error) {
+	if len(names) == 0 || atomic.LoadInt32(&systemLocksHeld) > 0 {
+		return &OpLock{}, nil
+	}
+
+	return LockPackages(op, names...)
+}
+
+// Unlock releases the locks
+func (l *OpLock) Unlock() error {
+	var firstErr error
+	for i := len(l.files) - 1; i >= 0; i-- {
+		f := l.files[i]
+		// the file is not removed, as someone else could be
+		// waiting on it; it is only cleared
+		f.Truncate(0)
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	l.files = nil
+	if l.system {
+		atomic.AddInt32(&systemLocksHeld, -1)
+		l.system = false
+	}
+
+	return firstErr
+}
@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"os"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+func (s *SnapTestSuite) TestLockPackages(c *C) {
+	foo, err := LockPackages("install foo", "foo")
+	c.Assert(err, IsNil)
+
+	// other packages can be worked on
+	bar, err := LockPackages("install bar", "bar.baz")
+	c.Assert(err, IsNil)
+	c.Assert(bar.Unlock(), IsNil)
+
+	// but not the same one
+	_, err = LockPackages("remove foo", "bar", "foo.baz=1.0")
+	c.Assert(err, DeepEquals, &ErrPrivOpInProgress{Op: fmt.Sprintf("install foo (pid %d)", os.Getpid())})
+	c.Check(err, ErrorMatches, "privileged operation already in progress: install foo .*")
+
+	// the locks that were taken were given back
+	bar, err = LockPackages("install bar", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(bar.Unlock(), IsNil)
+
+	c.Assert(foo.Unlock(), IsNil)
+	foo, err = LockPackages("remove foo", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(foo.Unlock(), IsNil)
+}
+
+func (s *SnapTestSuite) TestLockSystem(c *C) {
+	foo, err := LockPackages("install foo", "foo")
+	c.Assert(err, IsNil)
+
+	_, err = LockSystem("update")
+	c.Assert(err, DeepEquals, &ErrPrivOpInProgress{Op: fmt.Sprintf("install foo (pid %d)", os.Getpid())})
+	c.Assert(foo.Unlock(), IsNil)
+
+	system, err := LockSystem("update")
+	c.Assert(err, IsNil)
+
+	_, err = LockPackages("install foo", "foo")
+	c.Assert(err, DeepEquals, &ErrPrivOpInProgress{Op: fmt.Sprintf("update (pid %d)", os.Getpid())})
+	_, err = LockSystem("update")
+	c.Assert(err, FitsTypeOf, &ErrPrivOpInProgress{})
+
+	c.Assert(system.Unlock(), IsNil)
+}
+
+func (s *SnapTestSuite) TestLockPackagesSystemImage(c *C) {
+	foo, err := LockPackages("install foo", "foo")
+	c.Assert(err, IsNil)
+	defer foo.Unlock()
+
+	// the system image takes the global lock
+	_, err = LockPackages("rollback", SystemImagePartName)
+	c.Assert(err, FitsTypeOf, &ErrPrivOpInProgress{})
+}
+
+func (s *SnapTestSuite) TestLockPackagesOem(c *C) {
+	getOem = func() (*packageYaml, error) {
+		return &packageYaml{Name: "oem-test", Type: pkg.TypeOem}, nil
+	}
+	defer func() { getOem = getOemImpl }()
+
+	system, err := LockPackages("install oem-test", "oem-test")
+	c.Assert(err, IsNil)
+	defer system.Unlock()
+
+	// the oem snap takes the global lock
+	_, err = LockPackages("install foo", "foo")
+	c.Assert(err, DeepEquals, &ErrPrivOpInProgress{Op: fmt.Sprintf("install oem-test (pid %d)", os.Getpid())})
+}
+
+func (s *SnapTestSuite) TestLockFrameworks(c *C) {
+	fmk, err := LockPackages("remove fmk1", "fmk1")
+	c.Assert(err, IsNil)
+
+	_, err = lockFrameworks("install foo", []string{"fmk1", "fmk2"})
+	c.Assert(err, DeepEquals, &ErrPrivOpInProgress{Op: fmt.Sprintf("remove fmk1 (pid %d)", os.Getpid())})
+	c.Assert(fmk.Unlock(), IsNil)
+
+	lock, err := lockFrameworks("install foo", []string{"fmk1", "fmk2"})
+	c.Assert(err, IsNil)
+	_, err = LockPackages("remove fmk2", "fmk2")
+	c.Check(err, FitsTypeOf, &ErrPrivOpInProgress{})
+	c.Assert(lock.Unlock(), IsNil)
+
+	// under the global lock nothing else runs, and nothing more is
+	// taken
+	system, err := LockSystem("update")
+	c.Assert(err, IsNil)
+	lock, err = lockFrameworks("install foo", []string{"fmk1"})
+	c.Assert(err, IsNil)
+	c.Assert(lock.Unlock(), IsNil)
+	c.Assert(system.Unlock(), IsNil)
+
+	lock, err = lockFrameworks("install foo", []string{"fmk1"})
+	c.Assert(err, IsNil)
+	_, err = LockSystem("update")
+	c.Check(err, FitsTypeOf, &ErrPrivOpInProgress{})
+	c.Assert(lock.Unlock(), IsNil)
+}
@@ -0,0 +1,131 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/systemd"
+)
+
+// Snaps that can be mounted (snapfs ones) are not unpacked on install:
+// the snap file is kept as the blob of the part, and a systemd mount
+// unit mounts it read-only at the install dir. The data dirs are
+// unchanged.
+
+// how long to wait for a mount to go away
+var mountStopTimeout = 30 * time.Second
+
+// mountUnitFileName returns the path of the mount unit for the given
+// install dir
+func mountUnitFileName(baseDir string) string {
+	return filepath.Join(dirs.SnapServicesDir, systemd.MountUnitName(stripGlobalRootDir(baseDir)))
+}
+
+// isMounted returns true if the snap is mounted rather than unpacked
+func (s *SnapPart) isMounted() bool {
+	return helpers.FileExists(mountUnitFileName(s.basedir))
+}
+
+// installMount keeps the snap file as the blob of the part and mounts
+// it at the install dir
+func (s *SnapPart) installMount(inhibitHooks bool, inter interacter) (err error) {
+	if err := os.MkdirAll(dirs.SnapBlobDir, 0755); err != nil {
+		return err
+	}
+	if err := s.deb.CopyBlob(blobPath(s)); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			removeBlob(s)
+		}
+	}()
+
+	sysd := systemd.New(dirs.GlobalRootDir, inter)
+	unitName, err := sysd.WriteMountUnitFile(QualifiedName(s), stripGlobalRootDir(blobPath(s)), stripGlobalRootDir(s.basedir))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if e := os.Remove(mountUnitFileName(s.basedir)); e != nil && !os.IsNotExist(e) {
+				logger.Noticef("Failed to remove mount unit for %q: %v", s.basedir, e)
+			}
+		}
+	}()
+
+	// like services, always enable but only start when not
+	// inhibitHooks
+	if err := sysd.Enable(unitName); err != nil {
+		return err
+	}
+	if inhibitHooks {
+		return nil
+	}
+
+	if err := sysd.DaemonReload(); err != nil {
+		return err
+	}
+
+	return sysd.Start(unitName)
+}
+
+// startMount makes sure a mounted snap is mounted
+func (s *SnapPart) startMount(inhibitHooks bool, inter interacter) error {
+	if inhibitHooks || !s.isMounted() {
+		return nil
+	}
+
+	sysd := systemd.New(dirs.GlobalRootDir, inter)
+	unitName := filepath.Base(mountUnitFileName(s.basedir))
+	if err := sysd.Enable(unitName); err != nil {
+		return err
+	}
+
+	return sysd.Start(unitName)
+}
+
+// removeMount unmounts a mounted snap and removes its mount unit
+func (s *SnapPart) removeMount(inter interacter) error {
+	if !s.isMounted() {
+		return nil
+	}
+
+	sysd := systemd.New(dirs.GlobalRootDir, inter)
+	unitFile := mountUnitFileName(s.basedir)
+	unitName := filepath.Base(unitFile)
+	if err := sysd.Disable(unitName); err != nil {
+		return err
+	}
+	if err := sysd.Stop(unitName, mountStopTimeout); err != nil {
+		return err
+	}
+	if err := os.Remove(unitFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return sysd.DaemonReload()
+}
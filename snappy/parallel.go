@@ -0,0 +1,184 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// Apps of the same name from different origins can only be installed
+// and active side by side if the system opts in to it. An app installed
+// while it does, next to another origin of it, has its binaries and
+// services named after its qualified name (e.g. foo.alice.hello instead
+// of foo.hello), so they do not clash; it keeps these names for as long
+// as any version of it is installed.
+const (
+	parallelOriginsFile = "parallel-origins"
+	namespacedSuffix    = ".namespaced"
+)
+
+// ParallelOriginsEnabled returns true if apps of the same name from
+// different origins can be installed side by side
+func ParallelOriginsEnabled() bool {
+	return helpers.FileExists(filepath.Join(dirs.SnapMetaDir, parallelOriginsFile))
+}
+
+// SetParallelOrigins turns the installing of apps of the same name from
+// different origins side by side on or off. It is a property of
+// ubuntu-core.
+func SetParallelOrigins(pkgname, value string, inter progress.Meter) error {
+	if pkgname != SystemImagePartName {
+		return fmt.Errorf("parallel-origins is a property of %s", SystemImagePartName)
+	}
+
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("Can not parse parallel-origins value %s", value)
+	}
+
+	path := filepath.Join(dirs.SnapMetaDir, parallelOriginsFile)
+	if !on {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(dirs.SnapMetaDir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, nil, 0644)
+}
+
+// canCoexist returns true if a package of the given type can be active
+// alongside one of the same name from another origin
+func canCoexist(t pkg.Type) bool {
+	// a package.yaml without a type is an app
+	return (t == pkg.TypeApp || t == "") && ParallelOriginsEnabled()
+}
+
+// otherOriginInstalled returns true if the package of the given name is
+// installed from an origin other than the given one
+func otherOriginInstalled(name, origin string) bool {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return false
+	}
+
+	for _, part := range FindSnapsByName(name, installed) {
+		if part.Origin() != origin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isNamespaced returns true if the binaries and services of the package
+// with the given qualified name are named after it
+func isNamespaced(qn string) bool {
+	return helpers.FileExists(filepath.Join(dirs.SnapMetaDir, qn+namespacedSuffix))
+}
+
+// setNamespaced sets whether the binaries and services of the package
+// with the given qualified name are named after it
+func setNamespaced(qn string, namespaced bool) error {
+	path := filepath.Join(dirs.SnapMetaDir, qn+namespacedSuffix)
+	if !namespaced {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(dirs.SnapMetaDir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, nil, 0644)
+}
+
+// activeSnapByNameAndOrigin returns the active package with the given
+// name and origin, or nil if there is none. If origin is "" the package
+// can be from any origin, but if active packages of the name are from
+// more than one origin it is an ErrAmbiguousName.
+func activeSnapByNameAndOrigin(name, origin string) (Part, error) {
+	if origin == "" {
+		installed, err := NewMetaRepository().Installed()
+		if err != nil {
+			return nil, nil
+		}
+
+		var found Part
+		for _, part := range installed {
+			if !part.IsActive() || part.Name() != name {
+				continue
+			}
+			if found != nil && found.Origin() != part.Origin() {
+				return nil, ErrAmbiguousName(name)
+			}
+			found = part
+		}
+
+		return found, nil
+	}
+
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return nil, nil
+	}
+
+	for _, part := range installed {
+		if part.IsActive() && part.Name() == name && part.Origin() == origin {
+			return part, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ActiveSnapByQualifiedName returns the active package given as
+// name[.origin], see activeSnapByNameAndOrigin
+func ActiveSnapByQualifiedName(name string) (Part, error) {
+	return activeSnapByNameAndOrigin(SplitOrigin(name))
+}
+
+// activeSnapFor returns the active package that installing the given
+// part upgrades or conflicts with: the one with the same name, or with
+// the same name and origin if the part can coexist with other origins
+func activeSnapFor(part Part) Part {
+	if canCoexist(part.Type()) {
+		// with an origin it is never ambiguous
+		active, _ := activeSnapByNameAndOrigin(part.Name(), part.Origin())
+		return active
+	}
+
+	return ActiveSnapByName(part.Name())
+}
@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+const parallelPackageYaml = `name: foo
+icon: foo.svg
+vendor: Foo Bar <foo@example.com>
+binaries:
+ - name: bar
+   exec: bin/bar
+services:
+ - name: service
+   start: bin/hello
+version: 1.0
+`
+
+func (s *SnapTestSuite) TestSetParallelOrigins(c *C) {
+	c.Check(ParallelOriginsEnabled(), Equals, false)
+
+	c.Assert(SetProperty(SystemImagePartName, &MockProgressMeter{}, "parallel-origins=true"), IsNil)
+	c.Check(ParallelOriginsEnabled(), Equals, true)
+
+	c.Assert(SetProperty(SystemImagePartName, &MockProgressMeter{}, "parallel-origins=false"), IsNil)
+	c.Check(ParallelOriginsEnabled(), Equals, false)
+
+	c.Check(SetParallelOrigins("foo", "true", &MockProgressMeter{}), NotNil)
+	c.Check(SetParallelOrigins(SystemImagePartName, "maybe", &MockProgressMeter{}), NotNil)
+}
+
+func (s *SnapTestSuite) TestInstallParallelOrigins(c *C) {
+	c.Assert(SetParallelOrigins(SystemImagePartName, "true", nil), IsNil)
+
+	snapFile := makeTestSnapPackage(c, parallelPackageYaml)
+	_, err := installClick(snapFile, AllowUnauthenticated, nil, "alice")
+	c.Assert(err, IsNil)
+	_, err = installClick(snapFile, AllowUnauthenticated, nil, "bob")
+	c.Assert(err, IsNil)
+
+	installed, err := NewMetaLocalRepository().Installed()
+	c.Assert(err, IsNil)
+	parts := FindSnapsByName("foo", installed)
+	c.Assert(parts, HasLen, 2)
+	for _, part := range parts {
+		c.Check(part.IsActive(), Equals, true)
+	}
+
+	// the first one has nothing to clash with
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.bar")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "foo_service_1.0.service")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.alice.bar")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.bob.bar")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "foo.bob_service_1.0.service")), Equals, true)
+
+	// removing one leaves the other alone
+	c.Assert(Remove("foo.alice", 0, &progress.NullProgress{}), IsNil)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.bar")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.bob.bar")), Equals, true)
+	c.Check(isNamespaced("foo.alice"), Equals, false)
+	c.Check(isNamespaced("foo.bob"), Equals, true)
+}
+
+func (s *SnapTestSuite) TestInstallParallelOriginsAloneNotNamespaced(c *C) {
+	c.Assert(SetParallelOrigins(SystemImagePartName, "true", nil), IsNil)
+
+	snapFile := makeTestSnapPackage(c, parallelPackageYaml)
+	_, err := installClick(snapFile, AllowUnauthenticated, nil, "alice")
+	c.Assert(err, IsNil)
+
+	c.Check(isNamespaced("foo.alice"), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.bar")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.alice.bar")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestParallelOriginsNeedQualifiedName(c *C) {
+	c.Assert(SetParallelOrigins(SystemImagePartName, "true", nil), IsNil)
+
+	snapFile := makeTestSnapPackage(c, parallelPackageYaml)
+	_, err := installClick(snapFile, AllowUnauthenticated, nil, "alice")
+	c.Assert(err, IsNil)
+
+	// one origin, the name alone is enough
+	part, err := ActiveSnapByQualifiedName("foo")
+	c.Assert(err, IsNil)
+	c.Check(part.Origin(), Equals, "alice")
+
+	_, err = installClick(snapFile, AllowUnauthenticated, nil, "bob")
+	c.Assert(err, IsNil)
+
+	// two, and it is not
+	_, err = ActiveSnapByQualifiedName("foo")
+	c.Check(err, Equals, ErrAmbiguousName("foo"))
+	c.Check(err, ErrorMatches, `foo is installed from more than one origin, use foo.<origin>`)
+	_, err = SnapshotSave("foo")
+	c.Check(err, Equals, ErrAmbiguousName("foo"))
+	c.Check(Remove("foo", 0, &progress.NullProgress{}), Equals, ErrAmbiguousName("foo"))
+
+	part, err = ActiveSnapByQualifiedName("foo.bob")
+	c.Assert(err, IsNil)
+	c.Check(part.Origin(), Equals, "bob")
+}
+
+func (s *SnapTestSuite) TestInstallParallelOriginsKeepsNames(c *C) {
+	snapFile := makeTestSnapPackage(c, parallelPackageYaml)
+	_, err := installClick(snapFile, AllowUnauthenticated, nil, "alice")
+	c.Assert(err, IsNil)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.bar")), Equals, true)
+
+	// names are not clashing, so it can go in
+	c.Assert(SetParallelOrigins(SystemImagePartName, "true", nil), IsNil)
+	_, err = installClick(snapFile, AllowUnauthenticated, nil, "bob")
+	c.Assert(err, IsNil)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.bar")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "foo.bob.bar")), Equals, true)
+
+	// but not once parallel origins are off again
+	c.Assert(SetParallelOrigins(SystemImagePartName, "false", nil), IsNil)
+	_, err = installClick(snapFile, AllowUnauthenticated, nil, "carol")
+	c.Check(err, Equals, ErrPackageNameAlreadyInstalled)
+}
+
+func (s *SnapTestSuite) TestInstallParallelOriginsNotForFrameworks(c *C) {
+	c.Assert(SetParallelOrigins(SystemImagePartName, "true", nil), IsNil)
+
+	data := "name: afoo\nversion: 1\nvendor: foo\ntype: framework"
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, data)
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	yaml, err := parsePackageYamlData([]byte(data), false)
+	c.Assert(err, IsNil)
+	c.Check(yaml.checkForPackageInstalled("otherns"), Equals, ErrPackageNameAlreadyInstalled)
+}
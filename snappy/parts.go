@@ -147,6 +147,10 @@ func NewMetaStoreRepository() *MetaRepository {
 	m := new(MetaRepository)
 	m.all = []Repository{}
 
+	// a configured seed is preferred over the network
+	if repo := NewSeedSnapRepository(seedDir()); repo != nil {
+		m.all = append(m.all, repo)
+	}
 	if repo := NewUbuntuStoreSnapRepository(); repo != nil {
 		m.all = append(m.all, repo)
 	}
@@ -177,6 +181,9 @@ func NewMetaRepository() *MetaRepository {
 	// FIXME: make this a configuration file
 
 	m := NewMetaLocalRepository()
+	if repo := NewSeedSnapRepository(seedDir()); repo != nil {
+		m.all = append(m.all, repo)
+	}
 	if repo := NewUbuntuStoreSnapRepository(); repo != nil {
 		m.all = append(m.all, repo)
 	}
@@ -212,14 +219,25 @@ func (m *MetaRepository) All() ([]Part, error) {
 	return parts, nil
 }
 
-// Updates returns all updatable parts
+// Updates returns all updatable parts; if more than one repository
+// offers an update for the same part only the newest one is returned
 func (m *MetaRepository) Updates() (parts []Part, err error) {
+	idx := make(map[string]int)
 	for _, r := range m.all {
 		updates, err := r.Updates()
 		if err != nil {
 			return parts, err
 		}
-		parts = append(parts, updates...)
+		for _, part := range updates {
+			i, ok := idx[FullName(part)]
+			switch {
+			case !ok:
+				idx[FullName(part)] = len(parts)
+				parts = append(parts, part)
+			case VersionCompare(part.Version(), parts[i].Version()) > 0:
+				parts[i] = part
+			}
+		}
 	}
 
 	return parts, err
@@ -379,3 +397,9 @@ func iconPath(s Part) string {
 func RemoteManifestPath(s Part) string {
 	return filepath.Join(dirs.SnapMetaDir, fmt.Sprintf("%s_%s.manifest", QualifiedName(s), s.Version()))
 }
+
+// blobPath returns the would be path for the local copy of the snap
+// blob a part was installed from
+func blobPath(s Part) string {
+	return filepath.Join(dirs.SnapBlobDir, fmt.Sprintf("%s_%s.snap", QualifiedName(s), s.Version()))
+}
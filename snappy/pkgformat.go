@@ -37,6 +37,8 @@ type PackageFile interface {
 	ControlMember(name string) ([]byte, error)
 	MetaMember(name string) ([]byte, error)
 	ExtractHashes(targetDir string) error
+	NeedsMountUnit() bool
+	CopyBlob(targetFile string) error
 }
 
 // OpenPackageFile opens a given snap file with the right backend
@@ -0,0 +1,434 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/release"
+)
+
+// PreflightCheck is the result of a single check done by Preflight
+type PreflightCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// PreflightReport describes whether a snap can be installed on this
+// system, without installing it
+type PreflightReport struct {
+	Name          string           `json:"name"`
+	Origin        string           `json:"origin"`
+	Version       string           `json:"version"`
+	Channel       string           `json:"channel,omitempty"`
+	InstalledSize int64            `json:"installed_size"`
+	DownloadSize  int64            `json:"download_size"`
+	Checks        []PreflightCheck `json:"checks"`
+}
+
+func (r *PreflightReport) add(name string, ok bool, format string, v ...interface{}) {
+	r.Checks = append(r.Checks, PreflightCheck{Name: name, OK: ok, Detail: fmt.Sprintf(format, v...)})
+}
+
+// OK returns true if all the checks passed
+func (r *PreflightReport) OK() bool {
+	return r.Err() == nil
+}
+
+// Err returns an ErrPreflightFailed listing the failed checks, or nil
+// if all of them passed
+func (r *PreflightReport) Err() error {
+	var failed []string
+	for _, check := range r.Checks {
+		if !check.OK {
+			failed = append(failed, check.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &ErrPreflightFailed{Snap: r.Name, Failed: failed}
+	}
+
+	return nil
+}
+
+// freeSpace returns the space available to unprivileged users on the
+// filesystem that holds the given path, and an id for that filesystem
+var freeSpace = freeSpaceImpl
+
+func freeSpaceImpl(path string) (free uint64, fsid string, err error) {
+	// the path itself might not exist yet
+	for !helpers.FileExists(path) && path != filepath.Dir(path) {
+		path = filepath.Dir(path)
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, "", err
+	}
+
+	return st.Bavail * uint64(st.Bsize), fmt.Sprint(st.Fsid), nil
+}
+
+// Preflight checks whether the given snap, which can be a local file or
+// the name[.origin][=version] of a snap in the configured repositories,
+// can be installed from the given channel (the default one if it is
+// ""), like InstallFromChannel would. Only errors in finding the snap
+// are returned, the failed checks are in the report.
+func Preflight(name, channel string, flags InstallFlags) (*PreflightReport, error) {
+	return doPreflight(name, channel, flags, false)
+}
+
+// PreflightUpdate checks like Preflight whether the given snap can be
+// installed, as an update of the installed version of it if there is
+// one
+func PreflightUpdate(name, channel string, flags InstallFlags) (*PreflightReport, error) {
+	return doPreflight(name, channel, flags, true)
+}
+
+func doPreflight(name, channel string, flags InstallFlags, update bool) (*PreflightReport, error) {
+	if fi, err := snapFileInfo(name); err != nil {
+		return nil, err
+	} else if fi != nil {
+		if channel != "" {
+			return nil, fmt.Errorf("can not install a snap file from a channel")
+		}
+
+		part, err := NewSnapPartFromSnapFile(name, SideloadedOrigin, (flags&AllowUnauthenticated) != 0)
+		if err != nil {
+			return nil, err
+		}
+		defer part.deb.Close()
+
+		// a snap file replaces the sideloaded version of it
+		return preflight(part, "", snapFileInstalledSize(part, fi), flags, true), nil
+	}
+
+	if channel != "" && !validChannel.MatchString(channel) {
+		return nil, ErrInvalidChannel(channel)
+	}
+
+	name, origin, version := splitInstallName(name)
+	found, err := findStoreParts(name, origin, version, channel)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, ErrPackageNotFound
+	}
+
+	// another version of the same package can be asked for
+	return preflight(found[0], channel, found[0].InstalledSize(), flags, update || version != ""), nil
+}
+
+// PreflightPart checks whether the given part can be installed as an
+// upgrade of, or in addition to, what is installed
+func PreflightPart(part Part, flags InstallFlags) *PreflightReport {
+	return preflight(part, "", part.InstalledSize(), flags, true)
+}
+
+func preflight(part Part, channel string, installedSize int64, flags InstallFlags, allowUpgrade bool) *PreflightReport {
+	r := &PreflightReport{
+		Name:          part.Name(),
+		Origin:        part.Origin(),
+		Version:       part.Version(),
+		Channel:       channel,
+		InstalledSize: installedSize,
+		DownloadSize:  part.DownloadSize(),
+	}
+
+	current := activeSnapFor(part)
+	r.checkInstalled(part, current, allowUpgrade)
+	r.checkArchitecture(part)
+	r.checkRelease(part)
+	r.checkFrameworks(part)
+	r.checkOem(part, flags)
+	r.checkStore(part, channel)
+	r.checkSpace(part, current)
+
+	return r
+}
+
+func (r *PreflightReport) checkInstalled(part Part, current Part, allowUpgrade bool) {
+	installed, _ := NewMetaLocalRepository().Installed()
+	switch {
+	case len(FindSnapsByNameAndVersion(QualifiedName(part), part.Version(), installed)) > 0:
+		r.add("installed", false, "%s", ErrAlreadyInstalled)
+	case current == nil:
+		r.add("installed", true, "not installed")
+	case !allowUpgrade || current.Origin() != part.Origin():
+		r.add("installed", false, "%s", ErrPackageNameAlreadyInstalled)
+	default:
+		r.add("installed", true, "upgrade from %s", current.Version())
+	}
+}
+
+func (r *PreflightReport) checkArchitecture(part Part) {
+	var archs []string
+	switch p := part.(type) {
+	case *SnapPart:
+		archs = p.m.Architectures
+	case *RemoteSnapPart:
+		archs = p.pkg.Architectures
+	case *SeedSnapPart:
+		archs = p.pkg.Architectures
+	}
+
+	if len(archs) == 0 {
+		r.add("architecture", true, "not restricted")
+		return
+	}
+
+	if !helpers.IsSupportedArchitecture(archs) {
+		r.add("architecture", false, "%s", &ErrArchitectureNotSupported{archs})
+		return
+	}
+
+	r.add("architecture", true, "%s", strings.Join(archs, ", "))
+}
+
+func (r *PreflightReport) checkRelease(part Part) {
+	var releases []string
+	switch p := part.(type) {
+	case *RemoteSnapPart:
+		releases = p.pkg.Releases
+	case *SeedSnapPart:
+		releases = p.pkg.Releases
+	}
+
+	if len(releases) == 0 {
+		r.add("release", true, "not restricted")
+		return
+	}
+
+	current := release.String()
+	for _, rel := range releases {
+		if rel == current {
+			r.add("release", true, "%s", current)
+			return
+		}
+	}
+
+	r.add("release", false, "snap is for %s, system is %s", strings.Join(releases, ", "), current)
+}
+
+func (r *PreflightReport) checkFrameworks(part Part) {
+	snap, ok := part.(*SnapPart)
+	if !ok {
+		// store data does not have the frameworks, they are
+		// resolved once the snap is downloaded
+		r.add("frameworks", true, "checked after download")
+		return
+	}
+
+	missing, err := snap.m.missingFrameworks()
+	if err != nil {
+		r.add("frameworks", false, "%s", err)
+		return
+	}
+
+	var toInstall, notFound []string
+	for _, name := range missing {
+		if _, err := findFramework(name); err != nil {
+			notFound = append(notFound, name)
+		} else {
+			toInstall = append(toInstall, name)
+		}
+	}
+
+	switch {
+	case len(notFound) > 0:
+		r.add("frameworks", false, "%s", ErrMissingFrameworks(notFound))
+	case len(toInstall) > 0:
+		r.add("frameworks", true, "will install %s", strings.Join(toInstall, ", "))
+	default:
+		r.add("frameworks", true, "%s", strings.Join(snap.m.Frameworks, ", "))
+	}
+}
+
+func (r *PreflightReport) checkOem(part Part, flags InstallFlags) {
+	// only oem snaps are restricted, mirroring SnapPart.CanInstall
+	if part.Type() != pkg.TypeOem {
+		return
+	}
+
+	if (flags & AllowOEM) != 0 {
+		r.add("oem", true, "allowed")
+		return
+	}
+
+	if currentOEM, err := getOem(); err == nil && currentOEM.Name == part.Name() {
+		r.add("oem", true, "upgrade of the oem snap")
+		return
+	}
+
+	r.add("oem", false, "%s", ErrOEMPackageInstall)
+}
+
+func (r *PreflightReport) checkStore(part Part, channel string) {
+	// snap files and seeds are not downloaded from the store
+	if _, ok := part.(*RemoteSnapPart); !ok {
+		return
+	}
+
+	// the oem snap (or UBUNTU_STORE_ID) can restrict installs to
+	// the snaps of its store, which the store enforces on the
+	// lookup done by the install; do the same lookup
+	storeID := activeStoreID()
+	if storeID == "" {
+		return
+	}
+
+	repo := NewUbuntuStoreSnapRepository()
+	if repo == nil {
+		r.add("store", false, "no store configured")
+		return
+	}
+
+	var err error
+	if channel == "" {
+		_, err = repo.Details(part.Name(), part.Origin())
+	} else {
+		_, err = repo.DetailsFromChannel(part.Name(), part.Origin(), channel)
+	}
+
+	switch err {
+	case nil:
+		r.add("store", true, "in store %s", storeID)
+	case ErrPackageNotFound:
+		r.add("store", false, "not in store %s", storeID)
+	default:
+		r.add("store", false, "%s", err)
+	}
+}
+
+func (r *PreflightReport) checkSpace(part Part, current Part) {
+	type need struct {
+		what string
+		path string
+		size int64
+	}
+
+	appsDir := dirs.SnapAppsDir
+	if part.Type() == pkg.TypeOem {
+		appsDir = dirs.SnapOemDir
+	}
+
+	installedSize := r.InstalledSize
+	if installedSize < 0 {
+		// the store does not tell us, assume it does not
+		// get any smaller when unpacked
+		installedSize = r.DownloadSize
+	}
+
+	needs := []need{{"apps space", appsDir, installedSize}}
+	if _, local := part.(*SnapPart); !local && r.DownloadSize > 0 {
+		needs = append(needs, need{"download space", dirs.SnapDownloadCacheDir, r.DownloadSize})
+	}
+	// the data of the current version is copied for the new one
+	if current != nil && current.Origin() == part.Origin() {
+		needs = append(needs, need{"data space", dirs.SnapDataDir, snapDataSize(QualifiedName(current), current.Version())})
+	}
+
+	free := make(map[string]uint64)
+	total := make(map[string]int64)
+	fsids := make([]string, len(needs))
+	for i, n := range needs {
+		avail, fsid, err := freeSpace(n.path)
+		if err != nil {
+			r.add(n.what, false, "%s", err)
+			continue
+		}
+		fsids[i] = fsid
+		free[fsid] = avail
+		total[fsid] += n.size
+	}
+
+	for i, n := range needs {
+		fsid := fsids[i]
+		if fsid == "" {
+			continue
+		}
+
+		ok := total[fsid] <= int64(free[fsid])
+		if total[fsid] == n.size {
+			r.add(n.what, ok, "needs %d bytes in %s, %d available", n.size, n.path, free[fsid])
+		} else {
+			r.add(n.what, ok, "needs %d bytes in %s (%d with the rest on the same filesystem), %d available", n.size, n.path, total[fsid], free[fsid])
+		}
+	}
+}
+
+// snapFileInstalledSize returns the installed size recorded in the
+// control file of the snap, or the size of the snap file if there is
+// none
+func snapFileInstalledSize(part *SnapPart, fi os.FileInfo) int64 {
+	control, err := part.deb.ControlMember("control")
+	if err != nil {
+		return fi.Size()
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(control))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Installed-Size:") {
+			continue
+		}
+		// in kB, as for debs
+		kb, err := strconv.ParseInt(strings.TrimSpace(line[len("Installed-Size:"):]), 10, 64)
+		if err != nil {
+			break
+		}
+		return kb * 1024
+	}
+
+	return fi.Size()
+}
+
+// snapDataSize returns the size of the data of the given snap version
+func snapDataSize(fullName, version string) int64 {
+	dataDirs, err := snapDataDirs(fullName, version)
+	if err != nil {
+		return 0
+	}
+
+	size := int64(0)
+	for _, dir := range dataDirs {
+		filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+			if err == nil {
+				size += info.Size()
+			}
+			return nil
+		})
+	}
+
+	return size
+}
@@ -0,0 +1,330 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+	"github.com/ubuntu-core/snappy/release"
+)
+
+// mockFreeSpace makes every path have the given free space, on the
+// filesystem given by fsids (or the same one if not given)
+func mockFreeSpace(free uint64, fsids map[string]string) {
+	freeSpace = func(path string) (uint64, string, error) {
+		if fsid, ok := fsids[path]; ok {
+			return free, fsid, nil
+		}
+		return free, "root", nil
+	}
+}
+
+func findCheck(c *C, r *PreflightReport, name string) PreflightCheck {
+	for _, check := range r.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	c.Fatalf("no %q check in %v", name, r.Checks)
+
+	return PreflightCheck{}
+}
+
+func (s *SnapTestSuite) TestPreflightRemoteOK(c *C) {
+	mockFreeSpace(1000, nil)
+
+	part := NewRemoteSnapPart(remote.Snap{
+		Name:          "foo",
+		Origin:        "bar",
+		Version:       "1.0",
+		DownloadSize:  100,
+		Architectures: []string{helpers.UbuntuArchitecture()},
+		Releases:      []string{release.String()},
+	})
+
+	r := PreflightPart(part, 0)
+	c.Check(r.Err(), IsNil)
+	c.Check(r.OK(), Equals, true)
+	c.Check(r.Name, Equals, "foo")
+	c.Check(r.DownloadSize, Equals, int64(100))
+	c.Check(findCheck(c, r, "installed").Detail, Equals, "not installed")
+	c.Check(findCheck(c, r, "apps space").Detail, Equals, "needs 100 bytes in "+dirs.SnapAppsDir+" (200 with the rest on the same filesystem), 1000 available")
+}
+
+func (s *SnapTestSuite) TestPreflightRemoteIncompatible(c *C) {
+	mockFreeSpace(1000, nil)
+
+	part := NewRemoteSnapPart(remote.Snap{
+		Name:          "foo",
+		Origin:        "bar",
+		Version:       "1.0",
+		Architectures: []string{"potato"},
+		Releases:      []string{"12.04-core"},
+	})
+
+	r := PreflightPart(part, 0)
+	c.Check(r.OK(), Equals, false)
+	c.Check(r.Err(), DeepEquals, &ErrPreflightFailed{Snap: "foo", Failed: []string{"architecture", "release"}})
+	c.Check(findCheck(c, r, "release").Detail, Equals, "snap is for 12.04-core, system is "+release.String())
+}
+
+func (s *SnapTestSuite) TestPreflightNotEnoughSpace(c *C) {
+	mockFreeSpace(150, map[string]string{dirs.SnapDownloadCacheDir: "writable"})
+
+	part := NewRemoteSnapPart(remote.Snap{Name: "foo", Origin: "bar", Version: "1.0", DownloadSize: 100})
+
+	// apps and downloads on different filesystems, both fit
+	r := PreflightPart(part, 0)
+	c.Check(r.Err(), IsNil)
+
+	// on the same one they don't
+	mockFreeSpace(150, nil)
+	r = PreflightPart(part, 0)
+	c.Check(r.Err(), DeepEquals, &ErrPreflightFailed{Snap: "foo", Failed: []string{"apps space", "download space"}})
+}
+
+func (s *SnapTestSuite) TestPreflightAlreadyInstalled(c *C) {
+	mockFreeSpace(1000, nil)
+
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	part := NewRemoteSnapPart(remote.Snap{Name: "hello-app", Origin: testOrigin, Version: "1.10"})
+	r := PreflightPart(part, 0)
+	c.Check(r.Err(), DeepEquals, &ErrPreflightFailed{Snap: "hello-app", Failed: []string{"installed"}})
+
+	// another origin can't be installed next to it
+	part = NewRemoteSnapPart(remote.Snap{Name: "hello-app", Origin: "potato", Version: "2.0"})
+	r = PreflightPart(part, 0)
+	c.Check(findCheck(c, r, "installed").OK, Equals, false)
+}
+
+func (s *SnapTestSuite) TestPreflightUpgradeNeedsDataSpace(c *C) {
+	mockFreeSpace(1000, nil)
+
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	dataDir := filepath.Join(dirs.SnapDataDir, "hello-app."+testOrigin, "1.10")
+	c.Assert(os.MkdirAll(dataDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dataDir, "data"), make([]byte, 2000), 0644), IsNil)
+
+	part := NewRemoteSnapPart(remote.Snap{Name: "hello-app", Origin: testOrigin, Version: "2.0"})
+	r := PreflightPart(part, 0)
+	c.Check(findCheck(c, r, "installed").Detail, Equals, "upgrade from 1.10")
+	c.Check(r.Err(), DeepEquals, &ErrPreflightFailed{Snap: "hello-app", Failed: []string{"apps space", "data space"}})
+}
+
+func (s *SnapTestSuite) TestPreflightOem(c *C) {
+	mockFreeSpace(1000, nil)
+
+	part := NewRemoteSnapPart(remote.Snap{Name: "oem", Origin: "bar", Version: "1.0", Type: pkg.TypeOem})
+
+	r := PreflightPart(part, 0)
+	c.Check(r.Err(), DeepEquals, &ErrPreflightFailed{Snap: "oem", Failed: []string{"oem"}})
+
+	r = PreflightPart(part, AllowOEM)
+	c.Check(r.Err(), IsNil)
+	c.Check(findCheck(c, r, "oem").Detail, Equals, "allowed")
+
+	getOem = func() (*packageYaml, error) {
+		return &packageYaml{Name: "oem", Type: pkg.TypeOem}, nil
+	}
+	defer func() { getOem = getOemImpl }()
+
+	r = PreflightPart(part, 0)
+	c.Check(r.Err(), IsNil)
+	c.Check(findCheck(c, r, "oem").Detail, Equals, "upgrade of the oem snap")
+}
+
+func (s *SnapTestSuite) TestPreflightNotOem(c *C) {
+	mockFreeSpace(1000, nil)
+
+	part := NewRemoteSnapPart(remote.Snap{Name: "foo", Origin: "bar", Version: "1.0"})
+
+	r := PreflightPart(part, 0)
+	c.Check(r.Err(), IsNil)
+	for _, check := range r.Checks {
+		c.Check(check.Name, Not(Equals), "oem")
+	}
+}
+
+func (s *SnapTestSuite) TestPreflightSnapFile(c *C) {
+	mockFreeSpace(1000*1024*1024, nil)
+	findFramework = func(name string) (Part, error) {
+		return nil, ErrPackageNotFound
+	}
+
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: 1.0\nvendor: foo\nframeworks:\n - fmk\n")
+
+	r, err := Preflight(snapFile, "", AllowUnauthenticated)
+	c.Assert(err, IsNil)
+	c.Check(r.Name, Equals, "foo")
+	c.Check(r.Origin, Equals, SideloadedOrigin)
+	c.Check(r.InstalledSize > 0, Equals, true)
+	c.Check(r.InstalledSize%1024, Equals, int64(0))
+	c.Check(r.Err(), DeepEquals, &ErrPreflightFailed{Snap: "foo", Failed: []string{"frameworks"}})
+	c.Check(findCheck(c, r, "frameworks").Detail, Equals, "missing frameworks: fmk")
+
+	// and nothing got installed
+	installed, err := ListInstalled()
+	c.Assert(err, IsNil)
+	c.Check(installed, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestPreflightVersionAndChannel(c *C) {
+	mockFreeSpace(1000, nil)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/details/foo.bar":
+			c.Check(r.URL.Query().Get("channel"), Equals, "beta")
+			io.WriteString(w, `{"package_name": "foo", "version": "2", "origin": "bar"}`)
+		case "/details/foo.bar/revisions":
+			io.WriteString(w, `[{"package_name": "foo", "version": "2", "origin": "bar"}, {"package_name": "foo", "version": "1", "origin": "bar"}]`)
+		default:
+			panic("unexpected url path: " + r.URL.Path)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	r, err := Preflight("foo.bar=1", "beta", 0)
+	c.Assert(err, IsNil)
+	c.Check(r.Name, Equals, "foo")
+	c.Check(r.Version, Equals, "1")
+	c.Check(r.Channel, Equals, "beta")
+
+	_, err = Preflight("foo.bar=3", "beta", 0)
+	c.Check(err, DeepEquals, &ErrVersionNotFound{Snap: "foo", Version: "3"})
+	_, err = Preflight("foo.bar", "-beta", 0)
+	c.Check(err, Equals, ErrInvalidChannel("-beta"))
+}
+
+func (s *SnapTestSuite) TestPreflightUpdate(c *C) {
+	mockFreeSpace(1000*1024*1024, nil)
+
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/details/hello-app."+testOrigin)
+		io.WriteString(w, `{"package_name": "hello-app", "version": "2.0", "origin": "`+testOrigin+`"}`)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	// installing it again fails, like InstallFromChannel does
+	r, err := Preflight("hello-app."+testOrigin, "", 0)
+	c.Assert(err, IsNil)
+	c.Check(r.Err(), DeepEquals, &ErrPreflightFailed{Snap: "hello-app", Failed: []string{"installed"}})
+
+	// updating it does not
+	r, err = PreflightUpdate("hello-app."+testOrigin, "", 0)
+	c.Assert(err, IsNil)
+	c.Check(r.Err(), IsNil)
+	c.Check(findCheck(c, r, "installed").Detail, Equals, "upgrade from 1.10")
+}
+
+func (s *SnapTestSuite) TestPreflightFileNamedLikePackage(c *C) {
+	mockFreeSpace(1000, nil)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"package_name": "foo", "version": "1.0", "origin": "bar"}`)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	defer os.Chdir(cwd)
+	c.Assert(os.Chdir(c.MkDir()), IsNil)
+	c.Assert(ioutil.WriteFile("foo.bar", []byte("not a snap"), 0644), IsNil)
+
+	r, err := Preflight("foo.bar", "", 0)
+	c.Assert(err, IsNil)
+	c.Check(r.Origin, Equals, "bar")
+	c.Check(r.Err(), IsNil)
+
+	_, err = Preflight("./foo.bar", "", 0)
+	c.Check(err, NotNil)
+	_, err = Preflight("missing.snap", "", 0)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SnapTestSuite) TestPreflightOemStore(c *C) {
+	mockFreeSpace(1000, nil)
+
+	getOem = func() (*packageYaml, error) {
+		return &packageYaml{Name: "oem", Type: pkg.TypeOem, OEM: OEM{Store: Store{ID: "my-store"}}}, nil
+	}
+	defer func() { getOem = getOemImpl }()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("X-Ubuntu-Store"), Equals, "my-store")
+		switch r.URL.Path {
+		case "/details/foo.bar":
+			io.WriteString(w, `{"package_name": "foo", "version": "1.0", "origin": "bar"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	r := PreflightPart(NewRemoteSnapPart(remote.Snap{Name: "foo", Origin: "bar", Version: "1.0"}), 0)
+	c.Check(r.Err(), IsNil)
+	c.Check(findCheck(c, r, "store").Detail, Equals, "in store my-store")
+
+	// not in the oem store, the install would not find it either
+	r = PreflightPart(NewRemoteSnapPart(remote.Snap{Name: "other", Origin: "bar", Version: "1.0"}), 0)
+	c.Check(r.Err(), DeepEquals, &ErrPreflightFailed{Snap: "other", Failed: []string{"store"}})
+	c.Check(findCheck(c, r, "store").Detail, Equals, "not in store my-store")
+}
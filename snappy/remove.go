@@ -50,7 +50,11 @@ func Remove(partSpec string, flags RemoveFlags, meter progress.Meter) error {
 		parts = FindSnapsByNameAndVersion(name, version, installed)
 	} else {
 		if (flags & DoRemoveGC) == 0 {
-			if part := ActiveSnapByName(partSpec); part != nil {
+			part, err := activeSnapByNameAndOrigin(SplitOrigin(partSpec))
+			if err != nil {
+				return err
+			}
+			if part != nil {
 				parts = append(parts, part)
 			}
 		} else {
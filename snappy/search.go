@@ -19,11 +19,50 @@
 
 package snappy
 
-import "strings"
+import (
+	"net"
+	"net/url"
+	"strings"
+)
 
 // Search searches all repositories with the given keywords in the args slice
 func Search(args []string) (SharedNames, error) {
+	searchTerm := strings.Join(args, ",")
+
+	seed := NewSeedSnapRepository(seedDir())
+	if seed == nil {
+		m := NewUbuntuStoreSnapRepository()
+
+		return m.Search(searchTerm)
+	}
+
+	results, err := seed.Search(searchTerm)
+	if err != nil {
+		return nil, err
+	}
+
 	m := NewUbuntuStoreSnapRepository()
+	storeResults, err := m.Search(searchTerm)
+	// with a seed we can live without the network
+	_, netError := err.(net.Error)
+	_, urlError := err.(*url.Error)
+	switch {
+	case netError || urlError:
+		return results, nil
+	case err != nil:
+		return nil, err
+	}
+
+	for name, sharedName := range storeResults {
+		if _, ok := results[name]; !ok {
+			results[name] = sharedName
+			continue
+		}
+		results[name].Parts = append(results[name].Parts, sharedName.Parts...)
+		if results[name].Alias == nil {
+			results[name].Alias = sharedName.Alias
+		}
+	}
 
-	return m.Search(strings.Join(args, ","))
+	return results, nil
 }
@@ -0,0 +1,353 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+	"github.com/ubuntu-core/snappy/pkg/snapfs"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// seedIndexFile is the name of the index file inside a seed directory
+const seedIndexFile = "index.json"
+
+// seedDir returns the directory the seed repository is read from; it
+// can be pointed somewhere else (e.g. a usb stick) via SNAPPY_SEED_DIR
+func seedDir() string {
+	if dir := os.Getenv("SNAPPY_SEED_DIR"); dir != "" {
+		return dir
+	}
+
+	return dirs.SnapSeedDir
+}
+
+// seedIndexEntry is a single snap in the seed index; it carries the
+// same metadata the store would give us, plus the name of the snap
+// file relative to the seed directory
+type seedIndexEntry struct {
+	remote.Snap
+	File string `json:"file"`
+}
+
+// SnapSeedRepository is a repository of snaps in a local directory,
+// e.g. a usb stick or a factory seed, described by an index file
+type SnapSeedRepository struct {
+	path    string
+	entries []seedIndexEntry
+}
+
+// NewSeedSnapRepository returns a new SnapSeedRepository for the given
+// path, or nil if there is no seed index in it
+func NewSeedSnapRepository(path string) *SnapSeedRepository {
+	if !helpers.FileExists(filepath.Join(path, seedIndexFile)) {
+		return nil
+	}
+
+	return &SnapSeedRepository{path: path}
+}
+
+// Description describes the seed repository
+func (s *SnapSeedRepository) Description() string {
+	return fmt.Sprintf("Snap seed repository for %s", s.path)
+}
+
+// load reads the index, once
+func (s *SnapSeedRepository) load() ([]seedIndexEntry, error) {
+	if s.entries != nil {
+		return s.entries, nil
+	}
+
+	indexFile := filepath.Join(s.path, seedIndexFile)
+	content, err := ioutil.ReadFile(indexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []seedIndexEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, &ErrInvalidSeedIndex{File: indexFile, Err: err}
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Name == "" || entry.Version == "" || entry.Origin == "" || entry.File == "" {
+			return nil, &ErrInvalidSeedIndex{File: indexFile, Err: fmt.Errorf("entry %q is missing the name, version, origin or file", entry.Name)}
+		}
+
+		entry.File, err = seedRelPath(entry.File)
+		if err != nil {
+			return nil, &ErrInvalidSeedIndex{File: indexFile, Err: err}
+		}
+		if entry.IconURL != "" && !strings.Contains(entry.IconURL, "://") {
+			entry.IconURL, err = seedRelPath(entry.IconURL)
+			if err != nil {
+				return nil, &ErrInvalidSeedIndex{File: indexFile, Err: err}
+			}
+		}
+	}
+
+	// newest first, so that the first match is the best one
+	sort.Stable(sort.Reverse(seedByVersion(entries)))
+	s.entries = entries
+
+	return entries, nil
+}
+
+// seedRelPath cleans the given path from the seed index, which must be
+// relative to and stay inside the seed directory
+func seedRelPath(path string) (string, error) {
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%q is not a path inside the seed", path)
+	}
+
+	return clean, nil
+}
+
+func (s *SnapSeedRepository) part(entry seedIndexEntry) *SeedSnapPart {
+	return &SeedSnapPart{
+		RemoteSnapPart: RemoteSnapPart{pkg: entry.Snap},
+		path:           filepath.Join(s.path, entry.File),
+		seedDir:        s.path,
+	}
+}
+
+// Details returns details for the given snap in the seed, newest first
+func (s *SnapSeedRepository) Details(name string, origin string) (parts []Part, err error) {
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == name && (origin == "" || entry.Origin == origin) {
+			parts = append(parts, s.part(entry))
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil, ErrPackageNotFound
+	}
+
+	return parts, nil
+}
+
+// All the parts in the seed
+func (s *SnapSeedRepository) All() ([]Part, error) {
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]Part, len(entries))
+	for i, entry := range entries {
+		parts[i] = s.part(entry)
+	}
+
+	return parts, nil
+}
+
+// Search searches the seed for the given (comma separated) searchTerm
+func (s *SnapSeedRepository) Search(searchTerm string) (SharedNames, error) {
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := strings.Split(strings.ToLower(searchTerm), ",")
+	sharedNames := make(SharedNames)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		// only offer the newest version of each
+		fullName := entry.Name + "." + entry.Origin
+		if seen[fullName] || !entry.matches(terms) {
+			continue
+		}
+		seen[fullName] = true
+
+		snap := s.part(entry)
+		if _, ok := sharedNames[entry.Name]; !ok {
+			sharedNames[entry.Name] = new(SharedName)
+		}
+		sharedNames[entry.Name].Parts = append(sharedNames[entry.Name].Parts, snap)
+		if entry.Alias != "" {
+			sharedNames[entry.Name].Alias = snap
+		}
+	}
+
+	return sharedNames, nil
+}
+
+func (e *seedIndexEntry) matches(terms []string) bool {
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		for _, field := range []string{e.Name, e.Title, e.Description} {
+			if strings.Contains(strings.ToLower(field), term) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Updates returns the snaps in the seed that are newer than the active
+// ones
+func (s *SnapSeedRepository) Updates() (parts []Part, err error) {
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := ActiveSnapsByType(pkg.TypeApp, pkg.TypeFramework, pkg.TypeOem)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, current := range active {
+		for _, entry := range entries {
+			if entry.Name != current.Name() || entry.Origin != current.Origin() {
+				continue
+			}
+			// entries are sorted, so this is the newest one
+			if VersionCompare(entry.Version, current.Version()) > 0 {
+				parts = append(parts, s.part(entry))
+			}
+			break
+		}
+	}
+
+	return parts, nil
+}
+
+// Installed returns the installed snaps from this repository
+func (s *SnapSeedRepository) Installed() (parts []Part, err error) {
+	return nil, err
+}
+
+// SeedSnapPart represents a snap available in a seed directory
+type SeedSnapPart struct {
+	RemoteSnapPart
+
+	path    string
+	seedDir string
+}
+
+// localIcon returns the path to the icon in the seed, if it has one
+func (s *SeedSnapPart) localIcon() string {
+	icon := s.pkg.IconURL
+	if icon == "" || strings.Contains(icon, "://") {
+		return ""
+	}
+
+	return filepath.Join(s.seedDir, icon)
+}
+
+// Icon returns the icon
+func (s *SeedSnapPart) Icon() string {
+	if icon := s.localIcon(); icon != "" {
+		return icon
+	}
+
+	return s.pkg.IconURL
+}
+
+// Download copies the snap out of the seed and returns the filename
+func (s *SeedSnapPart) Download(pbar progress.Meter) (string, error) {
+	if err := os.MkdirAll(dirs.SnapDownloadCacheDir, 0755); err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(dirs.SnapDownloadCacheDir, fmt.Sprintf("%s_%s.snap", QualifiedName(s), s.Version()))
+	if err := helpers.CopyFile(s.path, target, helpers.CopyFlagOverwrite); err != nil {
+		return "", err
+	}
+
+	// and the detached signature, if there is one
+	if sig := s.path + snapfs.SignatureExt; helpers.FileExists(sig) {
+		if err := helpers.CopyFile(sig, target+snapfs.SignatureExt, helpers.CopyFlagOverwrite); err != nil {
+			os.Remove(target)
+			return "", err
+		}
+	}
+
+	if s.pkg.DownloadSha512 == "" {
+		return target, nil
+	}
+
+	got, err := helpers.Sha512sum(target)
+	if err != nil {
+		os.Remove(target)
+		return "", err
+	}
+	if got != s.pkg.DownloadSha512 {
+		os.Remove(target)
+		return "", &ErrHashMismatch{File: s.path, Expected: s.pkg.DownloadSha512, Got: got}
+	}
+
+	return target, nil
+}
+
+// Install installs the snap from the seed
+func (s *SeedSnapPart) Install(pbar progress.Meter, flags InstallFlags) (string, error) {
+	snapFile, err := s.Download(pbar)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(snapFile)
+	defer os.Remove(snapFile + snapfs.SignatureExt)
+
+	if icon := s.localIcon(); icon != "" && helpers.FileExists(icon) {
+		if err := os.MkdirAll(dirs.SnapIconsDir, 0755); err != nil {
+			return "", err
+		}
+		if err := helpers.CopyFile(icon, iconPath(s), helpers.CopyFlagOverwrite); err != nil {
+			return "", err
+		}
+	}
+
+	return s.installDownloaded(snapFile, pbar, flags)
+}
+
+type seedByVersion []seedIndexEntry
+
+func (bv seedByVersion) Less(a, b int) bool {
+	return VersionCompare(bv[a].Version, bv[b].Version) < 0
+}
+func (bv seedByVersion) Swap(a, b int) {
+	bv[a], bv[b] = bv[b], bv[a]
+}
+func (bv seedByVersion) Len() int {
+	return len(bv)
+}
@@ -0,0 +1,256 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+const seedIndex = `[
+ {"package_name": "foo", "origin": "bar", "version": "1.0", "file": "foo_1.0.snap", "title": "the foo"},
+ {"package_name": "foo", "origin": "bar", "version": "2.0", "file": "foo_2.0.snap", "title": "the foo"},
+ {"package_name": "foo", "origin": "baz", "version": "1.5", "file": "foo.baz_1.5.snap"},
+ {"package_name": "hello-app", "origin": "testspacethename", "version": "1.9", "file": "hello-app_1.9.snap"},
+ {"package_name": "hello-app", "origin": "testspacethename", "version": "2.0", "file": "hello-app_2.0.snap", "icon_url": "hello.png"}
+]`
+
+func makeSeed(c *C, dir, index string) {
+	c.Assert(os.MkdirAll(dir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, seedIndexFile), []byte(index), 0644), IsNil)
+}
+
+func (s *SnapTestSuite) TestSeedRepositoryNoIndex(c *C) {
+	c.Check(NewSeedSnapRepository(c.MkDir()), IsNil)
+}
+
+func (s *SnapTestSuite) TestSeedDirFromEnv(c *C) {
+	c.Check(seedDir(), Equals, dirs.SnapSeedDir)
+
+	os.Setenv("SNAPPY_SEED_DIR", "/media/usb")
+	defer os.Unsetenv("SNAPPY_SEED_DIR")
+	c.Check(seedDir(), Equals, "/media/usb")
+}
+
+func (s *SnapTestSuite) TestSeedRepositoryInvalidIndex(c *C) {
+	makeSeed(c, dirs.SnapSeedDir, `[{"package_name": "foo"}]`)
+
+	repo := NewSeedSnapRepository(dirs.SnapSeedDir)
+	c.Assert(repo, NotNil)
+	_, err := repo.All()
+	c.Assert(err, FitsTypeOf, &ErrInvalidSeedIndex{})
+}
+
+func (s *SnapTestSuite) TestSeedRepositoryIndexNeedsOrigin(c *C) {
+	makeSeed(c, dirs.SnapSeedDir, `[{"package_name": "foo", "version": "1.0", "file": "foo_1.0.snap"}]`)
+
+	_, err := NewSeedSnapRepository(dirs.SnapSeedDir).All()
+	c.Assert(err, FitsTypeOf, &ErrInvalidSeedIndex{})
+	c.Check(err, ErrorMatches, `invalid seed index .*: entry "foo" is missing the name, version, origin or file`)
+}
+
+func (s *SnapTestSuite) TestSeedRepositoryIndexPathsStayInSeed(c *C) {
+	for _, entry := range []string{
+		`"file": "/etc/shadow"`,
+		`"file": "../foo_1.0.snap"`,
+		`"file": "snaps/../../foo_1.0.snap"`,
+		`"file": "."`,
+		`"file": "foo_1.0.snap", "icon_url": "/etc/shadow"`,
+		`"file": "foo_1.0.snap", "icon_url": "icons/../../../etc/shadow"`,
+	} {
+		makeSeed(c, dirs.SnapSeedDir, `[{"package_name": "foo", "origin": "bar", "version": "1.0", `+entry+`}]`)
+
+		_, err := NewSeedSnapRepository(dirs.SnapSeedDir).All()
+		c.Check(err, ErrorMatches, `invalid seed index .*: ".*" is not a path inside the seed`, Commentf(entry))
+	}
+
+	makeSeed(c, dirs.SnapSeedDir, `[{"package_name": "foo", "origin": "bar", "version": "1.0", "file": "./snaps//foo_1.0.snap", "icon_url": "icons/../foo.png"}]`)
+	parts, err := NewSeedSnapRepository(dirs.SnapSeedDir).All()
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Check(parts[0].(*SeedSnapPart).path, Equals, filepath.Join(dirs.SnapSeedDir, "snaps", "foo_1.0.snap"))
+	c.Check(parts[0].Icon(), Equals, filepath.Join(dirs.SnapSeedDir, "foo.png"))
+}
+
+func (s *SnapTestSuite) TestSeedRepositoryDetails(c *C) {
+	makeSeed(c, dirs.SnapSeedDir, seedIndex)
+	repo := NewSeedSnapRepository(dirs.SnapSeedDir)
+
+	parts, err := repo.Details("foo", "")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 3)
+	c.Check(parts[0].Version(), Equals, "2.0")
+	c.Check(parts[0].Origin(), Equals, "bar")
+	c.Check(parts[0].Description(), Equals, "the foo")
+
+	parts, err = repo.Details("foo", "baz")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Check(parts[0].Version(), Equals, "1.5")
+
+	_, err = repo.Details("potato", "")
+	c.Check(err, Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestSeedRepositorySearch(c *C) {
+	makeSeed(c, dirs.SnapSeedDir, seedIndex)
+	repo := NewSeedSnapRepository(dirs.SnapSeedDir)
+
+	results, err := repo.Search("potato,FOO")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Assert(results["foo"].Parts, HasLen, 2)
+	c.Check(results["foo"].Parts[0].Version(), Equals, "2.0")
+	c.Check(results["foo"].Parts[1].Version(), Equals, "1.5")
+}
+
+func (s *SnapTestSuite) TestSeedSearchWithoutNetwork(c *C) {
+	makeSeed(c, dirs.SnapSeedDir, seedIndex)
+
+	// the store urls are bogus in the tests, so this is
+	// equivalent to not having a network
+	results, err := Search([]string{"hello"})
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results["hello-app"].Parts[0].Version(), Equals, "2.0")
+}
+
+func (s *SnapTestSuite) TestSeedRepositoryUpdates(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	makeSeed(c, dirs.SnapSeedDir, seedIndex)
+	repo := NewSeedSnapRepository(dirs.SnapSeedDir)
+
+	updates, err := repo.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 1)
+	c.Check(updates[0].Name(), Equals, "hello-app")
+	c.Check(updates[0].Version(), Equals, "2.0")
+	c.Check(updates[0].Icon(), Equals, filepath.Join(dirs.SnapSeedDir, "hello.png"))
+}
+
+func (s *SnapTestSuite) TestSeedRepositoryNoDowngrades(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	makeSeed(c, dirs.SnapSeedDir, `[
+ {"package_name": "hello-app", "origin": "testspacethename", "version": "1.9", "file": "hello-app_1.9.snap"}
+]`)
+	repo := NewSeedSnapRepository(dirs.SnapSeedDir)
+
+	updates, err := repo.Updates()
+	c.Assert(err, IsNil)
+	c.Check(updates, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestMetaRepositoryUpdatesPicksNewest(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	seedA := filepath.Join(c.MkDir(), "a")
+	makeSeed(c, seedA, `[
+ {"package_name": "hello-app", "origin": "testspacethename", "version": "2.0", "file": "hello-app_2.0.snap"}
+]`)
+	seedB := filepath.Join(c.MkDir(), "b")
+	makeSeed(c, seedB, `[
+ {"package_name": "hello-app", "origin": "testspacethename", "version": "3.0", "file": "hello-app_3.0.snap"}
+]`)
+
+	m := &MetaRepository{all: []Repository{
+		NewSeedSnapRepository(seedA),
+		NewSeedSnapRepository(seedB),
+		NewSeedSnapRepository(seedA),
+	}}
+	updates, err := m.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 1)
+	c.Check(updates[0].Version(), Equals, "3.0")
+}
+
+func (s *SnapTestSuite) TestMetaStoreRepositoryUsesSeed(c *C) {
+	seed := c.MkDir()
+	makeSeed(c, seed, seedIndex)
+	os.Setenv("SNAPPY_SEED_DIR", seed)
+	defer os.Unsetenv("SNAPPY_SEED_DIR")
+
+	parts, err := NewMetaStoreRepository().Details("foo", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 2)
+	c.Check(parts[0].(*SeedSnapPart).path, Equals, filepath.Join(seed, "foo_2.0.snap"))
+}
+
+func (s *SnapTestSuite) TestSeedSnapPartDownload(c *C) {
+	makeSeed(c, dirs.SnapSeedDir, `[
+ {"package_name": "foo", "origin": "bar", "version": "1.0", "file": "foo_1.0.snap", "download_sha512": "`+sha512hex("snap")+`"}
+]`)
+	snapFile := filepath.Join(dirs.SnapSeedDir, "foo_1.0.snap")
+	c.Assert(ioutil.WriteFile(snapFile, []byte("snap"), 0644), IsNil)
+
+	parts, err := NewSeedSnapRepository(dirs.SnapSeedDir).Details("foo", "")
+	c.Assert(err, IsNil)
+	fn, err := parts[0].(*SeedSnapPart).Download(nil)
+	c.Assert(err, IsNil)
+	c.Check(fn, Equals, filepath.Join(dirs.SnapDownloadCacheDir, "foo.bar_1.0.snap"))
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "snap")
+
+	// the seed is left alone
+	c.Check(helpers.FileExists(snapFile), Equals, true)
+
+	// a corrupted file in the seed is detected
+	c.Assert(ioutil.WriteFile(snapFile, []byte("bogus"), 0644), IsNil)
+	_, err = parts[0].(*SeedSnapPart).Download(nil)
+	c.Assert(err, FitsTypeOf, &ErrHashMismatch{})
+	c.Check(helpers.FileExists(fn), Equals, false)
+}
+
+func (s *SnapTestSuite) TestSeedSnapPartInstall(c *C) {
+	snapPackage := makeTestSnapPackage(c, "")
+	sha, err := helpers.Sha512sum(snapPackage)
+	c.Assert(err, IsNil)
+
+	makeSeed(c, dirs.SnapSeedDir, `[
+ {"package_name": "foo", "origin": "bar", "version": "1.0", "file": "foo_1.0.snap", "icon_url": "foo.png", "download_sha512": "`+sha+`"}
+]`)
+	c.Assert(helpers.CopyFile(snapPackage, filepath.Join(dirs.SnapSeedDir, "foo_1.0.snap"), 0), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapSeedDir, "foo.png"), []byte("icon"), 0644), IsNil)
+
+	name, err := Install("foo", AllowUnauthenticated, &MockProgressMeter{})
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "foo")
+
+	installed, err := ListInstalled()
+	c.Assert(err, IsNil)
+	c.Assert(installed, HasLen, 1)
+	c.Check(installed[0].Origin(), Equals, "bar")
+	c.Check(installed[0].Icon(), Equals, filepath.Join(dirs.SnapIconsDir, "foo.bar_1.0.png"))
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBlobDir, "foo.bar_1.0.snap")), Equals, true)
+}
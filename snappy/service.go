@@ -81,7 +81,7 @@ func FindServices(snapName string, serviceName string, pb progress.Meter) (Servi
 			// can't happen
 			continue
 		}
-		if snapName != "" && snapName != snap.Name() {
+		if snapName != "" && snapName != snap.Name() && snapName != QualifiedName(snap) {
 			continue
 		}
 		foundSnap = true
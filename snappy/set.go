@@ -28,7 +28,9 @@ import (
 
 // map from
 var setFuncs = map[string]func(k, v string, pb progress.Meter) error{
-	"active": makeSnapActiveByNameAndVersion,
+	"active":           makeSnapActiveByNameAndVersion,
+	"channel":          SetChannel,
+	"parallel-origins": SetParallelOrigins,
 }
 
 // SetProperty sets a property for the given pkgname from the args list
@@ -44,6 +44,7 @@ import (
 	"github.com/ubuntu-core/snappy/oauth"
 	"github.com/ubuntu-core/snappy/pkg"
 	"github.com/ubuntu-core/snappy/pkg/remote"
+	"github.com/ubuntu-core/snappy/pkg/snapfs"
 	"github.com/ubuntu-core/snappy/policy"
 	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/release"
@@ -162,12 +163,15 @@ type ServiceYaml struct {
 	Ports *Ports `yaml:"ports,omitempty" json:"ports,omitempty"`
 
 	SecurityDefinitions `yaml:",inline"`
+
+	// must be a pointer so that it can be "nil" and omitempty works
+	Health *HealthCheck `yaml:"health,omitempty" json:"health,omitempty"`
 }
 
 // Binary represents a single binary inside the binaries: package.yaml
 type Binary struct {
-	Name string `yaml:"name"`
-	Exec string `yaml:"exec"`
+	Name string `yaml:"name" json:"name"`
+	Exec string `yaml:"exec" json:"exec,omitempty"`
 
 	SecurityDefinitions `yaml:",inline"`
 }
@@ -237,6 +241,13 @@ type packageYaml struct {
 
 	ExplicitLicenseAgreement bool   `yaml:"explicit-license-agreement,omitempty"`
 	LicenseVersion           string `yaml:"license-version,omitempty"`
+
+	// how the data is carried over to a new version
+	DataSnapshot string `yaml:"data-snapshot,omitempty"`
+
+	// the qualified name the binaries and services are named after,
+	// if the package is installed alongside others of the same name
+	namespace string
 }
 
 type searchResults struct {
@@ -253,9 +264,19 @@ func parsePackageYamlFile(yamlPath string) (*packageYaml, error) {
 	}
 
 	// legacy support sucks :-/
-	hasConfig := helpers.FileExists(filepath.Join(filepath.Dir(yamlPath), "hooks", "config"))
+	hooksDir := filepath.Join(filepath.Dir(yamlPath), "hooks")
+	hasConfig := helpers.FileExists(filepath.Join(hooksDir, "config"))
 
-	return parsePackageYamlData(yamlData, hasConfig)
+	m, err := parsePackageYamlData(yamlData, hasConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasLifecycleHooks(hooksDir) {
+		m.addLifecycleHooksIntegration()
+	}
+
+	return m, nil
 }
 
 func validatePackageYamlData(file string, yamlData []byte, m *packageYaml) error {
@@ -291,6 +312,9 @@ func validatePackageYamlData(file string, yamlData []byte, m *packageYaml) error
 		if err := verifyServiceYaml(service); err != nil {
 			return err
 		}
+		if err := verifyHealthCheck(service); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -354,6 +378,16 @@ func (m *packageYaml) qualifiedName(origin string) string {
 	return m.Name + "." + origin
 }
 
+// unitName returns the name the binaries and services of the package
+// are named after
+func (m *packageYaml) unitName() string {
+	if m.namespace != "" {
+		return m.namespace
+	}
+
+	return m.Name
+}
+
 func (m *packageYaml) checkForNameClashes() error {
 	d := make(map[string]struct{})
 	for _, bin := range m.Binaries {
@@ -374,11 +408,16 @@ func (m *packageYaml) checkForPackageInstalled(origin string) error {
 		return nil
 	}
 
-	if part.Origin() != origin {
-		return ErrPackageNameAlreadyInstalled
+	if part.Origin() == origin {
+		return nil
 	}
 
-	return nil
+	// what clashes are the names of the binaries and services
+	if canCoexist(m.Type) && (m.namespace != "" || isNamespaced(QualifiedName(part))) {
+		return nil
+	}
+
+	return ErrPackageNameAlreadyInstalled
 }
 
 func addCoreFmk(fmks []string) []string {
@@ -403,10 +442,12 @@ func (m *packageYaml) FrameworksForClick() string {
 	return strings.Join(fmks, ",")
 }
 
-func (m *packageYaml) checkForFrameworks() error {
+// missingFrameworks returns the frameworks needed by the snap that
+// are not active in the system
+func (m *packageYaml) missingFrameworks() ([]string, error) {
 	installed, err := ActiveSnapIterByType(BareName, pkg.TypeFramework)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	sort.Strings(installed)
 
@@ -419,6 +460,15 @@ func (m *packageYaml) checkForFrameworks() error {
 		}
 	}
 
+	return missing, nil
+}
+
+func (m *packageYaml) checkForFrameworks() error {
+	missing, err := m.missingFrameworks()
+	if err != nil {
+		return err
+	}
+
 	if len(missing) > 0 {
 		return ErrMissingFrameworks(missing)
 	}
@@ -550,6 +600,13 @@ func NewSnapPartFromSnapFile(snapFile string, origin string, unauthOk bool) (*Sn
 		return nil, err
 	}
 
+	// a signed snapfs snap can only be installed for the origin it
+	// was signed for; only an unsigned snap allowed in as
+	// unauthenticated has no signed origin
+	if sd, ok := d.(*snapfs.Snap); ok && sd.SignedOrigin() != "" && origin != SideloadedOrigin && sd.SignedOrigin() != origin {
+		return nil, &ErrSignedOriginMismatch{Origin: origin, SignedOrigin: sd.SignedOrigin()}
+	}
+
 	yamlData, err := d.MetaMember("package.yaml")
 	if err != nil {
 		return nil, err
@@ -563,6 +620,13 @@ func NewSnapPartFromSnapFile(snapFile string, origin string, unauthOk bool) (*Sn
 		return nil, err
 	}
 
+	for _, hook := range lifecycleHooks {
+		if _, err := d.MetaMember("hooks/" + hook); err == nil {
+			m.addLifecycleHooksIntegration()
+			break
+		}
+	}
+
 	targetDir := dirs.SnapAppsDir
 	// the "oem" parts are special
 	if m.Type == pkg.TypeOem {
@@ -576,6 +640,12 @@ func NewSnapPartFromSnapFile(snapFile string, origin string, unauthOk bool) (*Sn
 	fullName := m.qualifiedName(origin)
 	instDir := filepath.Join(targetDir, fullName, m.Version)
 
+	// a package keeps the names it was first installed with, which
+	// clash with another origin of it if that is installed
+	if isNamespaced(fullName) || (canCoexist(m.Type) && !helpers.FileExists(filepath.Dir(instDir)) && otherOriginInstalled(m.Name, origin)) {
+		m.namespace = fullName
+	}
+
 	return &SnapPart{
 		basedir: instDir,
 		origin:  origin,
@@ -601,6 +671,10 @@ func NewSnapPartFromYaml(yamlPath, origin string, m *packageYaml) (*SnapPart, er
 	// and origin is empty for frameworks, even sideloaded ones.
 	m.Version = filepath.Base(part.basedir)
 
+	if fullName := m.qualifiedName(origin); isNamespaced(fullName) {
+		m.namespace = fullName
+	}
+
 	// check if the part is active
 	allVersionsDir := filepath.Dir(part.basedir)
 	p, err := filepath.EvalSymlinks(filepath.Join(allVersionsDir, "current"))
@@ -620,17 +694,21 @@ func NewSnapPartFromYaml(yamlPath, origin string, m *packageYaml) (*SnapPart, er
 	// read hash, its ok if its not there, some older versions of
 	// snappy did not write this file
 	hashesData, err := ioutil.ReadFile(filepath.Join(part.basedir, "meta", "hashes.yaml"))
-	if err != nil {
+	switch {
+	case err == nil:
+		var h hashesYaml
+		err = yaml.Unmarshal(hashesData, &h)
+		if err != nil {
+			return nil, &ErrInvalidYaml{File: "hashes.yaml", Err: err, Yaml: hashesData}
+		}
+		part.hash = h.ArchiveSha512
+	case os.IsNotExist(err) && part.isMounted():
+		// mounted snaps are read-only, there is nowhere to
+		// write the hashes to
+	default:
 		return nil, err
 	}
 
-	var h hashesYaml
-	err = yaml.Unmarshal(hashesData, &h)
-	if err != nil {
-		return nil, &ErrInvalidYaml{File: "hashes.yaml", Err: err, Yaml: hashesData}
-	}
-	part.hash = h.ArchiveSha512
-
 	remoteManifestPath := RemoteManifestPath(part)
 	if helpers.FileExists(remoteManifestPath) {
 		content, err := ioutil.ReadFile(remoteManifestPath)
@@ -704,8 +782,13 @@ func (s *SnapPart) Hash() string {
 	return s.hash
 }
 
-// Channel returns the channel used
+// Channel returns the channel tracked, or the channel used if none was
+// set
 func (s *SnapPart) Channel() string {
+	if ch := trackedChannel(QualifiedName(s)); ch != "" {
+		return ch
+	}
+
 	if r := s.remoteM; r != nil {
 		return r.Channel
 	}
@@ -782,7 +865,6 @@ func (s *SnapPart) OemConfig() SystemConfig {
 // Install installs the snap
 func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name string, err error) {
 	allowOEM := (flags & AllowOEM) != 0
-	inhibitHooks := (flags & InhibitHooks) != 0
 
 	if s.IsInstalled() {
 		return "", ErrAlreadyInstalled
@@ -792,6 +874,13 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 		return "", err
 	}
 
+	return s.install(inter, flags)
+}
+
+// install installs the snap, which has passed CanInstall
+func (s *SnapPart) install(inter progress.Meter, flags InstallFlags) (name string, err error) {
+	inhibitHooks := (flags & InhibitHooks) != 0
+
 	manifestData, err := s.deb.ControlMember("manifest")
 	if err != nil {
 		logger.Noticef("Snap inspect failed for %q: %v", s.Name(), err)
@@ -830,20 +919,49 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 		}
 	}()
 
-	// we need to call the external helper so that we can reliable drop
-	// privs
-	if err := s.deb.UnpackWithDropPrivs(s.basedir, dirs.GlobalRootDir); err != nil {
-		return "", err
+	if s.m.namespace != "" && oldPart == nil {
+		if err := setNamespaced(fullName, true); err != nil {
+			return "", err
+		}
+		defer func() {
+			if err != nil {
+				if e := setNamespaced(fullName, false); e != nil {
+					logger.Noticef("Failed to remove the namespace of %s: %v", fullName, e)
+				}
+			}
+		}()
 	}
 
-	// legacy, the hooks (e.g. apparmor) need this. Once we converted
-	// all hooks this can go away
-	clickMetaDir := filepath.Join(s.basedir, ".click", "info")
-	if err := os.MkdirAll(clickMetaDir, 0755); err != nil {
-		return "", err
-	}
-	if err := writeCompatManifestJSON(clickMetaDir, manifestData, s.origin); err != nil {
-		return "", err
+	if s.deb.NeedsMountUnit() {
+		// the install dir is a read-only mount of the snap, so
+		// there is no compat manifest for mounted snaps
+		if err := s.installMount(inhibitHooks, inter); err != nil {
+			return "", err
+		}
+		defer func() {
+			if err != nil {
+				if e := s.removeMount(inter); e != nil {
+					logger.Noticef("Failed to remove the mount of %q: %v", s.basedir, e)
+				}
+				removeBlob(s)
+			}
+		}()
+	} else {
+		// we need to call the external helper so that we can reliable drop
+		// privs
+		if err := s.deb.UnpackWithDropPrivs(s.basedir, dirs.GlobalRootDir); err != nil {
+			return "", err
+		}
+
+		// legacy, the hooks (e.g. apparmor) need this. Once we converted
+		// all hooks this can go away
+		clickMetaDir := filepath.Join(s.basedir, ".click", "info")
+		if err := os.MkdirAll(clickMetaDir, 0755); err != nil {
+			return "", err
+		}
+		if err := writeCompatManifestJSON(clickMetaDir, manifestData, s.origin); err != nil {
+			return "", err
+		}
 	}
 
 	// write the hashes now
@@ -859,6 +977,13 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 	//
 	// otherwise just create a empty data dir
 	if oldPart != nil {
+		// give the old version a chance to prepare for the update
+		if !inhibitHooks {
+			if err = oldPart.runHook(hookPreRefresh, inter); err != nil {
+				return "", err
+			}
+		}
+
 		// we need to stop making it active
 		err = oldPart.deactivate(inhibitHooks, inter)
 		defer func() {
@@ -872,7 +997,7 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 			return "", err
 		}
 
-		err = copySnapData(fullName, oldPart.Version(), s.Version())
+		err = copySnapData(fullName, oldPart.Version(), s.Version(), s.m.DataSnapshot == dataSnapshotHardlink)
 	} else {
 		err = os.MkdirAll(dataDir, 0755)
 	}
@@ -902,6 +1027,24 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 		return "", err
 	}
 
+	// the install and post-refresh hooks need the security policy
+	// of the new version, so they run once it is active
+	if !inhibitHooks {
+		if oldPart != nil {
+			err = s.runHook(hookPostRefresh, inter)
+		} else {
+			err = s.runHook(hookInstall, inter)
+			if err != nil {
+				if cerr := s.deactivate(inhibitHooks, inter); cerr != nil {
+					logger.Noticef("When deactivating %s after a failed install hook: %v", s.Name(), cerr)
+				}
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// oh, one more thing: refresh the security bits
 	if !inhibitHooks {
 		deps, err := s.Dependents()
@@ -959,6 +1102,17 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 		}
 	}
 
+	// an update that breaks its services is rolled back to the old
+	// version by the deferred cleanups above
+	if oldPart != nil && !inhibitHooks {
+		if err = s.checkHealth(inter); err != nil {
+			if inter != nil {
+				inter.Notify(fmt.Sprintf("%s; going back to %s %s", err, s.Name(), oldPart.Version()))
+			}
+			return "", err
+		}
+	}
+
 	return s.Name(), nil
 }
 
@@ -980,6 +1134,11 @@ func (s *SnapPart) activate(inhibitHooks bool, inter interacter) error {
 		return nil
 	}
 
+	// inactive versions stay mounted, but make sure
+	if err := s.startMount(inhibitHooks, inter); err != nil {
+		return err
+	}
+
 	// there is already an active part
 	if currentActiveDir != "" {
 		// TODO: support switching origins
@@ -1113,6 +1272,14 @@ func (s *SnapPart) Uninstall(pb progress.Meter) (err error) {
 		return ErrFrameworkInUse(deps)
 	}
 
+	// only removing the active version removes the snap as far as
+	// the snap is concerned, old versions are just pruned
+	if s.IsActive() {
+		if err := s.runHook(hookRemove, pb); err != nil {
+			return err
+		}
+	}
+
 	if err := s.remove(pb); err != nil {
 		return err
 	}
@@ -1132,6 +1299,10 @@ func (s *SnapPart) remove(inter interacter) (err error) {
 		return err
 	}
 
+	if err := s.removeMount(inter); err != nil {
+		return err
+	}
+
 	err = os.RemoveAll(s.basedir)
 	if err != nil {
 		return err
@@ -1140,6 +1311,13 @@ func (s *SnapPart) remove(inter interacter) (err error) {
 	// best effort(?)
 	os.Remove(filepath.Dir(s.basedir))
 
+	// once the last version is gone the next install picks the names
+	if s.m.namespace != "" && !helpers.FileExists(filepath.Dir(s.basedir)) {
+		if err := setNamespaced(QualifiedName(s), false); err != nil {
+			logger.Noticef("Failed to remove the namespace of %s: %s", QualifiedName(s), err)
+		}
+	}
+
 	// don't fail if icon can't be removed
 	if helpers.FileExists(iconPath(s)) {
 		if err := os.Remove(iconPath(s)); err != nil {
@@ -1147,6 +1325,8 @@ func (s *SnapPart) remove(inter interacter) (err error) {
 		}
 	}
 
+	removeBlob(s)
+
 	return nil
 }
 
@@ -1221,6 +1401,12 @@ func (s *SnapPart) Dependents() ([]*SnapPart, error) {
 
 // CanInstall checks whether the SnapPart passes a series of tests required for installation
 func (s *SnapPart) CanInstall(allowOEM bool, inter interacter) error {
+	return s.canInstall(allowOEM, inter, true)
+}
+
+// canInstall is CanInstall, which only checks that the frameworks of
+// the snap are there if checkFrameworks is set
+func (s *SnapPart) canInstall(allowOEM bool, inter interacter, checkFrameworks bool) error {
 	if s.IsInstalled() {
 		return ErrAlreadyInstalled
 	}
@@ -1238,8 +1424,10 @@ func (s *SnapPart) CanInstall(allowOEM bool, inter interacter) error {
 		return err
 	}
 
-	if err := s.m.checkForFrameworks(); err != nil {
-		return err
+	if checkFrameworks {
+		if err := s.m.checkForFrameworks(); err != nil {
+			return err
+		}
 	}
 
 	if s.Type() == pkg.TypeOem {
@@ -1545,35 +1733,52 @@ func download(name string, w io.Writer, req *http.Request, pbar progress.Meter)
 	return err
 }
 
-// Download downloads the snap and returns the filename
+// Download downloads the snap and returns the filename. If the store
+// offers a delta against the currently installed version that is used
+// instead, falling back to the full snap if applying it fails.
+//
+// The download is resumed if an earlier one was interrupted, and the
+// result is verified against the sha512 the store gave us.
+//
+// If the store has a detached signature for the snap it is downloaded
+// next to it.
 func (s *RemoteSnapPart) Download(pbar progress.Meter) (string, error) {
-	w, err := ioutil.TempFile("", s.pkg.Name)
+	snapFile, err := s.downloadSnap(pbar)
 	if err != nil {
 		return "", err
 	}
-	defer func() {
-		if err != nil {
-			os.Remove(w.Name())
-		}
-	}()
-	defer w.Close()
 
-	// try anonymous download first and fallback to authenticated
-	url := s.pkg.AnonDownloadURL
-	if url == "" {
-		url = s.pkg.DownloadURL
-	}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
+	if err := s.downloadSignature(snapFile); err != nil {
+		os.Remove(snapFile)
 		return "", err
 	}
-	setUbuntuStoreHeaders(req)
 
-	if err := download(s.Name(), w, req, pbar); err != nil {
-		return "", err
+	return snapFile, nil
+}
+
+func (s *RemoteSnapPart) downloadSnap(pbar progress.Meter) (string, error) {
+	snapFile, err := s.downloadDelta(pbar)
+	switch err {
+	case nil:
+		return snapFile, nil
+	case errNoDelta:
+		// nothing to see here
+	default:
+		logger.Noticef("Delta update of %s failed, downloading the full snap: %v", s.Name(), err)
 	}
 
-	return w.Name(), w.Sync()
+	cacheName := fmt.Sprintf("%s_%s.snap", QualifiedName(s), s.Version())
+	return downloadToCache(s.pkg.Name, cacheName, s.pkg.AnonDownloadURL, s.pkg.DownloadURL, s.pkg.DownloadSha512, pbar)
+}
+
+// downloadSignature downloads the detached signature of the given snap
+// file, if the store has one
+func (s *RemoteSnapPart) downloadSignature(snapFile string) error {
+	if s.pkg.SignatureURL == "" {
+		return nil
+	}
+
+	return downloadAndVerify(s.pkg.Name+" (signature)", snapFile+snapfs.SignatureExt, s.pkg.SignatureURL, "", nil)
 }
 
 func (s *RemoteSnapPart) downloadIcon(pbar progress.Meter) error {
@@ -1625,16 +1830,49 @@ func (s *RemoteSnapPart) Install(pbar progress.Meter, flags InstallFlags) (strin
 		return "", err
 	}
 	defer os.Remove(downloadedSnap)
+	defer os.Remove(downloadedSnap + snapfs.SignatureExt)
 
 	if err := s.downloadIcon(pbar); err != nil {
 		return "", err
 	}
 
+	return s.installDownloaded(downloadedSnap, pbar, flags)
+}
+
+// installDownloaded installs the given snap file, which was obtained
+// for this RemoteSnapPart, and records the metadata we got for it
+func (s *RemoteSnapPart) installDownloaded(downloadedSnap string, pbar progress.Meter, flags InstallFlags) (string, error) {
 	if err := s.saveStoreManifest(); err != nil {
 		return "", err
 	}
 
-	return installClick(downloadedSnap, flags, pbar, s.Origin())
+	name, err := installClick(downloadedSnap, flags, pbar, s.Origin())
+	if err != nil {
+		return "", err
+	}
+
+	// keep the snap around so future updates can be deltas against
+	// it; mounted snaps are already kept, as what is mounted
+	if !s.isMountable(downloadedSnap) {
+		if err := keepBlob(downloadedSnap, s); err != nil {
+			logger.Noticef("Failed to keep snap blob for %s: %v", s.Name(), err)
+		}
+	}
+	pruneBlobs(s)
+
+	return name, nil
+}
+
+// isMountable returns true if the given snap file is installed by
+// mounting it rather than by unpacking it
+func (s *RemoteSnapPart) isMountable(snapFile string) bool {
+	d, err := OpenPackageFile(snapFile)
+	if err != nil {
+		return false
+	}
+	defer d.Close()
+
+	return d.NeedsMountUnit()
 }
 
 // SetActive sets the snap active
@@ -1748,6 +1986,17 @@ func NewUbuntuStoreSnapRepository() *SnapUbuntuStoreRepository {
 	}
 }
 
+// activeStoreID returns the id of the store snaps are looked up in: the
+// one from UBUNTU_STORE_ID, or the one setup by the oem package, or an
+// empty string for the default store
+func activeStoreID() string {
+	if storeID := os.Getenv("UBUNTU_STORE_ID"); storeID != "" {
+		return storeID
+	}
+
+	return StoreID()
+}
+
 // small helper that sets the correct http headers for the ubuntu store
 func setUbuntuStoreHeaders(req *http.Request) {
 	req.Header.Set("Accept", "application/hal+json")
@@ -1759,9 +2008,7 @@ func setUbuntuStoreHeaders(req *http.Request) {
 	req.Header.Set("X-Ubuntu-Release", release.String())
 	req.Header.Set("X-Ubuntu-Device-Channel", release.Get().Channel)
 
-	if storeID := os.Getenv("UBUNTU_STORE_ID"); storeID != "" {
-		req.Header.Set("X-Ubuntu-Store", storeID)
-	} else if storeID := StoreID(); storeID != "" {
+	if storeID := activeStoreID(); storeID != "" {
 		req.Header.Set("X-Ubuntu-Store", storeID)
 	}
 
@@ -1777,8 +2024,15 @@ func (s *SnapUbuntuStoreRepository) Description() string {
 	return fmt.Sprintf("Snap remote repository for %s", s.searchURI)
 }
 
-// Details returns details for the given snap in this repository
+// Details returns details for the given snap in this repository, from
+// the channel it tracks if it is installed
 func (s *SnapUbuntuStoreRepository) Details(name string, origin string) (parts []Part, err error) {
+	return s.DetailsFromChannel(name, origin, installedChannel(name, origin))
+}
+
+// DetailsFromChannel returns details for the given snap in the given
+// channel of this repository, or in the default channel if it is ""
+func (s *SnapUbuntuStoreRepository) DetailsFromChannel(name, origin, channel string) (parts []Part, err error) {
 	snapName := name
 	if origin != "" {
 		snapName = name + "." + origin
@@ -1788,6 +2042,11 @@ func (s *SnapUbuntuStoreRepository) Details(name string, origin string) (parts [
 	if err != nil {
 		return nil, err
 	}
+	if channel != "" {
+		q := url.Query()
+		q.Set("channel", channel)
+		url.RawQuery = q.Encode()
+	}
 
 	req, err := http.NewRequest("GET", url.String(), nil)
 	if err != nil {
@@ -1825,6 +2084,54 @@ func (s *SnapUbuntuStoreRepository) Details(name string, origin string) (parts [
 	return parts, nil
 }
 
+// Revisions returns all the versions of the given snap in this
+// repository, from all channels
+func (s *SnapUbuntuStoreRepository) Revisions(name, origin string) (parts []Part, err error) {
+	snapName := name
+	if origin != "" {
+		snapName = name + "." + origin
+	}
+
+	url, err := s.detailsURI.Parse(snapName + "/revisions")
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// set headers
+	setUbuntuStoreHeaders(req)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 404:
+		return nil, ErrPackageNotFound
+	case resp.StatusCode != 200:
+		return nil, fmt.Errorf("SnapUbuntuStoreRepository: unexpected http statusCode %v for the revisions of %s", resp.StatusCode, snapName)
+	}
+
+	var revisionsData []remote.Snap
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&revisionsData); err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range revisionsData {
+		parts = append(parts, NewRemoteSnapPart(pkg))
+	}
+
+	return parts, nil
+}
+
 // All (installable) parts from the store
 func (s *SnapUbuntuStoreRepository) All() ([]Part, error) {
 	req, err := http.NewRequest("GET", s.searchURI.String(), nil)
@@ -1941,7 +2248,7 @@ func (s *SnapUbuntuStoreRepository) Updates() (parts []Part, err error) {
 	}
 
 	for _, pkg := range updateData {
-		current := ActiveSnapByName(pkg.Name)
+		current, _ := activeSnapByNameAndOrigin(pkg.Name, pkg.Origin)
 		if current == nil || current.Version() != pkg.Version {
 			snap := NewRemoteSnapPart(pkg)
 			parts = append(parts, snap)
@@ -20,22 +20,61 @@
 package snappy
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
 
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg/remote"
 	"github.com/ubuntu-core/snappy/pkg/snapfs"
+	"github.com/ubuntu-core/snappy/systemd"
 
 	. "gopkg.in/check.v1"
 )
 
 type SnapfsTestSuite struct {
+	systemctlCmd func(cmd ...string) ([]byte, error)
+	keyring      string
+	signer       *openpgp.Entity
+}
+
+func (s *SnapfsTestSuite) SetUpSuite(c *C) {
+	var err error
+	s.signer, err = openpgp.NewEntity("snappy test", "", "test@example.com", nil)
+	c.Assert(err, IsNil)
 }
 
 func (s *SnapfsTestSuite) SetUpTest(c *C) {
 	// mocks
 	aaClickHookCmd = "/bin/true"
 	dirs.SetRootDir(c.MkDir())
+	os.MkdirAll(filepath.Join(dirs.SnapServicesDir, "multi-user.target.wants"), 0755)
+
+	// "mount" the snaps by unpacking them
+	s.systemctlCmd = systemd.SystemctlCmd
+	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+		if len(cmd) == 2 && cmd[0] == "start" && strings.HasSuffix(cmd[1], ".mount") {
+			return nil, mockMount(filepath.Join(dirs.SnapServicesDir, cmd[1]))
+		}
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	// trust our test key
+	s.keyring = snapfs.TrustedKeyring
+	snapfs.TrustedKeyring = filepath.Join(c.MkDir(), "trusted.gpg")
+	var buf bytes.Buffer
+	c.Assert(s.signer.Serialize(&buf), IsNil)
+	c.Assert(ioutil.WriteFile(snapfs.TrustedKeyring, buf.Bytes(), 0644), IsNil)
 
 	// ensure we use the right builder func (snapfs)
 	snapBuilderFunc = BuildSnapfsSnap
@@ -43,6 +82,56 @@ func (s *SnapfsTestSuite) SetUpTest(c *C) {
 
 func (s *SnapfsTestSuite) TearDownTest(c *C) {
 	snapBuilderFunc = BuildLegacySnap
+	systemd.SystemctlCmd = s.systemctlCmd
+	snapfs.TrustedKeyring = s.keyring
+}
+
+// signature returns the detached signature of the given snap for the
+// given origin
+func (s *SnapfsTestSuite) signature(c *C, snapPkg, origin string) []byte {
+	sha512, err := helpers.Sha512sum(snapPkg)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, s.signer.PrivateKey, nil)
+	c.Assert(err, IsNil)
+	fmt.Fprintf(w, "name: hello-app\nversion: 1.10\norigin: %s\nsha512: %s\n", origin, sha512)
+	c.Assert(w.Close(), IsNil)
+
+	return buf.Bytes()
+}
+
+// mountUnitValues returns the What and Where of the given mount unit
+func mountUnitValues(unitFile string) (what, where string, err error) {
+	f, err := os.Open(unitFile)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "What=") {
+			what = strings.TrimPrefix(line, "What=")
+		}
+		if strings.HasPrefix(line, "Where=") {
+			where = strings.TrimPrefix(line, "Where=")
+		}
+	}
+
+	return what, where, scanner.Err()
+}
+
+// mockMount does what starting the mount unit would, by unpacking
+// the snap into the mount point
+func mockMount(unitFile string) error {
+	what, where, err := mountUnitValues(unitFile)
+	if err != nil {
+		return err
+	}
+
+	return snapfs.New(filepath.Join(dirs.GlobalRootDir, what)).Unpack("*", filepath.Join(dirs.GlobalRootDir, where))
 }
 
 var _ = Suite(&SnapfsTestSuite{})
@@ -70,15 +159,125 @@ func (s *SnapfsTestSuite) TestInstallViaSnapfsWorks(c *C) {
 	_, err = part.Install(&MockProgressMeter{}, 0)
 	c.Assert(err, IsNil)
 
-	// after install its just on disk for now, note that this will
-	// change once the mounting gets added
+	// after install the snap is kept and mounted at the base dir
 	base := filepath.Join(dirs.SnapAppsDir, "hello-app.origin", "1.10")
+	blob := filepath.Join(dirs.SnapBlobDir, "hello-app.origin_1.10.snap")
+	c.Assert(helpers.FileExists(blob), Equals, true)
+
+	what, where, err := mountUnitValues(filepath.Join(dirs.SnapServicesDir, "apps-hello\\x2dapp.origin-1.10.mount"))
+	c.Assert(err, IsNil)
+	c.Check(what, Equals, "/var/lib/snappy/snaps/hello-app.origin_1.10.snap")
+	c.Check(where, Equals, "/apps/hello-app.origin/1.10")
+
 	for _, needle := range []string{
 		"bin/foo",
 		"meta/package.yaml",
-		".click/info/hello-app.origin.manifest",
 	} {
-		println(needle)
-		c.Assert(helpers.FileExists(filepath.Join(base, needle)), Equals, true)
+		c.Assert(helpers.FileExists(filepath.Join(base, needle)), Equals, true, Commentf(needle))
+	}
+
+	// no compat manifest in the read-only mount
+	c.Check(helpers.FileExists(filepath.Join(base, ".click")), Equals, false)
+}
+
+func (s *SnapfsTestSuite) TestRemoveViaSnapfsWorks(c *C) {
+	snapPkg := makeTestSnapPackage(c, packageHello)
+	part, err := NewSnapPartFromSnapFile(snapPkg, "origin", true)
+	c.Assert(err, IsNil)
+
+	_, err = part.Install(&MockProgressMeter{}, 0)
+	c.Assert(err, IsNil)
+
+	installed, err := NewInstalledSnapPart(filepath.Join(dirs.SnapAppsDir, "hello-app.origin", "1.10", "meta", "package.yaml"), "origin")
+	c.Assert(err, IsNil)
+	c.Assert(installed.remove(&MockProgressMeter{}), IsNil)
+
+	// the mount unit, the snap and the base dir are gone
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "apps-hello\\x2dapp.origin-1.10.mount")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBlobDir, "hello-app.origin_1.10.snap")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "hello-app.origin", "1.10")), Equals, false)
+}
+
+func (s *SnapfsTestSuite) TestInstallSignedSnapfs(c *C) {
+	snapPkg := makeTestSnapPackage(c, packageHello)
+
+	// not signed
+	_, err := NewSnapPartFromSnapFile(snapPkg, "origin", false)
+	c.Assert(err, ErrorMatches, "Signature verification failed: No signature.")
+
+	// signed for the origin
+	c.Assert(ioutil.WriteFile(snapPkg+".sig", s.signature(c, snapPkg, "origin"), 0644), IsNil)
+	_, err = NewSnapPartFromSnapFile(snapPkg, "origin", false)
+	c.Assert(err, IsNil)
+
+	// signed for another origin
+	c.Assert(ioutil.WriteFile(snapPkg+".sig", s.signature(c, snapPkg, "other"), 0644), IsNil)
+	_, err = NewSnapPartFromSnapFile(snapPkg, "origin", true)
+	c.Assert(err, DeepEquals, &ErrSignedOriginMismatch{Origin: "origin", SignedOrigin: "other"})
+
+	// but can be sideloaded
+	_, err = NewSnapPartFromSnapFile(snapPkg, SideloadedOrigin, false)
+	c.Assert(err, IsNil)
+}
+
+func (s *SnapfsTestSuite) TestDownloadSignature(c *C) {
+	snapPkg := makeTestSnapPackage(c, packageHello)
+	snapData, err := ioutil.ReadFile(snapPkg)
+	c.Assert(err, IsNil)
+	sig := s.signature(c, snapPkg, "origin")
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/snap":
+			w.Write(snapData)
+		case "/sig":
+			w.Write(sig)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mockServer.Close()
+
+	part := NewRemoteSnapPart(remote.Snap{
+		Name:         "hello-app",
+		Origin:       "origin",
+		Version:      "1.10",
+		DownloadURL:  mockServer.URL + "/snap",
+		SignatureURL: mockServer.URL + "/sig",
+	})
+	snapFile, err := part.Download(nil)
+	c.Assert(err, IsNil)
+	defer os.Remove(snapFile)
+
+	content, err := ioutil.ReadFile(snapFile + ".sig")
+	c.Assert(err, IsNil)
+	c.Check(content, DeepEquals, sig)
+
+	// which makes it installable
+	_, err = NewSnapPartFromSnapFile(snapFile, "origin", false)
+	c.Assert(err, IsNil)
+}
+
+func (s *SnapfsTestSuite) TestVerifySnapfsNotVerifiable(c *C) {
+	snapPkg := makeTestSnapPackage(c, packageHello)
+	part, err := NewSnapPartFromSnapFile(snapPkg, "origin", true)
+	c.Assert(err, IsNil)
+	_, err = part.Install(&MockProgressMeter{}, 0)
+	c.Assert(err, IsNil)
+
+	// all the active packages, which does not stop at the snapfs one
+	results, err := Verify("", false, nil)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Name, Equals, "hello-app")
+	c.Check(results[0].NotVerifiable, Equals, true)
+	c.Check(results[0].OK(), Equals, true)
+
+	for _, repair := range []bool{false, true} {
+		results, err = Verify("hello-app", repair, nil)
+		c.Assert(err, IsNil)
+		c.Assert(results, HasLen, 1)
+		c.Check(results[0].NotVerifiable, Equals, true)
+		c.Check(results[0].Problems, HasLen, 0)
 	}
 }
@@ -29,6 +29,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/helpers"
@@ -46,6 +47,7 @@ type SnapTestSuite struct {
 	tempdir   string
 	clickhook string
 	secbase   string
+	verify    func(string, bool) error
 }
 
 var _ = Suite(&SnapTestSuite{})
@@ -73,15 +75,18 @@ func (s *SnapTestSuite) SetUpTest(c *C) {
 	// create a fake systemd environment
 	os.MkdirAll(filepath.Join(dirs.SnapServicesDir, "multi-user.target.wants"), 0755)
 
-	// we may not have debsig-verify installed (and we don't need it
+	// the test snaps are not signed (and we don't need them to be
 	// for the unittests)
-	clickdeb.VerifyCmd = "true"
+	s.verify = clickdeb.Verify
+	clickdeb.Verify = func(string, bool) error { return nil }
 	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
 		return []byte("ActiveState=inactive\n"), nil
 	}
 
 	// fake "du"
-	duCmd = makeFakeDuCommand(c)
+	dirSize = func(string) (string, error) {
+		return "17", nil
+	}
 
 	// fake udevadm
 	runUdevAdm = func(args ...string) error {
@@ -104,12 +109,22 @@ func (s *SnapTestSuite) TearDownTest(c *C) {
 	// ensure all functions are back to their original state
 	aaClickHookCmd = s.clickhook
 	policy.SecBase = s.secbase
+	clickdeb.Verify = s.verify
 	regenerateAppArmorRules = regenerateAppArmorRulesImpl
 	ActiveSnapIterByType = activeSnapIterByTypeImpl
-	duCmd = "du"
+	dirSize = dirSizeImpl
 	stripGlobalRootDir = stripGlobalRootDirImpl
 	runScFilterGen = runScFilterGenImpl
 	runUdevAdm = runUdevAdmImpl
+	applyDelta = applyDeltaImpl
+	downloadRetryDelay = 1 * time.Second
+	findFramework = findFrameworkImpl
+	freeSpace = freeSpaceImpl
+	runHookScript = runHookScriptImpl
+	runHealthCommand = runHealthCommandImpl
+	healthCheckInterval = time.Second
+	cpReflinkArgs = []string{"-a", "--reflink=always"}
+	renameDataDir = os.Rename
 }
 
 func (s *SnapTestSuite) makeInstalledMockSnap(yamls ...string) (yamlFile string, err error) {
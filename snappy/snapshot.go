@@ -0,0 +1,511 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// dataSnapshotHardlink is the "data-snapshot" value in package.yaml
+// that makes updates hardlink the data of the old version instead of
+// copying it. This is only safe for snaps that never modify their
+// data files in place (i.e. they always write a new file and rename
+// it over the old one), otherwise writes show up in both versions.
+const dataSnapshotHardlink = "hardlink"
+
+// the top level directories in a snapshot, the system data, the data
+// of each user under home/<user> and extra files in meta
+const (
+	snapshotSystemDir = "system"
+	snapshotHomeDir   = "home"
+	// not data, but information about it
+	snapshotMetaDir = "meta"
+)
+
+// snapshotTimeFormat is used for the name of the snapshot files
+const snapshotTimeFormat = "20060102T150405Z"
+
+// snapshotDataDirs returns the data directories of the given snap
+// version, keyed by their name in a snapshot
+func snapshotDataDirs(fullName, version string) (map[string]string, error) {
+	dataDirs, err := snapDataDirs(fullName, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// dirs.SnapDataHomeGlob is <home>/*/apps
+	homeRoot := filepath.Dir(filepath.Dir(dirs.SnapDataHomeGlob))
+	res := make(map[string]string, len(dataDirs))
+	for _, dir := range dataDirs {
+		rel, err := filepath.Rel(homeRoot, dir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			res[snapshotSystemDir] = dir
+			continue
+		}
+		// rel is <user>/apps/<fullName>/<version>
+		user := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+		res[filepath.Join(snapshotHomeDir, user)] = dir
+	}
+
+	return res, nil
+}
+
+// snapshotTarget returns the data directory of the given snap version
+// for the given top level directory of a snapshot
+func snapshotTarget(top, fullName, version string) (string, error) {
+	if top == snapshotSystemDir {
+		return filepath.Join(dirs.SnapDataDir, fullName, version), nil
+	}
+
+	user := strings.TrimPrefix(top, snapshotHomeDir+"/")
+	if user == top || user == "" || strings.Contains(user, "/") || user == ".." {
+		return "", &ErrInvalidSnapshot{Msg: fmt.Sprintf("unexpected directory %q", top)}
+	}
+
+	return filepath.Join(strings.Replace(dirs.SnapDataHomeGlob, "*", user, 1), fullName, version), nil
+}
+
+// SnapshotSave saves the data of the active version of the given
+// package as a compressed tarball in dirs.SnapSnapshotsDir and returns
+// its path
+func SnapshotSave(pkgName string) (string, error) {
+	part, err := activeSnapByNameAndOrigin(SplitOrigin(pkgName))
+	if err != nil {
+		return "", err
+	}
+	if part == nil {
+		return "", ErrPackageNotFound
+	}
+
+	if err := os.MkdirAll(dirs.SnapSnapshotsDir, 0700); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s_%s_%s.tar.gz", QualifiedName(part), part.Version(), time.Now().UTC().Format(snapshotTimeFormat))
+	target := filepath.Join(dirs.SnapSnapshotsDir, name)
+
+	f, err := os.OpenFile(target+".partial", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err = writeDataTarball(f, part, nil); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+
+	if err = os.Rename(f.Name(), target); err != nil {
+		return "", err
+	}
+
+	return target, nil
+}
+
+// writeDataTarball writes the data of the given part as a compressed
+// tarball to w. The given meta files go first, in the meta/ directory.
+func writeDataTarball(w io.Writer, part Part, meta map[string][]byte) error {
+	dataDirs, err := snapshotDataDirs(QualifiedName(part), part.Version())
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for name, content := range meta {
+		hdr := &tar.Header{
+			Name:     filepath.Join(snapshotMetaDir, name),
+			Mode:     0600,
+			Size:     int64(len(content)),
+			ModTime:  time.Now(),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+
+	for top, dir := range dataDirs {
+		if !helpers.IsDirectory(dir) {
+			continue
+		}
+		if err := tarDirectory(tw, dir, top); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// tarDirectory adds the given directory to the tar writer, named as
+// prefix
+func tarDirectory(tw *tar.Writer, dir, prefix string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if helpers.IsSymlink(info.Mode()) {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.Join(prefix, rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// SnapshotRestore replaces the data of the active version of the given
+// package with the data in the given snapshot. The package is
+// deactivated while its data is replaced. Data of users that are not
+// in the snapshot is left alone, data of users without a home directory
+// is not restored.
+func SnapshotRestore(pkgName, snapshot string, meter progress.Meter) error {
+	part, err := activeSnapByNameAndOrigin(SplitOrigin(pkgName))
+	if err != nil {
+		return err
+	}
+	if part == nil {
+		return ErrPackageNotFound
+	}
+
+	f, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return restoreDataTarball(part, f, meter)
+}
+
+// restoreTarget is where a top level directory of a snapshot is
+// restored to
+type restoreTarget struct {
+	dir string
+	// the owner of the restored files, if chown is set
+	uid, gid int
+	chown    bool
+}
+
+// newRestoreTarget returns where the given top level directory of a
+// snapshot is restored to, or nil if it is the data of a user that has
+// no home directory on this system
+func newRestoreTarget(top, fullName, version string) (*restoreTarget, error) {
+	dir, err := snapshotTarget(top, fullName, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// the system data is owned by whoever restores it, like
+	// when it is created on install
+	if top == snapshotSystemDir {
+		return &restoreTarget{dir: dir}, nil
+	}
+
+	// dir is <home>/apps/<fullName>/<version>
+	home := filepath.Dir(filepath.Dir(filepath.Dir(dir)))
+	st, err := os.Stat(home)
+	if os.IsNotExist(err) {
+		logger.Noticef("Not restoring the data in %s of the snapshot, %s does not exist", top, home)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("can not get the owner of %s", home)
+	}
+
+	t := &restoreTarget{
+		dir:   dir,
+		uid:   int(sys.Uid),
+		gid:   int(sys.Gid),
+		chown: os.Getuid() == 0,
+	}
+
+	// the directories between the home and the data belong to
+	// the user too
+	for _, parent := range []string{filepath.Dir(filepath.Dir(dir)), filepath.Dir(dir)} {
+		if err := t.mkdir(parent); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// mkdir creates the given directory, if it does not exist, owned by the
+// owner of the target
+func (t *restoreTarget) mkdir(dir string) error {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if t.chown {
+		return os.Lchown(dir, t.uid, t.gid)
+	}
+
+	return nil
+}
+
+// chownAll gives everything in dir to the owner of the target
+func (t *restoreTarget) chownAll(dir string) error {
+	if !t.chown {
+		return nil
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, t.uid, t.gid)
+	})
+}
+
+// renameDataDir is os.Rename, mockable for testing
+var renameDataDir = os.Rename
+
+// restoreDataTarball replaces the data of the given part with the data
+// in the compressed tarball read from r, ignoring its meta files. All
+// the data is unpacked before any of it is replaced, and the data that
+// got replaced is put back if replacing the rest fails.
+func restoreDataTarball(part Part, r io.Reader, meter progress.Meter) (err error) {
+	fullName := QualifiedName(part)
+
+	// unpack next to the data first, so that a broken snapshot
+	// leaves the current data alone
+	const restoreSuffix = ".snapshot-restore"
+	const oldSuffix = ".snapshot-old"
+	targets := make(map[string]*restoreTarget)
+	defer func() {
+		for _, t := range targets {
+			if t != nil {
+				os.RemoveAll(t.dir + restoreSuffix)
+			}
+		}
+	}()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return &ErrInvalidSnapshot{Msg: err.Error()}
+	}
+	defer gz.Close()
+
+	err = helpers.TarIterate(gz, func(tr *tar.Reader, hdr *tar.Header) error {
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return &ErrInvalidSnapshot{Msg: fmt.Sprintf("invalid path %q", hdr.Name)}
+		}
+
+		if name == snapshotMetaDir || strings.HasPrefix(name, snapshotMetaDir+"/") {
+			return nil
+		}
+
+		top := snapshotSystemDir
+		if strings.HasPrefix(name, snapshotHomeDir+"/") {
+			top = strings.Join(strings.SplitN(name, "/", 3)[:2], "/")
+		} else if name != snapshotSystemDir && !strings.HasPrefix(name, snapshotSystemDir+"/") {
+			return &ErrInvalidSnapshot{Msg: fmt.Sprintf("unexpected path %q", hdr.Name)}
+		}
+
+		target, ok := targets[top]
+		if !ok {
+			t, err := newRestoreTarget(top, fullName, part.Version())
+			if err != nil {
+				return err
+			}
+			if t != nil {
+				os.RemoveAll(t.dir + restoreSuffix)
+			}
+			target = t
+			targets[top] = target
+		}
+		if target == nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(top, name)
+		if err != nil {
+			return err
+		}
+
+		return unpackSnapshotEntry(tr, hdr, target.dir+restoreSuffix, rel)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		if t == nil {
+			continue
+		}
+		if err := t.chownAll(t.dir + restoreSuffix); err != nil {
+			return err
+		}
+	}
+
+	snap, ok := part.(*SnapPart)
+	if ok {
+		if err := snap.deactivate(false, meter); err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := snap.activate(false, meter); cerr != nil {
+				logger.Noticef("Unable to activate %s after restoring its data: %v", snap.Name(), cerr)
+				if err == nil {
+					err = cerr
+				}
+			}
+		}()
+	}
+
+	// the old data is moved aside until all of it is replaced
+	var replaced []string
+	defer func() {
+		for i := len(replaced) - 1; i >= 0; i-- {
+			dir := replaced[i]
+			if err == nil {
+				os.RemoveAll(dir + oldSuffix)
+				continue
+			}
+
+			if rerr := os.RemoveAll(dir); rerr != nil {
+				logger.Noticef("Unable to remove the restored data in %s: %v", dir, rerr)
+				continue
+			}
+			if helpers.FileExists(dir + oldSuffix) {
+				if rerr := renameDataDir(dir+oldSuffix, dir); rerr != nil {
+					logger.Noticef("Unable to put the data in %s back: %v", dir, rerr)
+				}
+			}
+		}
+	}()
+
+	for _, t := range targets {
+		if t == nil {
+			continue
+		}
+
+		if err := os.RemoveAll(t.dir + oldSuffix); err != nil {
+			return err
+		}
+		if helpers.FileExists(t.dir) {
+			if err := renameDataDir(t.dir, t.dir+oldSuffix); err != nil {
+				return err
+			}
+		}
+		if err := renameDataDir(t.dir+restoreSuffix, t.dir); err != nil {
+			if helpers.FileExists(t.dir + oldSuffix) {
+				renameDataDir(t.dir+oldSuffix, t.dir)
+			}
+			return err
+		}
+		replaced = append(replaced, t.dir)
+	}
+
+	return nil
+}
+
+// unpackSnapshotEntry unpacks the given tar entry to rel in dir, keeping
+// its permissions. Entries that would be written through or over a
+// symlink make the snapshot invalid.
+func unpackSnapshotEntry(tr *tar.Reader, hdr *tar.Header, dir, rel string) error {
+	mode := hdr.FileInfo().Mode()
+	path := filepath.Join(dir, rel)
+	if !mode.IsDir() && !mode.IsRegular() && !helpers.IsSymlink(mode) {
+		return &helpers.ErrUnsupportedFileType{Name: path, Mode: mode}
+	}
+
+	if err := helpers.UnpackTarEntry(tr, hdr, dir, rel); err != nil {
+		if _, ok := err.(*helpers.ErrUnpackPathTraversal); ok {
+			return &ErrInvalidSnapshot{Msg: fmt.Sprintf("%q is written through a symlink", hdr.Name)}
+		}
+		return err
+	}
+
+	if !helpers.IsSymlink(mode) {
+		// the umask might have gotten in the way
+		return os.Chmod(path, mode.Perm())
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func (s *SnapTestSuite) TestCopySnapDataDirectoryFallsBackToCopy(c *C) {
+	oldPath := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(oldPath, "canary"), []byte("ni"), 0644), IsNil)
+	newPath := filepath.Join(c.MkDir(), "new")
+
+	// a clone that does not work, whatever the filesystem
+	cpReflinkArgs = []string{"-a", "--no-such-option"}
+
+	c.Assert(copySnapDataDirectory(oldPath, newPath), IsNil)
+	content, err := ioutil.ReadFile(filepath.Join(newPath, "canary"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "ni")
+
+	// and it is a copy
+	c.Assert(ioutil.WriteFile(filepath.Join(newPath, "canary"), []byte("ekke"), 0644), IsNil)
+	content, err = ioutil.ReadFile(filepath.Join(oldPath, "canary"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "ni")
+}
+
+func (s *SnapTestSuite) TestClickCopyDataHardlink(c *C) {
+	packageYaml := `name: foo
+vendor: Foo Bar <foo@example.com>
+data-snapshot: hardlink
+`
+	appDir := "foo." + testOrigin
+	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
+	_, err := installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	c.Assert(err, IsNil)
+	canaryDataFile := filepath.Join(dirs.SnapDataDir, appDir, "1.0", "canary.txt")
+	c.Assert(ioutil.WriteFile(canaryDataFile, []byte("ni"), 0644), IsNil)
+
+	snapFile = makeTestSnapPackage(c, packageYaml+"version: 2.0")
+	_, err = installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	c.Assert(err, IsNil)
+
+	oldFi, err := os.Stat(canaryDataFile)
+	c.Assert(err, IsNil)
+	newFi, err := os.Stat(filepath.Join(dirs.SnapDataDir, appDir, "2.0", "canary.txt"))
+	c.Assert(err, IsNil)
+	c.Check(os.SameFile(oldFi, newFi), Equals, true)
+}
+
+func (s *SnapTestSuite) TestSnapshotSaveRestore(c *C) {
+	dirs.SnapDataHomeGlob = filepath.Join(s.tempdir, "home", "*", "apps")
+
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	appDir := "hello-app." + testOrigin
+	systemData := filepath.Join(dirs.SnapDataDir, appDir, "1.10")
+	homeData := filepath.Join(s.tempdir, "home", "user1", "apps", appDir, "1.10")
+	c.Assert(os.MkdirAll(filepath.Join(systemData, "sub"), 0755), IsNil)
+	c.Assert(os.MkdirAll(homeData, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(systemData, "sub", "canary"), []byte("ni"), 0600), IsNil)
+	c.Assert(os.Symlink("sub/canary", filepath.Join(systemData, "link")), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(homeData, "canary.home"), []byte("ni ni"), 0644), IsNil)
+
+	snapshot, err := SnapshotSave("hello-app")
+	c.Assert(err, IsNil)
+	c.Check(filepath.Dir(snapshot), Equals, dirs.SnapSnapshotsDir)
+	c.Check(strings.HasPrefix(filepath.Base(snapshot), appDir+"_1.10_"), Equals, true)
+
+	// change the data
+	c.Assert(ioutil.WriteFile(filepath.Join(systemData, "sub", "canary"), []byte("ekke"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(systemData, "new"), nil, 0644), IsNil)
+	c.Assert(os.Remove(filepath.Join(homeData, "canary.home")), IsNil)
+
+	c.Assert(SnapshotRestore("hello-app", snapshot, &MockProgressMeter{}), IsNil)
+
+	content, err := ioutil.ReadFile(filepath.Join(systemData, "link"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "ni")
+	fi, err := os.Stat(filepath.Join(systemData, "sub", "canary"))
+	c.Assert(err, IsNil)
+	c.Check(fi.Mode().Perm(), Equals, os.FileMode(0600))
+	c.Check(helpers.FileExists(filepath.Join(systemData, "new")), Equals, false)
+	content, err = ioutil.ReadFile(filepath.Join(homeData, "canary.home"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "ni ni")
+
+	// no leftovers
+	leftovers, err := filepath.Glob(filepath.Join(dirs.SnapDataDir, appDir, "*.snapshot-restore"))
+	c.Assert(err, IsNil)
+	c.Check(leftovers, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestSnapshotNotInstalled(c *C) {
+	_, err := SnapshotSave("hello-app")
+	c.Check(err, Equals, ErrPackageNotFound)
+	c.Check(SnapshotRestore("hello-app", "/no/such/file", nil), Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestSnapshotRestoreInvalid(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	systemData := filepath.Join(dirs.SnapDataDir, "hello-app."+testOrigin, "1.10")
+	c.Assert(os.MkdirAll(systemData, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(systemData, "canary"), []byte("ni"), 0644), IsNil)
+
+	snapshot := filepath.Join(c.MkDir(), "bad.tar.gz")
+	f, err := os.Create(snapshot)
+	c.Assert(err, IsNil)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "system/canary", Mode: 0644, Size: 4, Typeflag: tar.TypeReg}), IsNil)
+	_, err = tw.Write([]byte("ekke"))
+	c.Assert(err, IsNil)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "home/../../etc/passwd", Mode: 0644, Typeflag: tar.TypeReg}), IsNil)
+	c.Assert(tw.Close(), IsNil)
+	c.Assert(gz.Close(), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	err = SnapshotRestore("hello-app", snapshot, &MockProgressMeter{})
+	c.Assert(err, FitsTypeOf, &ErrInvalidSnapshot{})
+
+	// the data is untouched
+	content, err := ioutil.ReadFile(filepath.Join(systemData, "canary"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "ni")
+	c.Check(helpers.FileExists(systemData+".snapshot-restore"), Equals, false)
+}
+
+// testTarEntry is an entry of a test snapshot, a Linkname makes it a
+// symlink and a Name ending in "/" a directory
+type testTarEntry struct {
+	Name, Linkname, Content string
+}
+
+// writeTestSnapshot writes a snapshot with the given entries to path
+func writeTestSnapshot(c *C, path string, entries ...testTarEntry) {
+	f, err := os.Create(path)
+	c.Assert(err, IsNil)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.Name, Mode: 0644, Size: int64(len(e.Content)), Typeflag: tar.TypeReg}
+		switch {
+		case e.Linkname != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.Linkname
+		case strings.HasSuffix(e.Name, "/"):
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		}
+		c.Assert(tw.WriteHeader(hdr), IsNil)
+		_, err = tw.Write([]byte(e.Content))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(tw.Close(), IsNil)
+	c.Assert(gz.Close(), IsNil)
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *SnapTestSuite) TestSnapshotRestoreThroughSymlink(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	outside := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(outside, "shadow"), []byte("secret"), 0600), IsNil)
+
+	for _, entries := range [][]testTarEntry{
+		// writing through a symlinked directory
+		{{Name: "system/x", Linkname: outside}, {Name: "system/x/shadow", Content: "evil"}},
+		// writing over a symlink
+		{{Name: "system/shadow", Linkname: filepath.Join(outside, "shadow")}, {Name: "system/shadow", Content: "evil"}},
+	} {
+		snapshot := filepath.Join(c.MkDir(), "bad.tar.gz")
+		writeTestSnapshot(c, snapshot, entries...)
+
+		err = SnapshotRestore("hello-app", snapshot, &MockProgressMeter{})
+		c.Check(err, FitsTypeOf, &ErrInvalidSnapshot{})
+
+		content, err := ioutil.ReadFile(filepath.Join(outside, "shadow"))
+		c.Assert(err, IsNil)
+		c.Check(string(content), Equals, "secret")
+	}
+}
+
+func (s *SnapTestSuite) TestSnapshotRestoreUsers(c *C) {
+	dirs.SnapDataHomeGlob = filepath.Join(s.tempdir, "home", "*", "apps")
+
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	home := filepath.Join(s.tempdir, "home", "user1")
+	c.Assert(os.MkdirAll(home, 0755), IsNil)
+	if os.Getuid() == 0 {
+		c.Assert(os.Chown(home, 1234, 1234), IsNil)
+	}
+
+	// the entries are owned by root in the snapshot
+	snapshot := filepath.Join(c.MkDir(), "users.tar.gz")
+	writeTestSnapshot(c, snapshot,
+		testTarEntry{Name: "home/user1/"},
+		testTarEntry{Name: "home/user1/canary", Content: "ni"},
+		testTarEntry{Name: "home/ghost/"},
+		testTarEntry{Name: "home/ghost/canary", Content: "ekke"},
+	)
+
+	c.Assert(SnapshotRestore("hello-app", snapshot, &MockProgressMeter{}), IsNil)
+
+	appDir := filepath.Join(home, "apps", "hello-app."+testOrigin)
+	content, err := ioutil.ReadFile(filepath.Join(appDir, "1.10", "canary"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "ni")
+
+	// a user that is not here gets nothing created
+	c.Check(helpers.FileExists(filepath.Join(s.tempdir, "home", "ghost")), Equals, false)
+
+	if os.Getuid() != 0 {
+		return
+	}
+	for _, path := range []string{filepath.Join(home, "apps"), appDir, filepath.Join(appDir, "1.10"), filepath.Join(appDir, "1.10", "canary")} {
+		fi, err := os.Lstat(path)
+		c.Assert(err, IsNil)
+		st := fi.Sys().(*syscall.Stat_t)
+		c.Check(st.Uid, Equals, uint32(1234), Commentf(path))
+		c.Check(st.Gid, Equals, uint32(1234), Commentf(path))
+	}
+}
+
+func (s *SnapTestSuite) TestSnapshotRestoreRollsBack(c *C) {
+	dirs.SnapDataHomeGlob = filepath.Join(s.tempdir, "home", "*", "apps")
+
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	appDir := "hello-app." + testOrigin
+	dataDirs := []string{
+		filepath.Join(dirs.SnapDataDir, appDir, "1.10"),
+		filepath.Join(s.tempdir, "home", "user1", "apps", appDir, "1.10"),
+	}
+	for _, dir := range dataDirs {
+		c.Assert(os.MkdirAll(dir, 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, "canary"), []byte("ni"), 0644), IsNil)
+	}
+
+	snapshot := filepath.Join(c.MkDir(), "both.tar.gz")
+	writeTestSnapshot(c, snapshot,
+		testTarEntry{Name: "system/"},
+		testTarEntry{Name: "system/canary", Content: "ekke"},
+		testTarEntry{Name: "home/user1/"},
+		testTarEntry{Name: "home/user1/canary", Content: "ekke"},
+	)
+
+	// the second directory can not be put in place
+	swapped := 0
+	renameDataDir = func(oldpath, newpath string) error {
+		if strings.HasSuffix(oldpath, ".snapshot-restore") {
+			swapped++
+			if swapped == 2 {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EIO}
+			}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+
+	err = SnapshotRestore("hello-app", snapshot, &MockProgressMeter{})
+	c.Assert(err, NotNil)
+	c.Check(swapped, Equals, 2)
+
+	// all of the data is what it was
+	for _, dir := range dataDirs {
+		content, err := ioutil.ReadFile(filepath.Join(dir, "canary"))
+		c.Assert(err, IsNil)
+		c.Check(string(content), Equals, "ni")
+
+		leftovers, err := filepath.Glob(dir + ".snapshot-*")
+		c.Assert(err, IsNil)
+		c.Check(leftovers, HasLen, 0)
+	}
+}
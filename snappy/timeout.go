@@ -52,6 +52,30 @@ func (t *Timeout) UnmarshalJSON(buf []byte) error {
 	return nil
 }
 
+// UnmarshalYAML is from the yaml.Unmarshaler interface; it takes a
+// duration like "10s", or a plain number of nanoseconds
+func (t *Timeout) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int64
+	if err := unmarshal(&n); err == nil {
+		*t = Timeout(n)
+		return nil
+	}
+
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+
+	dur, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+
+	*t = Timeout(dur)
+
+	return nil
+}
+
 // String returns a string representing the duration
 func (t Timeout) String() string {
 	return time.Duration(t).String()
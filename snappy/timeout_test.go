@@ -24,6 +24,7 @@ import (
 	"time"
 
 	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
 )
 
 func (s *SnapTestSuite) TestTimeoutMarshal(c *C) {
@@ -47,3 +48,14 @@ func (s *SnapTestSuite) TestTimeoutUnmarshal(c *C) {
 	c.Assert(json.Unmarshal([]byte(`{"T": "17ms"}`), &t), IsNil)
 	c.Check(t, DeepEquals, testT{T: Timeout(17 * time.Millisecond)})
 }
+
+func (s *SnapTestSuite) TestTimeoutUnmarshalYAML(c *C) {
+	var t testT
+	c.Assert(yaml.Unmarshal([]byte("t: 17ms"), &t), IsNil)
+	c.Check(t, DeepEquals, testT{T: Timeout(17 * time.Millisecond)})
+
+	c.Assert(yaml.Unmarshal([]byte("t: 25"), &t), IsNil)
+	c.Check(t, DeepEquals, testT{T: Timeout(25)})
+
+	c.Check(yaml.Unmarshal([]byte("t: soon"), &t), NotNil)
+}
@@ -0,0 +1,415 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// The ways a file of an installed package can differ from what was
+// installed
+const (
+	// VerifyModified is a file with different content, or of a
+	// different kind (e.g. a directory instead of a file)
+	VerifyModified = "modified"
+	// VerifyMissing is a file that is gone
+	VerifyMissing = "missing"
+	// VerifyExtra is a file that was not installed
+	VerifyExtra = "extra"
+	// VerifyMode is a file with different permissions
+	VerifyMode = "mode"
+)
+
+// VerifyProblem is a file of an installed package that differs from
+// what was installed
+type VerifyProblem struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Repaired bool   `json:"repaired"`
+}
+
+// VerifyResult is what checking an installed package found
+type VerifyResult struct {
+	Name     string          `json:"name"`
+	Origin   string          `json:"origin,omitempty"`
+	Version  string          `json:"version"`
+	Problems []VerifyProblem `json:"problems"`
+	// HashesUnchecked is set if there is no snap file kept from the
+	// install, so the hashes had to be taken from the install dir
+	// and could not be checked themselves
+	HashesUnchecked bool `json:"hashes-unchecked,omitempty"`
+	// NotVerifiable is set if the package has no hashes to check its
+	// files against, e.g. because it is a mounted snapfs snap
+	NotVerifiable bool `json:"not-verifiable,omitempty"`
+}
+
+// OK returns true if there are no problems, or all of them got repaired
+func (r *VerifyResult) OK() bool {
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Verify checks the files of the active version of the package given
+// as name[.origin], or of all active packages if it is "", against the
+// meta/hashes.yaml they were installed with. If repair is set, the
+// modified, missing and changed mode files are put back from the snap
+// file kept from the install. Packages without hashes are reported as
+// not verifiable.
+func Verify(pkgSpec string, repair bool, meter progress.Meter) ([]*VerifyResult, error) {
+	var parts []*SnapPart
+	if pkgSpec != "" {
+		part, err := activeSnapByNameAndOrigin(SplitOrigin(pkgSpec))
+		if err != nil {
+			return nil, err
+		}
+		if part == nil {
+			return nil, ErrPackageNotFound
+		}
+		snap, ok := part.(*SnapPart)
+		if !ok {
+			return nil, fmt.Errorf("Can not verify %s", pkgSpec)
+		}
+		parts = append(parts, snap)
+	} else {
+		installed, err := NewMetaLocalRepository().Installed()
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range installed {
+			if snap, ok := part.(*SnapPart); ok && snap.IsActive() {
+				parts = append(parts, snap)
+			}
+		}
+	}
+
+	var results []*VerifyResult
+	for _, part := range parts {
+		if !part.hasHashes() {
+			results = append(results, &VerifyResult{
+				Name:          part.Name(),
+				Origin:        part.Origin(),
+				Version:       part.Version(),
+				NotVerifiable: true,
+			})
+			continue
+		}
+
+		if meter != nil {
+			meter.Notify(fmt.Sprintf("Verifying %s (%s)", part.Name(), part.Version()))
+		}
+
+		problems, checked, err := part.verifyFiles()
+		if err != nil {
+			return nil, err
+		}
+
+		if repair && len(problems) > 0 {
+			if err := part.repairFiles(problems); err != nil {
+				return nil, err
+			}
+		}
+
+		results = append(results, &VerifyResult{
+			Name:            part.Name(),
+			Origin:          part.Origin(),
+			Version:         part.Version(),
+			Problems:        problems,
+			HashesUnchecked: !checked,
+		})
+	}
+
+	return results, nil
+}
+
+// hasHashes returns true if the snap has hashes to verify it against;
+// mounted snaps do not, they are read-only and their snap file carries
+// no hashes.yaml
+func (s *SnapPart) hasHashes() bool {
+	if s.isMounted() {
+		return false
+	}
+
+	return helpers.FileExists(blobPath(s)) || helpers.FileExists(filepath.Join(s.basedir, "meta", "hashes.yaml"))
+}
+
+// readHashes reads the hashes of the snap from the snap file kept from
+// the install, as the ones in the install dir can be changed along with
+// the files. Without a kept snap file it falls back to the install dir,
+// and returns that the hashes could not be checked.
+func (s *SnapPart) readHashes() (h *hashesYaml, checked bool, err error) {
+	var content []byte
+	if blob := blobPath(s); helpers.FileExists(blob) {
+		d, err := OpenPackageFile(blob)
+		if err != nil {
+			return nil, false, err
+		}
+		defer d.Close()
+
+		if content, err = d.ControlMember("hashes.yaml"); err != nil {
+			return nil, false, err
+		}
+		checked = true
+	} else {
+		if content, err = ioutil.ReadFile(filepath.Join(s.basedir, "meta", "hashes.yaml")); err != nil {
+			return nil, false, err
+		}
+	}
+
+	h = &hashesYaml{}
+	if err := yaml.Unmarshal(content, h); err != nil {
+		return nil, false, &ErrInvalidYaml{File: "hashes.yaml", Err: err, Yaml: content}
+	}
+
+	return h, checked, nil
+}
+
+// notHashed returns true for the files in the install dir that snappy
+// adds on install, so they are not in the hashes
+func notHashed(path string) bool {
+	return path == ".click" || path == filepath.Join("meta", "hashes.yaml")
+}
+
+// verifyFiles compares the files in the install dir of the snap with
+// its hashes, and returns whether the hashes themselves were checked
+func (s *SnapPart) verifyFiles() ([]VerifyProblem, bool, error) {
+	hashes, checked, err := s.readHashes()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var problems []VerifyProblem
+	expected := make(map[string]bool)
+	for _, h := range hashes.Files {
+		expected[h.Name] = true
+
+		kind, err := verifyFile(filepath.Join(s.basedir, h.Name), h)
+		if err != nil {
+			return nil, false, err
+		}
+		if kind != "" {
+			problems = append(problems, VerifyProblem{Path: h.Name, Kind: kind})
+		}
+	}
+
+	err = filepath.Walk(s.basedir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == s.basedir {
+			return nil
+		}
+
+		name := path[len(s.basedir)+1:]
+		if notHashed(name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !expected[name] {
+			problems = append(problems, VerifyProblem{Path: name, Kind: VerifyExtra})
+			// what is in there is extra too, no need to list it
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	sort.Sort(verifyProblemsByPath(problems))
+
+	return problems, checked, nil
+}
+
+// verifyFile returns how the given file differs from its hash, or ""
+func verifyFile(path string, h *fileHash) (string, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return VerifyMissing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var want os.FileMode
+	if h.Mode != nil {
+		want = h.Mode.mode
+	}
+	if info.Mode()&os.ModeType != want&os.ModeType {
+		return VerifyModified, nil
+	}
+
+	if info.Mode().IsRegular() {
+		if h.Size != nil && *h.Size != info.Size() {
+			return VerifyModified, nil
+		}
+		sum, err := helpers.Sha512sum(path)
+		if err != nil {
+			return "", err
+		}
+		if sum != h.Sha512 {
+			return VerifyModified, nil
+		}
+	}
+
+	// the permissions of symlinks mean nothing
+	if info.Mode()&os.ModeSymlink == 0 && info.Mode().Perm() != want.Perm() {
+		return VerifyMode, nil
+	}
+
+	return "", nil
+}
+
+// repairFiles puts the files with the given problems back as they were
+// installed, from the snap file kept from the install; extra files are
+// left alone. The problems that got fixed are marked as repaired.
+func (s *SnapPart) repairFiles(problems []VerifyProblem) error {
+	blob := blobPath(s)
+	if !helpers.FileExists(blob) {
+		return ErrNoSnapBlob(QualifiedName(s) + " " + s.Version())
+	}
+
+	hashes, _, err := s.readHashes()
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*fileHash)
+	for _, h := range hashes.Files {
+		byName[h.Name] = h
+	}
+
+	d, err := OpenPackageFile(blob)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	tmpdir, err := ioutil.TempDir("", "snappy-repair")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := d.UnpackWithDropPrivs(tmpdir, dirs.GlobalRootDir); err != nil {
+		return err
+	}
+
+	// the files are owned by whoever owns the install dir
+	var uid, gid int
+	if st, err := os.Stat(s.basedir); err == nil {
+		if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(sys.Uid), int(sys.Gid)
+		}
+	}
+
+	for i := range problems {
+		p := &problems[i]
+		h := byName[p.Path]
+		if h == nil {
+			continue
+		}
+
+		// the snap file has to have what was installed
+		src := filepath.Join(tmpdir, p.Path)
+		if kind, err := verifyFile(src, h); err != nil || kind != "" {
+			return fmt.Errorf("%s in %s does not match what was installed", p.Path, blob)
+		}
+
+		dst := filepath.Join(s.basedir, p.Path)
+		if err := restoreFile(src, dst, uid, gid); err != nil {
+			return err
+		}
+		logger.Noticef("Repaired %s file %s of %s", p.Kind, p.Path, QualifiedName(s))
+		p.Repaired = true
+	}
+
+	return nil
+}
+
+// restoreFile puts src in place of dst, with the given owner
+func restoreFile(src, dst string, uid, gid int) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Lstat(dst); err == nil && (!info.IsDir() || !fi.IsDir()) {
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	switch {
+	case info.IsDir():
+		if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+		return os.Lchown(dst, uid, gid)
+	default:
+		if err := helpers.CopyFile(src, dst, helpers.CopyFlagOverwrite|helpers.CopyFlagSync); err != nil {
+			return err
+		}
+	}
+
+	// no umask in the way
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Lchown(dst, uid, gid)
+}
+
+type verifyProblemsByPath []VerifyProblem
+
+func (ps verifyProblemsByPath) Len() int           { return len(ps) }
+func (ps verifyProblemsByPath) Swap(i, j int)      { ps[i], ps[j] = ps[j], ps[i] }
+func (ps verifyProblemsByPath) Less(i, j int) bool { return ps[i].Path < ps[j].Path }
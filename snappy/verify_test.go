@@ -0,0 +1,131 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+// installAndBreak installs a snap and then messes with its files
+func (s *SnapTestSuite) installAndBreak(c *C) (snapFile, baseDir string) {
+	snapFile = makeTestSnapPackage(c, "")
+	_, err := installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	c.Assert(err, IsNil)
+
+	results, err := Verify("foo", false, nil)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Problems, HasLen, 0)
+	c.Check(results[0].OK(), Equals, true)
+
+	baseDir = filepath.Join(dirs.SnapAppsDir, fooComposedName, "1.0")
+	c.Assert(ioutil.WriteFile(filepath.Join(baseDir, "bin", "foo"), []byte("#!/bin/sh\nrm -rf /"), 0755), IsNil)
+	c.Assert(os.Chmod(filepath.Join(baseDir, "meta", "license.txt"), 0666), IsNil)
+	c.Assert(os.Remove(filepath.Join(baseDir, "meta", "readme.md")), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(baseDir, "bin", "evil"), nil, 0755), IsNil)
+
+	return snapFile, baseDir
+}
+
+func (s *SnapTestSuite) TestVerify(c *C) {
+	s.installAndBreak(c)
+
+	results, err := Verify("foo."+testOrigin, false, nil)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Name, Equals, "foo")
+	c.Check(results[0].Version, Equals, "1.0")
+	c.Check(results[0].Problems, DeepEquals, []VerifyProblem{
+		{Path: "bin/evil", Kind: VerifyExtra},
+		{Path: "bin/foo", Kind: VerifyModified},
+		{Path: "meta/license.txt", Kind: VerifyMode},
+		{Path: "meta/readme.md", Kind: VerifyMissing},
+	})
+	c.Check(results[0].OK(), Equals, false)
+	// no snap file was kept to check the hashes against
+	c.Check(results[0].HashesUnchecked, Equals, true)
+
+	// all the active packages
+	results, err = Verify("", false, nil)
+	c.Assert(err, IsNil)
+	c.Check(results, HasLen, 1)
+
+	_, err = Verify("bar", false, nil)
+	c.Check(err, Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestVerifyRepair(c *C) {
+	snapFile, baseDir := s.installAndBreak(c)
+	part := ActiveSnapByName("foo")
+	c.Assert(keepBlob(snapFile, part), IsNil)
+
+	results, err := Verify("foo", true, nil)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Problems, DeepEquals, []VerifyProblem{
+		{Path: "bin/evil", Kind: VerifyExtra},
+		{Path: "bin/foo", Kind: VerifyModified, Repaired: true},
+		{Path: "meta/license.txt", Kind: VerifyMode, Repaired: true},
+		{Path: "meta/readme.md", Kind: VerifyMissing, Repaired: true},
+	})
+
+	content, err := ioutil.ReadFile(filepath.Join(baseDir, "bin", "foo"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "#!/bin/sh\necho \"hello\"")
+
+	// extra files are only reported
+	results, err = Verify("foo", false, nil)
+	c.Assert(err, IsNil)
+	c.Check(results[0].Problems, DeepEquals, []VerifyProblem{
+		{Path: "bin/evil", Kind: VerifyExtra},
+	})
+}
+
+func (s *SnapTestSuite) TestVerifyHashesFromBlob(c *C) {
+	snapFile, baseDir := s.installAndBreak(c)
+	c.Assert(keepBlob(snapFile, ActiveSnapByName("foo")), IsNil)
+
+	// the hashes in the install dir are changed along with the files
+	c.Assert(ioutil.WriteFile(filepath.Join(baseDir, "meta", "hashes.yaml"), []byte("files: []\n"), 0644), IsNil)
+
+	results, err := Verify("foo", false, nil)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].HashesUnchecked, Equals, false)
+	c.Check(results[0].Problems, DeepEquals, []VerifyProblem{
+		{Path: "bin/evil", Kind: VerifyExtra},
+		{Path: "bin/foo", Kind: VerifyModified},
+		{Path: "meta/license.txt", Kind: VerifyMode},
+		{Path: "meta/readme.md", Kind: VerifyMissing},
+	})
+}
+
+func (s *SnapTestSuite) TestVerifyRepairNeedsBlob(c *C) {
+	s.installAndBreak(c)
+
+	_, err := Verify("foo", true, nil)
+	c.Check(err, Equals, ErrNoSnapBlob(fooComposedName+" 1.0"))
+}
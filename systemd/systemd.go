@@ -95,6 +95,7 @@ type Systemd interface {
 	Status(service string) (string, error)
 	ServiceStatus(service string) (*ServiceStatus, error)
 	Logs(services []string) ([]Log, error)
+	WriteMountUnitFile(name, what, where string) (string, error)
 }
 
 // A Log is a single entry in the systemd journal
@@ -411,6 +412,64 @@ WantedBy={{.SocketSystemdTarget}}
 	return templateOut.String()
 }
 
+// EscapePath escapes the given path the way "systemd-escape --path"
+// does, e.g. for the names of mount units
+func EscapePath(path string) string {
+	path = filepath.Clean("/" + path)
+	if path == "/" {
+		return "-"
+	}
+
+	var buf bytes.Buffer
+	for i, c := range []byte(path[1:]) {
+		switch {
+		case c == '/':
+			buf.WriteByte('-')
+		case c == '.' && i == 0:
+			fmt.Fprintf(&buf, `\x%02x`, c)
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == ':', c == '_', c == '.':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, `\x%02x`, c)
+		}
+	}
+
+	return buf.String()
+}
+
+// MountUnitName returns the name of the mount unit for the given
+// mount point
+func MountUnitName(where string) string {
+	return EscapePath(where) + ".mount"
+}
+
+// WriteMountUnitFile writes the unit that mounts the squashfs file
+// what of the snap with the given name read-only at where, and returns
+// the name of the unit
+func (s *systemd) WriteMountUnitFile(name, what, where string) (string, error) {
+	content := fmt.Sprintf(`[Unit]
+Description=Squashfs mount unit for %s
+X-Snappy=yes
+
+[Mount]
+What=%s
+Where=%s
+Type=squashfs
+Options=nodev,nosuid,ro
+
+[Install]
+WantedBy=%s
+`, name, what, where, servicesSystemdTarget)
+
+	unitName := MountUnitName(where)
+	unitFile := filepath.Join(s.rootDir, snapServicesDir, unitName)
+	if err := os.MkdirAll(filepath.Dir(unitFile), 0755); err != nil {
+		return "", err
+	}
+
+	return unitName, helpers.AtomicWriteFile(unitFile, []byte(content), 0644, 0)
+}
+
 // Kill all processes of the unit with the given signal
 func (s *systemd) Kill(serviceName, signal string) error {
 	_, err := SystemctlCmd("kill", serviceName, "-s", signal)
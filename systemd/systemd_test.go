@@ -21,6 +21,7 @@ package systemd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -205,6 +206,45 @@ func (s *SystemdTestSuite) TestEnable(c *C) {
 	c.Assert(target, Equals, "/etc/systemd/system/foo")
 }
 
+func (s *SystemdTestSuite) TestEscapePath(c *C) {
+	for path, escaped := range map[string]string{
+		"/":                   "-",
+		"/apps/foo.mvo/1.0":   "apps-foo.mvo-1.0",
+		"apps//foo/":          "apps-foo",
+		"/apps/foo-bar/1.0~1": `apps-foo\x2dbar-1.0\x7e1`,
+		"/.hidden/a b":        `\x2ehidden-a\x20b`,
+	} {
+		c.Check(EscapePath(path), Equals, escaped, Commentf(path))
+	}
+
+	c.Check(MountUnitName("/apps/foo.mvo/1.0"), Equals, "apps-foo.mvo-1.0.mount")
+}
+
+func (s *SystemdTestSuite) TestWriteMountUnit(c *C) {
+	rootDir := c.MkDir()
+	sysd := New(rootDir, s.rep)
+
+	name, err := sysd.WriteMountUnitFile("foo.mvo", "/var/lib/snappy/snaps/foo.mvo_1.0.snap", "/apps/foo.mvo/1.0")
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "apps-foo.mvo-1.0.mount")
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, "/etc/systemd/system", name))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, `[Unit]
+Description=Squashfs mount unit for foo.mvo
+X-Snappy=yes
+
+[Mount]
+What=/var/lib/snappy/snaps/foo.mvo_1.0.snap
+Where=/apps/foo.mvo/1.0
+Type=squashfs
+Options=nodev,nosuid,ro
+
+[Install]
+WantedBy=multi-user.target
+`)
+}
+
 const expectedServiceFmt = `[Unit]
 Description=descr
 %s